@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/malivvan/aegis/cli"
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/mgrd"
+	"github.com/malivvan/aegis/opgp/crypto"
+	"github.com/malivvan/aegis/scard"
+	"github.com/malivvan/aegis/vault"
+)
+
+var errNoCard = errors.New("no OpenPGP card found")
+
+var cardCommand = &cli.Command{
+	Name:  "card",
+	Usage: "manage unlocking the keyring with OpenPGP cards",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "init",
+			Usage: "protect the keyring with a key file encrypted to OpenPGP cards",
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:     "recipient",
+					Aliases:  []string{"r"},
+					Usage:    "armored public key of a card that can unlock the keyring",
+					Required: true,
+				},
+			},
+			Action: cardInitAction,
+		},
+		{
+			Name:      "add",
+			Usage:     "allow another OpenPGP card, e.g. a backup token, to unlock the keyring",
+			ArgsUsage: "<public key>...",
+			Action:    cardAddAction,
+		},
+	},
+}
+
+// cardCredentials unlocks the first OpenPGP card with pin and decrypts the card key file of the keyring at path. It
+// returns the name of the reader of the card as well.
+func cardCredentials(path, pin string) (creds *kdbx.DBCredentials, reader string, err error) {
+	err = withCardDecrypter(pin, func(name string, key *scard.Key) (err error) {
+		reader = name
+		creds, err = vault.CardCredentials(path, key)
+		return err
+	})
+	return creds, reader, err
+}
+
+// withCardDecrypter calls fn with the reader name and the decryption key of the first connected OpenPGP card after
+// verifying pin.
+func withCardDecrypter(pin string, fn func(reader string, key *scard.Key) error) error {
+	ctx, err := scard.EstablishContext()
+	if err != nil {
+		return err
+	}
+	defer ctx.Release()
+
+	readers, err := ctx.ListReadersWithCard()
+	if err != nil {
+		return err
+	}
+	for _, reader := range readers {
+		card, err := reader.Connect()
+		if err != nil {
+			continue
+		}
+		defer card.Disconnect()
+		// select, verify and decrypt must not be interleaved with commands of other applications like gpg-agent
+		err = card.Transaction(func() error {
+			openpgp, err := card.OpenPGP()
+			if err != nil {
+				return errNoCard
+			}
+			if err := openpgp.Verify(scard.PW1, []byte(pin)); err != nil {
+				return fmt.Errorf("%s: %w", reader.Name(), pinError(err))
+			}
+			key, err := openpgp.Key(scard.KeyDecrypt)
+			if err != nil {
+				return fmt.Errorf("%s: %w", reader.Name(), err)
+			}
+			return fn(reader.Name(), key)
+		})
+		if errors.Is(err, errNoCard) {
+			continue
+		}
+		return err
+	}
+	return errNoCard
+}
+
+// pinError describes a failed PIN verification with the number of attempts left before the card blocks the PIN.
+func pinError(err error) error {
+	n, ok := scard.RetriesLeft(err)
+	if errors.Is(err, scard.ErrAuthenticationMethodBlocked) || ok && n == 0 {
+		return fmt.Errorf("PIN is blocked, unblock it with the reset code or admin PIN: %w", err)
+	}
+	if ok {
+		if n == 1 {
+			return fmt.Errorf("wrong PIN, 1 attempt left before the card blocks it: %w", err)
+		}
+		return fmt.Errorf("wrong PIN, %d attempts left: %w", n, err)
+	}
+	return err
+}
+
+func readPublicKeys(paths []string) ([]*crypto.Key, error) {
+	keys := make([]*crypto.Key, 0, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := crypto.NewKeyFromReader(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if key.IsPrivate() {
+			if key, err = key.ToPublic(); err != nil {
+				return nil, err
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func cardInitAction(ctx *cli.Context) error {
+	path, err := keyringPath(ctx)
+	if err != nil {
+		return err
+	}
+	keys, err := readPublicKeys(ctx.StringSlice("recipient"))
+	if err != nil {
+		return err
+	}
+	recipients, err := crypto.NewKeyRing(nil)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := recipients.AddKey(key); err != nil {
+			return err
+		}
+	}
+
+	// an existing keyring is unlocked with its current credentials and saved again with the card key
+	var v *vault.Vault
+	if _, err := os.Stat(path); err == nil {
+		if v, err = openVault(ctx); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	key, err := vault.NewCardKey(path, recipients)
+	if err != nil {
+		return err
+	}
+	creds, err := kdbx.NewKeyDataCredentials(key)
+	mgrd.WipeBytes(key)
+	if err == nil {
+		if v == nil {
+			fmt.Fprintf(os.Stderr, "Creating new keyring %s\n", path)
+			v, err = vault.Create(path, creds)
+		} else {
+			v.DB.Credentials = creds
+		}
+	}
+	if err == nil {
+		err = saveVault(v)
+	}
+	if err != nil {
+		os.Remove(vault.CardKeyPath(path))
+		os.Remove(vault.CardRecipientsPath(path))
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Keyring %s can now only be unlocked with --card\n", path)
+	return nil
+}
+
+func cardAddAction(ctx *cli.Context) error {
+	if ctx.NArg() == 0 {
+		return fmt.Errorf("%s: expected at least 1 argument", ctx.Command.Name)
+	}
+	path, err := keyringPath(ctx)
+	if err != nil {
+		return err
+	}
+	keys, err := readPublicKeys(ctx.Args().Slice())
+	if err != nil {
+		return err
+	}
+	pin, err := readPassword("Card PIN: ")
+	if err != nil {
+		return err
+	}
+	return withCardDecrypter(pin, func(_ string, key *scard.Key) error {
+		return vault.AddCardRecipients(path, key, keys...)
+	})
+}
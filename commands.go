@@ -0,0 +1,391 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/malivvan/aegis/cli"
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/otp"
+	"github.com/malivvan/aegis/vault"
+	"github.com/malivvan/aegis/yubiotp"
+)
+
+const mask = "********"
+
+var commands = []*cli.Command{
+	{
+		Name:      "ls",
+		Usage:     "list the groups and entries of a group",
+		ArgsUsage: "[group]",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "recursive", Aliases: []string{"r"}, Usage: "list subgroups recursively"},
+		},
+		Action: listAction,
+	},
+	{
+		Name:      "show",
+		Usage:     "show the values of an entry",
+		ArgsUsage: "<entry>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "reveal", Aliases: []string{"r"}, Usage: "print protected values in plain text"},
+			&cli.StringFlag{Name: "field", Aliases: []string{"f"}, Usage: "print only the value of the given field"},
+		},
+		Action: showAction,
+	},
+	{
+		Name:      "add",
+		Usage:     "add an entry, prompting for its password",
+		ArgsUsage: "<entry>",
+		Flags:     entryFlags,
+		Action:    addAction,
+	},
+	{
+		Name:      "edit",
+		Usage:     "change the values of an entry",
+		ArgsUsage: "<entry>",
+		Flags: append([]cli.Flag{
+			&cli.BoolFlag{Name: "password", Aliases: []string{"p"}, Usage: "prompt for a new password"},
+		}, entryFlags...),
+		Action: editAction,
+	},
+	{
+		Name:      "rm",
+		Usage:     "remove an entry or group",
+		ArgsUsage: "<path>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "recursive", Aliases: []string{"r"}, Usage: "remove groups and their contents"},
+		},
+		Action: removeAction,
+	},
+	{
+		Name:      "mv",
+		Usage:     "move or rename an entry or group",
+		ArgsUsage: "<source> <destination>",
+		Action:    moveAction,
+	},
+	{
+		Name:      "mkdir",
+		Usage:     "create a group",
+		ArgsUsage: "<group>",
+		Flags: []cli.Flag{
+			&cli.BoolFlag{Name: "parents", Aliases: []string{"p"}, Usage: "create parent groups as needed"},
+		},
+		Action: makeGroupAction,
+	},
+}
+
+var entryFlags = []cli.Flag{
+	&cli.StringFlag{Name: "username", Aliases: []string{"u"}, Usage: "user name of the entry"},
+	&cli.StringFlag{Name: "url", Usage: "url of the entry"},
+	&cli.StringFlag{Name: "notes", Usage: "notes of the entry"},
+}
+
+// keyringPath returns the keyring flag value with a leading ~ expanded to the home directory.
+func keyringPath(ctx *cli.Context) (string, error) {
+	path := ctx.String("keyring")
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		path = filepath.Join(home, path[1:])
+	}
+	return path, nil
+}
+
+// credentials builds the keyring credentials from password, or from the key file decrypted by an OpenPGP card if
+// the card flag is set, in which case password is the card PIN, or unsealed by a FIDO2 security key if the fido flag
+// is set, in which case password is ignored.
+func credentials(ctx *cli.Context, password string) (*kdbx.DBCredentials, error) {
+	if ctx.Bool("fido") {
+		path, err := keyringPath(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return fidoCredentials(path)
+	}
+	if ctx.Bool("card") {
+		path, err := keyringPath(ctx)
+		if err != nil {
+			return nil, err
+		}
+		creds, _, err := cardCredentials(path, password)
+		return creds, err
+	}
+	if keyfile := ctx.String("keyfile"); keyfile != "" {
+		if password == "" {
+			return kdbx.NewKeyCredentials(keyfile)
+		}
+		return kdbx.NewPasswordAndKeyCredentials(password, keyfile)
+	}
+	return kdbx.NewPasswordCredentials(password), nil
+}
+
+// mergeRules keep the counters of HOTP keys and Yubico OTP credentials from going back when a keyring is merged.
+var mergeRules = []kdbx.MergeRule{otp.MergeCounter, yubiotp.MergeCounter}
+
+// openVault prompts for the keyring password and opens the keyring.
+func openVault(ctx *cli.Context) (*vault.Vault, error) {
+	path, err := keyringPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	var password string
+	if !ctx.Bool("fido") {
+		prompt := "Keyring password: "
+		if ctx.Bool("card") {
+			prompt = "Card PIN: "
+		}
+		if password, err = readPassword(prompt); err != nil {
+			return nil, err
+		}
+	}
+	creds, err := credentials(ctx, password)
+	if err != nil {
+		return nil, err
+	}
+	v, err := vault.Open(path, creds)
+	if err != nil {
+		return nil, err
+	}
+	v.MergeRules = mergeRules
+	return v, nil
+}
+
+// saveVault saves the keyring and reports the changes that were merged into it, because the keyring file was changed
+// since it was opened.
+func saveVault(v *vault.Vault) error {
+	if err := v.Save(); err != nil {
+		return err
+	}
+	for _, change := range v.Merged {
+		fmt.Fprintf(os.Stderr, "Merged concurrent change: %s\n", change)
+	}
+	return nil
+}
+
+// openOrCreateVault opens the keyring or, if it does not exist yet, creates it with a new password.
+func openOrCreateVault(ctx *cli.Context) (*vault.Vault, error) {
+	path, err := keyringPath(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		return openVault(ctx)
+	}
+	if ctx.Bool("card") {
+		return nil, errors.New("create card protected keyrings with 'aegis card init'")
+	}
+	if ctx.Bool("fido") {
+		return nil, errors.New("create FIDO2 protected keyrings with 'aegis fido init'")
+	}
+	fmt.Fprintf(os.Stderr, "Creating new keyring %s\n", path)
+	password, err := readNewPassword("New keyring password: ")
+	if err != nil {
+		return nil, err
+	}
+	creds, err := credentials(ctx, password)
+	if err != nil {
+		return nil, err
+	}
+	v, err := vault.Create(path, creds)
+	if err != nil {
+		return nil, err
+	}
+	v.MergeRules = mergeRules
+	return v, nil
+}
+
+func requireArgs(ctx *cli.Context, n int) error {
+	if ctx.NArg() != n {
+		return fmt.Errorf("%s: expected %d argument(s), got %d", ctx.Command.Name, n, ctx.NArg())
+	}
+	return nil
+}
+
+func listAction(ctx *cli.Context) error {
+	if ctx.NArg() > 1 {
+		return requireArgs(ctx, 1)
+	}
+	v, err := openVault(ctx)
+	if err != nil {
+		return err
+	}
+	g, err := v.Group(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	listGroup(g, "", ctx.Bool("recursive"))
+	return nil
+}
+
+func listGroup(g *kdbx.Group, prefix string, recursive bool) {
+	for i := range g.Groups {
+		name := vault.Escape(g.Groups[i].Name)
+		fmt.Println(prefix + name + "/")
+		if recursive {
+			listGroup(&g.Groups[i], prefix+name+"/", true)
+		}
+	}
+	for i := range g.Entries {
+		fmt.Println(prefix + vault.Escape(g.Entries[i].GetTitle()))
+	}
+}
+
+func showAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 1); err != nil {
+		return err
+	}
+	v, err := openVault(ctx)
+	if err != nil {
+		return err
+	}
+	e, err := v.Entry(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	reveal := ctx.Bool("reveal")
+
+	if field := ctx.String("field"); field != "" {
+		value := e.Get(field)
+		if value == nil {
+			return fmt.Errorf("%s: no such field", field)
+		}
+		if value.Value.Protected.Bool && !reveal {
+			return fmt.Errorf("%s: field is protected, use --reveal to print it", field)
+		}
+		return printValue("", &value.Value)
+	}
+
+	for _, key := range vault.Fields(e) {
+		value := e.Get(key)
+		if value == nil || value.Value.IsEmpty() {
+			continue
+		}
+		if value.Value.Protected.Bool && !reveal {
+			fmt.Printf("%s: %s\n", key, mask)
+			continue
+		}
+		if err := printValue(key+": ", &value.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printValue prints prefix and the content of v to stdout without copying it out of guarded memory.
+func printValue(prefix string, v *kdbx.V) error {
+	buf, err := v.Open()
+	if err != nil {
+		return err
+	}
+	defer buf.Destroy()
+	_, err = os.Stdout.Write(append(append([]byte(prefix), buf.Bytes()...), '\n'))
+	return err
+}
+
+func addAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 1); err != nil {
+		return err
+	}
+	v, err := openOrCreateVault(ctx)
+	if err != nil {
+		return err
+	}
+	password, err := readNewPassword("Entry password: ")
+	if err != nil {
+		return err
+	}
+	e, err := v.AddEntry(ctx.Args().First())
+	if err != nil {
+		return err
+	}
+	v.SetValue(e, "UserName", ctx.String("username"))
+	v.SetValue(e, "Password", password)
+	v.SetValue(e, "URL", ctx.String("url"))
+	v.SetValue(e, "Notes", ctx.String("notes"))
+	return saveVault(v)
+}
+
+func editAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 1); err != nil {
+		return err
+	}
+	v, err := openVault(ctx)
+	if err != nil {
+		return err
+	}
+	path := ctx.Args().First()
+	if _, err := v.Entry(path); err != nil {
+		return err
+	}
+	var password string
+	if ctx.Bool("password") {
+		if password, err = readNewPassword("New entry password: "); err != nil {
+			return err
+		}
+	}
+	err = v.UpdateEntry(path, func(e *kdbx.Entry) {
+		for flag, key := range map[string]string{"username": "UserName", "url": "URL", "notes": "Notes"} {
+			if ctx.IsSet(flag) {
+				v.SetValue(e, key, ctx.String(flag))
+			}
+		}
+		if ctx.Bool("password") {
+			v.SetValue(e, "Password", password)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return saveVault(v)
+}
+
+func removeAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 1); err != nil {
+		return err
+	}
+	v, err := openVault(ctx)
+	if err != nil {
+		return err
+	}
+	if err := v.Remove(ctx.Args().First(), ctx.Bool("recursive")); err != nil {
+		return err
+	}
+	return saveVault(v)
+}
+
+func moveAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 2); err != nil {
+		return err
+	}
+	v, err := openVault(ctx)
+	if err != nil {
+		return err
+	}
+	if err := v.Move(ctx.Args().Get(0), ctx.Args().Get(1)); err != nil {
+		return err
+	}
+	return saveVault(v)
+}
+
+func makeGroupAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 1); err != nil {
+		return err
+	}
+	v, err := openOrCreateVault(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := v.MakeGroup(ctx.Args().First(), ctx.Bool("parents")); err != nil {
+		return err
+	}
+	return saveVault(v)
+}
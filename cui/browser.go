@@ -0,0 +1,616 @@
+package cui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/mgrd"
+	"github.com/malivvan/aegis/scard"
+	"github.com/malivvan/aegis/vault"
+	"github.com/malivvan/cui"
+)
+
+const (
+	mask = "********"
+	help = "/ search  tab switch pane  c copy password  u copy user name  o OATH code  r reveal  e edit  q quit"
+	// oathRefresh is how often the codes are calculated again although none of the listed ones expired, e.g. to
+	// pick up a token inserted after a failed attempt.
+	oathRefresh = 30 * time.Second
+)
+
+// browser shows the groups of an unlocked keyring as a tree next to the entries of the selected
+// group and the values of the selected entry.
+type browser struct {
+	app     *cui.Application
+	keyring string
+	vault   *vault.Vault
+	reader  string // of the card that unlocked the keyring, whose removal locks it again
+
+	root   *cui.Flex
+	tree   *cui.TreeView
+	search *cui.InputField
+	table  *cui.Table
+	detail *cui.TextView
+	status *cui.TextView
+
+	group   string   // path of the selected group
+	entries []string // paths of the listed entries, one per table row after the header
+	entry   string   // path of the selected entry
+
+	// revealed holds the protected values of the selected entry while they are shown in plain text.
+	revealed map[string]*mgrd.LockedBuffer
+	clear    *time.Timer
+
+	codes        Codes
+	oath         map[string]scard.OathCode // codes by credential name
+	oathFetched  time.Time                 // start of the last CalculateAll
+	oathFetching bool
+}
+
+func newBrowser(app *cui.Application, keyring string, codes Codes) *browser {
+	b := &browser{app: app, keyring: keyring, codes: codes}
+
+	b.tree = cui.NewTreeView()
+	b.tree.SetBorder(true)
+	b.tree.SetTitle(" groups ")
+	b.tree.SetChangedFunc(func(node *cui.TreeNode) {
+		b.selectGroup(node.GetReference().(string))
+	})
+
+	b.search = cui.NewInputField()
+	b.search.SetLabel("search: ")
+	b.search.SetChangedFunc(func(text string) {
+		b.listEntries()
+	})
+	b.search.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyEnter:
+			b.app.SetFocus(b.table)
+		case tcell.KeyEscape:
+			b.search.SetText("")
+			b.app.SetFocus(b.tree)
+		}
+	})
+
+	b.table = cui.NewTable()
+	b.table.SetBorder(true)
+	b.table.SetTitle(" entries ")
+	b.table.SetSelectable(true, false)
+	b.table.SetFixed(1, 0)
+	b.table.SetSelectionChangedFunc(func(row, column int) {
+		if row > 0 && row <= len(b.entries) {
+			b.selectEntry(b.entries[row-1])
+		}
+	})
+
+	b.detail = cui.NewTextView()
+	b.detail.SetBorder(true)
+	b.detail.SetTitle(" entry ")
+	b.detail.SetWrap(true)
+
+	b.status = cui.NewTextView()
+	b.status.SetText(help)
+
+	right := cui.NewFlex()
+	right.SetDirection(cui.FlexRow)
+	right.AddItem(b.search, 1, 0, false)
+	right.AddItem(b.table, 0, 1, false)
+	right.AddItem(b.detail, 0, 1, false)
+
+	body := cui.NewFlex()
+	body.SetDirection(cui.FlexColumn)
+	body.AddItem(b.tree, 0, 1, true)
+	body.AddItem(right, 0, 2, false)
+
+	b.root = cui.NewFlex()
+	b.root.SetDirection(cui.FlexRow)
+	b.root.AddItem(body, 0, 1, true)
+	b.root.AddItem(b.status, 1, 0, false)
+	b.root.SetInputCapture(b.handleKey)
+	return b
+}
+
+// show displays the unlocked keyring v.
+func (b *browser) show(v *vault.Vault) {
+	b.vault = v
+
+	root := b.vault.Root()
+	node := cui.NewTreeNode(root.Name)
+	node.SetReference("")
+	addGroups(node, root, "")
+	b.tree.SetRoot(node)
+	b.tree.SetCurrentNode(node)
+	b.selectGroup("")
+
+	b.app.SetRoot(b.root, true)
+	b.app.SetFocus(b.tree)
+}
+
+func addGroups(node *cui.TreeNode, g *kdbx.Group, path string) {
+	for i := range g.Groups {
+		child := cui.NewTreeNode(g.Groups[i].Name)
+		child.SetReference(vault.Join(path, g.Groups[i].Name))
+		node.AddChild(child)
+		addGroups(child, &g.Groups[i], vault.Join(path, g.Groups[i].Name))
+	}
+}
+
+func (b *browser) handleKey(event *tcell.EventKey) *tcell.EventKey {
+	if b.app.GetFocus() == b.search {
+		return event
+	}
+	switch event.Key() {
+	case tcell.KeyTab, tcell.KeyBacktab:
+		if b.app.GetFocus() == b.tree {
+			b.app.SetFocus(b.table)
+		} else {
+			b.app.SetFocus(b.tree)
+		}
+		return nil
+	case tcell.KeyRune:
+		switch event.Rune() {
+		case '/':
+			b.app.SetFocus(b.search)
+		case 'c':
+			b.copy("Password")
+		case 'u':
+			b.copy("UserName")
+		case 'o':
+			b.calculateCode()
+		case 'r':
+			b.toggleReveal()
+		case 'e':
+			b.edit()
+		case 'q':
+			b.app.Stop()
+		default:
+			return event
+		}
+		return nil
+	}
+	return event
+}
+
+func (b *browser) setStatus(format string, a ...interface{}) {
+	b.status.SetText(fmt.Sprintf(format, a...))
+}
+
+func (b *browser) selectGroup(path string) {
+	b.group = path
+	b.listEntries()
+}
+
+// listEntries fills the entry table with the entries of the selected group or, while searching,
+// with all entries matching the search text.
+func (b *browser) listEntries() {
+	b.entries = nil
+	if query := strings.ToLower(b.search.GetText()); query != "" {
+		b.findEntries(b.vault.Root(), "", query)
+	} else if g, err := b.vault.Group(b.group); err == nil {
+		for i := range g.Entries {
+			b.entries = append(b.entries, vault.Join(b.group, g.Entries[i].GetTitle()))
+		}
+	}
+
+	b.table.Clear()
+	titles := []string{"Title", "UserName", "URL"}
+	if b.codes != nil {
+		titles = append(titles, "Code")
+	}
+	for column, title := range titles {
+		cell := cui.NewTableCell(title)
+		cell.SetSelectable(false)
+		cell.SetExpansion(1)
+		b.table.SetCell(0, column, cell)
+	}
+	for row, path := range b.entries {
+		e, err := b.vault.Entry(path)
+		if err != nil {
+			continue
+		}
+		title := e.GetTitle()
+		if b.search.GetText() != "" {
+			title = path
+		}
+		for column, text := range []string{title, listed(e, "UserName"), listed(e, "URL")} {
+			cell := cui.NewTableCell(text)
+			cell.SetExpansion(1)
+			b.table.SetCell(row+1, column, cell)
+		}
+	}
+	b.showCodes(time.Now())
+
+	if len(b.entries) == 0 {
+		b.selectEntry("")
+		return
+	}
+	row := 0
+	for i, path := range b.entries {
+		if path == b.entry {
+			row = i
+		}
+	}
+	b.table.Select(row+1, 0)
+	b.selectEntry(b.entries[row])
+}
+
+// listed returns the content of key of e as the entry list shows it, protected values are masked.
+func listed(e *kdbx.Entry, key string) string {
+	if value := e.Get(key); value != nil && value.Value.Protected.Bool && !value.Value.IsEmpty() {
+		return mask
+	}
+	return e.GetContent(key)
+}
+
+func (b *browser) findEntries(g *kdbx.Group, path, query string) {
+	for i := range g.Entries {
+		e := &g.Entries[i]
+		for _, key := range []string{"Title", "UserName", "URL"} {
+			if strings.Contains(strings.ToLower(e.GetContent(key)), query) {
+				b.entries = append(b.entries, vault.Join(path, e.GetTitle()))
+				break
+			}
+		}
+	}
+	for i := range g.Groups {
+		b.findEntries(&g.Groups[i], vault.Join(path, g.Groups[i].Name), query)
+	}
+}
+
+// selected returns the selected entry or nil if there is none.
+func (b *browser) selected() *kdbx.Entry {
+	if b.entry == "" {
+		return nil
+	}
+	e, err := b.vault.Entry(b.entry)
+	if err != nil {
+		return nil
+	}
+	return e
+}
+
+func (b *browser) selectEntry(path string) {
+	if path != b.entry {
+		b.hide()
+	}
+	b.entry = path
+	b.showEntry()
+}
+
+func (b *browser) showEntry() {
+	e := b.selected()
+	if e == nil {
+		b.detail.SetText("")
+		return
+	}
+	var text strings.Builder
+	for _, key := range vault.Fields(e) {
+		value := e.Get(key)
+		if value == nil || value.Value.IsEmpty() {
+			continue
+		}
+		buf, revealed := b.revealed[key]
+		if !revealed && value.Value.Protected.Bool {
+			fmt.Fprintf(&text, "%s: %s\n", key, mask)
+			continue
+		}
+		if !revealed {
+			var err error
+			if buf, err = value.Value.Open(); err != nil {
+				b.setStatus("error: %s", err)
+				continue
+			}
+		}
+		fmt.Fprintf(&text, "%s: %s\n", key, buf.Bytes())
+		if !revealed {
+			buf.Destroy()
+		}
+	}
+	b.detail.SetText(text.String())
+}
+
+// toggleReveal shows or hides the protected values of the selected entry.
+func (b *browser) toggleReveal() {
+	if b.revealed != nil {
+		b.hide()
+		b.showEntry()
+		return
+	}
+	e := b.selected()
+	if e == nil {
+		return
+	}
+	b.revealed = make(map[string]*mgrd.LockedBuffer)
+	for i := range e.Values {
+		if value := &e.Values[i]; value.Value.Protected.Bool {
+			buf, err := value.Value.Open()
+			if err != nil {
+				b.hide()
+				b.setStatus("error: %s", err)
+				return
+			}
+			b.revealed[value.Key] = buf
+		}
+	}
+	b.showEntry()
+}
+
+// hide destroys the buffers of revealed values.
+func (b *browser) hide() {
+	for _, buf := range b.revealed {
+		buf.Destroy()
+	}
+	b.revealed = nil
+}
+
+// close hides revealed values and clears the clipboard if it still holds a copied secret.
+func (b *browser) close() {
+	b.hide()
+	if b.clear != nil && b.clear.Stop() {
+		_ = copyToClipboard(nil)
+	}
+}
+
+// lock closes the keyring and removes its contents from the screen, e.g. when the card that unlocked it is removed.
+func (b *browser) lock() {
+	b.close()
+	b.vault, b.reader = nil, ""
+	b.oath, b.oathFetched = nil, time.Time{}
+	b.group, b.entries, b.entry = "", nil, ""
+	b.search.SetText("")
+	b.tree.SetRoot(nil)
+	b.table.Clear()
+	b.detail.SetText("")
+}
+
+// copy copies the value of key of the selected entry to the clipboard and clears it after clipboardTimeout.
+func (b *browser) copy(key string) {
+	e := b.selected()
+	if e == nil {
+		return
+	}
+	buf, err := e.OpenContent(key)
+	if err != nil {
+		b.setStatus("error: %s", err)
+		return
+	}
+	defer buf.Destroy()
+	if buf.Size() == 0 {
+		b.setStatus("%s is empty", key)
+		return
+	}
+	if err := copyToClipboard(buf.Bytes()); err != nil {
+		b.setStatus("error: %s", err)
+		return
+	}
+	b.setStatus("%s copied, clipboard is cleared in %s", key, clipboardTimeout)
+
+	if b.clear != nil {
+		b.clear.Stop()
+	}
+	b.clear = time.AfterFunc(clipboardTimeout, func() {
+		_ = copyToClipboard(nil)
+		b.app.QueueUpdateDraw(func() {
+			b.setStatus("clipboard cleared")
+		})
+	})
+}
+
+// edit opens a form to change the values of the selected entry and saves the keyring on submit. Protected values,
+// like the password, are not copied into the form, they are only replaced if a new value is entered. New protected
+// values are kept in guarded buffers that are destroyed when the form is closed.
+func (b *browser) edit() {
+	e := b.selected()
+	if e == nil {
+		return
+	}
+	b.hide()
+	values := make(map[string]string)
+	secrets := make(map[string]*mgrd.LockedBuffer)
+	done := func() {
+		for _, buf := range secrets {
+			buf.Destroy()
+		}
+		b.app.SetRoot(b.root, true)
+		b.app.SetFocus(b.table)
+	}
+
+	form := cui.NewForm()
+	form.SetBorder(true)
+	form.SetTitle(" edit " + b.entry + " ")
+	for _, field := range []struct{ key, label string }{
+		{"UserName", "User name"}, {"Password", "Password"}, {"URL", "URL"}, {"Notes", "Notes"},
+	} {
+		key := field.key
+		if value := e.Get(key); key == "Password" || value != nil && value.Value.Protected.Bool {
+			form.AddPasswordField("New "+strings.ToLower(field.label), "", 0, '*', func(text string) {
+				if buf, ok := secrets[key]; ok {
+					buf.Destroy()
+				}
+				secrets[key] = mgrd.NewBufferFromBytes([]byte(text))
+			})
+			continue
+		}
+		values[key] = e.GetContent(key)
+		form.AddInputField(field.label, values[key], 0, nil, func(text string) {
+			values[key] = text
+		})
+	}
+	form.AddButton("Save", func() {
+		defer done()
+		changed := false
+		for key, value := range values {
+			changed = changed || e.GetContent(key) != value
+		}
+		for _, buf := range secrets {
+			changed = changed || buf.Size() > 0
+		}
+		if !changed {
+			return
+		}
+		err := b.vault.UpdateEntry(b.entry, func(e *kdbx.Entry) {
+			for key, value := range values {
+				if e.GetContent(key) != value {
+					b.vault.SetValue(e, key, value)
+				}
+			}
+			for key, buf := range secrets {
+				if buf.Size() > 0 {
+					b.vault.SetSecret(e, key, buf)
+				}
+			}
+		})
+		if err == nil {
+			err = b.vault.Save()
+		}
+		if err != nil {
+			b.setStatus("error: %s", err)
+			return
+		}
+		if len(b.vault.Merged) > 0 {
+			// the keyring file was changed since it was opened, the merged changes may include groups
+			b.show(b.vault)
+			b.setStatus("saved %s, merged %d concurrent changes", b.keyring, len(b.vault.Merged))
+			return
+		}
+		b.setStatus("saved %s", b.keyring)
+		b.listEntries()
+	})
+	form.AddButton("Cancel", done)
+	form.SetCancelFunc(done)
+	b.app.SetRoot(center(form, 70, 13), true)
+}
+
+// oathCredential returns the name of the OATH credential the entry at path references, if any.
+func (b *browser) oathCredential(path string) string {
+	e, err := b.vault.Entry(path)
+	if err != nil {
+		return ""
+	}
+	return e.GetContent(vault.OathField)
+}
+
+// updateCodes runs every second: it calculates the codes again in the background when a listed one expired and
+// updates the countdowns.
+func (b *browser) updateCodes() {
+	if b.vault == nil {
+		return
+	}
+	now := time.Now()
+	refresh := now.Sub(b.oathFetched) >= oathRefresh
+	referenced := false
+	for _, path := range b.entries {
+		name := b.oathCredential(path)
+		if name == "" {
+			continue
+		}
+		referenced = true
+		if code, ok := b.oath[name]; ok && !code.ValidTo.IsZero() && !now.Before(code.ValidTo) {
+			refresh = true
+		}
+	}
+	if referenced && refresh && !b.oathFetching {
+		b.oathFetching, b.oathFetched = true, now
+		go func() {
+			codes, err := b.codes.CalculateAll(now)
+			b.app.QueueUpdateDraw(func() {
+				b.oathFetching = false
+				if b.vault == nil {
+					return
+				}
+				if err != nil {
+					b.setStatus("error: %s", err)
+					return
+				}
+				b.setCodes(codes, now)
+			})
+		}()
+	}
+	b.showCodes(now)
+}
+
+// setCodes replaces the cached codes, keeping codes that were calculated on request and are still valid.
+func (b *browser) setCodes(codes []scard.OathCode, now time.Time) {
+	oath := make(map[string]scard.OathCode, len(codes))
+	for _, code := range codes {
+		name := code.Credential.Name
+		if old, ok := b.oath[name]; ok && code.Value == "" && old.Value != "" && now.Before(old.ValidTo) {
+			code = old
+		}
+		oath[name] = code
+	}
+	b.oath = oath
+	b.showCodes(now)
+}
+
+// showCodes fills the code column of the listed entries.
+func (b *browser) showCodes(now time.Time) {
+	if b.codes == nil {
+		return
+	}
+	for row, path := range b.entries {
+		name := b.oathCredential(path)
+		text := ""
+		if code, ok := b.oath[name]; ok {
+			switch {
+			case code.Value == "" && code.Credential.Type == scard.OathHOTP:
+				text = "HOTP, press o"
+			case code.Value == "":
+				text = "touch, press o"
+			case code.ValidTo.IsZero():
+				text = code.Value
+			case now.Before(code.ValidTo):
+				text = fmt.Sprintf("%s %2ds", code.Value, int(code.ValidTo.Sub(now).Seconds()))
+			}
+		} else if name != "" && b.oath != nil {
+			text = "not on token"
+		}
+		cell := cui.NewTableCell(text)
+		cell.SetExpansion(1)
+		b.table.SetCell(row+1, 3, cell)
+	}
+}
+
+// calculateCode calculates the code of the credential the selected entry references, which HOTP credentials and
+// credentials that require touch need.
+func (b *browser) calculateCode() {
+	if b.codes == nil || b.entry == "" {
+		return
+	}
+	name := b.oathCredential(b.entry)
+	if name == "" {
+		b.setStatus("%s references no OATH credential", b.entry)
+		return
+	}
+	c, ok := b.oath[name]
+	credential := c.Credential
+	if !ok {
+		credential = scard.OathCredential{Name: name, Type: scard.OathTOTP}
+	}
+	if credential.Touch {
+		b.setStatus("touch your token for %s", name)
+	} else {
+		b.setStatus("calculating %s", name)
+	}
+	go func() {
+		code, err := b.codes.Calculate(credential, time.Now())
+		b.app.QueueUpdateDraw(func() {
+			if b.vault == nil {
+				return
+			}
+			if err != nil {
+				b.setStatus("error: %s", err)
+				return
+			}
+			if b.oath == nil {
+				b.oath = make(map[string]scard.OathCode)
+			}
+			b.oath[name] = code
+			b.setStatus(help)
+			b.showCodes(time.Now())
+		})
+	}()
+}
@@ -0,0 +1,35 @@
+package cui
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"time"
+)
+
+// clipboardTimeout is the time after which copied secrets are cleared from the clipboard.
+const clipboardTimeout = 30 * time.Second
+
+var errNoClipboard = errors.New("no clipboard command found (wl-copy, xclip, xsel, pbcopy or clip.exe)")
+
+var clipboardCommands = [][]string{
+	{"wl-copy"},
+	{"xclip", "-selection", "clipboard"},
+	{"xsel", "--clipboard", "--input"},
+	{"pbcopy"},
+	{"clip.exe"},
+}
+
+// copyToClipboard writes data to the system clipboard using the first available clipboard command.
+func copyToClipboard(data []byte) error {
+	for _, args := range clipboardCommands {
+		path, err := exec.LookPath(args[0])
+		if err != nil {
+			continue
+		}
+		cmd := exec.Command(path, args[1:]...)
+		cmd.Stdin = bytes.NewReader(data)
+		return cmd.Run()
+	}
+	return errNoClipboard
+}
@@ -1,28 +1,92 @@
 package cui
 
 import (
+	"fmt"
+	"time"
+
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/scard"
+	"github.com/malivvan/aegis/vault"
 	"github.com/malivvan/cui"
 )
 
-func Execute(keyring string) error {
+// Credentials builds the keyring credentials from the password entered in the unlock dialog. If the password
+// unlocked a card, reader is the name of its reader.
+type Credentials func(password string) (creds *kdbx.DBCredentials, reader string, err error)
+
+// Codes calculates the codes of the credentials in the OATH application of a token, like *scard.OATH does.
+type Codes interface {
+	CalculateAll(t time.Time) ([]scard.OathCode, error)
+	Calculate(c scard.OathCredential, t time.Time) (scard.OathCode, error)
+}
+
+// Execute runs the interactive keyring browser for the keyring at path until the user quits. If cards is not nil,
+// the unlock dialog reports inserted cards and the keyring is locked again as soon as the card that unlocked it is
+// removed. If codes is not nil, entries that reference an OATH credential with vault.OathField show its code with a
+// countdown. rules are passed to kdbx.Merge if the keyring file was changed by another process when it is saved.
+func Execute(keyring string, credentials Credentials, cards <-chan scard.Event, codes Codes, rules []kdbx.MergeRule) error {
 	app := cui.NewApplication()
 
-	view := cui.NewFlex()
-	text1 := cui.NewTextView()
-	text1.SetText("aegis ") // + bom.Metadata.Component.Version)
-	text1.SetTextAlign(cui.AlignLeft)
-	text2 := cui.NewTextView()
-	text2.SetText(keyring)
-	text2.SetTextAlign(cui.AlignCenter)
-	text3 := cui.NewTextView()
-	text3.SetText("Press Ctrl+C to exit")
-	text3.SetTextAlign(cui.AlignRight)
-	view.SetDirection(cui.FlexColumn)
-	view.AddItem(text1, 0, 1, false)
-	view.AddItem(text2, 0, 1, false)
-	view.AddItem(text3, 0, 1, false)
-	app.SetRoot(view, true)
-
-	app.SetRoot(view, true)
+	b := newBrowser(app, keyring, codes)
+	defer b.close()
+	if codes != nil {
+		// the ticker stops sending when Execute returns, but only closing done ends the goroutine
+		ticker := time.NewTicker(time.Second)
+		done := make(chan struct{})
+		defer func() {
+			ticker.Stop()
+			close(done)
+		}()
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					app.QueueUpdateDraw(b.updateCodes)
+				}
+			}
+		}()
+	}
+
+	unlock := newUnlockDialog(app, keyring, credentials, func(v *vault.Vault, reader string) {
+		v.MergeRules = rules
+		b.reader = reader
+		b.show(v)
+	})
+	app.SetRoot(unlock.root, true)
+	if cards != nil {
+		go func() {
+			for event := range cards {
+				app.QueueUpdateDraw(func() {
+					switch {
+					case event.Err != nil:
+						unlock.setMessage(event.Err.Error())
+					case event.Type == scard.EventCardInserted && b.vault == nil:
+						unlock.setMessage(fmt.Sprintf("card inserted in %s", event.Reader))
+					case event.Type == scard.EventCardRemoved && b.vault != nil && event.Reader == b.reader:
+						b.lock()
+						unlock.show("card removed, keyring locked")
+					}
+				})
+			}
+		}()
+	}
 	return app.Run()
 }
+
+// center places p with the given size in the middle of the screen.
+func center(p cui.Primitive, width, height int) cui.Primitive {
+	row := cui.NewFlex()
+	row.SetDirection(cui.FlexRow)
+	row.AddItem(nil, 0, 1, false)
+	row.AddItem(p, height, 0, true)
+	row.AddItem(nil, 0, 1, false)
+
+	column := cui.NewFlex()
+	column.SetDirection(cui.FlexColumn)
+	column.AddItem(nil, 0, 1, false)
+	column.AddItem(row, width, 0, true)
+	column.AddItem(nil, 0, 1, false)
+	return column
+}
@@ -0,0 +1,76 @@
+package cui
+
+import (
+	"github.com/gdamore/tcell/v2"
+	"github.com/malivvan/aegis/vault"
+	"github.com/malivvan/cui"
+)
+
+// unlockDialog asks for the keyring password until the keyring could be opened and passes it to unlocked, with the
+// reader of the card that unlocked it, if any.
+type unlockDialog struct {
+	app     *cui.Application
+	root    cui.Primitive
+	message *cui.TextView
+	input   *cui.InputField
+}
+
+func newUnlockDialog(app *cui.Application, keyring string, credentials Credentials, unlocked func(v *vault.Vault, reader string)) *unlockDialog {
+	message := cui.NewTextView()
+	message.SetText(keyring)
+	message.SetTextAlign(cui.AlignCenter)
+
+	input := cui.NewInputField()
+	input.SetLabel("Password: ")
+	input.SetMaskCharacter('*')
+	input.SetDoneFunc(func(key tcell.Key) {
+		switch key {
+		case tcell.KeyEscape:
+			app.Stop()
+		case tcell.KeyEnter:
+			password := input.GetText()
+			input.SetText("")
+			message.SetText("unlocking...")
+			go func() {
+				v, reader, err := openVault(keyring, credentials, password)
+				app.QueueUpdateDraw(func() {
+					if err != nil {
+						message.SetText(err.Error())
+						return
+					}
+					unlocked(v, reader)
+				})
+			}()
+		}
+	})
+
+	form := cui.NewFlex()
+	form.SetDirection(cui.FlexRow)
+	form.SetBorder(true)
+	form.SetTitle(" unlock keyring ")
+	form.AddItem(message, 0, 1, false)
+	form.AddItem(input, 1, 0, true)
+	return &unlockDialog{app: app, root: center(form, 60, 5), message: message, input: input}
+}
+
+// setMessage replaces the text above the password input.
+func (d *unlockDialog) setMessage(text string) {
+	d.message.SetText(text)
+}
+
+// show brings the dialog back, e.g. after the keyring has been locked.
+func (d *unlockDialog) show(text string) {
+	d.setMessage(text)
+	d.input.SetText("")
+	d.app.SetRoot(d.root, true)
+	d.app.SetFocus(d.input)
+}
+
+func openVault(keyring string, credentials Credentials, password string) (*vault.Vault, string, error) {
+	creds, reader, err := credentials(password)
+	if err != nil {
+		return nil, "", err
+	}
+	v, err := vault.Open(keyring, creds)
+	return v, reader, err
+}
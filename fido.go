@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/malivvan/aegis/cli"
+	"github.com/malivvan/aegis/fido"
+	"github.com/malivvan/aegis/hid"
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/mgrd"
+	"github.com/malivvan/aegis/vault"
+)
+
+var errNoFIDODevice = errors.New("no FIDO2 security key found")
+
+var fidoCommand = &cli.Command{
+	Name:  "fido",
+	Usage: "manage unlocking the keyring with FIDO2 security keys",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "init",
+			Usage:  "protect the keyring with a key file sealed to the hmac-secret of a FIDO2 security key",
+			Action: fidoInitAction,
+		},
+		{
+			Name:   "add",
+			Usage:  "allow another FIDO2 security key, e.g. a backup token, to unlock the keyring; connect both keys",
+			Action: fidoAddAction,
+		},
+	},
+}
+
+// fidoCredentials unseals the FIDO key file of the keyring at path with the first connected security key.
+func fidoCredentials(path string) (*kdbx.DBCredentials, error) {
+	devices, err := openFIDODevices()
+	if err != nil {
+		return nil, err
+	}
+	defer closeFIDODevices(devices)
+	for _, d := range devices {
+		creds, err := vault.FIDOCredentials(context.Background(), path, d, touchPrompt())
+		if errors.Is(err, vault.ErrNoFIDOCredential) {
+			continue
+		}
+		return creds, err
+	}
+	return nil, vault.ErrNoFIDOCredential
+}
+
+// openFIDODevices opens the FIDO interfaces of all connected security keys.
+func openFIDODevices() ([]*fido.Device, error) {
+	var devices []*fido.Device
+	for dev, err := range hid.Enumerate() {
+		if err != nil {
+			closeFIDODevices(devices)
+			return nil, err
+		}
+		if !dev.IsFIDO() {
+			continue
+		}
+		conn, err := dev.OpenReports()
+		if err != nil {
+			continue
+		}
+		d, err := fido.Open(conn)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		devices = append(devices, d)
+	}
+	if len(devices) == 0 {
+		return nil, errNoFIDODevice
+	}
+	return devices, nil
+}
+
+func closeFIDODevices(devices []*fido.Device) {
+	for _, d := range devices {
+		d.Close()
+	}
+}
+
+// touchPrompt returns a keepalive callback that asks once for touch when the security key waits for it.
+func touchPrompt() hid.Keepalive {
+	prompted := false
+	return func(status int) {
+		if status == fido.STATUS_UPNEEDED && !prompted {
+			prompted = true
+			fmt.Fprintln(os.Stderr, "Touch your security key")
+		}
+	}
+}
+
+// fidoPIN asks for the PIN of a security key.
+func fidoPIN() ([]byte, error) {
+	pin, err := readPassword("Security key PIN: ")
+	return []byte(pin), err
+}
+
+func fidoInitAction(ctx *cli.Context) error {
+	path, err := keyringPath(ctx)
+	if err != nil {
+		return err
+	}
+	devices, err := openFIDODevices()
+	if err != nil {
+		return err
+	}
+	defer closeFIDODevices(devices)
+
+	// an existing keyring is unlocked with its current credentials and saved again with the FIDO key
+	var v *vault.Vault
+	if _, err := os.Stat(path); err == nil {
+		if v, err = openVault(ctx); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	key, err := vault.NewFIDOKey(context.Background(), path, devices[0], fidoPIN, touchPrompt())
+	if err != nil {
+		return err
+	}
+	creds, err := kdbx.NewKeyDataCredentials(key)
+	mgrd.WipeBytes(key)
+	if err == nil {
+		if v == nil {
+			fmt.Fprintf(os.Stderr, "Creating new keyring %s\n", path)
+			v, err = vault.Create(path, creds)
+		} else {
+			v.DB.Credentials = creds
+		}
+	}
+	if err == nil {
+		err = saveVault(v)
+	}
+	if err != nil {
+		os.Remove(vault.FIDOKeyPath(path))
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Keyring %s can now only be unlocked with --fido\n", path)
+	return nil
+}
+
+func fidoAddAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 0); err != nil {
+		return err
+	}
+	path, err := keyringPath(ctx)
+	if err != nil {
+		return err
+	}
+	devices, err := openFIDODevices()
+	if err != nil {
+		return err
+	}
+	defer closeFIDODevices(devices)
+	if len(devices) != 2 {
+		return fmt.Errorf("connect exactly the security key that unlocks the keyring and the new one, found %d", len(devices))
+	}
+	err = vault.AddFIDOKey(context.Background(), path, devices[0], devices[1], fidoPIN, touchPrompt())
+	if errors.Is(err, vault.ErrNoFIDOCredential) {
+		err = vault.AddFIDOKey(context.Background(), path, devices[1], devices[0], fidoPIN, touchPrompt())
+	}
+	return err
+}
@@ -0,0 +1,291 @@
+package fido
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// The subset of CBOR used by CTAP2: integers, byte and text strings, arrays, maps, booleans and null. Maps are
+// encoded in the canonical order CTAP2 requires, decoded integers are of type int and decoded maps of type cborMap
+// with int or string keys.
+
+const (
+	cborTypeUint   = 0
+	cborTypeNegint = 1
+	cborTypeBytes  = 2
+	cborTypeText   = 3
+	cborTypeArray  = 4
+	cborTypeMap    = 5
+	cborTypeSimple = 7
+
+	cborFalse = 20
+	cborTrue  = 21
+	cborNull  = 22
+
+	// cborMaxDepth limits the nesting of decoded arrays and maps
+	cborMaxDepth = 16
+)
+
+type cborMap map[any]any
+
+func cborHeader(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n <= math.MaxUint8:
+		return []byte{major<<5 | 24, byte(n)}
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(n))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, n)
+}
+
+func cborInt(i int64) []byte {
+	if i < 0 {
+		return cborHeader(cborTypeNegint, uint64(-1-i))
+	}
+	return cborHeader(cborTypeUint, uint64(i))
+}
+
+// marshalCBOR encodes v, which is built of the types that unmarshalCBOR returns and int, int64, uint8, uint64,
+// []string, []any, []cborMap and map[string]bool.
+func marshalCBOR(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case nil:
+		return []byte{cborTypeSimple<<5 | cborNull}, nil
+	case bool:
+		if v {
+			return []byte{cborTypeSimple<<5 | cborTrue}, nil
+		}
+		return []byte{cborTypeSimple<<5 | cborFalse}, nil
+	case int:
+		return cborInt(int64(v)), nil
+	case int64:
+		return cborInt(v), nil
+	case uint8:
+		return cborHeader(cborTypeUint, uint64(v)), nil
+	case uint64:
+		return cborHeader(cborTypeUint, v), nil
+	case []byte:
+		return append(cborHeader(cborTypeBytes, uint64(len(v))), v...), nil
+	case string:
+		return append(cborHeader(cborTypeText, uint64(len(v))), v...), nil
+	case []string:
+		b := cborHeader(cborTypeArray, uint64(len(v)))
+		for _, s := range v {
+			b = append(b, cborHeader(cborTypeText, uint64(len(s)))...)
+			b = append(b, s...)
+		}
+		return b, nil
+	case []cborMap:
+		items := make([]any, len(v))
+		for i := range v {
+			items[i] = v[i]
+		}
+		return marshalCBOR(items)
+	case []any:
+		b := cborHeader(cborTypeArray, uint64(len(v)))
+		for _, item := range v {
+			e, err := marshalCBOR(item)
+			if err != nil {
+				return nil, err
+			}
+			b = append(b, e...)
+		}
+		return b, nil
+	case map[string]bool:
+		m := make(cborMap, len(v))
+		for k, b := range v {
+			m[k] = b
+		}
+		return marshalCBOR(m)
+	case cborMap:
+		type entry struct{ key, value []byte }
+		entries := make([]entry, 0, len(v))
+		for k, value := range v {
+			ek, err := marshalCBOR(k)
+			if err != nil {
+				return nil, err
+			}
+			ev, err := marshalCBOR(value)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry{ek, ev})
+		}
+		// canonical order: shorter keys first, keys of the same length in lexical order
+		sort.Slice(entries, func(i, j int) bool {
+			if len(entries[i].key) != len(entries[j].key) {
+				return len(entries[i].key) < len(entries[j].key)
+			}
+			return bytes.Compare(entries[i].key, entries[j].key) < 0
+		})
+		b := cborHeader(cborTypeMap, uint64(len(entries)))
+		for _, e := range entries {
+			b = append(append(b, e.key...), e.value...)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("cannot encode %T as CBOR", v)
+}
+
+// unmarshalCBOR decodes the first item of b and returns it with the remaining bytes.
+func unmarshalCBOR(b []byte) (any, []byte, error) {
+	return decodeCBOR(b, 0)
+}
+
+func decodeCBOR(b []byte, depth int) (any, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, fmt.Errorf("%w: nested too deeply", ErrInvalidCBOR)
+	}
+	if len(b) == 0 {
+		return nil, nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	b = b[1:]
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(b) < size {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+		}
+		for _, c := range b[:size] {
+			n = n<<8 | uint64(c)
+		}
+		b = b[size:]
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported additional information %d", ErrInvalidCBOR, info)
+	}
+
+	switch major {
+	case cborTypeUint:
+		if n > math.MaxInt {
+			return nil, nil, fmt.Errorf("%w: integer overflow", ErrInvalidCBOR)
+		}
+		return int(n), b, nil
+	case cborTypeNegint:
+		if n > math.MaxInt {
+			return nil, nil, fmt.Errorf("%w: integer overflow", ErrInvalidCBOR)
+		}
+		return -1 - int(n), b, nil
+	case cborTypeBytes, cborTypeText:
+		if n > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+		}
+		if major == cborTypeText {
+			return string(b[:n]), b[n:], nil
+		}
+		return append([]byte(nil), b[:n]...), b[n:], nil
+	case cborTypeArray:
+		if n > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+		}
+		items := make([]any, n)
+		for i := range items {
+			var err error
+			if items[i], b, err = decodeCBOR(b, depth+1); err != nil {
+				return nil, nil, err
+			}
+		}
+		return items, b, nil
+	case cborTypeMap:
+		if n > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("%w: unexpected end of data", ErrInvalidCBOR)
+		}
+		m := make(cborMap, n)
+		for i := uint64(0); i < n; i++ {
+			k, rest, err := decodeCBOR(b, depth+1)
+			if err != nil {
+				return nil, nil, err
+			}
+			switch k.(type) {
+			case int, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported map key %T", ErrInvalidCBOR, k)
+			}
+			if _, ok := m[k]; ok {
+				return nil, nil, fmt.Errorf("%w: duplicate map key %v", ErrInvalidCBOR, k)
+			}
+			if m[k], b, err = decodeCBOR(rest, depth+1); err != nil {
+				return nil, nil, err
+			}
+		}
+		return m, b, nil
+	case cborTypeSimple:
+		switch info {
+		case cborFalse:
+			return false, b, nil
+		case cborTrue:
+			return true, b, nil
+		case cborNull:
+			return nil, b, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("%w: unsupported major type %d", ErrInvalidCBOR, major)
+}
+
+// decodeCBORMap decodes b, which must hold exactly one map.
+func decodeCBORMap(b []byte) (cborMap, error) {
+	v, rest, err := unmarshalCBOR(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrInvalidCBOR, len(rest))
+	}
+	m, ok := v.(cborMap)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected a map, got %T", ErrInvalidCBOR, v)
+	}
+	return m, nil
+}
+
+func (m cborMap) bytes(key any) ([]byte, bool) {
+	b, ok := m[key].([]byte)
+	return b, ok
+}
+
+func (m cborMap) text(key any) (string, bool) {
+	s, ok := m[key].(string)
+	return s, ok
+}
+
+func (m cborMap) int(key any) (int, bool) {
+	i, ok := m[key].(int)
+	return i, ok
+}
+
+func (m cborMap) bool(key any) (bool, bool) {
+	b, ok := m[key].(bool)
+	return b, ok
+}
+
+func (m cborMap) mapValue(key any) (cborMap, bool) {
+	v, ok := m[key].(cborMap)
+	return v, ok
+}
+
+func (m cborMap) array(key any) ([]any, bool) {
+	v, ok := m[key].([]any)
+	return v, ok
+}
+
+// strings returns the text strings of the array at key.
+func (m cborMap) strings(key any) []string {
+	items, _ := m.array(key)
+	var s []string
+	for _, item := range items {
+		if text, ok := item.(string); ok {
+			s = append(s, text)
+		}
+	}
+	return s
+}
@@ -0,0 +1,65 @@
+package fido
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCBOR(t *testing.T) {
+	for _, test := range []struct {
+		value any
+		hex   string
+	}{
+		{0, "00"},
+		{23, "17"},
+		{24, "1818"},
+		{1000, "1903e8"},
+		{-1, "20"},
+		{-25, "3818"},
+		{[]byte{1, 2}, "420102"},
+		{"id", "626964"},
+		{true, "f5"},
+		{nil, "f6"},
+		{[]any{1, "a"}, "82016161"},
+		// canonical order: integer keys before longer text keys, shorter text keys first
+		{cborMap{"type": "x", 3: -7, "id": []byte{0}, -1: 1}, "a403262001626964410064747970656178"},
+	} {
+		b, err := marshalCBOR(test.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(b) != test.hex {
+			t.Fatalf("Expected %s for %v, got %x", test.hex, test.value, b)
+		}
+		v, rest, err := unmarshalCBOR(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rest) != 0 || !reflect.DeepEqual(v, test.value) {
+			t.Fatalf("Expected %#v, got %#v", test.value, v)
+		}
+	}
+}
+
+func TestCBOR_Invalid(t *testing.T) {
+	for _, b := range [][]byte{
+		{},
+		{0x19, 0x01},             // truncated integer
+		{0x43, 0x01},             // truncated byte string
+		{0xa2, 0x01, 0x01, 0x01}, // missing value
+		{0xa2, 0x01, 0x01, 0x01, 0x02},
+		{0xa1, 0x41, 0x00, 0x01}, // byte string key
+		{0xfb},                   // float
+		bytes.Repeat([]byte{0x81}, cborMaxDepth+2),
+	} {
+		if _, _, err := unmarshalCBOR(b); !errors.Is(err, ErrInvalidCBOR) {
+			t.Errorf("Expected ErrInvalidCBOR for %x, got %v", b, err)
+		}
+	}
+	if _, err := decodeCBORMap([]byte{0xa0, 0x00}); !errors.Is(err, ErrInvalidCBOR) {
+		t.Fatalf("Expected ErrInvalidCBOR for trailing bytes, got %v", err)
+	}
+}
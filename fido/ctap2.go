@@ -0,0 +1,561 @@
+package fido
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/malivvan/aegis/hid"
+	"github.com/malivvan/aegis/mgrd"
+)
+
+// CTAP2 commands
+const (
+	CMD_MAKE_CREDENTIAL = 0x01
+	CMD_GET_ASSERTION   = 0x02
+	CMD_GET_INFO        = 0x04
+	CMD_CLIENT_PIN      = 0x06
+
+	CLIENT_PIN_GET_KEY_AGREEMENT = 0x02
+	CLIENT_PIN_GET_PIN_TOKEN     = 0x05
+
+	// COSE algorithm identifiers
+	COSE_ES256       = -7
+	COSE_EDDSA       = -8
+	COSE_ECDH_HKDF   = -25
+	COSE_KTY_OKP     = 1
+	COSE_KTY_EC2     = 2
+	COSE_CRV_P256    = 1
+	COSE_CRV_ED25519 = 6
+
+	// flags of authenticator data
+	FLAG_UP = 0x01 // user present
+	FLAG_UV = 0x04 // user verified
+	FLAG_AT = 0x40 // attested credential data included
+	FLAG_ED = 0x80 // extension data included
+
+	ExtensionHMACSecret = "hmac-secret"
+)
+
+var (
+	ErrInvalidCommand        = errors.New("invalid command")
+	ErrInvalidParameter      = errors.New("invalid parameter")
+	ErrInvalidLength         = errors.New("invalid length")
+	ErrInvalidCBOR           = errors.New("invalid CBOR")
+	ErrMissingParameter      = errors.New("missing parameter")
+	ErrUnsupportedExtension  = errors.New("unsupported extension")
+	ErrCredentialExcluded    = errors.New("credential excluded")
+	ErrUnsupportedAlgorithm  = errors.New("unsupported algorithm")
+	ErrOperationDenied       = errors.New("operation denied")
+	ErrKeyStoreFull          = errors.New("key store full")
+	ErrUnsupportedOption     = errors.New("unsupported option")
+	ErrInvalidOption         = errors.New("invalid option")
+	ErrKeepaliveCancel       = errors.New("cancelled")
+	ErrNoCredentials         = errors.New("no credentials")
+	ErrUserActionTimeout     = errors.New("user action timeout")
+	ErrPinInvalid            = errors.New("PIN invalid")
+	ErrPinBlocked            = errors.New("PIN blocked")
+	ErrPinAuthInvalid        = errors.New("PIN authentication invalid")
+	ErrPinNotSet             = errors.New("PIN not set")
+	ErrPinRequired           = errors.New("PIN required")
+	ErrInvalidSubcommand     = errors.New("invalid subcommand")
+	ErrUnknownStatus         = errors.New("unknown status")
+	errInvalidAuthenticator  = errors.New("invalid authenticator data")
+	errHMACSecretUnsupported = errors.New("authenticator does not support hmac-secret")
+)
+
+var statusCodes = map[byte]error{
+	0x01: ErrInvalidCommand,
+	0x02: ErrInvalidParameter,
+	0x03: ErrInvalidLength,
+	0x12: ErrInvalidCBOR,
+	0x14: ErrMissingParameter,
+	0x16: ErrUnsupportedExtension,
+	0x19: ErrCredentialExcluded,
+	0x26: ErrUnsupportedAlgorithm,
+	0x27: ErrOperationDenied,
+	0x28: ErrKeyStoreFull,
+	0x2B: ErrUnsupportedOption,
+	0x2C: ErrInvalidOption,
+	0x2D: ErrKeepaliveCancel,
+	0x2E: ErrNoCredentials,
+	0x2F: ErrUserActionTimeout,
+	0x31: ErrPinInvalid,
+	0x32: ErrPinBlocked,
+	0x33: ErrPinAuthInvalid,
+	0x35: ErrPinNotSet,
+	0x36: ErrPinRequired,
+	0x3E: ErrInvalidSubcommand,
+}
+
+// StatusError is returned for a CTAP2 response whose status is not CTAP2_OK. It matches the error the status is
+// classified as with errors.Is, e.g. ErrNoCredentials for 0x2E.
+type StatusError struct {
+	Status byte
+	Err    error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("ctap2: %s (%02X)", e.Err, e.Status)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+func statusError(status byte) error {
+	err, ok := statusCodes[status]
+	if !ok {
+		err = ErrUnknownStatus
+	}
+	return &StatusError{Status: status, Err: err}
+}
+
+// statusCode returns the status that err is classified as, for responses of the Simulator.
+func statusCode(err error) byte {
+	for status, e := range statusCodes {
+		if errors.Is(err, e) {
+			return status
+		}
+	}
+	return 0x7F
+}
+
+// cbor sends a CTAP2 command with an optional request map and returns the response map.
+func (d *Device) cbor(ctx context.Context, command byte, request cborMap, onKeepalive hid.Keepalive) (cborMap, error) {
+	msg := []byte{command}
+	if request != nil {
+		b, err := marshalCBOR(request)
+		if err != nil {
+			return nil, err
+		}
+		msg = append(msg, b...)
+	}
+	resp, err := d.transact(ctx, CTAPHID_CBOR, msg, onKeepalive)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 1 {
+		return nil, fmt.Errorf("ctap2: empty response")
+	}
+	if resp[0] != 0 {
+		return nil, statusError(resp[0])
+	}
+	if len(resp) == 1 {
+		return cborMap{}, nil
+	}
+	return decodeCBORMap(resp[1:])
+}
+
+// Info is the response of authenticatorGetInfo.
+type Info struct {
+	Versions           []string
+	Extensions         []string
+	AAGUID             []byte
+	Options            map[string]bool
+	MaxMsgSize         int
+	PinUvAuthProtocols []int
+}
+
+// Extension reports whether the authenticator supports the extension name.
+func (info *Info) Extension(name string) bool {
+	for _, e := range info.Extensions {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+// GetInfo returns the versions, extensions and options the authenticator supports.
+func (d *Device) GetInfo(ctx context.Context) (*Info, error) {
+	m, err := d.cbor(ctx, CMD_GET_INFO, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	info := &Info{
+		Versions:   m.strings(1),
+		Extensions: m.strings(2),
+		Options:    make(map[string]bool),
+	}
+	info.AAGUID, _ = m.bytes(3)
+	if options, ok := m.mapValue(4); ok {
+		for k, v := range options {
+			name, ok1 := k.(string)
+			value, ok2 := v.(bool)
+			if ok1 && ok2 {
+				info.Options[name] = value
+			}
+		}
+	}
+	info.MaxMsgSize, _ = m.int(5)
+	protocols, _ := m.array(6)
+	for _, p := range protocols {
+		if version, ok := p.(int); ok {
+			info.PinUvAuthProtocols = append(info.PinUvAuthProtocols, version)
+		}
+	}
+	return info, nil
+}
+
+// RelyingParty identifies the relying party a credential is scoped to.
+type RelyingParty struct {
+	ID   string
+	Name string
+}
+
+// User is the user account a credential is created for.
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// MakeCredentialRequest are the parameters of authenticatorMakeCredential.
+type MakeCredentialRequest struct {
+	ClientDataHash []byte
+	RP             RelyingParty
+	User           User
+	// HMACSecret enables the hmac-secret extension for the credential.
+	HMACSecret bool
+	// PinToken verifies the user on authenticators with a PIN, which refuse to create credentials without it.
+	PinToken *PinToken
+}
+
+// Credential is a credential created by MakeCredential.
+type Credential struct {
+	ID        []byte
+	PublicKey crypto.PublicKey
+	// HMACSecret reports whether the hmac-secret extension is enabled for the credential.
+	HMACSecret bool
+	AuthData   []byte
+}
+
+// MakeCredential creates a non-discoverable ES256 or EdDSA credential, which requires the user to touch the
+// authenticator.
+func (d *Device) MakeCredential(ctx context.Context, req *MakeCredentialRequest, onKeepalive hid.Keepalive) (*Credential, error) {
+	rp := cborMap{"id": req.RP.ID}
+	if req.RP.Name != "" {
+		rp["name"] = req.RP.Name
+	}
+	user := cborMap{"id": req.User.ID}
+	if req.User.Name != "" {
+		user["name"] = req.User.Name
+	}
+	if req.User.DisplayName != "" {
+		user["displayName"] = req.User.DisplayName
+	}
+	request := cborMap{
+		1: req.ClientDataHash,
+		2: rp,
+		3: user,
+		4: []cborMap{{"alg": COSE_ES256, "type": "public-key"}, {"alg": COSE_EDDSA, "type": "public-key"}},
+	}
+	if req.HMACSecret {
+		request[6] = cborMap{ExtensionHMACSecret: true}
+	}
+	if req.PinToken != nil {
+		request[8] = req.PinToken.protocol.authenticate(req.PinToken.token, req.ClientDataHash)
+		request[9] = req.PinToken.protocol.version()
+	}
+	resp, err := d.cbor(ctx, CMD_MAKE_CREDENTIAL, request, onKeepalive)
+	if err != nil {
+		return nil, err
+	}
+	authData, ok := resp.bytes(2)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing authenticator data", errInvalidAuthenticator)
+	}
+	ad, err := parseAuthData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if ad.rpIDHash != sha256.Sum256([]byte(req.RP.ID)) {
+		return nil, fmt.Errorf("%w: relying party ID hash mismatch", errInvalidAuthenticator)
+	}
+	if ad.credentialID == nil {
+		return nil, fmt.Errorf("%w: missing attested credential data", errInvalidAuthenticator)
+	}
+	c := &Credential{ID: ad.credentialID, PublicKey: ad.publicKey, AuthData: authData}
+	c.HMACSecret, _ = ad.extensions.bool(ExtensionHMACSecret)
+	return c, nil
+}
+
+// GetAssertionRequest are the parameters of authenticatorGetAssertion.
+type GetAssertionRequest struct {
+	RPID           string
+	ClientDataHash []byte
+	AllowList      [][]byte
+	// HMACSecretSalt are one or two salts of 32 bytes to evaluate the hmac-secret of the credential with.
+	HMACSecretSalt []byte
+}
+
+// Assertion is the response of GetAssertion.
+type Assertion struct {
+	CredentialID []byte
+	AuthData     []byte
+	Signature    []byte
+	SignCount    uint32
+	// HMACSecret holds the outputs of the hmac-secret extension for the salts of the request.
+	HMACSecret []byte
+}
+
+// Verify checks the signature of a over its authenticator data and clientDataHash with the public key of c.
+func (c *Credential) Verify(a *Assertion, clientDataHash []byte) bool {
+	signed := append(append([]byte(nil), a.AuthData...), clientDataHash...)
+	switch key := c.PublicKey.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(signed)
+		return ecdsa.VerifyASN1(key, digest[:], a.Signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, signed, a.Signature)
+	}
+	return false
+}
+
+// GetAssertion signs clientDataHash with one of the credentials of the allow list, which requires the user to
+// touch the authenticator. With HMACSecretSalt set, the hmac-secret extension is evaluated over a shared secret
+// agreed with the authenticator.
+func (d *Device) GetAssertion(ctx context.Context, req *GetAssertionRequest, onKeepalive hid.Keepalive) (*Assertion, error) {
+	request := cborMap{1: req.RPID, 2: req.ClientDataHash}
+	if len(req.AllowList) > 0 {
+		allow := make([]cborMap, len(req.AllowList))
+		for i, id := range req.AllowList {
+			allow[i] = cborMap{"id": id, "type": "public-key"}
+		}
+		request[3] = allow
+	}
+	var protocol pinProtocol
+	var sharedSecret []byte
+	if req.HMACSecretSalt != nil {
+		if len(req.HMACSecretSalt) != 32 && len(req.HMACSecretSalt) != 64 {
+			return nil, fmt.Errorf("hmac-secret salt must be 32 or 64 bytes, got %d", len(req.HMACSecretSalt))
+		}
+		info, err := d.GetInfo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !info.Extension(ExtensionHMACSecret) {
+			return nil, errHMACSecretUnsupported
+		}
+		protocol = selectPinProtocol(info.PinUvAuthProtocols)
+		platformKey, secret, err := d.keyAgreement(ctx, protocol)
+		if err != nil {
+			return nil, err
+		}
+		sharedSecret = secret
+		saltEnc, err := protocol.encrypt(sharedSecret, req.HMACSecretSalt)
+		if err != nil {
+			return nil, err
+		}
+		input := cborMap{1: platformKey, 2: saltEnc, 3: protocol.authenticate(sharedSecret, saltEnc)}
+		if protocol.version() != 1 {
+			input[4] = protocol.version()
+		}
+		request[4] = cborMap{ExtensionHMACSecret: input}
+	}
+	resp, err := d.cbor(ctx, CMD_GET_ASSERTION, request, onKeepalive)
+	if err != nil {
+		return nil, err
+	}
+	a := &Assertion{}
+	if credential, ok := resp.mapValue(1); ok {
+		a.CredentialID, _ = credential.bytes("id")
+	} else if len(req.AllowList) == 1 {
+		a.CredentialID = req.AllowList[0]
+	}
+	var ok bool
+	if a.AuthData, ok = resp.bytes(2); !ok {
+		return nil, fmt.Errorf("%w: missing authenticator data", errInvalidAuthenticator)
+	}
+	if a.Signature, ok = resp.bytes(3); !ok {
+		return nil, fmt.Errorf("%w: missing signature", errInvalidAuthenticator)
+	}
+	ad, err := parseAuthData(a.AuthData)
+	if err != nil {
+		return nil, err
+	}
+	if ad.rpIDHash != sha256.Sum256([]byte(req.RPID)) {
+		return nil, fmt.Errorf("%w: relying party ID hash mismatch", errInvalidAuthenticator)
+	}
+	a.SignCount = ad.signCount
+	if protocol != nil {
+		output, ok := ad.extensions.bytes(ExtensionHMACSecret)
+		if !ok {
+			return nil, fmt.Errorf("%w: missing hmac-secret output", errInvalidAuthenticator)
+		}
+		if a.HMACSecret, err = protocol.decrypt(sharedSecret, output); err != nil {
+			return nil, err
+		}
+		if len(a.HMACSecret) != len(req.HMACSecretSalt) {
+			return nil, fmt.Errorf("%w: hmac-secret output of %d bytes", errInvalidAuthenticator, len(a.HMACSecret))
+		}
+	}
+	return a, nil
+}
+
+// PinToken is a pinUvAuthToken of an authenticator, obtained with its PIN.
+type PinToken struct {
+	protocol pinProtocol
+	token    []byte
+}
+
+// GetPinToken verifies pin with the authenticator and returns the token that proves it to MakeCredential. A wrong
+// PIN fails with ErrPinInvalid, ErrPinBlocked once the authenticator ran out of retries and ErrPinNotSet if the
+// authenticator has no PIN.
+func (d *Device) GetPinToken(ctx context.Context, pin []byte) (*PinToken, error) {
+	info, err := d.GetInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Options["clientPin"] {
+		return nil, ErrPinNotSet
+	}
+	protocol := selectPinProtocol(info.PinUvAuthProtocols)
+	platformKey, sharedSecret, err := d.keyAgreement(ctx, protocol)
+	if err != nil {
+		return nil, err
+	}
+	defer mgrd.WipeBytes(sharedSecret)
+	pinHash := sha256.Sum256(pin)
+	defer mgrd.WipeBytes(pinHash[:])
+	pinHashEnc, err := protocol.encrypt(sharedSecret, pinHash[:16])
+	if err != nil {
+		return nil, err
+	}
+	resp, err := d.cbor(ctx, CMD_CLIENT_PIN, cborMap{
+		1: protocol.version(),
+		2: CLIENT_PIN_GET_PIN_TOKEN,
+		3: platformKey,
+		6: pinHashEnc,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	tokenEnc, ok := resp.bytes(2)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing PIN token", errInvalidAuthenticator)
+	}
+	token, err := protocol.decrypt(sharedSecret, tokenEnc)
+	if err != nil {
+		return nil, err
+	}
+	return &PinToken{protocol: protocol, token: token}, nil
+}
+
+// Destroy wipes the token.
+func (t *PinToken) Destroy() {
+	mgrd.WipeBytes(t.token)
+}
+
+// keyAgreement agrees on a shared secret with the authenticator and returns the COSE key of the platform.
+func (d *Device) keyAgreement(ctx context.Context, protocol pinProtocol) (cborMap, []byte, error) {
+	resp, err := d.cbor(ctx, CMD_CLIENT_PIN, cborMap{1: protocol.version(), 2: CLIENT_PIN_GET_KEY_AGREEMENT}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	cose, ok := resp.mapValue(1)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: missing key agreement", errInvalidAuthenticator)
+	}
+	peer, err := parseECDHKey(cose)
+	if err != nil {
+		return nil, nil, err
+	}
+	return encapsulate(protocol, peer)
+}
+
+// authData is the parsed authenticator data of a credential or assertion.
+type authData struct {
+	rpIDHash     [32]byte
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    crypto.PublicKey
+	extensions   cborMap
+}
+
+func parseAuthData(b []byte) (*authData, error) {
+	if len(b) < 37 {
+		return nil, fmt.Errorf("%w: %d bytes", errInvalidAuthenticator, len(b))
+	}
+	ad := &authData{flags: b[32], signCount: binary.BigEndian.Uint32(b[33:])}
+	copy(ad.rpIDHash[:], b)
+	rest := b[37:]
+	if ad.flags&FLAG_AT != 0 {
+		if len(rest) < 18 {
+			return nil, fmt.Errorf("%w: truncated attested credential data", errInvalidAuthenticator)
+		}
+		n := int(binary.BigEndian.Uint16(rest[16:]))
+		rest = rest[18:]
+		if len(rest) < n {
+			return nil, fmt.Errorf("%w: truncated credential ID", errInvalidAuthenticator)
+		}
+		ad.credentialID, rest = rest[:n], rest[n:]
+		key, r, err := unmarshalCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		cose, ok := key.(cborMap)
+		if !ok {
+			return nil, fmt.Errorf("%w: invalid credential public key", errInvalidAuthenticator)
+		}
+		if ad.publicKey, err = parseCOSEKey(cose); err != nil {
+			return nil, err
+		}
+		rest = r
+	}
+	ad.extensions = cborMap{}
+	if ad.flags&FLAG_ED != 0 {
+		var err error
+		if ad.extensions, err = decodeCBORMap(rest); err != nil {
+			return nil, err
+		}
+		rest = nil
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: %d trailing bytes", errInvalidAuthenticator, len(rest))
+	}
+	return ad, nil
+}
+
+// parseCOSEKey returns the ES256 or EdDSA public key of a COSE key.
+func parseCOSEKey(cose cborMap) (crypto.PublicKey, error) {
+	kty, _ := cose.int(1)
+	alg, _ := cose.int(3)
+	crv, _ := cose.int(-1)
+	x, _ := cose.bytes(-2)
+	switch {
+	case kty == COSE_KTY_EC2 && alg == COSE_ES256 && crv == COSE_CRV_P256:
+		y, _ := cose.bytes(-3)
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+	case kty == COSE_KTY_OKP && alg == COSE_EDDSA && crv == COSE_CRV_ED25519 && len(x) == ed25519.PublicKeySize:
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("%w: key type %d, algorithm %d", ErrUnsupportedAlgorithm, kty, alg)
+}
+
+// parseECDHKey returns the P-256 public key of a COSE key agreement key.
+func parseECDHKey(cose cborMap) (*ecdh.PublicKey, error) {
+	kty, _ := cose.int(1)
+	crv, _ := cose.int(-1)
+	x, _ := cose.bytes(-2)
+	y, _ := cose.bytes(-3)
+	if kty != COSE_KTY_EC2 || crv != COSE_CRV_P256 || len(x) != 32 || len(y) != 32 {
+		return nil, fmt.Errorf("%w: invalid key agreement key", ErrInvalidParameter)
+	}
+	return ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...))
+}
+
+// coseECDHKey encodes a P-256 public key for key agreement.
+func coseECDHKey(key *ecdh.PublicKey) cborMap {
+	point := key.Bytes()
+	return cborMap{1: COSE_KTY_EC2, 3: COSE_ECDH_HKDF, -1: COSE_CRV_P256, -2: point[1:33], -3: point[33:]}
+}
@@ -0,0 +1,236 @@
+// Package fido talks CTAP2 to FIDO2 security keys over CTAPHID, the framing of the FIDO HID interface, and derives
+// secrets with the hmac-secret extension. Simulator is a software authenticator for testing without a device.
+package fido
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/malivvan/aegis/hid"
+)
+
+// CTAPHID commands
+const (
+	CTAPHID_PING      = 0x81
+	CTAPHID_MSG       = 0x83
+	CTAPHID_INIT      = 0x86
+	CTAPHID_WINK      = 0x88
+	CTAPHID_CBOR      = 0x90
+	CTAPHID_CANCEL    = 0x91
+	CTAPHID_KEEPALIVE = 0xBB
+	CTAPHID_ERROR     = 0xBF
+
+	CID_BROADCAST = 0xFFFFFFFF
+
+	INIT_DATA_SIZE = hid.REPORT_SIZE - 7 // payload of an initialization packet
+	CONT_DATA_SIZE = hid.REPORT_SIZE - 5 // payload of a continuation packet
+	MAX_MSG_SIZE   = INIT_DATA_SIZE + 0x80*CONT_DATA_SIZE
+
+	CAPABILITY_WINK = 0x01
+	CAPABILITY_CBOR = 0x04
+	CAPABILITY_NMSG = 0x08
+
+	// keepalive status codes, equal to hid.STATUS_PROCESSING and hid.STATUS_UPNEEDED
+	STATUS_PROCESSING = 1
+	STATUS_UPNEEDED   = 2
+)
+
+// CTAPHID error codes
+const (
+	ERR_INVALID_CMD     = 0x01
+	ERR_INVALID_PAR     = 0x02
+	ERR_INVALID_LEN     = 0x03
+	ERR_INVALID_SEQ     = 0x04
+	ERR_MSG_TIMEOUT     = 0x05
+	ERR_CHANNEL_BUSY    = 0x06
+	ERR_LOCK_REQUIRED   = 0x0A
+	ERR_INVALID_CHANNEL = 0x0B
+	ERR_OTHER           = 0x7F
+)
+
+// HIDError is a CTAPHID_ERROR response.
+type HIDError byte
+
+func (e HIDError) Error() string {
+	switch e {
+	case ERR_INVALID_CMD:
+		return "ctaphid: invalid command"
+	case ERR_INVALID_PAR:
+		return "ctaphid: invalid parameter"
+	case ERR_INVALID_LEN:
+		return "ctaphid: invalid message length"
+	case ERR_INVALID_SEQ:
+		return "ctaphid: invalid message sequencing"
+	case ERR_MSG_TIMEOUT:
+		return "ctaphid: message timed out"
+	case ERR_CHANNEL_BUSY:
+		return "ctaphid: channel busy"
+	case ERR_LOCK_REQUIRED:
+		return "ctaphid: command requires channel lock"
+	case ERR_INVALID_CHANNEL:
+		return "ctaphid: invalid channel"
+	}
+	return fmt.Sprintf("ctaphid: error %02x", byte(e))
+}
+
+// DeviceVersion is the version an authenticator reports in the CTAPHID_INIT response.
+type DeviceVersion struct {
+	Protocol, Major, Minor, Build uint8
+}
+
+// Device is a FIDO authenticator on a CTAPHID channel of its own.
+type Device struct {
+	conn         hid.ReportConn
+	cid          uint32
+	Version      DeviceVersion
+	Capabilities uint8
+	mu           sync.Mutex
+}
+
+// Open allocates a channel on the FIDO interface behind conn, e.g. opened with hid.Device.OpenReports.
+func Open(conn hid.ReportConn) (*Device, error) {
+	d := &Device{conn: conn, cid: CID_BROADCAST}
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	resp, err := d.transact(context.Background(), CTAPHID_INIT, nonce, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp) < 17 || string(resp[:8]) != string(nonce) {
+		return nil, errors.New("ctaphid: invalid INIT response")
+	}
+	d.cid = binary.BigEndian.Uint32(resp[8:])
+	d.Version = DeviceVersion{Protocol: resp[12], Major: resp[13], Minor: resp[14], Build: resp[15]}
+	d.Capabilities = resp[16]
+	return d, nil
+}
+
+func (d *Device) Close() error { return d.conn.Close() }
+
+// Ping sends data to the authenticator, which echoes it.
+func (d *Device) Ping(ctx context.Context, data []byte) ([]byte, error) {
+	return d.transact(ctx, CTAPHID_PING, data, nil)
+}
+
+// Wink makes the authenticator blink, if it has the wink capability.
+func (d *Device) Wink(ctx context.Context) error {
+	if d.Capabilities&CAPABILITY_WINK == 0 {
+		return HIDError(ERR_INVALID_CMD)
+	}
+	_, err := d.transact(ctx, CTAPHID_WINK, nil, nil)
+	return err
+}
+
+// transact sends a message and returns the response. While the authenticator processes the message, onKeepalive
+// receives STATUS_PROCESSING or STATUS_UPNEEDED. If ctx is done, the command is cancelled with CTAPHID_CANCEL.
+func (d *Device) transact(ctx context.Context, cmd byte, data []byte, onKeepalive hid.Keepalive) ([]byte, error) {
+	if onKeepalive == nil {
+		onKeepalive = func(int) {}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.send(cmd, data); err != nil {
+		return nil, err
+	}
+	cancelled := false
+	for {
+		if !cancelled && ctx.Err() != nil {
+			if err := d.send(CTAPHID_CANCEL, nil); err != nil {
+				return nil, err
+			}
+			cancelled = true
+		}
+		respCmd, resp, err := d.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch respCmd {
+		case CTAPHID_KEEPALIVE:
+			if len(resp) > 0 {
+				onKeepalive(int(resp[0]))
+			}
+			continue
+		case CTAPHID_ERROR:
+			if len(resp) < 1 {
+				return nil, HIDError(ERR_OTHER)
+			}
+			return nil, HIDError(resp[0])
+		case cmd:
+			if cancelled {
+				return nil, ctx.Err()
+			}
+			return resp, nil
+		}
+		return nil, fmt.Errorf("ctaphid: unexpected response command %02x", respCmd)
+	}
+}
+
+// send splits a message into an initialization packet and continuation packets.
+func (d *Device) send(cmd byte, data []byte) error {
+	if len(data) > MAX_MSG_SIZE {
+		return HIDError(ERR_INVALID_LEN)
+	}
+	packet := make([]byte, hid.REPORT_SIZE)
+	binary.BigEndian.PutUint32(packet, d.cid)
+	packet[4] = cmd
+	binary.BigEndian.PutUint16(packet[5:], uint16(len(data)))
+	n := copy(packet[7:], data)
+	data = data[n:]
+	if _, err := d.conn.Write(packet); err != nil {
+		return err
+	}
+	for seq := byte(0); len(data) > 0; seq++ {
+		packet = make([]byte, hid.REPORT_SIZE)
+		binary.BigEndian.PutUint32(packet, d.cid)
+		packet[4] = seq
+		n := copy(packet[5:], data)
+		data = data[n:]
+		if _, err := d.conn.Write(packet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receive reassembles the next message on the channel of d, skipping packets of other channels.
+func (d *Device) receive() (byte, []byte, error) {
+	packet := make([]byte, hid.REPORT_SIZE)
+	var cmd byte
+	var size int
+	for {
+		n, err := d.conn.Read(packet)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n < 7 || binary.BigEndian.Uint32(packet) != d.cid || packet[4]&0x80 == 0 {
+			continue
+		}
+		cmd = packet[4]
+		size = int(binary.BigEndian.Uint16(packet[5:]))
+		break
+	}
+	if size > MAX_MSG_SIZE {
+		return 0, nil, HIDError(ERR_INVALID_LEN)
+	}
+	data := append([]byte(nil), packet[7:7+min(size, INIT_DATA_SIZE)]...)
+	for seq := byte(0); len(data) < size; seq++ {
+		n, err := d.conn.Read(packet)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n < 5 || binary.BigEndian.Uint32(packet) != d.cid {
+			continue
+		}
+		if packet[4] != seq {
+			return 0, nil, HIDError(ERR_INVALID_SEQ)
+		}
+		data = append(data, packet[5:5+min(size-len(data), CONT_DATA_SIZE)]...)
+	}
+	return cmd, data, nil
+}
@@ -0,0 +1,185 @@
+package fido
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newSimulatedDevice(t *testing.T) (*Simulator, *Device) {
+	t.Helper()
+	sim := NewSimulator()
+	d, err := Open(sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim, d
+}
+
+func makeCredential(t *testing.T, d *Device, rpID string) *Credential {
+	t.Helper()
+	hash := sha256.Sum256([]byte("client data"))
+	c, err := d.MakeCredential(context.Background(), &MakeCredentialRequest{
+		ClientDataHash: hash[:],
+		RP:             RelyingParty{ID: rpID},
+		User:           User{ID: []byte("user"), Name: "user"},
+		HMACSecret:     true,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDevice_Ping(t *testing.T) {
+	_, d := newSimulatedDevice(t)
+	if d.Capabilities&CAPABILITY_CBOR == 0 || d.Version.Protocol != 2 {
+		t.Fatalf("Unexpected INIT response %+v %02x", d.Version, d.Capabilities)
+	}
+	// spans an initialization packet and several continuation packets
+	data := make([]byte, 1000)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	echo, err := d.Ping(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(echo, data) {
+		t.Fatal("Ping did not echo the data")
+	}
+	if err := d.Wink(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDevice_GetInfo(t *testing.T) {
+	_, d := newSimulatedDevice(t)
+	info, err := d.GetInfo(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.Extension(ExtensionHMACSecret) || !info.Options["up"] || info.Options["rk"] {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+	if len(info.PinUvAuthProtocols) != 2 || info.MaxMsgSize != MAX_MSG_SIZE {
+		t.Fatalf("Unexpected info %+v", info)
+	}
+}
+
+func TestDevice_HMACSecret(t *testing.T) {
+	for _, protocols := range [][]int{{2, 1}, {1}} {
+		sim, d := newSimulatedDevice(t)
+		sim.pinProtocols = protocols
+		c := makeCredential(t, d, "aegis")
+		if !c.HMACSecret {
+			t.Fatal("Expected hmac-secret to be enabled")
+		}
+
+		salt := bytes.Repeat([]byte{0x42}, 64)
+		hash := sha256.Sum256([]byte("challenge"))
+		req := &GetAssertionRequest{RPID: "aegis", ClientDataHash: hash[:], AllowList: [][]byte{[]byte("unknown"), c.ID}, HMACSecretSalt: salt}
+		a, err := d.GetAssertion(context.Background(), req, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(a.CredentialID, c.ID) || a.SignCount != 1 || !c.Verify(a, hash[:]) {
+			t.Fatalf("Invalid assertion %+v", a)
+		}
+		if len(a.HMACSecret) != 64 || !bytes.Equal(a.HMACSecret[:32], a.HMACSecret[32:]) {
+			t.Fatalf("Unexpected hmac-secret output %x", a.HMACSecret)
+		}
+
+		// the output only depends on credential and salt, not on the shared secret of the request
+		req.HMACSecretSalt = salt[:32]
+		b, err := d.GetAssertion(context.Background(), req, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b.HMACSecret, a.HMACSecret[:32]) {
+			t.Fatalf("Expected the same output for the same salt, got %x", b.HMACSecret)
+		}
+
+		req.RPID = "other"
+		if _, err := d.GetAssertion(context.Background(), req, nil); !errors.Is(err, ErrNoCredentials) {
+			t.Fatalf("Expected ErrNoCredentials for another relying party, got %v", err)
+		}
+	}
+}
+
+func TestDevice_PIN(t *testing.T) {
+	for _, protocols := range [][]int{{2, 1}, {1}} {
+		sim, d := newSimulatedDevice(t)
+		sim.pinProtocols = protocols
+		if _, err := d.GetPinToken(context.Background(), []byte("1234")); !errors.Is(err, ErrPinNotSet) {
+			t.Fatalf("Expected ErrPinNotSet, got %v", err)
+		}
+		sim.SetPIN("1234")
+
+		hash := sha256.Sum256([]byte("client data"))
+		req := &MakeCredentialRequest{ClientDataHash: hash[:], RP: RelyingParty{ID: "aegis"}, User: User{ID: []byte("user")}}
+		if _, err := d.MakeCredential(context.Background(), req, nil); !errors.Is(err, ErrPinRequired) {
+			t.Fatalf("Expected ErrPinRequired without a token, got %v", err)
+		}
+		if _, err := d.GetPinToken(context.Background(), []byte("4321")); !errors.Is(err, ErrPinInvalid) {
+			t.Fatalf("Expected ErrPinInvalid for a wrong PIN, got %v", err)
+		}
+		token, err := d.GetPinToken(context.Background(), []byte("1234"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.PinToken = token
+		c, err := d.MakeCredential(context.Background(), req, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.AuthData[32]&FLAG_UV == 0 {
+			t.Fatal("Expected the user to be verified")
+		}
+
+		// a new token invalidates the previous one
+		if _, err := d.GetPinToken(context.Background(), []byte("1234")); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.MakeCredential(context.Background(), req, nil); !errors.Is(err, ErrPinAuthInvalid) {
+			t.Fatalf("Expected ErrPinAuthInvalid for a stale token, got %v", err)
+		}
+	}
+}
+
+func TestDevice_Cancel(t *testing.T) {
+	sim, d := newSimulatedDevice(t)
+	sim.SetUserPresence(func(cancel <-chan struct{}) bool {
+		<-cancel
+		return false
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	var keepalives []int
+	hash := sha256.Sum256([]byte("client data"))
+	_, err := d.MakeCredential(ctx, &MakeCredentialRequest{
+		ClientDataHash: hash[:],
+		RP:             RelyingParty{ID: "aegis"},
+		User:           User{ID: []byte("user")},
+	}, func(status int) { keepalives = append(keepalives, status) })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if len(keepalives) == 0 || keepalives[0] != STATUS_UPNEEDED {
+		t.Fatalf("Expected keepalives with STATUS_UPNEEDED, got %v", keepalives)
+	}
+
+	// the channel is free again after the cancelled command
+	sim.SetUserPresence(func(<-chan struct{}) bool { return false })
+	if _, err := d.MakeCredential(context.Background(), &MakeCredentialRequest{
+		ClientDataHash: hash[:],
+		RP:             RelyingParty{ID: "aegis"},
+		User:           User{ID: []byte("user")},
+	}, nil); !errors.Is(err, ErrOperationDenied) {
+		t.Fatalf("Expected ErrOperationDenied, got %v", err)
+	}
+}
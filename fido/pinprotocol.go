@@ -0,0 +1,138 @@
+package fido
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+// pinProtocol is a PIN/UV auth protocol, which the hmac-secret extension uses to encrypt the salts and outputs
+// with a secret shared between platform and authenticator.
+type pinProtocol interface {
+	version() int
+	// kdf derives the shared secret from the ECDH secret z.
+	kdf(z []byte) ([]byte, error)
+	encrypt(key, plaintext []byte) ([]byte, error)
+	decrypt(key, ciphertext []byte) ([]byte, error)
+	authenticate(key, message []byte) []byte
+}
+
+// selectPinProtocol returns the most recent of the protocols an authenticator supports, version one if it does
+// not tell.
+func selectPinProtocol(versions []int) pinProtocol {
+	for _, v := range versions {
+		if v == 2 {
+			return pinProtocolTwo{}
+		}
+	}
+	return pinProtocolOne{}
+}
+
+// encapsulate generates an ephemeral platform key and returns its COSE key with the secret shared with peer.
+func encapsulate(p pinProtocol, peer *ecdh.PublicKey) (cborMap, []byte, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	z, err := key.ECDH(peer)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := p.kdf(z)
+	if err != nil {
+		return nil, nil, err
+	}
+	return coseECDHKey(key.PublicKey()), secret, nil
+}
+
+// pinProtocolOne encrypts with AES-256-CBC and a zero IV and authenticates with HMAC-SHA-256 truncated to 16 bytes.
+type pinProtocolOne struct{}
+
+func (pinProtocolOne) version() int { return 1 }
+
+func (pinProtocolOne) kdf(z []byte) ([]byte, error) {
+	secret := sha256.Sum256(z)
+	return secret[:], nil
+}
+
+func (pinProtocolOne) encrypt(key, plaintext []byte) ([]byte, error) {
+	return cbcCrypt(key, make([]byte, aes.BlockSize), plaintext, true)
+}
+
+func (pinProtocolOne) decrypt(key, ciphertext []byte) ([]byte, error) {
+	return cbcCrypt(key, make([]byte, aes.BlockSize), ciphertext, false)
+}
+
+func (pinProtocolOne) authenticate(key, message []byte) []byte {
+	return hmacSHA256(key, message)[:16]
+}
+
+// pinProtocolTwo derives separate HMAC and AES keys with HKDF, encrypts with a random IV and authenticates with the
+// full HMAC-SHA-256.
+type pinProtocolTwo struct{}
+
+func (pinProtocolTwo) version() int { return 2 }
+
+func (pinProtocolTwo) kdf(z []byte) ([]byte, error) {
+	salt := make([]byte, 32)
+	hmacKey, err := hkdf.Key(sha256.New, z, salt, "CTAP2 HMAC key", 32)
+	if err != nil {
+		return nil, err
+	}
+	aesKey, err := hkdf.Key(sha256.New, z, salt, "CTAP2 AES key", 32)
+	if err != nil {
+		return nil, err
+	}
+	return append(hmacKey, aesKey...), nil
+}
+
+func (pinProtocolTwo) encrypt(key, plaintext []byte) ([]byte, error) {
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	ciphertext, err := cbcCrypt(key[32:], iv, plaintext, true)
+	if err != nil {
+		return nil, err
+	}
+	return append(iv, ciphertext...), nil
+}
+
+func (pinProtocolTwo) decrypt(key, ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < aes.BlockSize {
+		return nil, fmt.Errorf("%w: ciphertext too short", ErrInvalidLength)
+	}
+	return cbcCrypt(key[32:], ciphertext[:aes.BlockSize], ciphertext[aes.BlockSize:], false)
+}
+
+func (pinProtocolTwo) authenticate(key, message []byte) []byte {
+	return hmacSHA256(key[:32], message)
+}
+
+func cbcCrypt(key, iv, data []byte, encrypt bool) ([]byte, error) {
+	if len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("%w: %d bytes are no multiple of the block size", ErrInvalidLength, len(data))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, len(data))
+	if encrypt {
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(out, data)
+	} else {
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	}
+	return out, nil
+}
+
+func hmacSHA256(key, message []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,601 @@
+package fido
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/malivvan/aegis/hid"
+)
+
+var _ hid.ReportConn = (*Simulator)(nil)
+
+// simulatorAAGUID identifies the Simulator, it is no registered authenticator model
+var simulatorAAGUID = []byte("aegis simulator!")
+
+// Simulator is a software FIDO2 authenticator. It implements hid.ReportConn, so that Open(NewSimulator()) stands in
+// for a security key, e.g. to test higher layers without hardware. It supports non-discoverable ES256 credentials
+// with the hmac-secret extension, PIN/UV auth protocols one and two, a PIN that is required to create credentials
+// and cancelling commands that wait for user presence.
+type Simulator struct {
+	mu           sync.Mutex
+	reports      chan []byte
+	nextCID      uint32
+	pending      map[uint32]*simulatorMessage // messages of which continuation packets are missing
+	busy         map[uint32]chan struct{}     // closed to cancel the command in progress on a channel
+	presence     func(cancel <-chan struct{}) bool
+	keyAgreement *ecdh.PrivateKey
+	pinProtocols []int
+	pinHash      []byte // the first 16 bytes of the SHA-256 of the PIN, nil without a PIN
+	pinToken     []byte
+	credentials  map[string]*simulatorCredential
+}
+
+type simulatorMessage struct {
+	cmd  byte
+	size int
+	data []byte
+	seq  byte
+}
+
+type simulatorCredential struct {
+	rpIDHash   [32]byte
+	key        *ecdsa.PrivateKey
+	credRandom []byte // the secret of hmac-secret
+	signCount  uint32
+}
+
+// NewSimulator returns an authenticator without credentials on which the user is always present.
+func NewSimulator() *Simulator {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return &Simulator{
+		reports:      make(chan []byte, 512),
+		nextCID:      1,
+		pending:      make(map[uint32]*simulatorMessage),
+		busy:         make(map[uint32]chan struct{}),
+		presence:     func(<-chan struct{}) bool { return true },
+		keyAgreement: key,
+		pinProtocols: []int{2, 1},
+		credentials:  make(map[string]*simulatorCredential),
+	}
+}
+
+// SetUserPresence sets the function that is called when a command requires the user to touch the authenticator.
+// It blocks until the user touched it, returning true, or cancel is closed because the platform cancelled the
+// command, returning false. While it blocks, the Simulator sends keepalives with STATUS_UPNEEDED.
+func (s *Simulator) SetUserPresence(presence func(cancel <-chan struct{}) bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.presence = presence
+}
+
+// SetPIN sets the PIN of the authenticator, after which creating credentials requires a token of GetPinToken.
+func (s *Simulator) SetPIN(pin string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hash := sha256.Sum256([]byte(pin))
+	s.pinHash = hash[:16]
+}
+
+// Read returns the next input report.
+func (s *Simulator) Read(report []byte) (int, error) {
+	return copy(report, <-s.reports), nil
+}
+
+// Close ends the session like unplugging the authenticator: channels and partially received messages are
+// dropped. Credentials are kept.
+func (s *Simulator) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending = make(map[uint32]*simulatorMessage)
+	for _, cancel := range s.busy {
+		close(cancel)
+	}
+	s.busy = make(map[uint32]chan struct{})
+	for {
+		select {
+		case <-s.reports:
+		default:
+			return nil
+		}
+	}
+}
+
+// Write processes an output report.
+func (s *Simulator) Write(report []byte) (int, error) {
+	if len(report) != hid.REPORT_SIZE {
+		return 0, fmt.Errorf("write expects %d bytes, got %d", hid.REPORT_SIZE, len(report))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cid := binary.BigEndian.Uint32(report)
+	if report[4]&0x80 == 0 {
+		msg, ok := s.pending[cid]
+		if !ok {
+			return len(report), nil
+		}
+		if report[4] != msg.seq {
+			delete(s.pending, cid)
+			s.respondError(cid, ERR_INVALID_SEQ)
+			return len(report), nil
+		}
+		msg.seq++
+		msg.data = append(msg.data, report[5:5+min(msg.size-len(msg.data), CONT_DATA_SIZE)]...)
+		if len(msg.data) == msg.size {
+			delete(s.pending, cid)
+			s.dispatch(cid, msg.cmd, msg.data)
+		}
+		return len(report), nil
+	}
+
+	cmd := report[4]
+	size := int(binary.BigEndian.Uint16(report[5:]))
+	switch {
+	case cid == 0 || cid == CID_BROADCAST && cmd != CTAPHID_INIT:
+		s.respondError(cid, ERR_INVALID_CHANNEL)
+	case cmd == CTAPHID_CANCEL:
+		if cancel, ok := s.busy[cid]; ok {
+			close(cancel)
+			delete(s.busy, cid)
+		}
+	case s.busy[cid] != nil:
+		s.respondError(cid, ERR_CHANNEL_BUSY)
+	case size > MAX_MSG_SIZE:
+		s.respondError(cid, ERR_INVALID_LEN)
+	default:
+		msg := &simulatorMessage{cmd: cmd, size: size, data: append([]byte(nil), report[7:7+min(size, INIT_DATA_SIZE)]...)}
+		if len(msg.data) < size {
+			s.pending[cid] = msg
+		} else {
+			s.dispatch(cid, cmd, msg.data)
+		}
+	}
+	return len(report), nil
+}
+
+func (s *Simulator) dispatch(cid uint32, cmd byte, data []byte) {
+	switch cmd {
+	case CTAPHID_INIT:
+		if len(data) != 8 {
+			s.respondError(cid, ERR_INVALID_LEN)
+			return
+		}
+		newCID := cid
+		if cid == CID_BROADCAST {
+			newCID = s.nextCID
+			s.nextCID++
+		}
+		resp := binary.BigEndian.AppendUint32(append([]byte(nil), data...), newCID)
+		s.respond(cid, cmd, append(resp, 2, 5, 4, 3, CAPABILITY_WINK|CAPABILITY_CBOR|CAPABILITY_NMSG))
+	case CTAPHID_PING:
+		s.respond(cid, cmd, data)
+	case CTAPHID_WINK:
+		s.respond(cid, cmd, nil)
+	case CTAPHID_CBOR:
+		if len(data) == 0 {
+			s.respondError(cid, ERR_INVALID_LEN)
+			return
+		}
+		cancel := make(chan struct{})
+		s.busy[cid] = cancel
+		go func() {
+			resp := s.processCBOR(cid, data[0], data[1:], cancel)
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.busy[cid] == cancel {
+				delete(s.busy, cid)
+			}
+			s.respond(cid, cmd, resp)
+		}()
+	default:
+		s.respondError(cid, ERR_INVALID_CMD)
+	}
+}
+
+// respond splits a response into packets.
+func (s *Simulator) respond(cid uint32, cmd byte, data []byte) {
+	packet := make([]byte, hid.REPORT_SIZE)
+	binary.BigEndian.PutUint32(packet, cid)
+	packet[4] = cmd
+	binary.BigEndian.PutUint16(packet[5:], uint16(len(data)))
+	n := copy(packet[7:], data)
+	data = data[n:]
+	s.reports <- packet
+	for seq := byte(0); len(data) > 0; seq++ {
+		packet = make([]byte, hid.REPORT_SIZE)
+		binary.BigEndian.PutUint32(packet, cid)
+		packet[4] = seq
+		n := copy(packet[5:], data)
+		data = data[n:]
+		s.reports <- packet
+	}
+}
+
+func (s *Simulator) respondError(cid uint32, code byte) {
+	s.respond(cid, CTAPHID_ERROR, []byte{code})
+}
+
+// processCBOR executes a CTAP2 command and returns the status byte followed by the response map.
+func (s *Simulator) processCBOR(cid uint32, command byte, data []byte, cancel <-chan struct{}) []byte {
+	var params cborMap
+	if len(data) > 0 {
+		var err error
+		if params, err = decodeCBORMap(data); err != nil {
+			return []byte{statusCode(ErrInvalidCBOR)}
+		}
+	}
+	var resp cborMap
+	var err error
+	switch command {
+	case CMD_GET_INFO:
+		resp = s.getInfo()
+	case CMD_CLIENT_PIN:
+		resp, err = s.clientPIN(params)
+	case CMD_MAKE_CREDENTIAL:
+		resp, err = s.makeCredential(cid, params, cancel)
+	case CMD_GET_ASSERTION:
+		resp, err = s.getAssertion(cid, params, cancel)
+	default:
+		err = ErrInvalidCommand
+	}
+	if err != nil {
+		return []byte{statusCode(err)}
+	}
+	b, err := marshalCBOR(resp)
+	if err != nil {
+		return []byte{statusCode(ErrInvalidCBOR)}
+	}
+	return append([]byte{0}, b...)
+}
+
+func (s *Simulator) getInfo() cborMap {
+	protocols := make([]any, len(s.pinProtocols))
+	for i, p := range s.pinProtocols {
+		protocols[i] = p
+	}
+	return cborMap{
+		1: []string{"FIDO_2_0", "FIDO_2_1"},
+		2: []string{ExtensionHMACSecret},
+		3: simulatorAAGUID,
+		4: map[string]bool{"rk": false, "up": true, "plat": false, "clientPin": s.pinHash != nil},
+		5: MAX_MSG_SIZE,
+		6: protocols,
+	}
+}
+
+func (s *Simulator) clientPIN(params cborMap) (cborMap, error) {
+	subCommand, ok := params.int(2)
+	if !ok {
+		return nil, ErrMissingParameter
+	}
+	protocol, err := s.pinProtocol(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	switch subCommand {
+	case CLIENT_PIN_GET_KEY_AGREEMENT:
+		return cborMap{1: coseECDHKey(s.keyAgreement.PublicKey())}, nil
+	case CLIENT_PIN_GET_PIN_TOKEN:
+		return s.getPinToken(protocol, params)
+	}
+	return nil, ErrInvalidSubcommand
+}
+
+// getPinToken checks the encrypted PIN hash of params and returns a new token encrypted to the platform.
+func (s *Simulator) getPinToken(protocol pinProtocol, params cborMap) (cborMap, error) {
+	cose, ok1 := params.mapValue(3)
+	pinHashEnc, ok2 := params.bytes(6)
+	if !ok1 || !ok2 {
+		return nil, ErrMissingParameter
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pinHash == nil {
+		return nil, ErrPinNotSet
+	}
+	sharedSecret, err := s.sharedSecret(protocol, cose)
+	if err != nil {
+		return nil, err
+	}
+	pinHash, err := protocol.decrypt(sharedSecret, pinHashEnc)
+	if err != nil {
+		return nil, ErrPinInvalid
+	}
+	if !hmac.Equal(pinHash, s.pinHash) {
+		return nil, ErrPinInvalid
+	}
+	s.pinToken = make([]byte, 32)
+	if _, err := rand.Read(s.pinToken); err != nil {
+		return nil, err
+	}
+	tokenEnc, err := protocol.encrypt(sharedSecret, s.pinToken)
+	if err != nil {
+		return nil, err
+	}
+	return cborMap{2: tokenEnc}, nil
+}
+
+// sharedSecret derives the secret shared with the platform key cose.
+func (s *Simulator) sharedSecret(protocol pinProtocol, cose cborMap) ([]byte, error) {
+	peer, err := parseECDHKey(cose)
+	if err != nil {
+		return nil, err
+	}
+	z, err := s.keyAgreement.ECDH(peer)
+	if err != nil {
+		return nil, ErrInvalidParameter
+	}
+	return protocol.kdf(z)
+}
+
+// pinProtocol returns the protocol of the version at key in params.
+func (s *Simulator) pinProtocol(params cborMap, key any) (pinProtocol, error) {
+	version, ok := params.int(key)
+	if !ok {
+		return nil, ErrMissingParameter
+	}
+	for _, v := range s.pinProtocols {
+		if v == version {
+			if version == 2 {
+				return pinProtocolTwo{}, nil
+			}
+			return pinProtocolOne{}, nil
+		}
+	}
+	return nil, ErrInvalidParameter
+}
+
+// verifyPinToken checks the pinUvAuthParam of a makeCredential request over clientDataHash, which is required once
+// the authenticator has a PIN. It reports whether the user is verified.
+func (s *Simulator) verifyPinToken(params cborMap, clientDataHash []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	param, ok := params.bytes(8)
+	if !ok {
+		if s.pinHash != nil {
+			return false, ErrPinRequired
+		}
+		return false, nil
+	}
+	protocol, err := s.pinProtocol(params, 9)
+	if err != nil {
+		return false, err
+	}
+	if s.pinToken == nil || !hmac.Equal(protocol.authenticate(s.pinToken, clientDataHash), param) {
+		return false, ErrPinAuthInvalid
+	}
+	return true, nil
+}
+
+// userPresent waits for the user while sending keepalives on the channel cid.
+func (s *Simulator) userPresent(cid uint32, cancel <-chan struct{}) error {
+	s.mu.Lock()
+	presence := s.presence
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			s.mu.Lock()
+			s.respond(cid, CTAPHID_KEEPALIVE, []byte{STATUS_UPNEEDED})
+			s.mu.Unlock()
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	if presence(cancel) {
+		return nil
+	}
+	select {
+	case <-cancel:
+		return ErrKeepaliveCancel
+	default:
+		return ErrOperationDenied
+	}
+}
+
+func (s *Simulator) makeCredential(cid uint32, params cborMap, cancel <-chan struct{}) (cborMap, error) {
+	rp, ok1 := params.mapValue(2)
+	user, ok2 := params.mapValue(3)
+	algorithms, ok3 := params.array(4)
+	clientDataHash, ok := params.bytes(1)
+	if !ok || !ok1 || !ok2 || !ok3 {
+		return nil, ErrMissingParameter
+	}
+	verified, err := s.verifyPinToken(params, clientDataHash)
+	if err != nil {
+		return nil, err
+	}
+	rpID, ok := rp.text("id")
+	if _, hasID := user.bytes("id"); !ok || !hasID {
+		return nil, ErrMissingParameter
+	}
+	supported := false
+	for _, a := range algorithms {
+		if param, ok := a.(cborMap); ok {
+			alg, _ := param.int("alg")
+			typ, _ := param.text("type")
+			supported = supported || alg == COSE_ES256 && typ == "public-key"
+		}
+	}
+	if !supported {
+		return nil, ErrUnsupportedAlgorithm
+	}
+	extensions, _ := params.mapValue(6)
+	hmacSecret, _ := extensions.bool(ExtensionHMACSecret)
+	options, _ := params.mapValue(7)
+	if rk, _ := options.bool("rk"); rk {
+		return nil, ErrUnsupportedOption
+	}
+	if err := s.userPresent(cid, cancel); err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	c := &simulatorCredential{rpIDHash: sha256.Sum256([]byte(rpID)), key: key, credRandom: make([]byte, 32)}
+	id := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(c.credRandom); err != nil {
+		return nil, err
+	}
+	point, err := key.PublicKey.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	publicKey, err := marshalCBOR(cborMap{1: COSE_KTY_EC2, 3: COSE_ES256, -1: COSE_CRV_P256, -2: point[1:33], -3: point[33:]})
+	if err != nil {
+		return nil, err
+	}
+
+	flags := byte(FLAG_UP | FLAG_AT)
+	if verified {
+		flags |= FLAG_UV
+	}
+	var ext cborMap
+	if hmacSecret {
+		flags |= FLAG_ED
+		ext = cborMap{ExtensionHMACSecret: true}
+	}
+	authData := append(c.rpIDHash[:], flags, 0, 0, 0, 0)
+	authData = append(authData, simulatorAAGUID...)
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(id)))
+	authData = append(append(authData, id...), publicKey...)
+	if ext != nil {
+		b, err := marshalCBOR(ext)
+		if err != nil {
+			return nil, err
+		}
+		authData = append(authData, b...)
+	}
+
+	s.mu.Lock()
+	s.credentials[string(id)] = c
+	s.mu.Unlock()
+	return cborMap{1: "none", 2: authData, 3: cborMap{}}, nil
+}
+
+func (s *Simulator) getAssertion(cid uint32, params cborMap, cancel <-chan struct{}) (cborMap, error) {
+	rpID, ok1 := params.text(1)
+	clientDataHash, ok2 := params.bytes(2)
+	if !ok1 || !ok2 {
+		return nil, ErrMissingParameter
+	}
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	allowList, _ := params.array(3)
+	var id []byte
+	var c *simulatorCredential
+	s.mu.Lock()
+	for _, item := range allowList {
+		descriptor, _ := item.(cborMap)
+		candidate, _ := descriptor.bytes("id")
+		if credential, ok := s.credentials[string(candidate)]; ok && credential.rpIDHash == rpIDHash {
+			id, c = candidate, credential
+			break
+		}
+	}
+	s.mu.Unlock()
+	if c == nil {
+		return nil, ErrNoCredentials
+	}
+
+	var ext cborMap
+	extensions, _ := params.mapValue(4)
+	if input, ok := extensions.mapValue(ExtensionHMACSecret); ok {
+		output, err := s.hmacSecret(c, input)
+		if err != nil {
+			return nil, err
+		}
+		ext = cborMap{ExtensionHMACSecret: output}
+	}
+	options, _ := params.mapValue(5)
+	up, ok := options.bool("up")
+	if !ok {
+		up = true
+	}
+	flags := byte(0)
+	if up {
+		if err := s.userPresent(cid, cancel); err != nil {
+			return nil, err
+		}
+		flags |= FLAG_UP
+	}
+
+	s.mu.Lock()
+	c.signCount++
+	signCount := c.signCount
+	s.mu.Unlock()
+	if ext != nil {
+		flags |= FLAG_ED
+	}
+	authData := binary.BigEndian.AppendUint32(append(rpIDHash[:], flags), signCount)
+	if ext != nil {
+		b, err := marshalCBOR(ext)
+		if err != nil {
+			return nil, err
+		}
+		authData = append(authData, b...)
+	}
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash...))
+	signature, err := ecdsa.SignASN1(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+	return cborMap{1: cborMap{"id": id, "type": "public-key"}, 2: authData, 3: signature}, nil
+}
+
+// hmacSecret evaluates the hmac-secret extension of c for the encrypted salts of input.
+func (s *Simulator) hmacSecret(c *simulatorCredential, input cborMap) ([]byte, error) {
+	cose, ok1 := input.mapValue(1)
+	saltEnc, ok2 := input.bytes(2)
+	saltAuth, ok3 := input.bytes(3)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, ErrMissingParameter
+	}
+	if _, ok := input[4]; !ok {
+		input[4] = 1
+	}
+	protocol, err := s.pinProtocol(input, 4)
+	if err != nil {
+		return nil, err
+	}
+	sharedSecret, err := s.sharedSecret(protocol, cose)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(protocol.authenticate(sharedSecret, saltEnc), saltAuth) {
+		return nil, ErrPinAuthInvalid
+	}
+	salt, err := protocol.decrypt(sharedSecret, saltEnc)
+	if err != nil {
+		return nil, err
+	}
+	if len(salt) != 32 && len(salt) != 64 {
+		return nil, ErrInvalidLength
+	}
+	var output []byte
+	for i := 0; i < len(salt); i += 32 {
+		output = append(output, hmacSHA256(c.credRandom, salt[i:i+32])...)
+	}
+	return protocol.encrypt(sharedSecret, output)
+}
@@ -0,0 +1,38 @@
+package hid
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestChallengeResponder(t *testing.T) {
+	key := &fakeKey{hmacKey: []byte("12345678901234567890")}
+	p, err := New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed := make([]byte, 32)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	resp, err := NewChallengeResponder(context.Background(), p, 2, nil).ChallengeResponse(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// response of a fixed length slot to the master seed padded by KeePassXC with 32 bytes of 0x20
+	expected, _ := hex.DecodeString("a61400b99ffdbce68857e2d57b727f6937ebfbc3")
+	if !bytes.Equal(resp, expected) {
+		t.Fatalf("Expected response %x, got %x", expected, resp)
+	}
+
+	key.noTouch = true
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = NewChallengeResponder(ctx, p, 2, nil).ChallengeResponse(seed)
+	if !errors.As(err, new(*TimeoutError)) {
+		t.Fatalf("Expected a cancelled wait for touch, got %v", err)
+	}
+}
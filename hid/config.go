@@ -0,0 +1,359 @@
+package hid
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/malivvan/aegis/mhex"
+)
+
+const (
+	CMD_CONFIG_1      = 0x01
+	CMD_CONFIG_2      = 0x03
+	CMD_UPDATE_1      = 0x04
+	CMD_UPDATE_2      = 0x05
+	CMD_SWAP          = 0x06
+	CMD_DEVICE_SERIAL = 0x10
+
+	FIXED_SIZE      = 16 // public ID of Yubico OTP
+	UID_SIZE        = 6  // private ID of Yubico OTP
+	KEY_SIZE        = 16 // AES key of Yubico OTP
+	ACC_CODE_SIZE   = 6
+	CONFIG_SIZE     = FIXED_SIZE + UID_SIZE + KEY_SIZE + ACC_CODE_SIZE + 8
+	HMAC_KEY_SIZE   = 20
+	SCAN_CODES_SIZE = FIXED_SIZE + UID_SIZE + KEY_SIZE
+	SERIAL_SIZE     = 4
+)
+
+// Ticket flags
+const (
+	TKTFLAG_TAB_FIRST     = 0x01
+	TKTFLAG_APPEND_TAB1   = 0x02
+	TKTFLAG_APPEND_TAB2   = 0x04
+	TKTFLAG_APPEND_DELAY1 = 0x08
+	TKTFLAG_APPEND_DELAY2 = 0x10
+	TKTFLAG_APPEND_CR     = 0x20
+	TKTFLAG_OATH_HOTP     = 0x40
+	TKTFLAG_CHAL_RESP     = 0x40
+	TKTFLAG_PROTECT_CFG2  = 0x80
+)
+
+// Configuration flags
+const (
+	CFGFLAG_SEND_REF       = 0x01
+	CFGFLAG_SHORT_TICKET   = 0x02
+	CFGFLAG_OATH_HOTP8     = 0x02
+	CFGFLAG_PACING_10MS    = 0x04
+	CFGFLAG_HMAC_LT64      = 0x04
+	CFGFLAG_PACING_20MS    = 0x08
+	CFGFLAG_CHAL_BTN_TRIG  = 0x08
+	CFGFLAG_STRONG_PW1     = 0x10
+	CFGFLAG_STATIC_TICKET  = 0x20
+	CFGFLAG_CHAL_YUBICO    = 0x20
+	CFGFLAG_CHAL_HMAC      = 0x22
+	CFGFLAG_STRONG_PW2     = 0x40
+	CFGFLAG_MAN_UPDATE     = 0x80
+	CFGFLAG_OATH_FIXED_HEX = 0x50
+)
+
+// Extended flags
+const (
+	EXTFLAG_SERIAL_BTN_VISIBLE = 0x01
+	EXTFLAG_SERIAL_USB_VISIBLE = 0x02
+	EXTFLAG_SERIAL_API_VISIBLE = 0x04
+	EXTFLAG_USE_NUMERIC_KEYPAD = 0x08
+	EXTFLAG_FAST_TRIG          = 0x10
+	EXTFLAG_ALLOW_UPDATE       = 0x20
+	EXTFLAG_DORMANT            = 0x40
+	EXTFLAG_LED_INV            = 0x80
+)
+
+// Touch level flags of the status bytes
+const (
+	CONFIG1_VALID  = 0x01
+	CONFIG2_VALID  = 0x02
+	CONFIG1_TOUCH  = 0x04
+	CONFIG2_TOUCH  = 0x08
+	CONFIG_LED_INV = 0x10
+)
+
+var ErrNotSupported = errors.New("not supported by this YubiKey")
+
+// Status is the state of the OTP application reported after every command.
+type Status struct {
+	Version  Version
+	Sequence byte   // programming sequence, incremented by every successful configuration change
+	Touch    uint16 // touch level flags, see CONFIG1_VALID
+}
+
+func parseStatus(b []byte) (*Status, error) {
+	if len(b) < 6 {
+		return nil, fmt.Errorf("status bytes too short: %d", len(b))
+	}
+	version, err := VersionFromBytes(b[:3])
+	if err != nil {
+		return nil, err
+	}
+	return &Status{Version: version, Sequence: b[3], Touch: binary.LittleEndian.Uint16(b[4:6])}, nil
+}
+
+// Configured reports whether slot 1 or 2 holds a configuration.
+func (s *Status) Configured(slot int) bool {
+	return slot == 1 && s.Touch&CONFIG1_VALID != 0 || slot == 2 && s.Touch&CONFIG2_VALID != 0
+}
+
+// RequiresTouch reports whether the configuration in slot 1 or 2 is triggered by touching the key.
+func (s *Status) RequiresTouch(slot int) bool {
+	return slot == 1 && s.Touch&CONFIG1_TOUCH != 0 || slot == 2 && s.Touch&CONFIG2_TOUCH != 0
+}
+
+// Status reads the current status of the OTP application.
+func (p *Protocol) Status() (*Status, error) {
+	b, err := p.ReadStatus()
+	if err != nil {
+		return nil, err
+	}
+	return parseStatus(b)
+}
+
+func (v Version) atLeast(major, minor uint8) bool {
+	return v.Major > major || v.Major == major && v.Minor >= minor
+}
+
+func (p *Protocol) requireVersion(major, minor uint8, feature string) error {
+	if !p.Version.atLeast(major, minor) {
+		return fmt.Errorf("%s requires firmware %d.%d, got %d.%d.%d: %w", feature, major, minor,
+			p.Version.Major, p.Version.Minor, p.Version.Patch, ErrNotSupported)
+	}
+	return nil
+}
+
+// SlotConfig is the configuration of an OTP slot, created with YubicoOTP, StaticPassword, HMACSHA1 or HOTP.
+type SlotConfig struct {
+	fixed []byte
+	uid   [UID_SIZE]byte
+	key   [KEY_SIZE]byte
+	ext   byte
+	tkt   byte
+	cfg   byte
+}
+
+// newSlotConfig returns a configuration with the flags that every configuration starts with: the serial number
+// can be read and the slot can be updated later, e.g. to change its access code.
+func newSlotConfig() *SlotConfig {
+	return &SlotConfig{ext: EXTFLAG_SERIAL_API_VISIBLE | EXTFLAG_ALLOW_UPDATE}
+}
+
+// newKeyboardConfig returns a configuration of a slot that types its output, followed by enter.
+func newKeyboardConfig() *SlotConfig {
+	c := newSlotConfig()
+	c.ext |= EXTFLAG_FAST_TRIG
+	c.tkt |= TKTFLAG_APPEND_CR
+	return c
+}
+
+// YubicoOTP returns a Yubico OTP configuration. publicID is the modhex encoded prefix of every OTP (up to 16 bytes,
+// usually 6), privateID the 6 byte secret ID and key the 16 byte AES key.
+func YubicoOTP(publicID string, privateID, key []byte) (*SlotConfig, error) {
+	fixed, err := mhex.Decode(publicID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public ID: %w", err)
+	}
+	if len(fixed) > FIXED_SIZE {
+		return nil, fmt.Errorf("public ID too long: %d bytes", len(fixed))
+	}
+	if len(privateID) != UID_SIZE {
+		return nil, fmt.Errorf("private ID must be %d bytes, got %d", UID_SIZE, len(privateID))
+	}
+	if len(key) != KEY_SIZE {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KEY_SIZE, len(key))
+	}
+	c := newKeyboardConfig()
+	c.fixed = fixed
+	copy(c.uid[:], privateID)
+	copy(c.key[:], key)
+	return c, nil
+}
+
+// StaticPassword returns a configuration that types password, up to 38 characters, on a host with the given
+// keyboard layout. Characters that cannot be typed with layout are reported as *UntypeableError.
+func StaticPassword(password string, layout *Layout) (*SlotConfig, error) {
+	codes, err := layout.ScanCodes(password)
+	if err != nil {
+		return nil, err
+	}
+	if len(codes) > SCAN_CODES_SIZE {
+		return nil, fmt.Errorf("password too long: %d characters", len(codes))
+	}
+	padded := make([]byte, SCAN_CODES_SIZE)
+	copy(padded, codes)
+	c := newKeyboardConfig()
+	c.fixed = padded[:FIXED_SIZE]
+	copy(c.uid[:], padded[FIXED_SIZE:])
+	copy(c.key[:], padded[FIXED_SIZE+UID_SIZE:])
+	c.cfg |= CFGFLAG_SHORT_TICKET
+	return c, nil
+}
+
+// HMACSHA1 returns an HMAC-SHA1 challenge-response configuration with a key of up to 20 bytes, as used by
+// CalculateHMACSHA1. If requireTouch is set, every challenge has to be confirmed by touching the key.
+func HMACSHA1(key []byte, requireTouch bool) (*SlotConfig, error) {
+	c, err := newHMACKeyConfig(key)
+	if err != nil {
+		return nil, err
+	}
+	c.tkt |= TKTFLAG_CHAL_RESP
+	c.cfg |= CFGFLAG_CHAL_HMAC | CFGFLAG_HMAC_LT64
+	if requireTouch {
+		c.cfg |= CFGFLAG_CHAL_BTN_TRIG
+	}
+	return c, nil
+}
+
+// HOTP returns an OATH-HOTP configuration with a key of up to 20 bytes that types codes of 6 or 8 digits. counter
+// is the initial moving factor, a multiple of 16 up to 0xFFFF0.
+func HOTP(key []byte, digits int, counter uint32) (*SlotConfig, error) {
+	if digits != 6 && digits != 8 {
+		return nil, fmt.Errorf("invalid number of digits: %d", digits)
+	}
+	if counter%16 != 0 || counter > 0xffff0 {
+		return nil, fmt.Errorf("invalid initial counter: %d", counter)
+	}
+	c, err := newHMACKeyConfig(key)
+	if err != nil {
+		return nil, err
+	}
+	c.ext |= EXTFLAG_FAST_TRIG
+	c.tkt |= TKTFLAG_APPEND_CR | TKTFLAG_OATH_HOTP
+	if digits == 8 {
+		c.cfg |= CFGFLAG_OATH_HOTP8
+	}
+	binary.BigEndian.PutUint16(c.uid[4:], uint16(counter>>4))
+	return c, nil
+}
+
+// newHMACKeyConfig stores a 20 byte HMAC key in the key field and the start of the uid field.
+func newHMACKeyConfig(key []byte) (*SlotConfig, error) {
+	if len(key) > HMAC_KEY_SIZE {
+		return nil, fmt.Errorf("HMAC key too long: %d bytes", len(key))
+	}
+	padded := make([]byte, HMAC_KEY_SIZE)
+	copy(padded, key)
+	c := newSlotConfig()
+	copy(c.key[:], padded[:KEY_SIZE])
+	copy(c.uid[:], padded[KEY_SIZE:])
+	return c, nil
+}
+
+// bytes encodes the configuration with the access code it protects the slot with.
+func (c *SlotConfig) bytes(accessCode []byte) []byte {
+	b := make([]byte, 0, CONFIG_SIZE)
+	b = append(b, c.fixed...)
+	b = append(b, make([]byte, FIXED_SIZE-len(c.fixed))...)
+	b = append(b, c.uid[:]...)
+	b = append(b, c.key[:]...)
+	b = append(b, accessCode...)
+	b = append(b, make([]byte, ACC_CODE_SIZE-len(accessCode))...)
+	b = append(b, byte(len(c.fixed)), c.ext, c.tkt, c.cfg, 0, 0)
+	crc := ^calculateCRC(b)
+	return binary.LittleEndian.AppendUint16(b, crc)
+}
+
+func checkAccessCode(code []byte) error {
+	if code != nil && len(code) != ACC_CODE_SIZE {
+		return fmt.Errorf("access code must be %d bytes, got %d", ACC_CODE_SIZE, len(code))
+	}
+	return nil
+}
+
+// writeConfig sends a configuration followed by the current access code of the slot.
+func (p *Protocol) writeConfig(ctx context.Context, cmd byte, config []byte, currentAccessCode []byte) (*Status, error) {
+	if err := checkAccessCode(currentAccessCode); err != nil {
+		return nil, err
+	}
+	data := make([]byte, CONFIG_SIZE+ACC_CODE_SIZE)
+	copy(data, config)
+	copy(data[CONFIG_SIZE:], currentAccessCode)
+	status, err := p.SendAndReceive(ctx, cmd, data, nil)
+	if err != nil {
+		var cre *CommandRejectedError
+		if errors.As(err, &cre) {
+			return nil, &CommandRejectedError{"configuration not applied, wrong access code?"}
+		}
+		return nil, err
+	}
+	return parseStatus(status)
+}
+
+// PutConfig programs slot 1 or 2 with config, replacing its previous configuration. accessCode protects the new
+// configuration from changes and currentAccessCode is the access code of the previous one; both are 6 bytes or nil.
+func (p *Protocol) PutConfig(ctx context.Context, slot int, config *SlotConfig, accessCode, currentAccessCode []byte) (*Status, error) {
+	cmd, err := slotCommand(slot, CMD_CONFIG_1, CMD_CONFIG_2)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAccessCode(accessCode); err != nil {
+		return nil, err
+	}
+	if config.tkt&TKTFLAG_CHAL_RESP != 0 && config.cfg&CFGFLAG_CHAL_HMAC != 0 {
+		if err := p.requireVersion(2, 2, "HMAC-SHA1 challenge-response"); err != nil {
+			return nil, err
+		}
+	}
+	return p.writeConfig(ctx, cmd, config.bytes(accessCode), currentAccessCode)
+}
+
+// DeleteConfig removes the configuration of slot 1 or 2.
+func (p *Protocol) DeleteConfig(ctx context.Context, slot int, currentAccessCode []byte) (*Status, error) {
+	cmd, err := slotCommand(slot, CMD_CONFIG_1, CMD_CONFIG_2)
+	if err != nil {
+		return nil, err
+	}
+	return p.writeConfig(ctx, cmd, make([]byte, CONFIG_SIZE), currentAccessCode)
+}
+
+// SetAccessCode replaces the access code of slot 1 or 2 without touching its secret. A nil accessCode removes the
+// access code. The keyboard output flags of the slot are reset to their defaults. The slot must have been
+// programmed to allow updates, which all configurations of this package do.
+func (p *Protocol) SetAccessCode(ctx context.Context, slot int, accessCode, currentAccessCode []byte) (*Status, error) {
+	cmd, err := slotCommand(slot, CMD_UPDATE_1, CMD_UPDATE_2)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.requireVersion(2, 3, "updating a slot"); err != nil {
+		return nil, err
+	}
+	if err := checkAccessCode(accessCode); err != nil {
+		return nil, err
+	}
+	return p.writeConfig(ctx, cmd, newKeyboardConfig().bytes(accessCode), currentAccessCode)
+}
+
+// SwapSlots exchanges the configurations of slot 1 and 2.
+func (p *Protocol) SwapSlots(ctx context.Context) (*Status, error) {
+	if err := p.requireVersion(2, 3, "swapping slots"); err != nil {
+		return nil, err
+	}
+	status, err := p.SendAndReceive(ctx, CMD_SWAP, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return parseStatus(status)
+}
+
+// Serial reads the serial number of the YubiKey.
+func (p *Protocol) Serial(ctx context.Context) (uint32, error) {
+	if err := p.requireVersion(2, 2, "reading the serial number"); err != nil {
+		return 0, err
+	}
+	resp, err := p.SendAndReceive(ctx, CMD_DEVICE_SERIAL, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp) < SERIAL_SIZE+2 || !checkCRC(resp[:SERIAL_SIZE+2]) {
+		return 0, fmt.Errorf("invalid CRC in serial number response")
+	}
+	return binary.BigEndian.Uint32(resp[:SERIAL_SIZE]), nil
+}
@@ -0,0 +1,219 @@
+package hid
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// fakeKey emulates the OTP application of a YubiKey 5 that stores slot configurations.
+type fakeKey struct {
+	progSeq    byte
+	touch      byte
+	slots      [2][]byte // configurations of slot 1 and 2
+	accessCode [2][]byte
+	hmacKey    []byte // key of a fixed length HMAC-SHA1 challenge-response configuration
+	noTouch    bool   // challenges wait for a touch that never comes
+	waiting    bool
+	frame      [FRAME_SIZE]byte
+	pending    [][]byte
+}
+
+func (k *fakeKey) Receive() ([]byte, error) {
+	if len(k.pending) > 0 {
+		report := k.pending[0]
+		k.pending = k.pending[1:]
+		return report, nil
+	}
+	if k.waiting {
+		return []byte{0, 0, 0, 0, 0, 0, 0, RESP_TIMEOUT_WAIT_FLAG}, nil
+	}
+	return []byte{0, 5, 4, 3, k.progSeq, k.touch, 0, 0}, nil
+}
+
+func (k *fakeKey) Send(report []byte) error {
+	status := report[FEATURE_RPT_DATA_SIZE]
+	if status&SLOT_WRITE_FLAG == 0 || status == 0xFF {
+		return nil
+	}
+	seq := int(status & SEQUENCE_MASK)
+	copy(k.frame[seq*FEATURE_RPT_DATA_SIZE:], report[:FEATURE_RPT_DATA_SIZE])
+	if seq == 9 {
+		k.process()
+		k.frame = [FRAME_SIZE]byte{}
+	}
+	return nil
+}
+
+func (k *fakeKey) Close() error { return nil }
+
+func (k *fakeKey) process() {
+	payload := k.frame[:SLOT_DATA_SIZE]
+	crc := binary.LittleEndian.Uint16(k.frame[SLOT_DATA_SIZE+1:])
+	if crc != calculateCRC(payload) {
+		return
+	}
+	switch cmd := k.frame[SLOT_DATA_SIZE]; cmd {
+	case CMD_CONFIG_1, CMD_CONFIG_2, CMD_UPDATE_1, CMD_UPDATE_2:
+		i := 0
+		if cmd == CMD_CONFIG_2 || cmd == CMD_UPDATE_2 {
+			i = 1
+		}
+		config, current := payload[:CONFIG_SIZE], payload[CONFIG_SIZE:CONFIG_SIZE+ACC_CODE_SIZE]
+		if calculateCRC(config) != CRC_OK_RESIDUAL && !bytes.Equal(config, make([]byte, CONFIG_SIZE)) {
+			return
+		}
+		if k.accessCode[i] != nil && !bytes.Equal(current, k.accessCode[i]) {
+			return
+		}
+		if bytes.Equal(config, make([]byte, CONFIG_SIZE)) {
+			k.slots[i], k.accessCode[i] = nil, nil
+			k.touch &^= CONFIG1_VALID << i
+		} else {
+			if cmd == CMD_CONFIG_1 || cmd == CMD_CONFIG_2 {
+				k.slots[i] = append([]byte(nil), config...)
+			}
+			k.accessCode[i] = nil
+			if code := config[38:44]; !bytes.Equal(code, make([]byte, ACC_CODE_SIZE)) {
+				k.accessCode[i] = append([]byte(nil), code...)
+			}
+			k.touch |= CONFIG1_VALID << i
+		}
+		k.progSeq++
+	case CMD_SWAP:
+		k.slots[0], k.slots[1] = k.slots[1], k.slots[0]
+		k.accessCode[0], k.accessCode[1] = k.accessCode[1], k.accessCode[0]
+		k.progSeq++
+	case CMD_CHALLENGE_HMAC1, CMD_CHALLENGE_HMAC2:
+		if k.noTouch {
+			k.waiting = true
+			return
+		}
+		mac := hmac.New(sha1.New, k.hmacKey)
+		mac.Write(payload)
+		resp := mac.Sum(nil)
+		resp = binary.LittleEndian.AppendUint16(resp, ^calculateCRC(resp))
+		k.respond(resp)
+	case CMD_DEVICE_SERIAL:
+		resp := binary.BigEndian.AppendUint32(nil, 12345678)
+		resp = binary.LittleEndian.AppendUint16(resp, ^calculateCRC(resp))
+		k.respond(resp)
+	}
+}
+
+func (k *fakeKey) respond(resp []byte) {
+	for seq := 0; len(resp) > 0; seq++ {
+		report := make([]byte, FEATURE_RPT_SIZE)
+		n := copy(report[:FEATURE_RPT_DATA_SIZE], resp)
+		resp = resp[n:]
+		report[FEATURE_RPT_DATA_SIZE] = RESP_PENDING_FLAG | byte(seq)
+		k.pending = append(k.pending, report)
+	}
+	k.pending = append(k.pending, []byte{0, 0, 0, 0, 0, 0, 0, RESP_PENDING_FLAG})
+}
+
+func TestSlotConfig(t *testing.T) {
+	privateID := []byte{1, 2, 3, 4, 5, 6}
+	key := bytes.Repeat([]byte{0xaa}, 16)
+	otp, err := YubicoOTP("vvccccbhlnlr", privateID, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := otp.bytes(nil)
+	if len(b) != CONFIG_SIZE || calculateCRC(b) != CRC_OK_RESIDUAL {
+		t.Fatalf("Invalid configuration %x", b)
+	}
+	if !bytes.Equal(b[:6], []byte{0xff, 0x00, 0x00, 0x16, 0xab, 0xac}) || b[44] != 6 {
+		t.Fatalf("Unexpected public ID in %x", b)
+	}
+	if !bytes.Equal(b[16:22], privateID) || !bytes.Equal(b[22:38], key) {
+		t.Fatalf("Unexpected private ID or key in %x", b)
+	}
+
+	hmacKey := []byte("0123456789abcdefghij")
+	config, err := HMACSHA1(hmacKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b = config.bytes(nil)
+	if !bytes.Equal(b[22:38], hmacKey[:16]) || !bytes.Equal(b[16:20], hmacKey[16:]) {
+		t.Fatalf("Unexpected HMAC key in %x", b)
+	}
+	if b[46] != TKTFLAG_CHAL_RESP || b[47] != CFGFLAG_CHAL_HMAC|CFGFLAG_HMAC_LT64|CFGFLAG_CHAL_BTN_TRIG {
+		t.Fatalf("Unexpected flags in %x", b)
+	}
+
+	hotp, err := HOTP(hmacKey, 8, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b = hotp.bytes(nil); b[20] != 0 || b[21] != 2 || b[47] != CFGFLAG_OATH_HOTP8 {
+		t.Fatalf("Unexpected HOTP configuration %x", b)
+	}
+	if _, err := HOTP(hmacKey, 6, 17); err == nil {
+		t.Fatal("Expected an error for an initial counter that is no multiple of 16")
+	}
+
+	static, err := StaticPassword("Ab1!", LayoutUS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b = static.bytes(nil); !bytes.Equal(b[:5], []byte{0x84, 0x05, 0x1e, 0x9e, 0x00}) {
+		t.Fatalf("Unexpected scan codes in %x", b)
+	}
+	if _, err := StaticPassword("ä", LayoutUS); err == nil {
+		t.Fatal("Expected an error for a character not on the US layout")
+	}
+}
+
+func TestProtocol_Config(t *testing.T) {
+	ctx := context.Background()
+	key := &fakeKey{}
+	p, err := New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := p.Serial(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serial != 12345678 {
+		t.Fatalf("Expected serial 12345678, got %d", serial)
+	}
+
+	config, err := HMACSHA1([]byte("secret"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := []byte{1, 2, 3, 4, 5, 6}
+	status, err := p.PutConfig(ctx, 2, config, code, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Configured(2) || status.Configured(1) || status.Sequence != 1 {
+		t.Fatalf("Unexpected status %+v", status)
+	}
+	if _, err := p.DeleteConfig(ctx, 2, nil); !errors.As(err, new(*CommandRejectedError)) {
+		t.Fatalf("Expected the command to be rejected without access code, got %v", err)
+	}
+	if _, err := p.SetAccessCode(ctx, 2, nil, code); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.SwapSlots(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if key.slots[0] == nil || key.slots[1] != nil {
+		t.Fatal("Expected the configuration in slot 1 after swapping")
+	}
+	if status, err = p.DeleteConfig(ctx, 1, nil); err != nil {
+		t.Fatal(err)
+	}
+	if status.Configured(1) || key.slots[0] != nil {
+		t.Fatal("Expected slot 1 to be deleted")
+	}
+}
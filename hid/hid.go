@@ -38,6 +38,25 @@ const (
 	CRC_OK_RESIDUAL              uint16 = 0xF0B8
 )
 
+const (
+	REPORT_SIZE        = 64     // size of the input and output reports of the FIDO interface
+	USAGE_PAGE_FIDO    = 0xF1D0 // FIDO alliance usage page
+	USAGE_FIDO_CTAPHID = 0x01   // CTAPHID usage of the FIDO usage page
+)
+
+// IsFIDO reports whether the device is the FIDO interface of a security key.
+func (dev *Device) IsFIDO() bool {
+	return dev.UsagePage == USAGE_PAGE_FIDO && dev.Usage == USAGE_FIDO_CTAPHID
+}
+
+// ReportConn representing a connection that exchanges 64-byte input and output reports, as used by CTAPHID. Read
+// blocks until the next input report arrives.
+type ReportConn interface {
+	Read(report []byte) (int, error)
+	Write(report []byte) (int, error)
+	Close() error
+}
+
 // Conn representing an 8-byte HID feature report connection.
 type Conn interface {
 	Receive() ([]byte, error) // must return exactly 8 bytes
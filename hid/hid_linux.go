@@ -75,6 +75,47 @@ func (c *HidrawOtpConn) Send(data []byte) error {
 	return c.ioctl(req, buf)
 }
 
+// HidrawReportConn implements ReportConn by reading and writing hidraw input and output reports.
+type HidrawReportConn struct {
+	f *os.File
+}
+
+// OpenReports opens a hidraw path like '/dev/hidraw3' for input and output reports, e.g. of the FIDO interface.
+func (dev *Device) OpenReports() (*HidrawReportConn, error) {
+	f, err := os.OpenFile(dev.Path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &HidrawReportConn{f: f}, nil
+}
+
+func (c *HidrawReportConn) Close() error {
+	return c.f.Close()
+}
+
+// Read blocks until the next input report and copies it into report.
+func (c *HidrawReportConn) Read(report []byte) (int, error) {
+	buf := make([]byte, REPORT_SIZE)
+	n, err := c.f.Read(buf)
+	if err != nil {
+		return 0, err
+	}
+	return copy(report, buf[:n]), nil
+}
+
+// Write sends report as an output report with report ID 0.
+func (c *HidrawReportConn) Write(report []byte) (int, error) {
+	if len(report) != REPORT_SIZE {
+		return 0, fmt.Errorf("write expects %d bytes, got %d", REPORT_SIZE, len(report))
+	}
+	buf := make([]byte, 1+REPORT_SIZE)
+	copy(buf[1:], report)
+	if _, err := c.f.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(report), nil
+}
+
 func Enumerate() iter.Seq2[*Device, error] {
 	return func(yield func(device *Device, err error) bool) {
 		const sysHidraw = "/sys/class/hidraw"
@@ -0,0 +1,25 @@
+//go:build !linux && !windows
+
+package hid
+
+import (
+	"errors"
+	"iter"
+)
+
+// Enumerate yields errors.ErrUnsupported on platforms without a HID backend.
+func Enumerate() iter.Seq2[*Device, error] {
+	return func(yield func(device *Device, err error) bool) {
+		_ = yield(nil, errors.ErrUnsupported)
+	}
+}
+
+// Open is not supported on this platform.
+func (dev *Device) Open() (Conn, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// OpenReports is not supported on this platform.
+func (dev *Device) OpenReports() (ReportConn, error) {
+	return nil, errors.ErrUnsupported
+}
@@ -94,6 +94,80 @@ func (c *WinHidConn) Send(data []byte) error {
 	return hidDSetFeature(c.h, buf)
 }
 
+var _ ReportConn = (*WinHidReportConn)(nil)
+
+// WinHidReportConn implements ReportConn by reading and writing input and output reports of a HID device.
+type WinHidReportConn struct {
+	h      windows.Handle
+	inLen  uint16 // full input report length (includes report ID)
+	outLen uint16 // full output report length (includes report ID)
+}
+
+// OpenReports opens a Windows HID device (SetupAPI path) for input and output reports, e.g. of the FIDO interface.
+func (dev *Device) OpenReports() (*WinHidReportConn, error) {
+	hFile, err := windows.CreateFile(
+		windows.StringToUTF16Ptr(dev.Path),
+		windows.GENERIC_READ|windows.GENERIC_WRITE,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE,
+		nil,
+		windows.OPEN_EXISTING,
+		0,
+		0,
+	)
+	if err != nil {
+		return nil, err
+	}
+	ppd, err := hidDGetPreparsedData(hFile)
+	if err != nil {
+		_ = windows.Close(hFile)
+		return nil, err
+	}
+	defer func() { _ = hidDFreePreparsedData(ppd) }()
+	var caps hidpCaps
+	if err := hidPGetCaps(ppd, &caps); err != nil {
+		_ = windows.Close(hFile)
+		return nil, err
+	}
+	if caps.InputReportByteLength < 1+REPORT_SIZE || caps.OutputReportByteLength < 1+REPORT_SIZE {
+		_ = windows.Close(hFile)
+		return nil, fmt.Errorf("reports too small: %d/%d, need >= %d", caps.InputReportByteLength,
+			caps.OutputReportByteLength, 1+REPORT_SIZE)
+	}
+	return &WinHidReportConn{h: hFile, inLen: caps.InputReportByteLength, outLen: caps.OutputReportByteLength}, nil
+}
+
+func (c *WinHidReportConn) Close() error {
+	return windows.Close(c.h)
+}
+
+// Read blocks until the next input report and copies it into report.
+func (c *WinHidReportConn) Read(report []byte) (int, error) {
+	buf := make([]byte, c.inLen)
+	var n uint32
+	if err := windows.ReadFile(c.h, buf, &n, nil); err != nil {
+		return 0, err
+	}
+	if n < 1 {
+		return 0, errors.New("empty input report")
+	}
+	// skip the report ID
+	return copy(report, buf[1:n]), nil
+}
+
+// Write sends report as an output report with report ID 0.
+func (c *WinHidReportConn) Write(report []byte) (int, error) {
+	if len(report) != REPORT_SIZE {
+		return 0, fmt.Errorf("write expects %d bytes, got %d", REPORT_SIZE, len(report))
+	}
+	buf := make([]byte, c.outLen)
+	copy(buf[1:], report)
+	var n uint32
+	if err := windows.WriteFile(c.h, buf, &n, nil); err != nil {
+		return 0, err
+	}
+	return len(report), nil
+}
+
 func Enumerate() iter.Seq2[*Device, error] {
 	return func(yield func(device *Device, err error) bool) {
 		guid, err := getHidGuid()
@@ -0,0 +1,175 @@
+package hid
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/malivvan/aegis/mhex"
+)
+
+// SHIFT is set in a scan code of a configuration if the key is typed with shift held down.
+const SHIFT = 0x80
+
+// Layout maps characters to the HID usage IDs of the keys that type them with a keyboard layout of the host. A
+// YubiKey can only hold shift, so characters that need AltGr or dead keys cannot be typed.
+type Layout struct {
+	Name  string
+	codes map[rune]byte
+	chars map[byte]rune
+}
+
+// Keyboard layouts of the host.
+var (
+	LayoutUS = newLayout("us",
+		keyRow(0x04, "abcdefghijklmnopqrstuvwxyz", "ABCDEFGHIJKLMNOPQRSTUVWXYZ"),
+		keyRow(0x1e, "1234567890", "!@#$%^&*()"),
+		keyRow(0x2d, "-=[]\\", "_+{}|"),
+		keyRow(0x33, ";'`,./", ":\"~<>?"),
+	)
+	// LayoutDE is the German QWERTZ layout. ´, ` and ^ are dead keys and @, €, braces, brackets, backslash, | and ~
+	// need AltGr.
+	LayoutDE = newLayout("de",
+		keyRow(0x04, "abcdefghijklmnopqrstuvwxzy", "ABCDEFGHIJKLMNOPQRSTUVWXZY"),
+		keyRow(0x1e, "1234567890", "!\"§$%&/()="),
+		keyRow(0x2d, "ß", "?"),
+		keyRow(0x2f, "ü+", "Ü*"),
+		keyRow(0x32, "#öä\x00,.-", "'ÖÄ°;:_"),
+		keyRow(0x64, "<", ">"),
+	)
+	// LayoutDvorak is the US Dvorak layout.
+	LayoutDvorak = newLayout("dvorak",
+		keyRow(0x04, "axje.uidchtnmbrl'poygk,qf;", "AXJE>UIDCHTNMBRL\"POYGK<QF:"),
+		keyRow(0x1e, "1234567890", "!@#$%^&*()"),
+		keyRow(0x2d, "[]/=\\", "{}?+|"),
+		keyRow(0x33, "s-`wvz", "S_~WVZ"),
+	)
+)
+
+var layouts = map[string]*Layout{
+	LayoutUS.Name:     LayoutUS,
+	LayoutDE.Name:     LayoutDE,
+	LayoutDvorak.Name: LayoutDvorak,
+}
+
+// LookupLayout returns the layout with the given name.
+func LookupLayout(name string) (*Layout, bool) {
+	l, ok := layouts[strings.ToLower(name)]
+	return l, ok
+}
+
+// LayoutNames returns the names of the supported layouts in alphabetical order.
+func LayoutNames() []string {
+	names := make([]string, 0, len(layouts))
+	for name := range layouts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type key struct {
+	code           byte
+	plain, shifted rune
+}
+
+// keyRow returns the keys of consecutive usage IDs starting at first. A NUL character marks a dead key.
+func keyRow(first byte, plain, shifted string) []key {
+	p, s := []rune(plain), []rune(shifted)
+	if len(p) != len(s) {
+		panic("hid: plain and shifted characters of a key row differ in length")
+	}
+	keys := make([]key, len(p))
+	for i := range p {
+		keys[i] = key{code: first + byte(i), plain: p[i], shifted: s[i]}
+	}
+	return keys
+}
+
+func newLayout(name string, rows ...[]key) *Layout {
+	l := &Layout{Name: name, codes: make(map[rune]byte), chars: make(map[byte]rune)}
+	add := func(r rune, code byte) {
+		if r != 0 {
+			l.codes[r] = code
+			l.chars[code] = r
+		}
+	}
+	add(' ', 0x2c)
+	add('\t', 0x2b)
+	add('\n', 0x28)
+	for _, row := range rows {
+		for _, k := range row {
+			add(k.plain, k.code)
+			add(k.shifted, k.code|SHIFT)
+		}
+	}
+	return l
+}
+
+// UntypeableError lists the characters of a string that cannot be typed with a layout.
+type UntypeableError struct {
+	Layout string
+	Chars  []rune
+}
+
+func (e *UntypeableError) Error() string {
+	quoted := make([]string, len(e.Chars))
+	for i, r := range e.Chars {
+		quoted[i] = fmt.Sprintf("%q", r)
+	}
+	return fmt.Sprintf("characters not available on the %s keyboard layout: %s", e.Layout, strings.Join(quoted, ", "))
+}
+
+// ScanCodes returns the scan codes that type s, or an *UntypeableError.
+func (l *Layout) ScanCodes(s string) ([]byte, error) {
+	codes := make([]byte, 0, len(s))
+	var untypeable []rune
+	for _, r := range s {
+		code, ok := l.codes[r]
+		if !ok {
+			if !strings.ContainsRune(string(untypeable), r) {
+				untypeable = append(untypeable, r)
+			}
+			continue
+		}
+		codes = append(codes, code)
+	}
+	if untypeable != nil {
+		return nil, &UntypeableError{Layout: l.Name, Chars: untypeable}
+	}
+	return codes, nil
+}
+
+// Validate returns an *UntypeableError if s contains characters that cannot be typed with l.
+func (l *Layout) Validate(s string) error {
+	_, err := l.ScanCodes(s)
+	return err
+}
+
+// String returns the characters that codes type with l.
+func (l *Layout) String(codes []byte) (string, error) {
+	var b strings.Builder
+	for _, code := range codes {
+		r, ok := l.chars[code]
+		if !ok {
+			return "", fmt.Errorf("scan code %02x types no character on the %s keyboard layout", code, l.Name)
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), nil
+}
+
+// Modhex returns the alphabet that arrives at a host with layout l when a YubiKey types modhex. YubiKeys always
+// send the keys of the modhex characters on a US layout, which type the same characters on most layouts but not
+// e.g. on Dvorak.
+func (l *Layout) Modhex() mhex.Encoding {
+	codes, err := LayoutUS.ScanCodes(mhex.Alphabet)
+	if err != nil {
+		panic(err)
+	}
+	alphabet, err := l.String(codes)
+	if err != nil {
+		panic(err)
+	}
+	return mhex.New(alphabet)
+}
@@ -0,0 +1,81 @@
+package hid
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/malivvan/aegis/mhex"
+)
+
+func TestLayout_RoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		layout *Layout
+		s      string
+		codes  []byte
+	}{
+		{LayoutUS, "zY@\\", []byte{0x1d, 0x9c, 0x9f, 0x31}},
+		{LayoutDE, "zY\"ß#<>", []byte{0x1c, 0x9d, 0x9f, 0x2d, 0x32, 0x64, 0xe4}},
+		{LayoutDvorak, "aoe;Q", []byte{0x04, 0x16, 0x07, 0x1d, 0x9b}},
+	} {
+		codes, err := test.layout.ScanCodes(test.s)
+		if err != nil {
+			t.Fatalf("%s: %v", test.layout.Name, err)
+		}
+		if !bytes.Equal(codes, test.codes) {
+			t.Fatalf("%s: expected scan codes %x for %q, got %x", test.layout.Name, test.codes, test.s, codes)
+		}
+		s, err := test.layout.String(codes)
+		if err != nil {
+			t.Fatalf("%s: %v", test.layout.Name, err)
+		}
+		if s != test.s {
+			t.Fatalf("%s: expected %q, got %q", test.layout.Name, test.s, s)
+		}
+	}
+	for _, name := range LayoutNames() {
+		layout, _ := LookupLayout(name)
+		for r, code := range layout.codes {
+			if layout.chars[code] != r {
+				t.Fatalf("%s: scan code %02x of %q types %q", name, code, r, layout.chars[code])
+			}
+		}
+	}
+}
+
+func TestLayout_Validate(t *testing.T) {
+	err := LayoutDE.Validate("a@b€@^")
+	var untypeable *UntypeableError
+	if !errors.As(err, &untypeable) {
+		t.Fatalf("Expected an UntypeableError, got %v", err)
+	}
+	if untypeable.Layout != "de" || string(untypeable.Chars) != "@€^" {
+		t.Fatalf("Unexpected error %+v", untypeable)
+	}
+	if err := LayoutUS.Validate("ä"); err == nil {
+		t.Fatal("Expected ä to be untypeable on the US layout")
+	}
+	if _, err := LayoutUS.String([]byte{0x64}); err == nil {
+		t.Fatal("Expected an error for a key the US layout does not have")
+	}
+	if _, err := StaticPassword("Passwort@", LayoutDE); !errors.As(err, &untypeable) {
+		t.Fatalf("Expected an UntypeableError, got %v", err)
+	}
+}
+
+func TestLayout_Modhex(t *testing.T) {
+	if alphabet := string(LayoutDE.Modhex()); alphabet != mhex.Alphabet {
+		t.Fatalf("Expected the standard modhex alphabet on the German layout, got %s", alphabet)
+	}
+	dvorak := LayoutDvorak.Modhex()
+	if string(dvorak) != "jxe.uidchtnbpygk" {
+		t.Fatalf("Unexpected Dvorak modhex alphabet %s", dvorak)
+	}
+	b, err := dvorak.Decode(dvorak.Encode([]byte{0x01, 0xfe}))
+	if err != nil || !bytes.Equal(b, []byte{0x01, 0xfe}) {
+		t.Fatalf("Round trip failed: %x (%v)", b, err)
+	}
+	if _, ok := LookupLayout("Dvorak"); !ok {
+		t.Fatal("Expected layout names to be case insensitive")
+	}
+}
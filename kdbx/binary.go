@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"encoding/base64"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
@@ -22,6 +23,7 @@ type Binary struct {
 	MemoryProtection byte          `xml:"-"`               // Memory protection flag (Only KDBX v4)
 	Content          []byte        `xml:",innerxml"`       // Binary content
 	Compressed       w.BoolWrapper `xml:"Compressed,attr"` // Compressed flag (Only KDBX v3.1)
+	UnknownAttrs     []xml.Attr    `xml:",any,attr"`
 	isKDBX4          bool          `xml:"-"`
 }
 
@@ -31,6 +33,7 @@ type BinaryReference struct {
 	Value struct {
 		ID int `xml:"Ref,attr"`
 	} `xml:"Value"`
+	Unknown
 }
 
 // Find returns a reference to a binary with the same ID as id, or nil if none if found
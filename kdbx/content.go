@@ -34,6 +34,7 @@ type DBContent struct {
 	XMLName     xml.Name     `xml:"KeePassFile"`
 	Meta        *MetaData    `xml:"Meta"`
 	Root        *RootData    `xml:"Root"`
+	Unknown
 }
 
 func (c *DBContent) setKdbxFormatVersion(version formatVersion) {
@@ -41,6 +42,21 @@ func (c *DBContent) setKdbxFormatVersion(version formatVersion) {
 	c.Root.setKdbxFormatVersion(version)
 }
 
+// requiresKdbx41 reports whether c uses elements that were introduced with KDBX 4.1
+func (c *DBContent) requiresKdbx41() bool {
+	if c.Meta != nil && c.Meta.requiresKdbx41() {
+		return true
+	}
+	if c.Root != nil {
+		for i := range c.Root.Groups {
+			if c.Root.Groups[i].requiresKdbx41() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 type DBContentOption func(*DBContent)
 
 func WithDBContentFormattedTime(formatted bool) DBContentOption {
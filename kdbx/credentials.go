@@ -1,6 +1,7 @@
 package kdbx
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/sha256"
 	"crypto/sha512"
@@ -10,35 +11,63 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
-	"reflect"
 	"regexp"
 
 	"github.com/malivvan/aegis/kdbx/argon2"
+	"github.com/malivvan/aegis/mgrd"
 )
 
 var (
 	errUnsupportedKeyFileXMLFormat = errors.New("Unsupported key file XML format")
 )
 
+// ErrUnsupportedKdfType is returned if the KdfParameters UUID names an unknown key derivation function
+var ErrUnsupportedKdfType = errors.New("Type of key derivation function unsupported")
+
+// ErrInvalidKdfParameters is returned if the KdfParameters are out of the range the key derivation function accepts
+var ErrInvalidKdfParameters = errors.New("Parameters of key derivation function invalid")
+
+// ChallengeResponder answers a challenge with a HMAC-SHA1 response,
+// e.g. a hardware token slot configured for challenge-response
+type ChallengeResponder interface {
+	ChallengeResponse(challenge []byte) ([]byte, error)
+}
+
 // DBCredentials holds the key used to lock and unlock the database
 type DBCredentials struct {
-	Passphrase []byte // Passphrase if using one, stored in sha256 hash
-	Key        []byte // Contents of the keyfile if using one, stored in sha256 hash
-	Windows    []byte // Whatever is returned from windows user account auth, stored in sha256 hash
+	Passphrase        *mgrd.Enclave      // Passphrase if using one, stored in sha256 hash
+	Key               *mgrd.Enclave      // Contents of the keyfile if using one, stored in sha256 hash
+	Windows           []byte             // Whatever is returned from windows user account auth, stored in sha256 hash
+	ChallengeResponse ChallengeResponder // Challenge-response token if using one, response stored in sha256 hash
 }
 
-func (c *DBCredentials) buildCompositeKey() ([]byte, error) {
+// buildChallengeResponse returns the hashed response to the given challenge,
+// or nil if no challenge-response component is used
+func (c *DBCredentials) buildChallengeResponse(challenge []byte) ([]byte, error) {
+	if c.ChallengeResponse == nil {
+		return nil, nil
+	}
+	response, err := c.ChallengeResponse.ChallengeResponse(challenge)
+	if err != nil {
+		return nil, err
+	}
+	hashed := sha256.Sum256(response)
+	return hashed[:], nil
+}
+
+// buildCompositeKey hashes all credential components. If a challenge is given, the
+// challenge-response component is appended, matching KeePassXC for KDBX 4 KDFs
+func (c *DBCredentials) buildCompositeKey(challenge []byte) ([]byte, error) {
 	hash := sha256.New()
 	if c.Passphrase != nil { // If the hashed password is provided
-		_, err := hash.Write(c.Passphrase)
-		if err != nil {
+		if err := writeEnclave(hash, c.Passphrase); err != nil {
 			return nil, err
 		}
 	}
 	if c.Key != nil { // If the hashed keyfile is provided
-		_, err := hash.Write(c.Key)
-		if err != nil {
+		if err := writeEnclave(hash, c.Key); err != nil {
 			return nil, err
 		}
 	}
@@ -48,37 +77,111 @@ func (c *DBCredentials) buildCompositeKey() ([]byte, error) {
 			return nil, err
 		}
 	}
+	if challenge != nil { // If the challenge-response is part of the composite key
+		response, err := c.buildChallengeResponse(challenge)
+		if err != nil {
+			return nil, err
+		}
+		if response != nil {
+			_, err = hash.Write(response)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
 	return hash.Sum(nil), nil
 }
 
+// writeEnclave writes the content of e to w, keeping it in guarded memory
+func writeEnclave(w io.Writer, e *mgrd.Enclave) error {
+	buf, err := e.Open()
+	if err != nil {
+		return err
+	}
+	defer buf.Destroy()
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// challengeInCompositeKey reports whether the challenge-response component is mixed into the
+// composite key using the KDF seed as challenge. Otherwise (KDBX 3.1 and the legacy AES-KDF)
+// the master seed is the challenge and the response is mixed into the master key
+func challengeInCompositeKey(db *Database) bool {
+	return db.Header.IsKdbx4() &&
+		!bytes.Equal(db.Header.FileHeaders.KdfParameters.UUID, KdfAES3)
+}
+
+// argon2Parameters returns the time cost, the memory cost in KiB and the parallelism of the
+// Argon2 KdfParameters, or ErrInvalidKdfParameters if the argon2 package cannot derive a key with them
+func (k *KdfParameters) argon2Parameters() (uint32, uint32, uint8, error) {
+	if k.Iterations < 1 || k.Iterations > math.MaxUint32 {
+		return 0, 0, 0, fmt.Errorf("%w: %d iterations", ErrInvalidKdfParameters, k.Iterations)
+	}
+	if k.Parallelism < 1 || k.Parallelism > math.MaxUint8 {
+		return 0, 0, 0, fmt.Errorf("%w: parallelism of %d", ErrInvalidKdfParameters, k.Parallelism)
+	}
+	// Argon2 requires 8 KiB per lane, KeePass stores the memory in bytes
+	memory := k.Memory / 1024
+	if memory < 8*uint64(k.Parallelism) || memory > math.MaxUint32 {
+		return 0, 0, 0, fmt.Errorf("%w: %d bytes of memory", ErrInvalidKdfParameters, k.Memory)
+	}
+	return uint32(k.Iterations), uint32(memory), uint8(k.Parallelism), nil
+}
+
 func (c *DBCredentials) buildTransformedKey(db *Database) ([]byte, error) {
-	transformedKey, err := c.buildCompositeKey()
+	var challenge []byte
+	if challengeInCompositeKey(db) {
+		challenge = db.Header.FileHeaders.KdfParameters.Salt[:]
+	}
+	transformedKey, err := c.buildCompositeKey(challenge)
 	if err != nil {
 		return nil, err
 	}
 
 	if db.Header.IsKdbx4() {
-		if reflect.DeepEqual(db.Header.FileHeaders.KdfParameters.UUID, KdfArgon2) {
-			// Argon 2
+		kdf := db.Header.FileHeaders.KdfParameters
+		switch {
+		case bytes.Equal(kdf.UUID, KdfArgon2):
+			// Argon 2d
+			time, memory, threads, err := kdf.argon2Parameters()
+			if err != nil {
+				return nil, err
+			}
 			transformedKey = argon2.DKey(
 				transformedKey, // Master key
-				db.Header.FileHeaders.KdfParameters.Salt[:],             // Salt
-				uint32(db.Header.FileHeaders.KdfParameters.Iterations),  // Time cost
-				uint32(db.Header.FileHeaders.KdfParameters.Memory)/1024, // Memory cost
-				uint8(db.Header.FileHeaders.KdfParameters.Parallelism),  // Parallelism
-				32, // Hash length
+				kdf.Salt[:],    // Salt
+				time,           // Time cost
+				memory,         // Memory cost
+				threads,        // Parallelism
+				32,             // Hash length
 			)
-		} else {
+		case bytes.Equal(kdf.UUID, KdfArgon2id):
+			// Argon 2id
+			time, memory, threads, err := kdf.argon2Parameters()
+			if err != nil {
+				return nil, err
+			}
+			transformedKey = argon2.IDKey(
+				transformedKey, // Master key
+				kdf.Salt[:],    // Salt
+				time,           // Time cost
+				memory,         // Memory cost
+				threads,        // Parallelism
+				32,             // Hash length
+			)
+		case bytes.Equal(kdf.UUID, KdfAES3), bytes.Equal(kdf.UUID, KdfAES4):
 			// AES
 			key, err := cryptAESKey(
 				transformedKey,
-				db.Header.FileHeaders.KdfParameters.Salt[:],
-				db.Header.FileHeaders.KdfParameters.Rounds,
+				kdf.Salt[:],
+				kdf.Rounds,
 			)
 			if err != nil {
 				return nil, err
 			}
 			transformedKey = key[:]
+		default:
+			return nil, ErrUnsupportedKdfType
 		}
 	} else {
 		// AES
@@ -95,11 +198,18 @@ func (c *DBCredentials) buildTransformedKey(db *Database) ([]byte, error) {
 	return transformedKey, nil
 }
 
-func buildMasterKey(db *Database, transformedKey []byte) []byte {
+func buildMasterKey(db *Database, transformedKey []byte) ([]byte, error) {
 	masterKey := sha256.New()
 	masterKey.Write(db.Header.FileHeaders.MasterSeed)
+	if db.Credentials != nil && !challengeInCompositeKey(db) {
+		response, err := db.Credentials.buildChallengeResponse(db.Header.FileHeaders.MasterSeed)
+		if err != nil {
+			return nil, err
+		}
+		masterKey.Write(response)
+	}
 	masterKey.Write(transformedKey)
-	return masterKey.Sum(nil)
+	return masterKey.Sum(nil), nil
 }
 
 func buildHmacKey(db *Database, transformedKey []byte) []byte {
@@ -133,8 +243,27 @@ func cryptAESKey(masterKey []byte, seed []byte, rounds uint64) ([]byte, error) {
 
 // NewPasswordCredentials builds a new DBCredentials from a Password string
 func NewPasswordCredentials(password string) *DBCredentials {
+	return &DBCredentials{Passphrase: hashPassword(password)}
+}
+
+// hashPassword seals the sha256 hash of password into an enclave
+func hashPassword(password string) *mgrd.Enclave {
 	hashedpw := sha256.Sum256([]byte(password))
-	return &DBCredentials{Passphrase: hashedpw[:]}
+	return mgrd.NewEnclave(hashedpw[:])
+}
+
+// NewChallengeResponseCredentials builds a new DBCredentials from a challenge-response token
+func NewChallengeResponseCredentials(cr ChallengeResponder) *DBCredentials {
+	return &DBCredentials{ChallengeResponse: cr}
+}
+
+// NewPasswordAndChallengeResponseCredentials builds a new DBCredentials
+// from a password and a challenge-response token
+func NewPasswordAndChallengeResponseCredentials(password string, cr ChallengeResponder) *DBCredentials {
+	return &DBCredentials{
+		Passphrase:        hashPassword(password),
+		ChallengeResponse: cr,
+	}
 }
 
 // ParseKeyFile returns the hashed key from a key file
@@ -185,9 +314,10 @@ func ParseKeyData(data []byte) ([]byte, error) {
 		return decodedKey, err
 	}
 
-	// If the key is exactly 32 byte it is assumed to already be in the correct format
+	// If the key is exactly 32 byte it is assumed to already be in the correct format.
+	// It is copied as the credentials wipe the key when sealing it
 	if len(data) == 32 {
-		return data, nil
+		return bytes.Clone(data), nil
 	}
 
 	// If the key is 64 byte as a hex string, it should be decoded into 32 byte
@@ -265,7 +395,7 @@ func NewKeyCredentials(location string) (*DBCredentials, error) {
 		return nil, err
 	}
 
-	return &DBCredentials{Key: key}, nil
+	return &DBCredentials{Key: mgrd.NewEnclave(key)}, nil
 }
 
 // NewKeyDataCredentials builds a new DBCredentials from a key file in bytes
@@ -275,7 +405,7 @@ func NewKeyDataCredentials(data []byte) (*DBCredentials, error) {
 		return nil, err
 	}
 
-	return &DBCredentials{Key: key}, nil
+	return &DBCredentials{Key: mgrd.NewEnclave(key)}, nil
 }
 
 // NewPasswordAndKeyCredentials builds a new DBCredentials from a password
@@ -286,11 +416,9 @@ func NewPasswordAndKeyCredentials(password, location string) (*DBCredentials, er
 		return nil, err
 	}
 
-	hashedpw := sha256.Sum256([]byte(password))
-
 	return &DBCredentials{
-		Passphrase: hashedpw[:],
-		Key:        key,
+		Passphrase: hashPassword(password),
+		Key:        mgrd.NewEnclave(key),
 	}, nil
 }
 
@@ -302,19 +430,25 @@ func NewPasswordAndKeyDataCredentials(password string, data []byte) (*DBCredenti
 		return nil, err
 	}
 
-	hashedpw := sha256.Sum256([]byte(password))
-
 	return &DBCredentials{
-		Passphrase: hashedpw[:],
-		Key:        key,
+		Passphrase: hashPassword(password),
+		Key:        mgrd.NewEnclave(key),
 	}, nil
 }
 
 func (c *DBCredentials) String() string {
 	return fmt.Sprintf(
-		"Hashed Passphrase: %x\nHashed Key: %x\nHashed Windows Auth: %x",
-		c.Passphrase,
-		c.Key,
+		"Hashed Passphrase: %s\nHashed Key: %s\nHashed Windows Auth: %x",
+		sealedString(c.Passphrase),
+		sealedString(c.Key),
 		c.Windows,
 	)
 }
+
+// sealedString describes an enclave without opening it
+func sealedString(e *mgrd.Enclave) string {
+	if e == nil {
+		return "none"
+	}
+	return fmt.Sprintf("sealed (%d bytes)", e.Size())
+}
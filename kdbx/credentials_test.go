@@ -2,9 +2,14 @@ package kdbx
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
 	"fmt"
 	"reflect"
 	"testing"
+
+	"github.com/malivvan/aegis/hid"
 )
 
 func TestCryptAESKey(t *testing.T) {
@@ -200,3 +205,148 @@ func BenchmarkCryptAESKey(b *testing.B) {
 		})
 	}
 }
+
+// fakeOtpConn emulates a YubiKey OTP HID interface with an HMAC-SHA1 slot 2
+type fakeOtpConn struct {
+	secret  []byte
+	progSeq byte
+	frame   [hid.FRAME_SIZE]byte
+	pending [][]byte
+}
+
+func (c *fakeOtpConn) Receive() ([]byte, error) {
+	if len(c.pending) > 0 {
+		report := c.pending[0]
+		c.pending = c.pending[1:]
+		return report, nil
+	}
+	return []byte{0, 5, 4, 3, c.progSeq, 0x03, 0, 0}, nil
+}
+
+func (c *fakeOtpConn) Send(report []byte) error {
+	status := report[hid.FEATURE_RPT_DATA_SIZE]
+	if status&hid.SLOT_WRITE_FLAG == 0 || status == 0xFF {
+		return nil // reset of the read state
+	}
+	seq := int(status & hid.SEQUENCE_MASK)
+	copy(c.frame[seq*hid.FEATURE_RPT_DATA_SIZE:], report[:hid.FEATURE_RPT_DATA_SIZE])
+	if seq == 9 {
+		c.process()
+	}
+	return nil
+}
+
+func (c *fakeOtpConn) Close() error { return nil }
+
+func (c *fakeOtpConn) process() {
+	payload := c.frame[:hid.SLOT_DATA_SIZE]
+	if c.frame[hid.SLOT_DATA_SIZE] != hid.CMD_CHALLENGE_HMAC2 {
+		return
+	}
+	// strip the variable length challenge padding
+	end := len(payload)
+	for end > 0 && payload[end-1] == payload[len(payload)-1] {
+		end--
+	}
+	mac := hmac.New(sha1.New, c.secret)
+	mac.Write(payload[:end])
+	response := mac.Sum(nil)
+	crc := ^crc16(response)
+	response = append(response, byte(crc), byte(crc>>8))
+
+	for seq := 0; len(response) > 0; seq++ {
+		report := make([]byte, hid.FEATURE_RPT_SIZE)
+		n := copy(report, response)
+		if n > hid.FEATURE_RPT_DATA_SIZE {
+			n = hid.FEATURE_RPT_DATA_SIZE
+		}
+		response = response[n:]
+		report[hid.FEATURE_RPT_DATA_SIZE] = hid.RESP_PENDING_FLAG | byte(seq)
+		c.pending = append(c.pending, report)
+	}
+	c.pending = append(c.pending, []byte{0, 0, 0, 0, 0, 0, 0, hid.RESP_PENDING_FLAG})
+	c.frame = [hid.FRAME_SIZE]byte{}
+}
+
+func crc16(data []byte) uint16 {
+	var crc uint16 = 0xFFFF
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			j := crc & 1
+			crc >>= 1
+			if j == 1 {
+				crc ^= 0x8408
+			}
+		}
+	}
+	return crc
+}
+
+func newFakeChallengeResponder(t *testing.T, secret string) ChallengeResponder {
+	protocol, err := hid.New(&fakeOtpConn{secret: []byte(secret)})
+	if err != nil {
+		t.Fatalf("Failed to open fake token: %s", err)
+	}
+	return hid.NewChallengeResponder(context.Background(), protocol, 2, nil)
+}
+
+func TestChallengeResponseCredentials(t *testing.T) {
+	cases := []struct {
+		title   string
+		options []DatabaseOption
+	}{
+		{
+			title: "Database Format v3.1",
+		},
+		{
+			title:   "Database Format v4",
+			options: []DatabaseOption{WithDatabaseKDBXVersion4()},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			db := NewDatabase(c.options...)
+			db.Credentials = NewPasswordAndChallengeResponseCredentials(
+				password,
+				newFakeChallengeResponder(t, "yubikey secret"),
+			)
+
+			var buf bytes.Buffer
+			if err := NewEncoder(&buf).Encode(db); err != nil {
+				t.Fatalf("Failed to encode database: %s", err)
+			}
+
+			decoded := NewDatabase()
+			decoded.Credentials = NewPasswordAndChallengeResponseCredentials(
+				password,
+				newFakeChallengeResponder(t, "yubikey secret"),
+			)
+			if err := NewDecoder(bytes.NewReader(buf.Bytes())).Decode(decoded); err != nil {
+				t.Fatalf("Failed to decode database: %s", err)
+			}
+			if err := decoded.UnlockProtectedEntries(); err != nil {
+				t.Fatalf("Problem unlocking entries. %s", err)
+			}
+			if title := decoded.Content.Root.Groups[0].Entries[0].GetTitle(); title != "Sample Entry" {
+				t.Fatalf("Received title %s, expected Sample Entry", title)
+			}
+
+			wrongSecret := NewDatabase()
+			wrongSecret.Credentials = NewPasswordAndChallengeResponseCredentials(
+				password,
+				newFakeChallengeResponder(t, "another secret"),
+			)
+			if err := NewDecoder(bytes.NewReader(buf.Bytes())).Decode(wrongSecret); err == nil {
+				t.Fatal("Decoding with a wrong challenge response should fail")
+			}
+
+			passwordOnly := NewDatabase()
+			passwordOnly.Credentials = NewPasswordCredentials(password)
+			if err := NewDecoder(bytes.NewReader(buf.Bytes())).Decode(passwordOnly); err == nil {
+				t.Fatal("Decoding without the challenge response should fail")
+			}
+		})
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 
 	"github.com/malivvan/aegis/kdbx/crypto"
+	"github.com/malivvan/aegis/mgrd"
 )
 
 // Constant enumerator for the inner random stream ID
@@ -163,11 +164,16 @@ func (cs *StreamManager) UnlockProtectedEntries(e []Entry) {
 	}
 }
 
-// UnlockProtectedEntry unlocks a protected entry
+// UnlockProtectedEntry unlocks a protected entry, sealing the content of its protected values into enclaves.
+// Values that are sealed already are skipped, so unlocking an entry twice keeps their content
 func (cs *StreamManager) UnlockProtectedEntry(e *Entry) {
 	for i := range e.Values {
-		if e.Values[i].Value.Protected.Bool {
-			e.Values[i].Value.Content = string(cs.Unpack(e.Values[i].Value.Content))
+		if v := &e.Values[i].Value; v.Protected.Bool {
+			if v.enclave != nil {
+				continue
+			}
+			v.enclave = mgrd.NewEnclave(cs.Unpack(v.Content))
+			v.Content = ""
 		}
 	}
 	for i := range e.Histories {
@@ -176,35 +182,82 @@ func (cs *StreamManager) UnlockProtectedEntry(e *Entry) {
 }
 
 // LockProtectedGroups locks an array of unprotected groups
-func (cs *StreamManager) LockProtectedGroups(gs []Group) {
+func (cs *StreamManager) LockProtectedGroups(gs []Group) error {
+	var values []*V
 	for i := range gs {
-		cs.LockProtectedGroup(&gs[i])
+		values = protectedGroupValues(&gs[i], values)
 	}
+	return cs.lockValues(values)
 }
 
 // LockProtectedGroup locks an unprotected group
-func (cs *StreamManager) LockProtectedGroup(g *Group) {
-	cs.LockProtectedEntries(g.Entries)
-	cs.LockProtectedGroups(g.Groups)
+func (cs *StreamManager) LockProtectedGroup(g *Group) error {
+	return cs.lockValues(protectedGroupValues(g, nil))
 }
 
 // LockProtectedEntries locks an array of unprotected entries
-func (cs *StreamManager) LockProtectedEntries(es []Entry) {
+func (cs *StreamManager) LockProtectedEntries(es []Entry) error {
+	var values []*V
 	for i := range es {
-		cs.LockProtectedEntry(&es[i])
+		values = protectedEntryValues(&es[i], values)
 	}
+	return cs.lockValues(values)
 }
 
-// LockProtectedEntry locks an unprotected entry
-func (cs *StreamManager) LockProtectedEntry(e *Entry) {
+// LockProtectedEntry locks an unprotected entry. The enclaves of its protected values are
+// opened to pack their content and dropped afterwards
+func (cs *StreamManager) LockProtectedEntry(e *Entry) error {
+	return cs.lockValues(protectedEntryValues(e, nil))
+}
+
+// lockValues packs the content of values in order. All enclaves are opened before the first value is packed, so
+// that the values are left sealed and the stream untouched if one of them cannot be opened
+func (cs *StreamManager) lockValues(values []*V) error {
+	bufs := make([]*mgrd.LockedBuffer, 0, len(values))
+	defer func() {
+		for _, buf := range bufs {
+			buf.Destroy()
+		}
+	}()
+	for _, v := range values {
+		buf, err := v.Open()
+		if err != nil {
+			return err
+		}
+		bufs = append(bufs, buf)
+	}
+	for i, v := range values {
+		v.Content = cs.Pack(bufs[i].Bytes())
+		v.enclave = nil
+	}
+	return nil
+}
+
+// protectedGroupValues appends the protected values of the entries of g and its subgroups to values, in the order
+// they are encoded
+func protectedGroupValues(g *Group, values []*V) []*V {
+	for i := range g.Entries {
+		values = protectedEntryValues(&g.Entries[i], values)
+	}
+	for i := range g.Groups {
+		values = protectedGroupValues(&g.Groups[i], values)
+	}
+	return values
+}
+
+// protectedEntryValues appends the protected values of e and its history to values
+func protectedEntryValues(e *Entry, values []*V) []*V {
 	for i := range e.Values {
-		if e.Values[i].Value.Protected.Bool {
-			e.Values[i].Value.Content = cs.Pack([]byte(e.Values[i].Value.Content))
+		if v := &e.Values[i].Value; v.Protected.Bool {
+			values = append(values, v)
 		}
 	}
 	for i := range e.Histories {
-		cs.LockProtectedEntries(e.Histories[i].Entries)
+		for j := range e.Histories[i].Entries {
+			values = protectedEntryValues(&e.Histories[i].Entries[j], values)
+		}
 	}
+	return values
 }
 
 // ErrUnsupportedEncrypterType is retured if no encrypter manager can be created
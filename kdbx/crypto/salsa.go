@@ -58,14 +58,14 @@ func NewSalsaStream(key []byte) (*SalsaStream, error) {
 
 // Unpack returns the payload as unencrypted byte array
 func (s *SalsaStream) Unpack(payload string) []byte {
-	var result []byte
-
 	data, _ := base64.StdEncoding.DecodeString(payload)
 
 	salsaBytes := s.fetchBytes(len(data))
 
+	// allocated once, so that no partial copies of the plaintext are left behind by append
+	result := make([]byte, len(data))
 	for i := 0; i < len(data); i++ {
-		result = append(result, salsaBytes[i]^data[i])
+		result[i] = salsaBytes[i] ^ data[i]
 	}
 	return result
 }
@@ -2,6 +2,8 @@ package kdbx
 
 import (
 	"testing"
+
+	"github.com/malivvan/aegis/mgrd"
 )
 
 const testMessage = "test message"
@@ -59,3 +61,45 @@ func TestSalsa(t *testing.T) {
 		t.Fatalf("Failed to decode salsa: should be 'test message' not '%s'", decrypted)
 	}
 }
+
+func TestUnlockProtectedEntry_Twice(t *testing.T) {
+	key := make([]byte, 64)
+	e := Entry{Values: []ValueData{{Key: "Password", Value: NewV(testMessage, true)}}}
+
+	c, _ := NewStreamManager(ChaChaStreamID, key)
+	if err := c.LockProtectedEntry(&e); err != nil {
+		t.Fatalf("Failed to lock entry: %v", err)
+	}
+
+	// unlocking an unlocked entry again must not unpack the sealed values a second time
+	c2, _ := NewStreamManager(ChaChaStreamID, key)
+	c2.UnlockProtectedEntry(&e)
+	c2.UnlockProtectedEntry(&e)
+
+	if value := openPassword(t, &e); value != testMessage {
+		t.Fatalf("Failed to unlock twice: should be '%s' not '%s'", testMessage, value)
+	}
+}
+
+func TestLockProtectedEntry_Failed(t *testing.T) {
+	key := make([]byte, 64)
+	// the enclave of the second value cannot be opened anymore once the session key was purged
+	stale := NewV("stale", true)
+	mgrd.Purge()
+	e := Entry{Values: []ValueData{
+		{Key: "Password", Value: NewV(testMessage, true)},
+		{Key: "Stale", Value: stale},
+	}}
+
+	c, _ := NewStreamManager(ChaChaStreamID, key)
+	if err := c.LockProtectedEntry(&e); err == nil {
+		t.Fatal("Expected locking to fail")
+	}
+	// the first value must not be left packed with a stream that advanced
+	if value := e.Values[0].Value; !value.Sealed() || value.Content != "" {
+		t.Fatalf("Expected the password to stay sealed, got %q", value.Content)
+	}
+	if value := openPassword(t, &e); value != testMessage {
+		t.Fatalf("Expected password '%s', got '%s'", testMessage, value)
+	}
+}
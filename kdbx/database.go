@@ -44,6 +44,44 @@ func WithDatabaseKDBXVersion4() DatabaseOption {
 	}
 }
 
+func WithDatabaseKDBXVersion41() DatabaseOption {
+	return func(db *Database) {
+		db.Header = NewKDBX41Header()
+		withDBContentKDBX4InnerHeader(db.Content)
+	}
+}
+
+// WithDatabaseArgon2d configures a KDBX version 4 database to derive its key with Argon2d.
+// Memory is given in bytes and needs at least 8 KiB per lane, parallelism ranges from 1 to 255.
+// Encoding a database with parameters out of range fails with ErrInvalidKdfParameters
+func WithDatabaseArgon2d(memory, iterations uint64, parallelism uint32) DatabaseOption {
+	return withDatabaseArgon2(KdfArgon2, memory, iterations, parallelism)
+}
+
+// WithDatabaseArgon2id configures a KDBX version 4 database to derive its key with Argon2id.
+// Memory is given in bytes and needs at least 8 KiB per lane, parallelism ranges from 1 to 255.
+// Encoding a database with parameters out of range fails with ErrInvalidKdfParameters
+func WithDatabaseArgon2id(memory, iterations uint64, parallelism uint32) DatabaseOption {
+	return withDatabaseArgon2(KdfArgon2id, memory, iterations, parallelism)
+}
+
+func withDatabaseArgon2(uuid []byte, memory, iterations uint64, parallelism uint32) DatabaseOption {
+	return func(db *Database) {
+		// Argon2 is only available from KDBX version 4 on
+		if db.Header == nil || !db.Header.IsKdbx4() {
+			WithDatabaseKDBXVersion4()(db)
+		}
+
+		kdf := db.Header.FileHeaders.KdfParameters
+		kdf.UUID = uuid
+		kdf.Rounds = 0
+		kdf.Memory = memory
+		kdf.Iterations = iterations
+		kdf.Parallelism = parallelism
+		kdf.Version = defaultVersion
+	}
+}
+
 // NewDatabase creates a new database with some sensable default settings in KDBX version 3.1.
 // To create a database with no settings pre-set, use kdbx.Database{}
 func NewDatabase(options ...DatabaseOption) *Database {
@@ -92,9 +130,13 @@ func (db *Database) getTransformedKey() ([]byte, error) {
 // GetEncrypterManager returns an EncryptManager based on the master key and EncryptionIV,
 // or nil if the type is unsupported
 func (db *Database) GetEncrypterManager(transformedKey []byte) (*EncrypterManager, error) {
+	masterKey, err := buildMasterKey(db, transformedKey)
+	if err != nil {
+		return nil, err
+	}
 	return NewEncrypterManager(
 		db.Header.FileHeaders.CipherID,
-		buildMasterKey(db, transformedKey),
+		masterKey,
 		db.Header.FileHeaders.EncryptionIV,
 	)
 }
@@ -130,8 +172,8 @@ func (db *Database) GetStreamManager() (*StreamManager, error) {
 	return nil, nil
 }
 
-// UnlockProtectedEntries goes through the entire database and encrypts
-// any Values in entries with protected=true set.
+// UnlockProtectedEntries goes through the entire database and decrypts
+// any Values in entries with protected=true set into enclaves.
 // This should be called after decoding if you want to view plaintext password in an entry
 // Warning: If you call this when entry values are already unlocked,
 // it will cause them to be unreadable
@@ -147,8 +189,9 @@ func (db *Database) UnlockProtectedEntries() error {
 	return nil
 }
 
-// LockProtectedEntries goes through the entire database and decrypts
-// any Values in entries with protected=true set.
+// LockProtectedEntries goes through the entire database and encrypts
+// any Values in entries with protected=true set, dropping their enclaves.
+// If the content of a value cannot be opened, an error is returned and no value is locked.
 // Warning: Do not call this if entries are already locked
 // Warning: Encoding a database calls LockProtectedEntries automatically
 func (db *Database) LockProtectedEntries() error {
@@ -156,8 +199,7 @@ func (db *Database) LockProtectedEntries() error {
 	if err != nil {
 		return err
 	}
-	manager.LockProtectedGroups(db.Content.Root.Groups)
-	return nil
+	return manager.LockProtectedGroups(db.Content.Root.Groups)
 }
 
 // AddBinary adds a binary to the database.
@@ -174,6 +216,51 @@ func (db *Database) FindBinary(id int) *Binary {
 	return db.getBinaries().Find(id)
 }
 
+// TruncateHistory removes the oldest versions from the history of e until it holds at most Meta.HistoryMaxItems
+// versions taking at most Meta.HistoryMaxSize bytes. A negative limit is not enforced
+func (db *Database) TruncateHistory(e *Entry) {
+	if len(e.Histories) == 0 {
+		return
+	}
+	history := e.Histories[0].Entries
+	if max := db.Content.Meta.HistoryMaxItems; max >= 0 && int64(len(history)) > max {
+		history = history[int64(len(history))-max:]
+	}
+	if max := db.Content.Meta.HistoryMaxSize; max >= 0 {
+		var size int64
+		for i := range history {
+			size += db.entrySize(&history[i])
+		}
+		for len(history) > 0 && size > max {
+			size -= db.entrySize(&history[0])
+			history = history[1:]
+		}
+	}
+	e.Histories[0].Entries = history
+}
+
+// entrySize estimates the bytes an entry takes the way KeePass does for HistoryMaxSize, by the sizes of its
+// strings and binaries
+func (db *Database) entrySize(e *Entry) int64 {
+	size := int64(len(e.Tags) + len(e.OverrideURL))
+	for _, v := range e.Values {
+		size += int64(len(v.Key) + len(v.Value.Content))
+		if v.Value.enclave != nil {
+			size += int64(v.Value.enclave.Size())
+		}
+	}
+	for _, b := range e.Binaries {
+		size += int64(len(b.Name))
+		if binary := db.FindBinary(b.Value.ID); binary != nil {
+			size += int64(len(binary.Content))
+		}
+	}
+	for _, d := range e.CustomData {
+		size += int64(len(d.Key) + len(d.Value))
+	}
+	return size
+}
+
 // ErrRequiredAttributeMissing is returned if a required value is not given
 type ErrRequiredAttributeMissing string
 
@@ -150,6 +150,127 @@ func TestDatabase_GetStreamManager(t *testing.T) {
 	}
 }
 
+func TestNewDatabase_Argon2(t *testing.T) {
+	cases := []struct {
+		title        string
+		option       DatabaseOption
+		expectedUUID []byte
+	}{
+		{
+			title:        "with argon2d",
+			option:       WithDatabaseArgon2d(2*1024*1024, 3, 1),
+			expectedUUID: KdfArgon2,
+		},
+		{
+			title:        "with argon2id",
+			option:       WithDatabaseArgon2id(2*1024*1024, 3, 1),
+			expectedUUID: KdfArgon2id,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			db := NewDatabase(c.option)
+			db.Credentials = NewPasswordCredentials(password)
+
+			if !db.Header.IsKdbx4() {
+				t.Fatalf("Expected a KDBX4 header, received version %d", db.Header.Signature.MajorVersion)
+			}
+			kdf := db.Header.FileHeaders.KdfParameters
+			if !bytes.Equal(kdf.UUID, c.expectedUUID) {
+				t.Fatalf("Expected KDF %x, received %x", c.expectedUUID, kdf.UUID)
+			}
+			if kdf.Memory != 2*1024*1024 || kdf.Iterations != 3 || kdf.Parallelism != 1 {
+				t.Fatalf("Received unexpected KDF parameters %s", kdf)
+			}
+
+			var buf bytes.Buffer
+			if err := NewEncoder(&buf).Encode(db); err != nil {
+				t.Fatalf("Failed to encode database: %s", err)
+			}
+
+			decoded := NewDatabase()
+			decoded.Credentials = NewPasswordCredentials(password)
+			if err := NewDecoder(&buf).Decode(decoded); err != nil {
+				t.Fatalf("Failed to decode database: %s", err)
+			}
+			decodedKdf := decoded.Header.FileHeaders.KdfParameters
+			if !bytes.Equal(decodedKdf.UUID, c.expectedUUID) ||
+				decodedKdf.Memory != kdf.Memory ||
+				decodedKdf.Iterations != kdf.Iterations ||
+				decodedKdf.Parallelism != kdf.Parallelism {
+				t.Fatalf("Decoded KDF parameters %s do not match %s", decodedKdf, kdf)
+			}
+		})
+	}
+}
+
+func TestDatabase_UnsupportedKdf(t *testing.T) {
+	db := NewDatabase(WithDatabaseKDBXVersion4())
+	db.Credentials = NewPasswordCredentials(password)
+	db.Header.FileHeaders.KdfParameters.UUID = []byte{0x01, 0x02, 0x03, 0x04}
+
+	if _, err := db.getTransformedKey(); !errors.Is(err, ErrUnsupportedKdfType) {
+		t.Fatalf("Expected %v, received %v", ErrUnsupportedKdfType, err)
+	}
+}
+
+func TestDatabase_InvalidArgon2Parameters(t *testing.T) {
+	cases := []struct {
+		title  string
+		option DatabaseOption
+	}{
+		{"no parallelism", WithDatabaseArgon2d(2*1024*1024, 3, 0)},
+		{"parallelism over 255", WithDatabaseArgon2id(2*1024*1024, 3, 256)},
+		{"parallelism wrapping to 1", WithDatabaseArgon2id(2*1024*1024, 3, 257)},
+		{"no iterations", WithDatabaseArgon2d(2*1024*1024, 0, 1)},
+		{"memory below 8 KiB per lane", WithDatabaseArgon2id(15*1024, 3, 2)},
+		{"memory over 32 bits of KiB", WithDatabaseArgon2d(1<<42, 3, 1)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.title, func(t *testing.T) {
+			db := NewDatabase(c.option)
+			db.Credentials = NewPasswordCredentials(password)
+
+			if err := NewEncoder(new(bytes.Buffer)).Encode(db); !errors.Is(err, ErrInvalidKdfParameters) {
+				t.Fatalf("Expected %v, received %v", ErrInvalidKdfParameters, err)
+			}
+		})
+	}
+
+	t.Run("memory over 4 GiB", func(t *testing.T) {
+		kdf := &KdfParameters{Memory: 1<<32 + 2*1024*1024, Iterations: 3, Parallelism: 1}
+		if _, memory, _, err := kdf.argon2Parameters(); err != nil || memory != 1<<22+2*1024 {
+			t.Fatalf("Expected a memory cost of %d KiB, received %d (%v)", 1<<22+2*1024, memory, err)
+		}
+	})
+
+	t.Run("decoding", func(t *testing.T) {
+		db := NewDatabase(WithDatabaseArgon2d(2*1024*1024, 3, 1))
+		db.Credentials = NewPasswordCredentials(password)
+		var buf bytes.Buffer
+		if err := NewEncoder(&buf).Encode(db); err != nil {
+			t.Fatalf("Failed to encode database: %s", err)
+		}
+
+		// patch the parallelism in the KdfParameters of the header to 0
+		data := buf.Bytes()
+		i := bytes.Index(data, []byte("\x01\x00\x00\x00P\x04\x00\x00\x00\x01\x00\x00\x00"))
+		if i < 0 {
+			t.Fatal("Parallelism not found in the encoded header")
+		}
+		data[i+9] = 0
+
+		decoded := NewDatabase()
+		decoded.Options.ValidateHashes = false
+		decoded.Credentials = NewPasswordCredentials(password)
+		if err := NewDecoder(bytes.NewReader(data)).Decode(decoded); !errors.Is(err, ErrInvalidKdfParameters) {
+			t.Fatalf("Expected %v, received %v", ErrInvalidKdfParameters, err)
+		}
+	})
+}
+
 func ExampleNewDatabase_kdbxv3() {
 	buf := bytes.NewBuffer([]byte{})
 
@@ -93,6 +93,14 @@ func TestDecodeFile(t *testing.T) {
 			},
 			testContent: true,
 		},
+		{
+			title:      "Database Format v4.1, password credentials",
+			dbFilePath: "tests/kdbx4/example-41.kdbx",
+			newCredentials: func() (*DBCredentials, error) {
+				return NewPasswordCredentials("abcdefg12345678"), nil
+			},
+			testContent: true,
+		},
 		{
 			title:      "Database Format v4, without compression, password credentials",
 			dbFilePath: "tests/kdbx4/example-nocompression.kdbx",
@@ -173,7 +181,7 @@ func TestDecodeFile(t *testing.T) {
 			}
 
 			// Test password matching
-			pw := db.Content.Root.Groups[0].Groups[0].Entries[0].GetPassword()
+			pw := openPassword(t, &db.Content.Root.Groups[0].Groups[0].Entries[0])
 			if pw != password {
 				t.Fatalf(
 					"Failed to decode password: should be 'Password' not '%s'",
@@ -182,7 +190,7 @@ func TestDecodeFile(t *testing.T) {
 			}
 
 			// Test secondary password matching
-			pw = db.Content.Root.Groups[0].Groups[0].Entries[1].GetPassword()
+			pw = openPassword(t, &db.Content.Root.Groups[0].Groups[0].Entries[1])
 			if pw != anotherPassword {
 				t.Fatalf(
 					"Failed to decode password: should be 'AnotherPassword' not '%s'",
@@ -192,3 +200,43 @@ func TestDecodeFile(t *testing.T) {
 		})
 	}
 }
+
+func TestDecodeFile41(t *testing.T) {
+	file, err := os.Open("tests/kdbx4/example-41.kdbx")
+	if err != nil {
+		t.Fatalf("Failed to open keepass file: %s", err)
+	}
+	defer file.Close()
+
+	db := NewDatabase()
+	db.Credentials = NewPasswordCredentials("abcdefg12345678")
+	if err := NewDecoder(file).Decode(db); err != nil {
+		t.Fatalf("Failed to decode file: %s", err)
+	}
+	if !db.Header.IsKdbx41() {
+		t.Fatalf("Expected a KDBX 4.1 header, received %s", db.Header.Signature)
+	}
+
+	root := db.Content.Root.Groups[0]
+	if root.Groups[0].Tags != "work;mail" {
+		t.Fatalf("Expected group tags 'work;mail', received '%s'", root.Groups[0].Tags)
+	}
+	if parent := root.Groups[1].PreviousParentGroup; parent == nil || *parent != root.Groups[0].UUID {
+		t.Fatalf("Expected the previous parent group of the second group, received %v", parent)
+	}
+	if check := root.Groups[0].Entries[0].QualityCheck; check == nil || check.Bool {
+		t.Fatalf("Expected the quality check to be disabled, received %v", check)
+	}
+	if parent := root.Groups[0].Entries[1].PreviousParentGroup; parent == nil || *parent != root.Groups[0].UUID {
+		t.Fatalf("Expected the previous parent group of the entry, received %v", parent)
+	}
+	icons := db.Content.Meta.CustomIcons
+	icon := icons[len(icons)-1]
+	if icon.Name != "aegis" || icon.LastModificationTime == nil || icon.LastModificationTime.Time.Year() != 2024 {
+		t.Fatalf("Expected the name and modification time of the custom icon, received %+v", icon)
+	}
+	items := db.Content.Meta.CustomData
+	if item := items[len(items)-1]; item.LastModificationTime == nil {
+		t.Fatalf("Expected the modification time of the custom data item, received %+v", item)
+	}
+}
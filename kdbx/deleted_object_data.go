@@ -11,6 +11,7 @@ type DeletedObjectData struct {
 	XMLName      xml.Name       `xml:"DeletedObject"`
 	UUID         UUID           `xml:"UUID"`
 	DeletionTime *w.TimeWrapper `xml:"DeletionTime"`
+	Unknown
 }
 
 func (d *DeletedObjectData) setKdbxFormatVersion(version formatVersion) {
@@ -38,7 +38,8 @@ func (e *Encoder) Encode(db *Database) error {
 		return err
 	}
 
-	// ensure timestamps will be formatted correctly
+	// ensure the format version can hold the content and timestamps will be formatted correctly
+	db.Header.negotiateVersion(db.Content)
 	db.ensureKdbxFormatVersion()
 
 	// Calculate transformed key to make HMAC and encrypt
@@ -1,6 +1,8 @@
 package kdbx
 
 import (
+	"bytes"
+	"errors"
 	"os"
 	"reflect"
 	"testing"
@@ -50,13 +52,13 @@ func TestEncodeFile31(t *testing.T) {
 	}
 	db.Content.Meta.CustomIcons = []CustomIcon{
 		{
-			UUID{
+			UUID: UUID{
 				0xde, 0xad, 0xbe, 0xef,
 				0xc0, 0xff, 0xee, 0xde,
 				0xed, 0x01, 0x23, 0x45,
 				0x67, 0x89, 0xab, 0xcd,
 			},
-			encodedIcon,
+			Data: encodedIcon,
 		},
 	}
 
@@ -95,7 +97,7 @@ func TestEncodeFile31(t *testing.T) {
 	}
 
 	// Test password matching
-	pw := db.Content.Root.Groups[0].Groups[0].Entries[0].GetPassword()
+	pw := openPassword(t, &db.Content.Root.Groups[0].Groups[0].Entries[0])
 	if pw != password {
 		t.Fatalf(
 			"Failed to decode password: should be 'Password' not '%s'",
@@ -126,13 +128,13 @@ func TestEncodeFile31(t *testing.T) {
 	if !reflect.DeepEqual(
 		db.Content.Meta.CustomIcons[0],
 		CustomIcon{
-			UUID{
+			UUID: UUID{
 				0xde, 0xad, 0xbe, 0xef,
 				0xc0, 0xff, 0xee, 0xde,
 				0xed, 0x01, 0x23, 0x45,
 				0x67, 0x89, 0xab, 0xcd,
 			},
-			encodedIcon,
+			Data: encodedIcon,
 		},
 	) {
 		t.Fatal("Failed to properly store a custom icon in the Meta block")
@@ -180,13 +182,13 @@ func TestEncodeFile4(t *testing.T) {
 	}
 	db.Content.Meta.CustomIcons = []CustomIcon{
 		{
-			UUID{
+			UUID: UUID{
 				0xde, 0xad, 0xbe, 0xef,
 				0xc0, 0xff, 0xee, 0xde,
 				0xed, 0x01, 0x23, 0x45,
 				0x67, 0x89, 0xab, 0xcd,
 			},
-			encodedIcon,
+			Data: encodedIcon,
 		},
 	}
 
@@ -225,7 +227,7 @@ func TestEncodeFile4(t *testing.T) {
 	}
 
 	// Test password matching
-	pw := db.Content.Root.Groups[0].Groups[0].Entries[0].GetPassword()
+	pw := openPassword(t, &db.Content.Root.Groups[0].Groups[0].Entries[0])
 	if pw != password {
 		t.Fatalf(
 			"Failed to decode password: should be 'Password' not '%s'",
@@ -255,13 +257,13 @@ func TestEncodeFile4(t *testing.T) {
 	if !reflect.DeepEqual(
 		db.Content.Meta.CustomIcons[0],
 		CustomIcon{
-			UUID{
+			UUID: UUID{
 				0xde, 0xad, 0xbe, 0xef,
 				0xc0, 0xff, 0xee, 0xde,
 				0xed, 0x01, 0x23, 0x45,
 				0x67, 0x89, 0xab, 0xcd,
 			},
-			encodedIcon,
+			Data: encodedIcon,
 		},
 	) {
 		t.Fatal("Failed to properly store a custom icon in the Meta block")
@@ -337,7 +339,7 @@ func TestEncodeFile4_NoCompression(t *testing.T) {
 	}
 
 	// Test password matching
-	pw := db.Content.Root.Groups[0].Groups[0].Entries[0].GetPassword()
+	pw := openPassword(t, &db.Content.Root.Groups[0].Groups[0].Entries[0])
 	if pw != password {
 		t.Fatalf(
 			"Failed to decode password: should be 'Password' not '%s'",
@@ -413,3 +415,55 @@ func TestCreateNewFile(t *testing.T) {
 		)
 	}
 }
+
+func TestEncodeFile41(t *testing.T) {
+	encode := func(db *Database) *bytes.Buffer {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := db.LockProtectedEntries(); err != nil {
+			t.Fatal(err)
+		}
+		if err := NewEncoder(&buf).Encode(db); err != nil {
+			t.Fatalf("Failed to encode file: %s", err)
+		}
+		return &buf
+	}
+	decode := func(buf *bytes.Buffer) *Database {
+		t.Helper()
+		db := NewDatabase()
+		db.Credentials = NewPasswordCredentials("abcdefg12345678")
+		if err := NewDecoder(buf).Decode(db); err != nil {
+			t.Fatalf("Failed to decode file: %s", err)
+		}
+		return db
+	}
+
+	db := NewDatabase(WithDatabaseKDBXVersion4())
+	db.Credentials = NewPasswordCredentials("abcdefg12345678")
+	if decoded := decode(encode(db)); decoded.Header.IsKdbx41() {
+		t.Fatal("Expected a database without KDBX 4.1 elements to be written as 4.0")
+	}
+	if DefaultKDBX4Sig.MinorVersion != 0 {
+		t.Fatal("Expected the default signature to be left unchanged")
+	}
+
+	db.Content.Root.Groups[0].Tags = "tag"
+	decoded := decode(encode(db))
+	if !decoded.Header.IsKdbx41() || decoded.Content.Root.Groups[0].Tags != "tag" {
+		t.Fatalf("Expected group tags to raise the version to 4.1, received %s", decoded.Header.Signature)
+	}
+
+	// a 4.1 file keeps its elements when it is written again
+	decoded.Content.Root.Groups[0].Tags = ""
+	if again := decode(encode(decoded)); !again.Header.IsKdbx41() {
+		t.Fatal("Expected the version not to be lowered")
+	}
+
+	raw := encode(NewDatabase(WithDatabaseKDBXVersion41())).Bytes()
+	raw[10] = 5 // major version
+	db = NewDatabase()
+	db.Credentials = NewPasswordCredentials("abcdefg12345678")
+	if err := NewDecoder(bytes.NewReader(raw)).Decode(db); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("Expected ErrUnsupportedVersion for KDBX 5, received %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 
 	w "github.com/malivvan/aegis/kdbx/wrappers"
+	"github.com/malivvan/aegis/mgrd"
 )
 
 type EntryOption func(*Entry)
@@ -16,19 +17,22 @@ func WithEntryFormattedTime(formatted bool) EntryOption {
 
 // Entry is the structure which holds information about a parsed entry in a keepass database
 type Entry struct {
-	UUID            UUID              `xml:"UUID"`
-	IconID          int64             `xml:"IconID"`
-	CustomIconUUID  UUID              `xml:"CustomIconUUID"`
-	ForegroundColor string            `xml:"ForegroundColor"`
-	BackgroundColor string            `xml:"BackgroundColor"`
-	OverrideURL     string            `xml:"OverrideURL"`
-	Tags            string            `xml:"Tags"`
-	Times           TimeData          `xml:"Times"`
-	Values          []ValueData       `xml:"String,omitempty"`
-	AutoType        AutoTypeData      `xml:"AutoType"`
-	Histories       []History         `xml:"History"`
-	Binaries        []BinaryReference `xml:"Binary,omitempty"`
-	CustomData      []CustomData      `xml:"CustomData>Item"`
+	UUID                UUID              `xml:"UUID"`
+	IconID              int64             `xml:"IconID"`
+	CustomIconUUID      UUID              `xml:"CustomIconUUID"`
+	ForegroundColor     string            `xml:"ForegroundColor"`
+	BackgroundColor     string            `xml:"BackgroundColor"`
+	OverrideURL         string            `xml:"OverrideURL"`
+	QualityCheck        *w.BoolWrapper    `xml:"QualityCheck,omitempty"` // KDBX 4.1, password quality is checked if nil
+	Tags                string            `xml:"Tags"`
+	PreviousParentGroup *UUID             `xml:"PreviousParentGroup,omitempty"` // KDBX 4.1
+	Times               TimeData          `xml:"Times"`
+	Values              []ValueData       `xml:"String,omitempty"`
+	AutoType            AutoTypeData      `xml:"AutoType"`
+	Histories           []History         `xml:"History"`
+	Binaries            []BinaryReference `xml:"Binary,omitempty"`
+	CustomData          []CustomData      `xml:"CustomData>Item"`
+	Unknown
 }
 
 // NewEntry return a new entry with time data and uuid set
@@ -46,12 +50,28 @@ func NewEntry(options ...EntryOption) Entry {
 
 func (e *Entry) setKdbxFormatVersion(version formatVersion) {
 	(&e.Times).setKdbxFormatVersion(version)
+	setCustomDataKdbxFormatVersion(e.CustomData, version)
 
 	for i := range e.Histories {
 		(&e.Histories[i]).setKdbxFormatVersion(version)
 	}
 }
 
+func (e *Entry) requiresKdbx41() bool {
+	if e.PreviousParentGroup != nil || (e.QualityCheck != nil && !e.QualityCheck.Bool) ||
+		customDataRequiresKdbx41(e.CustomData) {
+		return true
+	}
+	for _, history := range e.Histories {
+		for i := range history.Entries {
+			if history.Entries[i].requiresKdbx41() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Clone creates a copy of an Entry struct including its child entities
 func (e Entry) Clone() Entry {
 	clone := e
@@ -79,15 +99,26 @@ func (e *Entry) Get(key string) *ValueData {
 	return nil
 }
 
-// GetContent returns the content of the value belonging to the given key in string form
+// GetContent returns the content of the unprotected value belonging to the given key in string form.
+// Protected values are kept in guarded memory and have to be read with OpenContent, GetContent returns ""
 func (e *Entry) GetContent(key string) string {
 	val := e.Get(key)
-	if val == nil {
+	if val == nil || val.Value.Protected.Bool {
 		return ""
 	}
 	return val.Value.Content
 }
 
+// OpenContent returns the content of the value belonging to the given key in guarded memory,
+// or an empty buffer if none is found. The caller has to destroy the buffer
+func (e *Entry) OpenContent(key string) (*mgrd.LockedBuffer, error) {
+	val := e.Get(key)
+	if val == nil {
+		return mgrd.NewBuffer(0), nil
+	}
+	return val.Value.Open()
+}
+
 // GetIndex returns the index of the Value belonging to the given key, or -1 if none is found
 func (e *Entry) GetIndex(key string) int {
 	for i := range e.Values {
@@ -98,9 +129,9 @@ func (e *Entry) GetIndex(key string) int {
 	return -1
 }
 
-// GetPassword returns the password of an entry
-func (e *Entry) GetPassword() string {
-	return e.GetContent("Password")
+// OpenPassword returns the password of an entry in guarded memory. The caller has to destroy the buffer
+func (e *Entry) OpenPassword() (*mgrd.LockedBuffer, error) {
+	return e.OpenContent("Password")
 }
 
 // GetPasswordIndex returns the index in the values slice belonging to the password
@@ -117,6 +148,7 @@ func (e *Entry) GetTitle() string {
 // in the form of a list of previous versions of that entry
 type History struct {
 	Entries []Entry `xml:"Entry"`
+	Unknown
 }
 
 func (h *History) setKdbxFormatVersion(version formatVersion) {
@@ -141,12 +173,53 @@ func (h History) Clone() History {
 type ValueData struct {
 	Key   string `xml:"Key"`
 	Value V      `xml:"Value"`
+	Unknown
 }
 
-// V is a wrapper for the content of a value, so that it can store whether it is protected
+// V is a wrapper for the content of a value, so that it can store whether it is protected.
+// Unlocking the database moves the content of protected values into an enclave, leaving Content empty
 type V struct {
 	Content   string        `xml:",chardata"`
 	Protected w.BoolWrapper `xml:"Protected,attr,omitempty"`
+	enclave   *mgrd.Enclave
+	Unknown
+}
+
+// NewV returns a value with the given content, which is sealed into an enclave if it is protected
+func NewV(content string, protected bool) V {
+	v := V{Protected: w.NewBoolWrapper(protected)}
+	if protected {
+		v.enclave = mgrd.NewEnclave([]byte(content))
+	} else {
+		v.Content = content
+	}
+	return v
+}
+
+// NewProtectedV returns a protected value holding the content of buf, which is sealed into its enclave and destroyed
+func NewProtectedV(buf *mgrd.LockedBuffer) V {
+	return V{Protected: w.NewBoolWrapper(true), enclave: buf.Seal()}
+}
+
+// Sealed reports whether the content of v is held in an enclave
+func (v *V) Sealed() bool {
+	return v.enclave != nil
+}
+
+// IsEmpty reports whether v has no content, without opening its enclave
+func (v *V) IsEmpty() bool {
+	if v.enclave != nil {
+		return v.enclave.Size() == 0
+	}
+	return v.Content == ""
+}
+
+// Open returns the content of v in guarded memory. The caller has to destroy the buffer
+func (v *V) Open() (*mgrd.LockedBuffer, error) {
+	if v.enclave != nil {
+		return v.enclave.Open()
+	}
+	return mgrd.NewBufferFromBytes([]byte(v.Content)), nil
 }
 
 // AutoTypeData is a structure containing auto type settings of an entry
@@ -155,17 +228,38 @@ type AutoTypeData struct {
 	DataTransferObfuscation int64                 `xml:"DataTransferObfuscation"`
 	DefaultSequence         string                `xml:"DefaultSequence"`
 	Associations            []AutoTypeAssociation `xml:"Association,omitempty"`
+	Unknown
 }
 
 // AutoTypeAssociation is a structure that store the keystroke sequence of a window for AutoTypeData
 type AutoTypeAssociation struct {
 	Window            string `xml:"Window"`
 	KeystrokeSequence string `xml:"KeystrokeSequence"`
+	Unknown
 }
 
 // CustomData is the structure for plugins custom data
 type CustomData struct {
-	XMLName xml.Name `xml:"Item"`
-	Key     string   `xml:"Key"`
-	Value   string   `xml:"Value"`
+	XMLName              xml.Name       `xml:"Item"`
+	Key                  string         `xml:"Key"`
+	Value                string         `xml:"Value"`
+	LastModificationTime *w.TimeWrapper `xml:"LastModificationTime,omitempty"` // KDBX 4.1
+	Unknown
+}
+
+func setCustomDataKdbxFormatVersion(items []CustomData, version formatVersion) {
+	for i := range items {
+		if items[i].LastModificationTime != nil {
+			items[i].LastModificationTime.Formatted = !isKdbx4(version)
+		}
+	}
+}
+
+func customDataRequiresKdbx41(items []CustomData) bool {
+	for _, item := range items {
+		if item.LastModificationTime != nil {
+			return true
+		}
+	}
+	return false
 }
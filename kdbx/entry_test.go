@@ -266,3 +266,54 @@ func TestHistory_Clone(t *testing.T) {
 		})
 	}
 }
+
+func TestV_Sealed(t *testing.T) {
+	db := NewDatabase(WithDatabaseKDBXVersion4())
+	db.Content.Root.Groups = []Group{NewGroup()}
+	entry := NewEntry()
+	entry.Values = []ValueData{
+		{Key: "Title", Value: NewV("title", false)},
+		{Key: "Password", Value: NewV(password, true)},
+		{Key: "Empty", Value: NewV("", true)},
+	}
+	db.Content.Root.Groups[0].Entries = []Entry{entry}
+	e := &db.Content.Root.Groups[0].Entries[0]
+
+	for i := 0; i < 2; i++ {
+		value := e.Get("Password").Value
+		if !value.Sealed() || value.Content != "" || value.IsEmpty() {
+			t.Fatalf("Expected the password to be sealed, got %+v", value)
+		}
+		if pw := openPassword(t, e); pw != password {
+			t.Fatalf("Expected password %q, got %q", password, pw)
+		}
+		if e.GetContent("Password") != "" {
+			t.Fatal("Expected GetContent not to return protected values")
+		}
+		if e.GetContent("Title") != "title" || e.Get("Title").Value.Sealed() {
+			t.Fatal("Expected the title to be stored in plain text")
+		}
+		if empty := e.Get("Empty").Value; !empty.IsEmpty() {
+			t.Fatalf("Expected an empty protected value, got %+v", empty)
+		}
+
+		if err := db.LockProtectedEntries(); err != nil {
+			t.Fatal(err)
+		}
+		if value := e.Get("Password").Value; value.Sealed() || value.Content == "" || value.Content == password {
+			t.Fatalf("Expected the locked password to be packed, got %+v", value)
+		}
+		if err := db.UnlockProtectedEntries(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf, err := e.OpenContent("Missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Destroy()
+	if buf.Size() != 0 {
+		t.Fatalf("Expected an empty buffer for a missing value, got %d bytes", buf.Size())
+	}
+}
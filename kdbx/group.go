@@ -47,9 +47,12 @@ type Group struct {
 	EnableAutoType          w.NullableBoolWrapper `xml:"EnableAutoType"`
 	EnableSearching         w.NullableBoolWrapper `xml:"EnableSearching"`
 	LastTopVisibleEntry     string                `xml:"LastTopVisibleEntry"`
+	PreviousParentGroup     *UUID                 `xml:"PreviousParentGroup,omitempty"` // KDBX 4.1
+	Tags                    string                `xml:"Tags,omitempty"`                // KDBX 4.1
 	Entries                 []Entry               `xml:"Entry,omitempty"`
 	Groups                  []Group               `xml:"Group,omitempty"`
 	groupChildOrder         int                   `xml:"-"`
+	Unknown
 }
 
 // Clone creates a copy of a Group struct including its child entities
@@ -68,7 +71,8 @@ func (g Group) Clone() Group {
 }
 
 // UnmarshalXML unmarshals the boolean from d
-func (g *Group) UnmarshalXML(d *xml.Decoder, _ xml.StartElement) error {
+func (g *Group) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	g.UnknownAttrs = append(g.UnknownAttrs[:0], start.Attr...)
 	for {
 		token, err := d.Token()
 		if errors.Is(err, io.EOF) {
@@ -131,6 +135,13 @@ func unmarshalGroupToken(g *Group, d *xml.Decoder, element xml.StartElement) err
 		return d.DecodeElement(&g.EnableSearching, &element)
 	case "LastTopVisibleEntry":
 		return d.DecodeElement(&g.LastTopVisibleEntry, &element)
+	case "PreviousParentGroup":
+		g.PreviousParentGroup = new(UUID)
+		return d.DecodeElement(g.PreviousParentGroup, &element)
+	case "Tags":
+		return d.DecodeElement(&g.Tags, &element)
+	default:
+		return g.unmarshalUnknownElement(d, element)
 	}
 
 	return nil
@@ -163,3 +174,20 @@ func (g *Group) setKdbxFormatVersion(version formatVersion) {
 		(&g.Entries[i]).setKdbxFormatVersion(version)
 	}
 }
+
+func (g *Group) requiresKdbx41() bool {
+	if g.Tags != "" || g.PreviousParentGroup != nil {
+		return true
+	}
+	for i := range g.Entries {
+		if g.Entries[i].requiresKdbx41() {
+			return true
+		}
+	}
+	for i := range g.Groups {
+		if g.Groups[i].requiresKdbx41() {
+			return true
+		}
+	}
+	return false
+}
@@ -310,6 +310,39 @@ func TestGroupUnmarshalXML(t *testing.T) {
 				LastTopVisibleEntry:     "SnB29sd3a06jo6GR1BkGBQ==",
 			},
 		},
+		{
+			title: "with KDBX 4.1 fields",
+			xmlData: `
+      <Group>
+        <Name>kdbx41</Name>
+        <PreviousParentGroup>uJSFMJ8KrUSO0Qiivnk2Eg==</PreviousParentGroup>
+        <Tags>work;mail</Tags>
+        <Entry>
+          <QualityCheck>False</QualityCheck>
+          <PreviousParentGroup>uJSFMJ8KrUSO0Qiivnk2Eg==</PreviousParentGroup>
+        </Entry>
+      </Group>`,
+			expectedGroup: Group{
+				Name: "kdbx41",
+				PreviousParentGroup: &UUID{
+					0xb8, 0x94, 0x85, 0x30,
+					0x9f, 0xa, 0xad, 0x44,
+					0x8e, 0xd1, 0x8, 0xa2,
+					0xbe, 0x79, 0x36, 0x12,
+				},
+				Tags: "work;mail",
+				Entries: []Entry{{
+					QualityCheck: &w.BoolWrapper{Bool: false},
+					PreviousParentGroup: &UUID{
+						0xb8, 0x94, 0x85, 0x30,
+						0x9f, 0xa, 0xad, 0x44,
+						0x8e, 0xd1, 0x8, 0xa2,
+						0xbe, 0x79, 0x36, 0x12,
+					},
+				}},
+				groupChildOrder: groupChildOrderEntryFirst,
+			},
+		},
 	}
 
 	for _, c := range cases {
@@ -25,10 +25,16 @@ var (
 	// DefaultKDBX4Sig is the full valid default signature struct for new databases (Kdbx v4.0)
 	DefaultKDBX4Sig = Signature{BaseSignature, SecondarySignature, 0, 4}
 
+	// DefaultKDBX41Sig is the full valid default signature struct for new databases (Kdbx v4.1)
+	DefaultKDBX41Sig = Signature{BaseSignature, SecondarySignature, 1, 4}
+
 	// DefaultSig is the full valid default signature struct for new databases (Kdbx v3.1)
 	DefaultSig = DefaultKDBX3Sig
 
 	errHeaderSHA256MisMatching = errors.New("Sha256 of header mismatching")
+
+	// ErrUnsupportedVersion is returned if the major format version of a file is newer than KDBX 4
+	ErrUnsupportedVersion = errors.New("Format version of database unsupported")
 )
 
 // Compression flags
@@ -57,7 +63,8 @@ const (
 	defaultIterations      = 2
 	defaultVersion         = 19
 
-	kdbxV4Version = 4
+	kdbxV4Version       = 4
+	kdbxV41MinorVersion = 1
 )
 
 // CipherAES is the AES cipher ID
@@ -108,6 +115,14 @@ var KdfArgon2 = []byte{
 	0x03, 0xE3, 0x0A, 0x0C,
 }
 
+// KdfArgon2id is the Argon2id key derivation function ID
+var KdfArgon2id = []byte{
+	0x9E, 0x29, 0x8B, 0x19,
+	0x56, 0xDB, 0x47, 0x73,
+	0xB2, 0x3D, 0xFC, 0x3E,
+	0xC6, 0xF0, 0xA1, 0xE6,
+}
+
 // DBHeader is the header of a database
 type DBHeader struct {
 	RawData     []byte
@@ -178,16 +193,27 @@ func NewHeader() *DBHeader {
 
 // NewKDBX3Header creates a new Header with good defaults for KDBX3
 func NewKDBX3Header() *DBHeader {
+	sig := DefaultKDBX3Sig
 	return &DBHeader{
-		Signature:   &DefaultKDBX3Sig,
+		Signature:   &sig,
 		FileHeaders: NewKDBX3FileHeaders(),
 	}
 }
 
 // NewKDBX4Header creates a new Header with good defaults for KDBX4
 func NewKDBX4Header() *DBHeader {
+	sig := DefaultKDBX4Sig
+	return &DBHeader{
+		Signature:   &sig,
+		FileHeaders: NewKDBX4FileHeaders(),
+	}
+}
+
+// NewKDBX41Header creates a new Header with good defaults for KDBX4.1
+func NewKDBX41Header() *DBHeader {
+	sig := DefaultKDBX41Sig
 	return &DBHeader{
-		Signature:   &DefaultKDBX4Sig,
+		Signature:   &sig,
 		FileHeaders: NewKDBX4FileHeaders(),
 	}
 }
@@ -266,6 +292,10 @@ func (h *DBHeader) readFrom(r io.Reader) error {
 	if err := binary.Read(tR, binary.LittleEndian, h.Signature); err != nil {
 		return err
 	}
+	// Newer minor versions only add elements, newer major versions change the file structure
+	if h.Signature.MajorVersion > kdbxV4Version {
+		return ErrUnsupportedVersion
+	}
 
 	// Read file headers
 	h.FileHeaders = new(FileHeaders)
@@ -499,7 +529,7 @@ func (k *KdfParameters) updateRawData() {
 	if len(k.AssocData) > 0 {
 		assocDataItem := &VariantDictionaryItem{
 			Type:  variantDictionaryTypeBinary,
-			Name:  []byte("K"),
+			Name:  []byte("A"),
 			Value: k.AssocData,
 		}
 		dict.Items = append(dict.Items, assocDataItem)
@@ -759,10 +789,26 @@ func (h *DBHeader) IsKdbx4() bool {
 	return isKdbx4(formatVersion(h.Signature.MajorVersion))
 }
 
+// IsKdbx41 returns true if the header version is 4.1 or a later minor version of 4
+func (h *DBHeader) IsKdbx41() bool {
+	return h.IsKdbx4() && h.Signature.MinorVersion >= kdbxV41MinorVersion
+}
+
 func (h *DBHeader) formatVersion() formatVersion {
 	return formatVersion(h.Signature.MajorVersion)
 }
 
+// negotiateVersion raises a KDBX 4.0 header to 4.1 if content uses elements introduced with 4.1.
+// Like KeePass, the version is only raised when needed, so that 4.0 readers can still open other files
+func (h *DBHeader) negotiateVersion(content *DBContent) {
+	if !h.IsKdbx4() || h.IsKdbx41() || !content.requiresKdbx41() {
+		return
+	}
+	sig := *h.Signature
+	sig.MinorVersion = kdbxV41MinorVersion
+	h.Signature = &sig
+}
+
 // GetSha256 returns the Sha256 hash of the header
 func (h *DBHeader) GetSha256() [32]byte {
 	return sha256.Sum256(h.RawData)
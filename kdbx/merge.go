@@ -0,0 +1,435 @@
+package kdbx
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	w "github.com/malivvan/aegis/kdbx/wrappers"
+)
+
+// ErrNoRootGroup is returned by Merge if a database has no root group
+var ErrNoRootGroup = errors.New("kdbx: database has no root group")
+
+// ChangeType is the kind of a change Merge made to the local database
+type ChangeType int
+
+const (
+	ChangeAdded   ChangeType = iota // the group or entry only existed in the remote database
+	ChangeUpdated                   // the remote version was newer, the local one was moved into the history
+	ChangeHistory                   // the local version was newer, the remote one was added to the history
+	ChangeMoved                     // the group or entry was moved to its parent group in the remote database
+	ChangeDeleted                   // the group or entry was deleted in the remote database
+)
+
+func (t ChangeType) String() string {
+	switch t {
+	case ChangeAdded:
+		return "added"
+	case ChangeUpdated:
+		return "updated"
+	case ChangeHistory:
+		return "history"
+	case ChangeMoved:
+		return "moved"
+	case ChangeDeleted:
+		return "deleted"
+	}
+	return fmt.Sprintf("ChangeType(%d)", int(t))
+}
+
+// Change is a change Merge made to a group or entry of the local database
+type Change struct {
+	Type  ChangeType
+	UUID  UUID
+	Group bool   // whether a group or an entry was changed
+	Name  string // name of the group or title of the entry
+}
+
+func (c Change) String() string {
+	kind := "entry"
+	if c.Group {
+		kind = "group"
+	}
+	return fmt.Sprintf("%s %s %s", c.Type, kind, c.Name)
+}
+
+// MergeRule is applied by Merge to each entry found in both databases, after the newer version was chosen, with
+// the merged entry and the other version. It keeps what must not be lost to an older version, e.g. a counter that
+// must never decrease, and reports whether it changed merged
+type MergeRule func(merged, other *Entry) bool
+
+// merger holds the state of a merge of remote into local
+type merger struct {
+	local, remote *Database
+	deleted       map[UUID]time.Time
+	binaries      map[int]int // ids of remote binaries to ids of the copies in local
+	rules         []MergeRule
+	changes       []Change
+}
+
+// Merge synchronizes local with remote the way KeePass does. Groups and entries are matched by their UUID and
+// the version that was modified last wins, with the other version of an entry added to its history. Objects one of
+// the databases deleted after they were last modified are removed, and objects are moved to the parent group of the
+// database that changed their location last. The rules are applied to entries found in both databases. Both
+// databases have to be unlocked, only local is changed. Merge returns the changes made to local
+func Merge(local, remote *Database, rules ...MergeRule) ([]Change, error) {
+	if len(local.Content.Root.Groups) == 0 || len(remote.Content.Root.Groups) == 0 {
+		return nil, ErrNoRootGroup
+	}
+	m := &merger{local: local, remote: remote, deleted: map[UUID]time.Time{}, binaries: map[int]int{}, rules: rules}
+	m.mergeDeletedObjects()
+
+	root := m.localRoot()
+	remoteRoot := &remote.Content.Root.Groups[0]
+	if root.UUID == remoteRoot.UUID {
+		m.mergeGroupData(root, remoteRoot)
+	}
+	if err := m.mergeChildren(remoteRoot, root.UUID); err != nil {
+		return nil, err
+	}
+	m.applyDeletions(m.localRoot())
+	m.mergeCustomIcons()
+	return m.changes, nil
+}
+
+func (m *merger) localRoot() *Group {
+	return &m.local.Content.Root.Groups[0]
+}
+
+// group returns the local group with the given uuid, or the root group if there is none
+func (m *merger) group(uuid UUID) *Group {
+	if g, _ := findGroup(m.localRoot(), uuid); g != nil {
+		return g
+	}
+	return m.localRoot()
+}
+
+func (m *merger) change(t ChangeType, uuid UUID, group bool, name string) {
+	m.changes = append(m.changes, Change{Type: t, UUID: uuid, Group: group, Name: name})
+}
+
+// mergeDeletedObjects adds the deleted objects of remote to local, keeping the later deletion time of objects both
+// databases deleted
+func (m *merger) mergeDeletedObjects() {
+	objects := &m.local.Content.Root.DeletedObjects
+	index := map[UUID]int{}
+	for i, object := range *objects {
+		index[object.UUID] = i
+		m.deleted[object.UUID] = timeOf(object.DeletionTime)
+	}
+	for _, object := range m.remote.Content.Root.DeletedObjects {
+		i, ok := index[object.UUID]
+		if !ok {
+			index[object.UUID] = len(*objects)
+			*objects = append(*objects, object)
+			m.deleted[object.UUID] = timeOf(object.DeletionTime)
+			continue
+		}
+		if after(timeOf(object.DeletionTime), m.deleted[object.UUID]) {
+			(*objects)[i].DeletionTime = object.DeletionTime
+			m.deleted[object.UUID] = timeOf(object.DeletionTime)
+		}
+	}
+}
+
+// isDeleted reports whether the object was deleted after it was modified last
+func (m *merger) isDeleted(uuid UUID, times *TimeData) bool {
+	deleted, ok := m.deleted[uuid]
+	return ok && !after(timeOf(times.LastModificationTime), deleted)
+}
+
+// mergeChildren merges the groups and entries of the remote group g into the local group parent
+func (m *merger) mergeChildren(g *Group, parent UUID) error {
+	for i := range g.Groups {
+		remote := &g.Groups[i]
+		target := remote.UUID
+		if local, localParent := findGroup(m.localRoot(), remote.UUID); local != nil {
+			m.mergeGroupData(local, remote)
+			m.moveGroup(local, localParent, remote, parent)
+		} else if m.isDeleted(remote.UUID, &remote.Times) {
+			// the children that were not deleted as well are kept in the parent group
+			target = parent
+		} else {
+			added := *remote
+			added.Entries, added.Groups = nil, nil
+			to := m.group(parent)
+			to.Groups = append(to.Groups, added)
+			m.change(ChangeAdded, remote.UUID, true, remote.Name)
+		}
+		if err := m.mergeChildren(remote, target); err != nil {
+			return err
+		}
+	}
+
+	for i := range g.Entries {
+		remote := &g.Entries[i]
+		local, localParent := findEntry(m.localRoot(), remote.UUID)
+		if local == nil {
+			if m.isDeleted(remote.UUID, &remote.Times) {
+				continue
+			}
+			added, err := m.importEntry(remote)
+			if err != nil {
+				return err
+			}
+			to := m.group(parent)
+			to.Entries = append(to.Entries, added)
+			m.change(ChangeAdded, remote.UUID, false, remote.GetTitle())
+			continue
+		}
+		if err := m.mergeEntry(local, remote); err != nil {
+			return err
+		}
+		m.moveEntry(local, localParent, remote, parent)
+	}
+	return nil
+}
+
+// mergeGroupData replaces the data of the local group with the one of the remote group if that is newer
+func (m *merger) mergeGroupData(local, remote *Group) {
+	if !after(timeOf(remote.Times.LastModificationTime), timeOf(local.Times.LastModificationTime)) {
+		return
+	}
+	entries, groups, locationChanged := local.Entries, local.Groups, local.Times.LocationChanged
+	*local = *remote
+	local.Entries, local.Groups, local.Times.LocationChanged = entries, groups, locationChanged
+	m.change(ChangeUpdated, local.UUID, true, local.Name)
+}
+
+// moveGroup moves the local group into parent if the remote database changed its location last
+func (m *merger) moveGroup(local, localParent, remote *Group, parent UUID) {
+	if localParent == nil || localParent.UUID == parent ||
+		!after(timeOf(remote.Times.LocationChanged), timeOf(local.Times.LocationChanged)) {
+		return
+	}
+	// a group cannot be moved into itself
+	if to, _ := findGroup(local, parent); to != nil || local.UUID == parent {
+		return
+	}
+	moved := *local
+	moved.Times.LocationChanged = remote.Times.LocationChanged
+	for i := range localParent.Groups {
+		if localParent.Groups[i].UUID == moved.UUID {
+			localParent.Groups = append(localParent.Groups[:i], localParent.Groups[i+1:]...)
+			break
+		}
+	}
+	to := m.group(parent)
+	to.Groups = append(to.Groups, moved)
+	m.change(ChangeMoved, moved.UUID, true, moved.Name)
+}
+
+// moveEntry moves the local entry into parent if the remote database changed its location last
+func (m *merger) moveEntry(local *Entry, localParent *Group, remote *Entry, parent UUID) {
+	if localParent.UUID == parent ||
+		!after(timeOf(remote.Times.LocationChanged), timeOf(local.Times.LocationChanged)) {
+		return
+	}
+	moved := *local
+	moved.Times.LocationChanged = remote.Times.LocationChanged
+	for i := range localParent.Entries {
+		if localParent.Entries[i].UUID == moved.UUID {
+			localParent.Entries = append(localParent.Entries[:i], localParent.Entries[i+1:]...)
+			break
+		}
+	}
+	to := m.group(parent)
+	to.Entries = append(to.Entries, moved)
+	m.change(ChangeMoved, moved.UUID, false, moved.GetTitle())
+}
+
+// mergeEntry keeps the newer version of an entry with the history of both versions, the older version included,
+// truncated to the limits of the local database
+func (m *merger) mergeEntry(local, remote *Entry) error {
+	imported, err := m.importEntry(remote)
+	if err != nil {
+		return err
+	}
+	localTime, remoteTime := timeOf(local.Times.LastModificationTime), timeOf(remote.Times.LastModificationTime)
+
+	history := entryHistory(local)
+	known := len(history)
+	versions := map[int64]bool{}
+	for _, e := range history {
+		versions[timeOf(e.Times.LastModificationTime).Unix()] = true
+	}
+	for _, e := range entryHistory(&imported) {
+		if !versions[timeOf(e.Times.LastModificationTime).Unix()] {
+			versions[timeOf(e.Times.LastModificationTime).Unix()] = true
+			history = append(history, e)
+		}
+	}
+
+	other, updated := withoutHistory(imported), false
+	switch {
+	case after(remoteTime, localTime):
+		other = withoutHistory(*local)
+		history = append(history, other)
+		locationChanged := local.Times.LocationChanged
+		*local = imported
+		local.Times.LocationChanged = locationChanged
+		m.change(ChangeUpdated, local.UUID, false, local.GetTitle())
+		updated = true
+	case after(localTime, remoteTime) && !versions[remoteTime.Unix()]:
+		history = append(history, other)
+		m.change(ChangeHistory, local.UUID, false, local.GetTitle())
+	case len(history) > known:
+		m.change(ChangeHistory, local.UUID, false, local.GetTitle())
+	}
+	for _, rule := range m.rules {
+		if rule(local, &other) && !updated {
+			m.change(ChangeUpdated, local.UUID, false, local.GetTitle())
+			updated = true
+		}
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return timeOf(history[i].Times.LastModificationTime).Before(timeOf(history[j].Times.LastModificationTime))
+	})
+	local.Histories = nil
+	if len(history) > 0 {
+		local.Histories = []History{{Entries: history}}
+		m.local.TruncateHistory(local)
+	}
+	return nil
+}
+
+// importEntry returns a copy of a remote entry whose binaries are copied into the local database
+func (m *merger) importEntry(remote *Entry) (Entry, error) {
+	e := *remote
+	e.Values = append([]ValueData(nil), remote.Values...)
+	e.CustomData = append([]CustomData(nil), remote.CustomData...)
+	e.Binaries = append([]BinaryReference(nil), remote.Binaries...)
+	for i := range e.Binaries {
+		id, err := m.importBinary(e.Binaries[i].Value.ID)
+		if err != nil {
+			return Entry{}, fmt.Errorf("%s: %w", remote.GetTitle(), err)
+		}
+		e.Binaries[i].Value.ID = id
+	}
+	e.Histories = make([]History, len(remote.Histories))
+	for i, history := range remote.Histories {
+		e.Histories[i] = History{Entries: make([]Entry, len(history.Entries)), Unknown: history.Unknown}
+		for j := range history.Entries {
+			imported, err := m.importEntry(&history.Entries[j])
+			if err != nil {
+				return Entry{}, err
+			}
+			e.Histories[i].Entries[j] = imported
+		}
+	}
+	return e, nil
+}
+
+func (m *merger) importBinary(id int) (int, error) {
+	if localID, ok := m.binaries[id]; ok {
+		return localID, nil
+	}
+	binary := m.remote.FindBinary(id)
+	if binary == nil {
+		return 0, fmt.Errorf("kdbx: binary %d not found", id)
+	}
+	content, err := binary.GetContentBytes()
+	if err != nil {
+		return 0, err
+	}
+	localID := m.local.AddBinary(content).ID
+	m.binaries[id] = localID
+	return localID, nil
+}
+
+// applyDeletions removes the deleted groups and entries below g. Groups are only removed if none of their
+// children are kept
+func (m *merger) applyDeletions(g *Group) {
+	entries := g.Entries[:0]
+	for _, e := range g.Entries {
+		if m.isDeleted(e.UUID, &e.Times) {
+			m.change(ChangeDeleted, e.UUID, false, e.GetTitle())
+			continue
+		}
+		entries = append(entries, e)
+	}
+	g.Entries = entries
+
+	groups := g.Groups[:0]
+	for _, child := range g.Groups {
+		m.applyDeletions(&child)
+		if len(child.Entries) == 0 && len(child.Groups) == 0 && m.isDeleted(child.UUID, &child.Times) {
+			m.change(ChangeDeleted, child.UUID, true, child.Name)
+			continue
+		}
+		groups = append(groups, child)
+	}
+	g.Groups = groups
+}
+
+// mergeCustomIcons adds the custom icons of remote that local does not have
+func (m *merger) mergeCustomIcons() {
+	icons := map[UUID]bool{}
+	for _, icon := range m.local.Content.Meta.CustomIcons {
+		icons[icon.UUID] = true
+	}
+	for _, icon := range m.remote.Content.Meta.CustomIcons {
+		if !icons[icon.UUID] {
+			m.local.Content.Meta.CustomIcons = append(m.local.Content.Meta.CustomIcons, icon)
+		}
+	}
+}
+
+// findGroup returns the group with the given uuid below g, which may be g itself, and its parent
+func findGroup(g *Group, uuid UUID) (*Group, *Group) {
+	if g.UUID == uuid {
+		return g, nil
+	}
+	for i := range g.Groups {
+		if g.Groups[i].UUID == uuid {
+			return &g.Groups[i], g
+		}
+		if found, parent := findGroup(&g.Groups[i], uuid); found != nil {
+			return found, parent
+		}
+	}
+	return nil, nil
+}
+
+// findEntry returns the entry with the given uuid below g and its parent
+func findEntry(g *Group, uuid UUID) (*Entry, *Group) {
+	for i := range g.Entries {
+		if g.Entries[i].UUID == uuid {
+			return &g.Entries[i], g
+		}
+	}
+	for i := range g.Groups {
+		if found, parent := findEntry(&g.Groups[i], uuid); found != nil {
+			return found, parent
+		}
+	}
+	return nil, nil
+}
+
+func entryHistory(e *Entry) []Entry {
+	var history []Entry
+	for _, h := range e.Histories {
+		history = append(history, h.Entries...)
+	}
+	return history
+}
+
+func withoutHistory(e Entry) Entry {
+	e.Histories = nil
+	return e
+}
+
+func timeOf(t *w.TimeWrapper) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return t.Time
+}
+
+// after compares with the precision of one second that KDBX 4 stores times with
+func after(a, b time.Time) bool {
+	return a.Unix() > b.Unix()
+}
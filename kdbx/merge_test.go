@@ -0,0 +1,292 @@
+package kdbx
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	w "github.com/malivvan/aegis/kdbx/wrappers"
+)
+
+var mergeBase = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// mergeTime returns the time minutes after mergeBase
+func mergeTime(minutes int) *w.TimeWrapper {
+	return &w.TimeWrapper{Time: mergeBase.Add(time.Duration(minutes) * time.Minute)}
+}
+
+func setMergeTimes(times *TimeData, minutes int) {
+	times.LastModificationTime = mergeTime(minutes)
+	times.LocationChanged = mergeTime(minutes)
+}
+
+func newMergeGroup(name string) Group {
+	g := NewGroup()
+	g.Name = name
+	setMergeTimes(&g.Times, 0)
+	return g
+}
+
+func newMergeEntry(title string) Entry {
+	e := NewEntry()
+	e.Values = []ValueData{
+		{Key: "Title", Value: NewV(title, false)},
+		{Key: "Password", Value: NewV(title+" password", true)},
+	}
+	setMergeTimes(&e.Times, 0)
+	return e
+}
+
+// mergeCopies encodes db and returns two decoded and unlocked copies of it
+func mergeCopies(t *testing.T, db *Database) (*Database, *Database) {
+	t.Helper()
+	db.Credentials = NewPasswordCredentials("password")
+	if err := db.LockProtectedEntries(); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(db); err != nil {
+		t.Fatal(err)
+	}
+	var copies [2]*Database
+	for i := range copies {
+		copies[i] = NewDatabase()
+		copies[i].Credentials = db.Credentials
+		if err := NewDecoder(bytes.NewReader(buf.Bytes())).Decode(copies[i]); err != nil {
+			t.Fatal(err)
+		}
+		if err := copies[i].UnlockProtectedEntries(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return copies[0], copies[1]
+}
+
+func expectChanges(t *testing.T, changes []Change, expected ...string) {
+	t.Helper()
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected changes %q, received %v", expected, changes)
+	}
+	for i, change := range changes {
+		if change.String() != expected[i] {
+			t.Fatalf("Expected changes %q, received %v", expected, changes)
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	db := NewDatabase(WithDatabaseKDBXVersion4())
+	root := newMergeGroup("Root")
+	a, b := newMergeGroup("a"), newMergeGroup("b")
+	a.Entries = []Entry{newMergeEntry("e1")}
+	b.Entries = []Entry{newMergeEntry("e2")}
+	root.Groups = []Group{a, b}
+	root.Entries = []Entry{newMergeEntry("e3"), newMergeEntry("e5")}
+	db.Content.Root.Groups = []Group{root}
+	local, remote := mergeCopies(t, db)
+
+	// the remote version of e1 is newer
+	e1, _ := findEntry(&remote.Content.Root.Groups[0], a.Entries[0].UUID)
+	e1.Values[0].Value = NewV("e1 remote", false)
+	e1.Times.LastModificationTime = mergeTime(10)
+	// the local version of e2 is newer
+	e2, _ := findEntry(&remote.Content.Root.Groups[0], b.Entries[0].UUID)
+	e2.Values[0].Value = NewV("e2 remote", false)
+	e2.Times.LastModificationTime = mergeTime(10)
+	e2, _ = findEntry(&local.Content.Root.Groups[0], b.Entries[0].UUID)
+	e2.Values[0].Value = NewV("e2 local", false)
+	e2.Times.LastModificationTime = mergeTime(20)
+	// remote adds group c with an entry with an attachment
+	c := newMergeGroup("c")
+	e4 := newMergeEntry("e4")
+	e4.Binaries = []BinaryReference{remote.AddBinary([]byte("attachment")).CreateReference("file.txt")}
+	c.Entries = []Entry{e4}
+	remoteRoot := &remote.Content.Root.Groups[0]
+	remoteRoot.Groups = append(remoteRoot.Groups, c)
+	// remote moves b into a
+	moved := remoteRoot.Groups[1]
+	moved.Times.LocationChanged = mergeTime(10)
+	remoteRoot.Groups[0].Groups = append(remoteRoot.Groups[0].Groups, moved)
+	remoteRoot.Groups = append(remoteRoot.Groups[:1], remoteRoot.Groups[2:]...)
+	// remote deletes e3 and local deletes e5
+	remoteRoot.Entries = remoteRoot.Entries[1:]
+	remote.Content.Root.DeletedObjects = append(remote.Content.Root.DeletedObjects,
+		DeletedObjectData{UUID: root.Entries[0].UUID, DeletionTime: mergeTime(10)})
+	localRoot := &local.Content.Root.Groups[0]
+	localRoot.Entries = localRoot.Entries[:1]
+	local.Content.Root.DeletedObjects = append(local.Content.Root.DeletedObjects,
+		DeletedObjectData{UUID: root.Entries[1].UUID, DeletionTime: mergeTime(10)})
+
+	changes, err := Merge(local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectChanges(t, changes,
+		"moved group b", "history entry e2 local", "updated entry e1 remote",
+		"added group c", "added entry e4", "deleted entry e3")
+
+	localRoot = &local.Content.Root.Groups[0]
+	if len(localRoot.Entries) != 0 || len(localRoot.Groups) != 2 || localRoot.Groups[0].Groups[0].Name != "b" {
+		t.Fatalf("Unexpected groups and entries of the root group: %+v", localRoot)
+	}
+	e1, _ = findEntry(localRoot, a.Entries[0].UUID)
+	if e1.GetTitle() != "e1 remote" || len(e1.Histories) != 1 || e1.Histories[0].Entries[0].GetTitle() != "e1" {
+		t.Fatalf("Expected the remote version of e1 with the local one in its history, received %+v", e1)
+	}
+	e2, parent := findEntry(localRoot, b.Entries[0].UUID)
+	if e2.GetTitle() != "e2 local" || len(e2.Histories) != 1 || e2.Histories[0].Entries[0].GetTitle() != "e2 remote" {
+		t.Fatalf("Expected the local version of e2 with the remote one in its history, received %+v", e2)
+	}
+	if parent.Name != "b" {
+		t.Fatalf("Expected e2 to stay in group b, received %s", parent.Name)
+	}
+	added, parent := findEntry(localRoot, e4.UUID)
+	if added == nil || parent.Name != "c" || openPassword(t, added) != "e4 password" {
+		t.Fatalf("Expected e4 in group c, received %+v", added)
+	}
+	content, err := local.FindBinary(added.Binaries[0].Value.ID).GetContentString()
+	if err != nil || content != "attachment" {
+		t.Fatalf("Expected the attachment of e4, received %q %v", content, err)
+	}
+	if len(local.Content.Root.DeletedObjects) != 2 {
+		t.Fatalf("Expected the deleted objects of both databases, received %+v", local.Content.Root.DeletedObjects)
+	}
+
+	// merging again changes nothing
+	changes, err = Merge(local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectChanges(t, changes)
+}
+
+func TestMerge_DeletedGroup(t *testing.T) {
+	db := NewDatabase(WithDatabaseKDBXVersion4())
+	root := newMergeGroup("Root")
+	g := newMergeGroup("g")
+	g.Entries = []Entry{newMergeEntry("kept"), newMergeEntry("deleted")}
+	root.Groups = []Group{g}
+	db.Content.Root.Groups = []Group{root}
+	local, remote := mergeCopies(t, db)
+
+	// remote deletes the group with its entries, local changes one of them afterwards
+	remote.Content.Root.Groups[0].Groups = nil
+	for _, uuid := range []UUID{g.UUID, g.Entries[0].UUID, g.Entries[1].UUID} {
+		remote.Content.Root.DeletedObjects = append(remote.Content.Root.DeletedObjects,
+			DeletedObjectData{UUID: uuid, DeletionTime: mergeTime(10)})
+	}
+	kept, _ := findEntry(&local.Content.Root.Groups[0], g.Entries[0].UUID)
+	kept.Times.LastModificationTime = mergeTime(20)
+
+	changes, err := Merge(local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectChanges(t, changes, "deleted entry deleted")
+	if group := local.Content.Root.Groups[0].Groups; len(group) != 1 || len(group[0].Entries) != 1 {
+		t.Fatalf("Expected the group with the changed entry to be kept, received %+v", group)
+	}
+
+	// once the entry is deleted as well, the group goes with it
+	remote.Content.Root.DeletedObjects[1].DeletionTime = mergeTime(30)
+	changes, err = Merge(local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectChanges(t, changes, "deleted entry kept", "deleted group g")
+	if len(local.Content.Root.Groups[0].Groups) != 0 {
+		t.Fatalf("Expected the group to be deleted, received %+v", local.Content.Root.Groups[0].Groups)
+	}
+}
+
+func TestMerge_Rules(t *testing.T) {
+	// the counter of an entry never decreases, whichever version wins
+	counter := func(merged, other *Entry) bool {
+		if other.GetContent("Counter") <= merged.GetContent("Counter") {
+			return false
+		}
+		merged.Values[merged.GetIndex("Counter")].Value = NewV(other.GetContent("Counter"), false)
+		return true
+	}
+
+	db := NewDatabase(WithDatabaseKDBXVersion4())
+	root := newMergeGroup("Root")
+	newer, same := newMergeEntry("newer"), newMergeEntry("same")
+	for _, e := range []*Entry{&newer, &same} {
+		e.Values = append(e.Values, ValueData{Key: "Counter", Value: NewV("1", false)})
+	}
+	root.Entries = []Entry{newer, same}
+	db.Content.Root.Groups = []Group{root}
+	local, remote := mergeCopies(t, db)
+
+	// the remote version of newer wins with a lower counter, same is changed at the same time in both databases
+	localRoot, remoteRoot := &local.Content.Root.Groups[0], &remote.Content.Root.Groups[0]
+	localRoot.Entries[0].Values[2].Value = NewV("5", false)
+	setMergeTimes(&localRoot.Entries[0].Times, 10)
+	remoteRoot.Entries[0].Values[2].Value = NewV("3", false)
+	setMergeTimes(&remoteRoot.Entries[0].Times, 20)
+	localRoot.Entries[1].Values[2].Value = NewV("2", false)
+	setMergeTimes(&localRoot.Entries[1].Times, 10)
+	remoteRoot.Entries[1].Values[2].Value = NewV("4", false)
+	setMergeTimes(&remoteRoot.Entries[1].Times, 10)
+
+	changes, err := Merge(local, remote, counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectChanges(t, changes, "updated entry newer", "updated entry same")
+	for i, counter := range []string{"5", "4"} {
+		if e := &localRoot.Entries[i]; e.GetContent("Counter") != counter {
+			t.Fatalf("Expected counter %s of %s, received %s", counter, e.GetTitle(), e.GetContent("Counter"))
+		}
+	}
+}
+
+func TestMerge_HistoryLimits(t *testing.T) {
+	db := NewDatabase(WithDatabaseKDBXVersion4())
+	root := newMergeGroup("Root")
+	root.Entries = []Entry{newMergeEntry("e")}
+	db.Content.Root.Groups = []Group{root}
+	local, remote := mergeCopies(t, db)
+
+	// both databases recorded three other versions of e, the remote version is the newest
+	for i, db := range []*Database{local, remote} {
+		e := &db.Content.Root.Groups[0].Entries[0]
+		var history []Entry
+		for minutes := 1; minutes <= 3; minutes++ {
+			version := withoutHistory(*e)
+			version.Values = []ValueData{{Key: "Title", Value: NewV(fmt.Sprintf("e%d", i*3+minutes), false)}}
+			version.Times.LastModificationTime = mergeTime(i*3 + minutes)
+			history = append(history, version)
+		}
+		e.Histories = []History{{Entries: history}}
+		e.Times.LastModificationTime = mergeTime(10 + i)
+	}
+	local.Content.Meta.HistoryMaxItems = 4
+
+	if _, err := Merge(local, remote); err != nil {
+		t.Fatal(err)
+	}
+	expectHistory := func(titles ...string) {
+		t.Helper()
+		history := local.Content.Root.Groups[0].Entries[0].Histories[0].Entries
+		if len(history) != len(titles) {
+			t.Fatalf("Expected the history %q, received %d versions", titles, len(history))
+		}
+		for i, title := range titles {
+			if history[i].GetTitle() != title {
+				t.Fatalf("Expected the history %q, received %s at %d", titles, history[i].GetTitle(), i)
+			}
+		}
+	}
+	// the local version and the three remote versions are the most recent of seven
+	expectHistory("e4", "e5", "e6", "e")
+
+	// the size of a version is the size of its strings, 7 bytes for the title of e4 to e6
+	e := &local.Content.Root.Groups[0].Entries[0]
+	local.Content.Meta.HistoryMaxItems = -1
+	local.Content.Meta.HistoryMaxSize = 7 + local.entrySize(&e.Histories[0].Entries[3])
+	local.TruncateHistory(e)
+	expectHistory("e6", "e")
+}
@@ -11,6 +11,7 @@ type MemProtection struct {
 	ProtectPassword w.BoolWrapper `xml:"ProtectPassword"`
 	ProtectURL      w.BoolWrapper `xml:"ProtectURL"`
 	ProtectNotes    w.BoolWrapper `xml:"ProtectNotes"`
+	Unknown
 }
 
 type MetaDataOption func(*MetaData)
@@ -18,8 +19,11 @@ type MetaDataOption func(*MetaData)
 // CustomIcon is the structure needed to store custom icons.
 // Unsure of what version/format requires this
 type CustomIcon struct {
-	UUID UUID   `xml:"UUID"` // Entry's CustomIcon UUID should match this
-	Data string `xml:"Data"` // base64 encoded PNG icon.  Unknown size constraints
+	UUID                 UUID           `xml:"UUID"`                           // Entry's CustomIcon UUID should match this
+	Data                 string         `xml:"Data"`                           // base64 encoded PNG icon.  Unknown size constraints
+	Name                 string         `xml:"Name,omitempty"`                 // KDBX 4.1
+	LastModificationTime *w.TimeWrapper `xml:"LastModificationTime,omitempty"` // KDBX 4.1
+	Unknown
 }
 
 func WithMetaDataFormattedTime(formatted bool) MetaDataOption {
@@ -79,6 +83,7 @@ type MetaData struct {
 	LastTopVisibleGroup        string         `xml:"LastTopVisibleGroup"`
 	Binaries                   Binaries       `xml:"Binaries>Binary,omitempty"`
 	CustomData                 []CustomData   `xml:"CustomData>Item"`
+	Unknown
 }
 
 func (md *MetaData) setKdbxFormatVersion(version formatVersion) {
@@ -103,4 +108,19 @@ func (md *MetaData) setKdbxFormatVersion(version formatVersion) {
 	if md.EntryTemplatesGroupChanged != nil {
 		md.EntryTemplatesGroupChanged.Formatted = !isKdbx4(version)
 	}
+	for i := range md.CustomIcons {
+		if md.CustomIcons[i].LastModificationTime != nil {
+			md.CustomIcons[i].LastModificationTime.Formatted = !isKdbx4(version)
+		}
+	}
+	setCustomDataKdbxFormatVersion(md.CustomData, version)
+}
+
+func (md *MetaData) requiresKdbx41() bool {
+	for _, icon := range md.CustomIcons {
+		if icon.Name != "" || icon.LastModificationTime != nil {
+			return true
+		}
+	}
+	return customDataRequiresKdbx41(md.CustomData)
 }
@@ -46,22 +46,22 @@ func TestNewMetaData(t *testing.T) {
 				func(md *MetaData) {
 					md.CustomIcons = []CustomIcon{
 						{
-							UUID{
+							UUID: UUID{
 								0xde, 0xad, 0xbe, 0xef,
 								0xc0, 0xff, 0xee, 0xde,
 								0xed, 0x01, 0x23, 0x45,
 								0x67, 0x89, 0xab, 0xcd,
 							},
-							encodedIcon,
+							Data: encodedIcon,
 						},
 						{
-							UUID{
+							UUID: UUID{
 								0xdd, 0xad, 0xbe, 0xef,
 								0xc0, 0xff, 0xee, 0xde,
 								0xed, 0x01, 0x23, 0x45,
 								0x67, 0x89, 0xab, 0xcd,
 							},
-							encodedIcon,
+							Data: encodedIcon,
 						},
 					}
 				},
@@ -74,22 +74,22 @@ func TestNewMetaData(t *testing.T) {
 				MaintenanceHistoryDays: 365,
 				CustomIcons: []CustomIcon{
 					{
-						UUID{
+						UUID: UUID{
 							0xde, 0xad, 0xbe, 0xef,
 							0xc0, 0xff, 0xee, 0xde,
 							0xed, 0x01, 0x23, 0x45,
 							0x67, 0x89, 0xab, 0xcd,
 						},
-						encodedIcon2,
+						Data: encodedIcon2,
 					},
 					{
-						UUID{
+						UUID: UUID{
 							0xdd, 0xad, 0xbe, 0xef,
 							0xc0, 0xff, 0xee, 0xde,
 							0xed, 0x01, 0x23, 0x45,
 							0x67, 0x89, 0xab, 0xcd,
 						},
-						encodedIcon2,
+						Data: encodedIcon2,
 					},
 				},
 			},
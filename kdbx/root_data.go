@@ -17,6 +17,7 @@ func WithRootDataFormattedTime(formatted bool) RootDataOption {
 type RootData struct {
 	Groups         []Group             `xml:"Group"`
 	DeletedObjects []DeletedObjectData `xml:"DeletedObjects>DeletedObject"`
+	Unknown
 }
 
 // NewRootData returns a RootData struct with good defaults
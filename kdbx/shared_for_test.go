@@ -1,5 +1,7 @@
 package kdbx
 
+import "testing"
+
 const (
 	password        = "Password"
 	anotherPassword = "AnotherPassword"
@@ -13,3 +15,14 @@ const (
 		"gOgAAHUwAADqYAAAOpgAABdwnLpRPAAAACZJREFUOE9jbGBo+M9ACQAZ" +
 		"QAlmoEQz2PWjBoyGwWg6AGdCivMCAKxN4SAQ+6S+AAAAAElFTkSuQmCC"
 )
+
+// openPassword returns the password of e, failing the test if its enclave cannot be opened
+func openPassword(t *testing.T, e *Entry) string {
+	t.Helper()
+	buf, err := e.OpenPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Destroy()
+	return string(buf.Bytes())
+}
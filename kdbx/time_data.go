@@ -26,6 +26,7 @@ type TimeData struct {
 	Expires              w.BoolWrapper  `xml:"Expires"`
 	UsageCount           int64          `xml:"UsageCount"`
 	LocationChanged      *w.TimeWrapper `xml:"LocationChanged"`
+	Unknown
 }
 
 func (td *TimeData) setKdbxFormatVersion(version formatVersion) {
@@ -0,0 +1,30 @@
+package kdbx
+
+import (
+	"encoding/xml"
+)
+
+// Unknown keeps the XML attributes and child elements of a node that its structure has no field for,
+// e.g. elements of newer KeePass versions or plugins, so that they are written again unchanged.
+// It is embedded into every structure of the XML document
+type Unknown struct {
+	UnknownAttrs    []xml.Attr       `xml:",any,attr"`
+	UnknownElements []UnknownElement `xml:",any"`
+}
+
+// UnknownElement is an element that is kept verbatim
+type UnknownElement struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   []byte     `xml:",innerxml"`
+}
+
+// unmarshalUnknownElement keeps the element started by start in u
+func (u *Unknown) unmarshalUnknownElement(d *xml.Decoder, start xml.StartElement) error {
+	var element UnknownElement
+	if err := d.DecodeElement(&element, &start); err != nil {
+		return err
+	}
+	u.UnknownElements = append(u.UnknownElements, element)
+	return nil
+}
@@ -0,0 +1,326 @@
+package kdbx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// xmlNode is an element of a parsed XML document, used to compare documents independent of their formatting
+type xmlNode struct {
+	name     string
+	attrs    map[string]string
+	text     string
+	children []*xmlNode
+}
+
+func parseXMLNode(t *testing.T, data []byte) *xmlNode {
+	t.Helper()
+	root := &xmlNode{}
+	stack := []*xmlNode{root}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Failed to parse xml: %s", err)
+		}
+		parent := stack[len(stack)-1]
+		switch token := token.(type) {
+		case xml.StartElement:
+			node := &xmlNode{name: token.Name.Local, attrs: map[string]string{}}
+			for _, attr := range token.Attr {
+				node.attrs[attr.Name.Local] = attr.Value
+			}
+			parent.children = append(parent.children, node)
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(parent.children) > 0 {
+				parent.text = strings.TrimSpace(parent.text)
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			parent.text += string(token)
+		}
+	}
+	return root
+}
+
+// normalizeXMLValue ignores the case of booleans, which KeePass reads case insensitive
+func normalizeXMLValue(s string) string {
+	if strings.EqualFold(s, "true") || strings.EqualFold(s, "false") {
+		return strings.ToLower(s)
+	}
+	return s
+}
+
+// missingXML returns the path of the first element or attribute of expected that is missing in actual, or "" if
+// actual contains everything of expected. Only the order of elements with the same name is significant
+func missingXML(expected, actual *xmlNode, path string) string {
+	path += "/" + expected.name
+	if normalizeXMLValue(expected.text) != normalizeXMLValue(actual.text) {
+		return path + " = " + expected.text
+	}
+	for name, value := range expected.attrs {
+		if normalizeXMLValue(actual.attrs[name]) != normalizeXMLValue(value) {
+			return path + "@" + name
+		}
+	}
+	byName := map[string][]*xmlNode{}
+	for _, child := range actual.children {
+		byName[child.name] = append(byName[child.name], child)
+	}
+	seen := map[string]int{}
+	for _, child := range expected.children {
+		candidates := byName[child.name]
+		if seen[child.name] >= len(candidates) {
+			return path + "/" + child.name
+		}
+		if missing := missingXML(child, candidates[seen[child.name]], path); missing != "" {
+			return missing
+		}
+		seen[child.name]++
+	}
+	return ""
+}
+
+// canonicalXML removes what KeePass reads like missing values from n: empty elements, all zero UUIDs and False
+// attributes. The encoder writes those for fields without a value, e.g. an empty CustomIcons element for databases
+// without custom icons or Protected="False" for values that are not protected
+func canonicalXML(n *xmlNode) *xmlNode {
+	for name, value := range n.attrs {
+		if strings.EqualFold(value, "false") {
+			delete(n.attrs, name)
+		}
+	}
+	if n.text == "AAAAAAAAAAAAAAAAAAAAAA==" {
+		n.text = ""
+	}
+	children := n.children[:0]
+	for _, child := range n.children {
+		if child = canonicalXML(child); child.text != "" || len(child.attrs) > 0 || len(child.children) > 0 {
+			children = append(children, child)
+		}
+	}
+	n.children = children
+	return n
+}
+
+// diffXML returns the path of the first element or attribute in which the canonical forms of expected and actual
+// differ, or "" if they are equal. Only the order of elements with the same name is significant
+func diffXML(expected, actual *xmlNode) string {
+	expected, actual = canonicalXML(expected), canonicalXML(actual)
+	if missing := missingXML(expected, actual, ""); missing != "" {
+		return "missing " + missing
+	}
+	if added := missingXML(actual, expected, ""); added != "" {
+		return "added " + added
+	}
+	return ""
+}
+
+// contentXML returns the xml document of a decoded database, without the padding of uncompressed content
+func contentXML(t *testing.T, db *Database) []byte {
+	t.Helper()
+	r := bytes.NewReader(db.Content.RawData)
+	if db.Header.IsKdbx4() {
+		if err := new(InnerHeader).readFrom(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := []byte("</KeePassFile>")
+	return data[:bytes.LastIndex(data, end)+len(end)]
+}
+
+func reencode(t *testing.T, db *Database) *Database {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode(db); err != nil {
+		t.Fatalf("Failed to encode database: %s", err)
+	}
+	decoded := NewDatabase()
+	decoded.Credentials = db.Credentials
+	if err := NewDecoder(&buf).Decode(decoded); err != nil {
+		t.Fatalf("Failed to decode encoded database: %s", err)
+	}
+	return decoded
+}
+
+func TestRoundTripFixtures(t *testing.T) {
+	cases := []struct {
+		dbFilePath  string
+		password    string
+		keyFilePath string
+	}{
+		{dbFilePath: "tests/kdbx3/example.kdbx", password: "abcdefg12345678"},
+		{dbFilePath: "tests/kdbx3/example-key.kdbx", password: "abcdefg12345678", keyFilePath: "tests/kdbx3/example-key.key"},
+		{dbFilePath: "tests/kdbx4/example.kdbx", password: "abcdefg12345678"},
+		{dbFilePath: "tests/kdbx4/example-key.kdbx", password: "abcdefg12345678", keyFilePath: "tests/kdbx4/example-key.key"},
+		{dbFilePath: "tests/kdbx4/example-41.kdbx", password: "abcdefg12345678"},
+		{dbFilePath: "tests/kdbx4/example-nocompression.kdbx", password: "abcdefg12345678"},
+		{dbFilePath: "tests/kdbx4/example-chacha.kdbx", password: "abcdefg12345678"},
+		{dbFilePath: "tests/kdbx4/example-chacha-argon2.kdbx", password: "abcdefg12345678"},
+		{dbFilePath: "tests/kdbx4/example-twofish.kdbx", password: "test1234test"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.dbFilePath, func(t *testing.T) {
+			file, err := os.Open(c.dbFilePath)
+			if err != nil {
+				t.Fatalf("Failed to open keepass file: %s", err)
+			}
+			defer file.Close()
+
+			db := NewDatabase()
+			db.Credentials = NewPasswordCredentials(c.password)
+			if c.keyFilePath != "" {
+				if db.Credentials, err = NewPasswordAndKeyCredentials(c.password, c.keyFilePath); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := NewDecoder(file).Decode(db); err != nil {
+				t.Fatalf("Failed to decode file: %s", err)
+			}
+			original := contentXML(t, db)
+
+			// the original document is written again, protected values included
+			encoded := reencode(t, db)
+			first := contentXML(t, encoded)
+			if diff := diffXML(parseXMLNode(t, original), parseXMLNode(t, first)); diff != "" {
+				t.Fatalf("Encoded xml differs in %s", diff)
+			}
+
+			// and encoding it again gives the same document byte for byte
+			second := contentXML(t, reencode(t, encoded))
+			if !bytes.Equal(first, second) {
+				t.Fatalf("Encoded xml changed on the second round trip:\n%s\n%s", first, second)
+			}
+		})
+	}
+}
+
+const unknownXML = `<KeePassFile Plugin="x">
+	<Meta>
+		<Generator>KeePass</Generator>
+		<FutureSetting Enabled="True">42</FutureSetting>
+		<MemoryProtection>
+			<ProtectPassword>True</ProtectPassword>
+			<ProtectPasskey>True</ProtectPasskey>
+		</MemoryProtection>
+		<CustomIcons>
+			<Icon Format="png">
+				<UUID>AAAAAAAAAAAAAAAAAAAAAQ==</UUID>
+				<Data>` + encodedIcon + `</Data>
+				<Size>16</Size>
+			</Icon>
+		</CustomIcons>
+		<CustomData>
+			<Item Scope="plugin">
+				<Key>key</Key>
+				<Value>value</Value>
+				<Source>plugin</Source>
+			</Item>
+		</CustomData>
+	</Meta>
+	<Root Version="2">
+		<Group Collapsed="False">
+			<UUID>AAAAAAAAAAAAAAAAAAAAAg==</UUID>
+			<Name>Root</Name>
+			<Times Zone="UTC">
+				<CreationTime>2024-01-01T00:00:00Z</CreationTime>
+				<LastUsedTime>2024-01-02T00:00:00Z</LastUsedTime>
+			</Times>
+			<Color>#ff0000</Color>
+			<Entry Pinned="True">
+				<UUID>AAAAAAAAAAAAAAAAAAAAAw==</UUID>
+				<String Origin="import">
+					<Key>Title</Key>
+					<Value Format="plain">title</Value>
+					<Hint>first</Hint>
+				</String>
+				<AutoType Mode="legacy">
+					<Enabled>True</Enabled>
+					<Association Scope="window">
+						<Window>Target</Window>
+						<KeystrokeSequence>{PASSWORD}</KeystrokeSequence>
+						<Delay>100</Delay>
+					</Association>
+					<Obfuscation>
+						<Level>2</Level>
+					</Obfuscation>
+				</AutoType>
+				<Binary Inline="False">
+					<Key>file.txt</Key>
+					<Value Ref="0" />
+					<Size>11</Size>
+				</Binary>
+				<History Limit="10">
+					<Entry>
+						<UUID>AAAAAAAAAAAAAAAAAAAAAw==</UUID>
+						<Rating>5</Rating>
+					</Entry>
+					<Reason>edit</Reason>
+				</History>
+				<Passkey>
+					<Credential Id="1">secret</Credential>
+				</Passkey>
+			</Entry>
+			<Group>
+				<UUID>AAAAAAAAAAAAAAAAAAAABA==</UUID>
+				<Name>Child</Name>
+				<Sort Order="asc" />
+			</Group>
+		</Group>
+		<DeletedObjects>
+			<DeletedObject Reason="merge">
+				<UUID>AAAAAAAAAAAAAAAAAAAABQ==</UUID>
+				<DeletionTime>2024-01-03T00:00:00Z</DeletionTime>
+				<Origin>sync</Origin>
+			</DeletedObject>
+		</DeletedObjects>
+		<Trash>
+			<Count>0</Count>
+		</Trash>
+	</Root>
+	<Signature>abc</Signature>
+</KeePassFile>`
+
+func TestUnknownRoundTrip(t *testing.T) {
+	content := new(DBContent)
+	if err := xml.Unmarshal([]byte(unknownXML), content); err != nil {
+		t.Fatal(err)
+	}
+
+	group := content.Root.Groups[0]
+	if len(group.UnknownAttrs) != 1 || group.UnknownAttrs[0].Value != "False" {
+		t.Fatalf("Expected the unknown attribute of the group, received %v", group.UnknownAttrs)
+	}
+	if len(group.UnknownElements) != 1 || group.UnknownElements[0].XMLName.Local != "Color" ||
+		string(group.UnknownElements[0].Inner) != "#ff0000" {
+		t.Fatalf("Expected the unknown element of the group, received %+v", group.UnknownElements)
+	}
+	if len(group.Groups) != 1 || len(group.Groups[0].UnknownElements) != 1 {
+		t.Fatalf("Expected the unknown element of the child group, received %+v", group.Groups)
+	}
+	if value := group.Entries[0].Values[0].Value; value.Content != "title" || len(value.UnknownAttrs) != 1 {
+		t.Fatalf("Expected the value with its unknown attribute, received %+v", value)
+	}
+
+	encoded, err := xml.MarshalIndent(content, "", "\t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the document lacks most of the known elements, which the encoder adds, so only check that nothing is lost
+	if missing := missingXML(parseXMLNode(t, []byte(unknownXML)), parseXMLNode(t, encoded), ""); missing != "" {
+		t.Fatalf("Encoded xml lost %s:\n%s", missing, encoded)
+	}
+}
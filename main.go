@@ -1,16 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os"
 
 	"github.com/malivvan/aegis/cli"
 	"github.com/malivvan/aegis/cui"
+	"github.com/malivvan/aegis/kdbx"
 	"github.com/malivvan/aegis/mgrd"
-	"github.com/malivvan/aegis/opgp/crypto"
+	"github.com/malivvan/aegis/scard"
 )
 
+var version = "dev"
+
 func main() {
 
 	mgrd.CatchSignal(func(_ os.Signal) {
@@ -18,20 +21,6 @@ func main() {
 	}, os.Interrupt)
 	defer mgrd.Purge()
 
-	pgp := crypto.PGP()
-	aliceKeyPriv, err := pgp.KeyGeneration().
-		AddUserId("alice", "alice@alice.com").
-		New().
-		GenerateKey()
-	if err != nil {
-		log.Fatal(err)
-	}
-	aliceKeyPub, err := aliceKeyPriv.ToPublic()
-	if err != nil {
-		log.Fatal(err)
-	}
-	fmt.Println(aliceKeyPub.Armor())
-
 	if err := (&cli.App{
 		Name:  "aegis",
 		Usage: "a terminal application for secret management with hardware token support",
@@ -42,23 +31,61 @@ func main() {
 				Usage:   "path to the keyring database",
 				EnvVars: []string{"AEGIS_KEYRING"},
 			},
+			&cli.StringFlag{
+				Name:    "keyfile",
+				Usage:   "path to a key file required to unlock the keyring",
+				EnvVars: []string{"AEGIS_KEYFILE"},
+			},
+			&cli.BoolFlag{
+				Name:    "card",
+				Usage:   "unlock the keyring with an OpenPGP card instead of a password",
+				EnvVars: []string{"AEGIS_CARD"},
+			},
+			&cli.BoolFlag{
+				Name:    "fido",
+				Usage:   "unlock the keyring with a FIDO2 security key instead of a password",
+				EnvVars: []string{"AEGIS_FIDO"},
+			},
 		},
 		Action: func(ctx *cli.Context) error {
-			if ctx.NArg() == 0 {
-				return cui.Execute("TODO")
+			if ctx.NArg() != 0 {
+				return cli.ShowAppHelp(ctx)
+			}
+			path, err := keyringPath(ctx)
+			if err != nil {
+				return err
 			}
-			return nil
+			var cards <-chan scard.Event
+			if ctx.Bool("card") {
+				sc, err := scard.EstablishContext()
+				if err != nil {
+					return err
+				}
+				defer sc.Release()
+				watch, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				if cards, err = sc.Watch(watch); err != nil {
+					return err
+				}
+			}
+			return cui.Execute(path, func(password string) (*kdbx.DBCredentials, string, error) {
+				if ctx.Bool("card") {
+					return cardCredentials(path, password)
+				}
+				creds, err := credentials(ctx, password)
+				return creds, "", err
+			}, cards, &oathCodes{}, mergeRules)
 		},
-		Commands: []*cli.Command{
+		Commands: append([]*cli.Command{
 			{
 				Name:  "version",
 				Usage: "print the version information",
 				Action: func(ctx *cli.Context) error {
-					//	fmt.Println(bom.Metadata.Component.Version)
+					fmt.Println(version)
 					return nil
 				},
 			},
-		},
+		}, append(commands, cardCommand, fidoCommand, mergeCommand, oathCommand, otpCommand, yubiotpCommand)...),
 	}).Run(os.Args); err != nil {
 		println("error: " + err.Error())
 		mgrd.SafeExit(1)
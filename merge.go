@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/malivvan/aegis/cli"
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/vault"
+)
+
+var mergeCommand = &cli.Command{
+	Name:      "merge",
+	Usage:     "merge the groups and entries of another database into the keyring, the newer version of each wins",
+	ArgsUsage: "<database>",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{Name: "password", Aliases: []string{"p"}, Usage: "prompt for the password of the other database instead of unlocking it like the keyring"},
+		&cli.BoolFlag{Name: "dry-run", Aliases: []string{"n"}, Usage: "print the changes without saving them"},
+	},
+	Action: mergeAction,
+}
+
+func mergeAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 1); err != nil {
+		return err
+	}
+	v, err := openVault(ctx)
+	if err != nil {
+		return err
+	}
+	path := ctx.Args().First()
+	creds := v.DB.Credentials
+	if ctx.Bool("password") {
+		password, err := readPassword(fmt.Sprintf("Password of %s: ", path))
+		if err != nil {
+			return err
+		}
+		creds = kdbx.NewPasswordCredentials(password)
+	}
+	other, err := vault.Open(path, creds)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	changes, err := v.Merge(other)
+	if err != nil {
+		return err
+	}
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if ctx.Bool("dry-run") {
+		return nil
+	}
+	return saveVault(v)
+}
@@ -6,7 +6,10 @@ import (
 	"github.com/malivvan/aegis/mgrd"
 )
 
-var stdEncoding = New("cbdefghijklnrtuv")
+// Alphabet is the modhex alphabet of YubiKeys, whose characters are on the same keys on most keyboard layouts.
+const Alphabet = "cbdefghijklnrtuv"
+
+var stdEncoding = New(Alphabet)
 
 func Encode(data []byte) string {
 	return stdEncoding.Encode(data)
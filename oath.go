@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/malivvan/aegis/cli"
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/otp"
+	"github.com/malivvan/aegis/scard"
+	"github.com/malivvan/aegis/vault"
+)
+
+var errNoOathToken = errors.New("no token with an OATH application found")
+
+var oathCommand = &cli.Command{
+	Name:  "oath",
+	Usage: "manage the TOTP and HOTP credentials stored on a token",
+	Subcommands: []*cli.Command{
+		{
+			Name:   "list",
+			Usage:  "list the credentials on the token",
+			Action: oathListAction,
+		},
+		{
+			Name:      "code",
+			Aliases:   []string{"codes"},
+			Usage:     "print the codes of all credentials or of those whose name contains filter",
+			ArgsUsage: "[filter]",
+			Action:    oathCodeAction,
+		},
+		{
+			Name:      "add",
+			Usage:     "store a credential on the token, the base32 secret is read from the terminal",
+			ArgsUsage: "<account>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "issuer", Usage: "issuer of the credential"},
+				&cli.BoolFlag{Name: "hotp", Usage: "counter based instead of time based credential"},
+				&cli.StringFlag{Name: "algorithm", Value: "SHA1", Usage: "SHA1, SHA256 or SHA512"},
+				&cli.IntFlag{Name: "digits", Value: 6, Usage: "number of digits of the codes"},
+				&cli.IntFlag{Name: "period", Value: 30, Usage: "time step of TOTP codes in seconds"},
+				&cli.IntFlag{Name: "counter", Usage: "initial counter of HOTP credentials"},
+				&cli.BoolFlag{Name: "touch", Usage: "require touch to calculate codes"},
+			},
+			Action: oathAddAction,
+		},
+		{
+			Name:      "delete",
+			Usage:     "delete a credential from the token",
+			ArgsUsage: "<name>",
+			Action:    oathDeleteAction,
+		},
+		{
+			Name:  "password",
+			Usage: "set the password of the OATH application",
+			Flags: []cli.Flag{
+				&cli.BoolFlag{Name: "remove", Usage: "remove the password instead"},
+			},
+			Action: oathPasswordAction,
+		},
+		{
+			Name:      "link",
+			Usage:     "show the code of a credential on the token with an entry in the interactive browser",
+			ArgsUsage: "<entry> <name>",
+			Action:    oathLinkAction,
+		},
+	},
+}
+
+// withOATH calls fn with the OATH application of the first connected token that has one. A password protected
+// application is unlocked with AEGIS_OATH_PASSWORD or, if prompt is set, with a password read from the terminal.
+func withOATH(prompt bool, fn func(o *scard.OATH) error) error {
+	ctx, err := scard.EstablishContext()
+	if err != nil {
+		return err
+	}
+	defer ctx.Release()
+
+	readers, err := ctx.ListReadersWithCard()
+	if err != nil {
+		return err
+	}
+	for _, reader := range readers {
+		card, err := reader.Connect()
+		if err != nil {
+			continue
+		}
+		defer card.Disconnect()
+		err = card.Transaction(func() error {
+			o, err := card.OATH()
+			if err != nil {
+				return errNoOathToken
+			}
+			if o.Locked() {
+				password, ok := os.LookupEnv("AEGIS_OATH_PASSWORD")
+				if !ok && !prompt {
+					return fmt.Errorf("%s: OATH application is password protected, set AEGIS_OATH_PASSWORD", reader.Name())
+				}
+				if !ok {
+					if password, err = readPassword("OATH password: "); err != nil {
+						return err
+					}
+				}
+				if err := o.Validate(password); err != nil {
+					return fmt.Errorf("%s: %w", reader.Name(), err)
+				}
+			}
+			return fn(o)
+		})
+		if errors.Is(err, errNoOathToken) {
+			continue
+		}
+		return err
+	}
+	return errNoOathToken
+}
+
+// oathCodes calculates codes for the interactive browser. It connects to the token for every call, so that the
+// token can be removed and inserted again while the browser runs.
+type oathCodes struct {
+	mu sync.Mutex
+}
+
+func (c *oathCodes) CalculateAll(t time.Time) (codes []scard.OathCode, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err = withOATH(false, func(o *scard.OATH) error {
+		codes, err = o.CalculateAll(t)
+		return err
+	})
+	return codes, err
+}
+
+func (c *oathCodes) Calculate(cred scard.OathCredential, t time.Time) (code scard.OathCode, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err = withOATH(false, func(o *scard.OATH) error {
+		code, err = o.Calculate(cred, t)
+		return err
+	})
+	return code, err
+}
+
+func oathListAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 0); err != nil {
+		return err
+	}
+	return withOATH(true, func(o *scard.OATH) error {
+		credentials, err := o.List()
+		if err != nil {
+			return err
+		}
+		for _, c := range credentials {
+			fmt.Printf("%s\t%s\t%s\n", c.Name, c.Type, c.Algorithm)
+		}
+		return nil
+	})
+}
+
+func oathCodeAction(ctx *cli.Context) error {
+	if ctx.NArg() > 1 {
+		return fmt.Errorf("%s: expected at most 1 argument, got %d", ctx.Command.Name, ctx.NArg())
+	}
+	filter := strings.ToLower(ctx.Args().First())
+	return withOATH(true, func(o *scard.OATH) error {
+		now := time.Now()
+		codes, err := o.CalculateAll(now)
+		if err != nil {
+			return err
+		}
+		var matches []scard.OathCode
+		for _, code := range codes {
+			if strings.Contains(strings.ToLower(code.Credential.Name), filter) {
+				matches = append(matches, code)
+			}
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("no credential matches %q", filter)
+		}
+		for _, code := range matches {
+			// HOTP codes advance the counter, so they are only calculated when asked for explicitly
+			if code.Value == "" && (code.Credential.Touch || len(matches) == 1) {
+				if code.Credential.Touch {
+					fmt.Fprintf(os.Stderr, "Touch your token for %s\n", code.Credential.Name)
+				}
+				calculated, err := o.Calculate(code.Credential, now)
+				if err != nil {
+					return fmt.Errorf("%s: %w", code.Credential.Name, err)
+				}
+				code = calculated
+			}
+			fmt.Println(formatOathCode(code, now))
+		}
+		return nil
+	})
+}
+
+// formatOathCode formats the name and value of code with the seconds it remains valid.
+func formatOathCode(code scard.OathCode, now time.Time) string {
+	switch {
+	case code.Value == "" && code.Credential.Type == scard.OathHOTP:
+		return fmt.Sprintf("%s\t[HOTP]", code.Credential.Name)
+	case code.Value == "":
+		return fmt.Sprintf("%s\t[touch]", code.Credential.Name)
+	case code.ValidTo.IsZero():
+		return fmt.Sprintf("%s\t%s", code.Credential.Name, code.Value)
+	}
+	return fmt.Sprintf("%s\t%s\t%ds", code.Credential.Name, code.Value, int(code.ValidTo.Sub(now).Seconds()))
+}
+
+func oathAddAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 1); err != nil {
+		return err
+	}
+	c := scard.OathCredential{Type: scard.OathTOTP, Touch: ctx.Bool("touch")}
+	if ctx.Bool("hotp") {
+		c.Type = scard.OathHOTP
+	}
+	switch strings.ToUpper(ctx.String("algorithm")) {
+	case "SHA1":
+		c.Algorithm = scard.OathSHA1
+	case "SHA256":
+		c.Algorithm = scard.OathSHA256
+	case "SHA512":
+		c.Algorithm = scard.OathSHA512
+	default:
+		return fmt.Errorf("unknown algorithm %q", ctx.String("algorithm"))
+	}
+	if ctx.Int("period") <= 0 || ctx.Int("counter") < 0 {
+		return errors.New("period must be positive and counter must not be negative")
+	}
+	c.Name = scard.OathCredentialName(c.Type, ctx.String("issuer"), ctx.Args().First(), time.Duration(ctx.Int("period"))*time.Second)
+
+	secret, err := readPassword("Secret (base32): ")
+	if err != nil {
+		return err
+	}
+	key, err := otp.DecodeBase32(secret)
+	if err != nil {
+		return err
+	}
+	return withOATH(true, func(o *scard.OATH) error {
+		return o.Put(c, scard.OathSecret{Key: key, Digits: ctx.Int("digits"), Counter: uint32(ctx.Int("counter"))})
+	})
+}
+
+func oathDeleteAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 1); err != nil {
+		return err
+	}
+	return withOATH(true, func(o *scard.OATH) error {
+		return o.Delete(ctx.Args().First())
+	})
+}
+
+func oathPasswordAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 0); err != nil {
+		return err
+	}
+	var password string
+	if !ctx.Bool("remove") {
+		var err error
+		if password, err = readNewPassword("New OATH password: "); err != nil {
+			return err
+		}
+	}
+	return withOATH(true, func(o *scard.OATH) error {
+		return o.SetPassword(password)
+	})
+}
+
+func oathLinkAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 2); err != nil {
+		return err
+	}
+	v, err := openVault(ctx)
+	if err != nil {
+		return err
+	}
+	path, name := ctx.Args().Get(0), ctx.Args().Get(1)
+	if err := v.UpdateEntry(path, func(e *kdbx.Entry) { v.SetValue(e, vault.OathField, name) }); err != nil {
+		return err
+	}
+	return saveVault(v)
+}
@@ -37,6 +37,19 @@ func (dpb *DecryptionHandleBuilder) DecryptionKey(decryptionKey *Key) *Decryptio
 	return dpb
 }
 
+// Decrypter sets a decryption key whose session key decryption is delegated to
+// decrypter, for example a decryption key on an OpenPGP smart card. key is the
+// public key that contains the (sub)key matching decrypter.Public().
+// Triggers the hybrid decryption mode.
+func (dpb *DecryptionHandleBuilder) Decrypter(key *Key, decrypter Decrypter) *DecryptionHandleBuilder {
+	externalKey, err := NewKeyWithDecrypter(key, decrypter)
+	if err != nil {
+		dpb.err = err
+		return dpb
+	}
+	return dpb.DecryptionKey(externalKey)
+}
+
 // SessionKey sets a session key for decrypting the pgp message.
 // Assumes that the message was encrypted with session key provided.
 // Triggers the session key decryption mode.
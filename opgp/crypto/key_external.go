@@ -0,0 +1,126 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto"
+	stdecdh "crypto/ecdh"
+	stdecdsa "crypto/ecdsa"
+	stded25519 "crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+
+	"github.com/malivvan/aegis/opgp/gocrypto/openpgp/ecdh"
+	"github.com/malivvan/aegis/opgp/gocrypto/openpgp/ecdsa"
+	"github.com/malivvan/aegis/opgp/gocrypto/openpgp/ed25519"
+	"github.com/malivvan/aegis/opgp/gocrypto/openpgp/eddsa"
+	packet "github.com/malivvan/aegis/opgp/gocrypto/openpgp/packet"
+)
+
+// Decrypter is a decryption key whose private key material is held outside of
+// memory, for example by an OpenPGP smart card. RSA keys must implement
+// crypto.Decrypter and decrypt PKCS#1 v1.5 cryptograms, ECDH keys must
+// implement ECDHDecrypter.
+type Decrypter interface {
+	Public() crypto.PublicKey
+}
+
+// ECDHDecrypter is an ECDH decryption key whose private scalar is held outside
+// of memory.
+type ECDHDecrypter interface {
+	Decrypter
+	// Decaps returns the shared secret of the private key and the ephemeral
+	// public point. For Curve25519 the point and the shared secret are
+	// u-coordinates, for NIST curves the point is uncompressed and the shared
+	// secret is its x-coordinate.
+	Decaps(ephemeral []byte) ([]byte, error)
+}
+
+// NewKeyWithSigner returns a private key for the public key whose signing key
+// delegates all signature operations to signer. The (sub)key that matches
+// signer.Public() is used to sign, other signing subkeys are dropped.
+func NewKeyWithSigner(publicKey *Key, signer crypto.Signer) (*Key, error) {
+	if signer == nil {
+		return nil, errors.New("gopenpgp: nil signer provided")
+	}
+	return newExternalKey(publicKey, signer.Public(), signer, true)
+}
+
+// NewKeyWithDecrypter returns a private key for the public key whose
+// decryption key delegates all session key decryption to decrypter. The
+// (sub)key that matches decrypter.Public() is used to decrypt.
+func NewKeyWithDecrypter(publicKey *Key, decrypter Decrypter) (*Key, error) {
+	if decrypter == nil {
+		return nil, errors.New("gopenpgp: nil decrypter provided")
+	}
+	switch decrypter.(type) {
+	case crypto.Decrypter, ECDHDecrypter:
+	default:
+		return nil, errors.New("gopenpgp: decrypter is neither a crypto.Decrypter nor an ECDHDecrypter")
+	}
+	return newExternalKey(publicKey, decrypter.Public(), decrypter, false)
+}
+
+func newExternalKey(publicKey *Key, pub crypto.PublicKey, priv interface{}, sign bool) (*Key, error) {
+	if publicKey == nil || publicKey.entity == nil {
+		return nil, errors.New("gopenpgp: nil key provided")
+	}
+	key, err := publicKey.Copy()
+	if err != nil {
+		return nil, err
+	}
+	if key.IsPrivate() {
+		key.ClearPrivateParams()
+	}
+	entity := key.entity
+
+	found := false
+	// The primary private key must be present for the key to count as
+	// unlocked, even if only a subkey is held externally.
+	entity.PrivateKey = &packet.PrivateKey{PublicKey: *entity.PrimaryKey}
+	if publicKeyMatches(entity.PrimaryKey, pub) {
+		entity.PrivateKey.PrivateKey = priv
+		found = true
+	}
+	subkeys := entity.Subkeys[:0]
+	for _, subkey := range entity.Subkeys {
+		if publicKeyMatches(subkey.PublicKey, pub) {
+			subkey.PrivateKey = &packet.PrivateKey{PublicKey: *subkey.PublicKey, PrivateKey: priv}
+			found = true
+		} else if sign && subkey.PublicKey.PubKeyAlgo.CanSign() {
+			// keep the newest signing subkey from being selected
+			// when it is not the one held externally
+			continue
+		}
+		subkeys = append(subkeys, subkey)
+	}
+	entity.Subkeys = subkeys
+
+	if !found {
+		return nil, errors.New("gopenpgp: no key matches the external private key")
+	}
+	return key, nil
+}
+
+// publicKeyMatches reports whether the public key packet pk holds the public
+// key pub.
+func publicKeyMatches(pk *packet.PublicKey, pub crypto.PublicKey) bool {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		k, ok := pk.PublicKey.(*rsa.PublicKey)
+		return ok && k.Equal(pub)
+	case *stdecdsa.PublicKey:
+		k, ok := pk.PublicKey.(*ecdsa.PublicKey)
+		return ok && k.X.Cmp(pub.X) == 0 && k.Y.Cmp(pub.Y) == 0
+	case stded25519.PublicKey:
+		switch k := pk.PublicKey.(type) {
+		case *eddsa.PublicKey:
+			return bytes.Equal(k.X, pub)
+		case *ed25519.PublicKey:
+			return bytes.Equal(k.Point, pub)
+		}
+	case *stdecdh.PublicKey:
+		k, ok := pk.PublicKey.(*ecdh.PublicKey)
+		return ok && bytes.Equal(k.Point, pub.Bytes())
+	}
+	return false
+}
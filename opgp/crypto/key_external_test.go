@@ -0,0 +1,87 @@
+package crypto
+
+import (
+	"crypto"
+	stdecdh "crypto/ecdh"
+	stded25519 "crypto/ed25519"
+	"testing"
+
+	"github.com/malivvan/aegis/opgp/gocrypto/openpgp/ecdh"
+	"github.com/malivvan/aegis/opgp/gocrypto/openpgp/eddsa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// externalSigner and externalDecrypter hide the concrete private key type the
+// same way a smart card does.
+type externalSigner struct{ crypto.Signer }
+
+type externalDecrypter struct{ crypto.Decrypter }
+
+type externalECDH struct{ key *stdecdh.PrivateKey }
+
+func (e externalECDH) Public() crypto.PublicKey { return e.key.Public() }
+
+func (e externalECDH) Decaps(ephemeral []byte) ([]byte, error) {
+	pub, err := e.key.Curve().NewPublicKey(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	return e.key.ECDH(pub)
+}
+
+func TestExternalKey_RSA(t *testing.T) {
+	publicKey, err := keyTestRSA.ToPublic()
+	require.NoError(t, err)
+
+	signer := externalSigner{keyTestRSA.entity.PrivateKey.PrivateKey.(crypto.Signer)}
+	decrypter := externalDecrypter{keyTestRSA.entity.Subkeys[0].PrivateKey.PrivateKey.(crypto.Decrypter)}
+	testExternalKey(t, publicKey, signer, decrypter)
+}
+
+func TestExternalKey_EC(t *testing.T) {
+	publicKey, err := keyTestEC.ToPublic()
+	require.NoError(t, err)
+
+	signer := externalSigner{stded25519.NewKeyFromSeed(keyTestEC.entity.PrivateKey.PrivateKey.(*eddsa.PrivateKey).D)}
+	ecdhKey, err := stdecdh.X25519().NewPrivateKey(keyTestEC.entity.Subkeys[0].PrivateKey.PrivateKey.(*ecdh.PrivateKey).D)
+	require.NoError(t, err)
+	testExternalKey(t, publicKey, signer, externalECDH{ecdhKey})
+}
+
+func TestExternalKey_NoMatch(t *testing.T) {
+	publicKey, err := keyTestRSA.ToPublic()
+	require.NoError(t, err)
+
+	signer := externalSigner{stded25519.NewKeyFromSeed(make([]byte, stded25519.SeedSize))}
+	_, err = NewKeyWithSigner(publicKey, signer)
+	assert.Error(t, err)
+
+	_, err = testPGP.Sign().Signer(publicKey, signer).New()
+	assert.Error(t, err)
+}
+
+func testExternalKey(t *testing.T, publicKey *Key, signer crypto.Signer, decrypter Decrypter) {
+	sign, err := testPGP.Sign().Signer(publicKey, signer).New()
+	require.NoError(t, err)
+	signed, err := sign.Sign([]byte(testMessage), Bytes)
+	require.NoError(t, err)
+
+	verify, err := testPGP.Verify().VerificationKey(publicKey).New()
+	require.NoError(t, err)
+	verified, err := verify.VerifyInline(signed, Bytes)
+	require.NoError(t, err)
+	assert.NoError(t, verified.SignatureError())
+	assert.Equal(t, testMessage, string(verified.Bytes()))
+
+	encrypt, err := testPGP.Encryption().Recipient(publicKey).New()
+	require.NoError(t, err)
+	encrypted, err := encrypt.Encrypt([]byte(testMessage))
+	require.NoError(t, err)
+
+	decrypt, err := testPGP.Decryption().Decrypter(publicKey, decrypter).New()
+	require.NoError(t, err)
+	decrypted, err := decrypt.Decrypt(encrypted.Bytes(), Bytes)
+	require.NoError(t, err)
+	assert.Equal(t, testMessage, string(decrypted.Bytes()))
+}
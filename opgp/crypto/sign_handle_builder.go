@@ -1,5 +1,7 @@
 package crypto
 
+import "crypto"
+
 // SignHandleBuilder allows to configure a sign handle
 // to sign data with OpenPGP.
 type SignHandleBuilder struct {
@@ -27,6 +29,18 @@ func (shb *SignHandleBuilder) SigningKey(key *Key) *SignHandleBuilder {
 	return shb
 }
 
+// Signer sets a signing key whose private key operations are delegated to signer,
+// for example a signature key on an OpenPGP smart card. key is the public key
+// that contains the (sub)key matching signer.Public().
+func (shb *SignHandleBuilder) Signer(key *Key, signer crypto.Signer) *SignHandleBuilder {
+	externalKey, err := NewKeyWithSigner(key, signer)
+	if err != nil {
+		shb.err = err
+		return shb
+	}
+	return shb.SigningKey(externalKey)
+}
+
 // SigningKeys sets the signing keys that are used to create signature of the message.
 func (shb *SignHandleBuilder) SigningKeys(signingKeys *KeyRing) *SignHandleBuilder {
 	shb.handle.SignKeyRing = signingKeys
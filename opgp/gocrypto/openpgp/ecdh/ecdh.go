@@ -119,14 +119,44 @@ func Encrypt(random io.Reader, pub *PublicKey, msg, curveOID, fingerprint []byte
 }
 
 func Decrypt(priv *PrivateKey, vsG, c, curveOID, fingerprint []byte) (msg []byte, err error) {
-	var m []byte
 	zb, err := priv.PublicKey.curve.Decaps(priv.curve.UnmarshalBytePoint(vsG), priv.D)
+	if err != nil {
+		return nil, err
+	}
+	return unwrap(&priv.PublicKey, zb, c, curveOID, fingerprint)
+}
+
+// Decrypter is an ECDH private key whose secret scalar is not available in
+// memory, for example because it is stored on a smart card.
+type Decrypter interface {
+	// Decaps returns the shared secret of the private key and the ephemeral
+	// public point in the native format of the curve: the u-coordinate for
+	// Curve25519 and the x-coordinate for NIST and Brainpool curves.
+	Decaps(ephemeral []byte) (sharedSecret []byte, err error)
+}
+
+// DecryptWith decrypts c like Decrypt, using d to compute the shared secret
+// of the private key belonging to pub.
+func DecryptWith(pub *PublicKey, d Decrypter, vsG, c, curveOID, fingerprint []byte) (msg []byte, err error) {
+	point := pub.curve.UnmarshalBytePoint(vsG)
+	if point == nil {
+		return nil, errors.New("ecdh: invalid ephemeral point")
+	}
+	zb, err := d.Decaps(point)
+	if err != nil {
+		return nil, err
+	}
+	return unwrap(pub, zb, c, curveOID, fingerprint)
+}
+
+func unwrap(pub *PublicKey, zb, c, curveOID, fingerprint []byte) (msg []byte, err error) {
+	var m []byte
 
 	// Try buildKey three times to workaround an old bug, see comments in buildKey.
 	for i := 0; i < 3; i++ {
 		var z []byte
 		// RFC6637 §8: "Compute Z = KDF( S, Z_len, Param );"
-		z, err = buildKey(&priv.PublicKey, zb, curveOID, fingerprint, i == 1, i == 2)
+		z, err = buildKey(pub, zb, curveOID, fingerprint, i == 1, i == 2)
 		if err != nil {
 			return nil, err
 		}
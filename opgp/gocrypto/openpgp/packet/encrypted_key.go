@@ -172,7 +172,10 @@ func (e *EncryptedKey) Decrypt(priv *PrivateKey, config *Config) error {
 	switch priv.PubKeyAlgo {
 	case PubKeyAlgoRSA, PubKeyAlgoRSAEncryptOnly:
 		// Supports both *rsa.PrivateKey and crypto.Decrypter
-		k := priv.PrivateKey.(crypto.Decrypter)
+		k, ok := priv.PrivateKey.(crypto.Decrypter)
+		if !ok {
+			return errors.InvalidArgumentError("cannot decrypt encrypted session key: private key is not a crypto.Decrypter")
+		}
 		b, err = k.Decrypt(config.Random(), padToKeySize(k.Public().(*rsa.PublicKey), e.encryptedMPI1.Bytes()), nil)
 	case PubKeyAlgoElGamal:
 		c1 := new(big.Int).SetBytes(e.encryptedMPI1.Bytes())
@@ -187,7 +190,15 @@ func (e *EncryptedKey) Decrypt(priv *PrivateKey, config *Config) error {
 			// For v5 the, the fingerprint must be restricted to 20 bytes
 			fp = fp[:20]
 		}
-		b, err = ecdh.Decrypt(priv.PrivateKey.(*ecdh.PrivateKey), vsG, m, oid, fp)
+		// Supports both *ecdh.PrivateKey and ecdh.Decrypter
+		switch k := priv.PrivateKey.(type) {
+		case *ecdh.PrivateKey:
+			b, err = ecdh.Decrypt(k, vsG, m, oid, fp)
+		case ecdh.Decrypter:
+			b, err = ecdh.DecryptWith(priv.PublicKey.PublicKey.(*ecdh.PublicKey), k, vsG, m, oid, fp)
+		default:
+			return errors.InvalidArgumentError("cannot decrypt encrypted session key: private key is not an ecdh.Decrypter")
+		}
 	case PubKeyAlgoX25519:
 		b, err = x25519.Decrypt(priv.PrivateKey.(*x25519.PrivateKey), e.ephemeralPublicX25519, e.encryptedSession)
 	case PubKeyAlgoX448:
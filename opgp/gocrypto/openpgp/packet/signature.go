@@ -945,8 +945,8 @@ func (sig *Signature) Sign(h hash.Hash, priv *PrivateKey, config *Config) (err e
 	switch priv.PubKeyAlgo {
 	case PubKeyAlgoRSA, PubKeyAlgoRSASignOnly:
 		// supports both *rsa.PrivateKey and crypto.Signer
-		sigdata, err := priv.PrivateKey.(crypto.Signer).Sign(config.Random(), digest, sig.Hash)
-		if err == nil {
+		var sigdata []byte
+		if sigdata, err = signWithSigner(priv, config, digest, sig.Hash); err == nil {
 			sig.RSASignature = encoding.NewMPI(sigdata)
 		}
 	case PubKeyAlgoDSA:
@@ -957,8 +957,8 @@ func (sig *Signature) Sign(h hash.Hash, priv *PrivateKey, config *Config) (err e
 		if len(digest) > subgroupSize {
 			digest = digest[:subgroupSize]
 		}
-		r, s, err := dsa.Sign(config.Random(), dsaPriv, digest)
-		if err == nil {
+		var r, s *big.Int
+		if r, s, err = dsa.Sign(config.Random(), dsaPriv, digest); err == nil {
 			sig.DSASigR = new(encoding.MPI).SetBig(r)
 			sig.DSASigS = new(encoding.MPI).SetBig(s)
 		}
@@ -968,7 +968,7 @@ func (sig *Signature) Sign(h hash.Hash, priv *PrivateKey, config *Config) (err e
 			r, s, err = ecdsa.Sign(config.Random(), sk, digest)
 		} else {
 			var b []byte
-			b, err = priv.PrivateKey.(crypto.Signer).Sign(config.Random(), digest, sig.Hash)
+			b, err = signWithSigner(priv, config, digest, sig.Hash)
 			if err == nil {
 				r, s, err = unwrapECDSASig(b)
 			}
@@ -979,22 +979,36 @@ func (sig *Signature) Sign(h hash.Hash, priv *PrivateKey, config *Config) (err e
 			sig.ECDSASigS = new(encoding.MPI).SetBig(s)
 		}
 	case PubKeyAlgoEdDSA:
-		sk := priv.PrivateKey.(*eddsa.PrivateKey)
-		r, s, err := eddsa.Sign(sk, digest)
+		// supports both *eddsa.PrivateKey and crypto.Signer, the latter
+		// signing the digest as the message
+		var r, s []byte
+		if sk, ok := priv.PrivateKey.(*eddsa.PrivateKey); ok {
+			r, s, err = eddsa.Sign(sk, digest)
+		} else {
+			var b []byte
+			if b, err = signWithSigner(priv, config, digest, crypto.Hash(0)); err == nil {
+				r, s = priv.PublicKey.PublicKey.(*eddsa.PublicKey).GetCurve().MarshalSignature(b)
+			}
+		}
 		if err == nil {
 			sig.EdDSASigR = encoding.NewMPI(r)
 			sig.EdDSASigS = encoding.NewMPI(s)
 		}
 	case PubKeyAlgoEd25519:
-		sk := priv.PrivateKey.(*ed25519.PrivateKey)
-		signature, err := ed25519.Sign(sk, digest)
+		// supports both *ed25519.PrivateKey and crypto.Signer
+		var signature []byte
+		if sk, ok := priv.PrivateKey.(*ed25519.PrivateKey); ok {
+			signature, err = ed25519.Sign(sk, digest)
+		} else {
+			signature, err = signWithSigner(priv, config, digest, crypto.Hash(0))
+		}
 		if err == nil {
 			sig.EdSig = signature
 		}
 	case PubKeyAlgoEd448:
 		sk := priv.PrivateKey.(*ed448.PrivateKey)
-		signature, err := ed448.Sign(sk, digest)
-		if err == nil {
+		var signature []byte
+		if signature, err = ed448.Sign(sk, digest); err == nil {
 			sig.EdSig = signature
 		}
 	default:
@@ -1004,6 +1018,16 @@ func (sig *Signature) Sign(h hash.Hash, priv *PrivateKey, config *Config) (err e
 	return
 }
 
+// signWithSigner signs digest with a private key that implements
+// crypto.Signer, such as a key held by a smart card.
+func signWithSigner(priv *PrivateKey, config *Config, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	signer, ok := priv.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.InvalidArgumentError("private key of type " + strconv.Itoa(int(priv.PubKeyAlgo)) + " is not a crypto.Signer")
+	}
+	return signer.Sign(config.Random(), digest, opts)
+}
+
 // unwrapECDSASig parses the two integer components of an ASN.1-encoded ECDSA signature.
 func unwrapECDSASig(b []byte) (r, s *big.Int, err error) {
 	var ecsdaSig struct {
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/malivvan/aegis/cli"
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/otp"
+)
+
+var otpCommand = &cli.Command{
+	Name:  "otp",
+	Usage: "generate TOTP and HOTP codes from keys stored in entries",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "code",
+			Usage:     "print the current code of an entry, HOTP codes advance the counter stored in the entry",
+			ArgsUsage: "<entry>",
+			Action:    otpCodeAction,
+		},
+		{
+			Name:      "set",
+			Usage:     "store a key in an entry, the base32 secret or otpauth:// URI is read from the terminal",
+			ArgsUsage: "<entry>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "issuer", Usage: "issuer of the key"},
+				&cli.StringFlag{Name: "account", Usage: "account of the key"},
+				&cli.BoolFlag{Name: "hotp", Usage: "counter based instead of time based key"},
+				&cli.BoolFlag{Name: "steam", Usage: "generate Steam Guard codes"},
+				&cli.StringFlag{Name: "algorithm", Value: "SHA1", Usage: "SHA1, SHA256 or SHA512"},
+				&cli.IntFlag{Name: "digits", Value: otp.DefaultDigits, Usage: "number of digits of the codes"},
+				&cli.IntFlag{Name: "period", Value: int(otp.DefaultPeriod / time.Second), Usage: "time step of TOTP codes in seconds"},
+				&cli.IntFlag{Name: "counter", Usage: "initial counter of HOTP keys"},
+			},
+			Action: otpSetAction,
+		},
+	},
+}
+
+func otpCodeAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 1); err != nil {
+		return err
+	}
+	v, err := openVault(ctx)
+	if err != nil {
+		return err
+	}
+	path := ctx.Args().First()
+	e, err := v.Entry(path)
+	if err != nil {
+		return err
+	}
+	k, err := otp.Load(e)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	if k.Type != otp.HOTP {
+		code, err := k.Code(now)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\t%ds\n", code, int(k.Remaining(now).Seconds()))
+		return nil
+	}
+	// the code must not be generated again, so the advanced counter is saved before it is shown. The counter is not a
+	// change of the entry worth a history entry, which would push out the real history.
+	code, _, err := otp.Generate(e, now)
+	if err != nil {
+		return err
+	}
+	if err := saveVault(v); err != nil {
+		return err
+	}
+	fmt.Println(code)
+	return nil
+}
+
+func otpSetAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 1); err != nil {
+		return err
+	}
+	secret, err := readPassword("Secret (base32 or otpauth:// URI): ")
+	if err != nil {
+		return err
+	}
+	var k *otp.Key
+	if strings.HasPrefix(strings.TrimSpace(secret), "otpauth:") {
+		if k, err = otp.ParseURI(secret); err != nil {
+			return err
+		}
+	} else {
+		k = &otp.Key{
+			Type:    otp.TOTP,
+			Issuer:  ctx.String("issuer"),
+			Account: ctx.String("account"),
+			Digits:  ctx.Int("digits"),
+			Period:  time.Duration(ctx.Int("period")) * time.Second,
+			Counter: uint64(max(ctx.Int("counter"), 0)),
+		}
+		if ctx.Bool("hotp") {
+			k.Type = otp.HOTP
+		}
+		if ctx.Bool("steam") {
+			k.Steam, k.Digits = true, otp.SteamDigits
+		}
+		if k.Algorithm, err = otp.ParseAlgorithm(ctx.String("algorithm")); err != nil {
+			return err
+		}
+		if k.Secret, err = otp.DecodeBase32(secret); err != nil {
+			return err
+		}
+		if _, err := k.Code(time.Now()); err != nil {
+			return err
+		}
+	}
+
+	v, err := openVault(ctx)
+	if err != nil {
+		return err
+	}
+	path := ctx.Args().First()
+	if k.Account == "" {
+		e, err := v.Entry(path)
+		if err != nil {
+			return err
+		}
+		k.Account = e.GetContent("UserName")
+	}
+	if err := v.UpdateEntry(path, func(e *kdbx.Entry) { otp.Store(e, k) }); err != nil {
+		return err
+	}
+	return saveVault(v)
+}
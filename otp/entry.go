@@ -0,0 +1,228 @@
+package otp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/malivvan/aegis/kdbx"
+	w "github.com/malivvan/aegis/kdbx/wrappers"
+)
+
+// URIField is the entry value KeePassXC stores an otpauth:// URI in.
+const URIField = "otp"
+
+// Prefixes of the entry values KeePass 2 stores TOTP and HOTP keys in. The secret is stored in one of the values
+// prefix+"Secret" (UTF-8), prefix+"Secret-Hex", prefix+"Secret-Base32" or prefix+"Secret-Base64".
+// https://keepass.info/help/base/placeholders.html#otp
+const (
+	TimeOtpPrefix = "TimeOtp-"
+	HmacOtpPrefix = "HmacOtp-"
+)
+
+// Suffixes of the KeePass 2 values of the other parameters.
+const (
+	LengthField    = "Length"
+	PeriodField    = "Period"
+	AlgorithmField = "Algorithm"
+	CounterField   = "Counter"
+)
+
+var ErrNoKey = errors.New("entry has no OTP key")
+
+// Load reads the key stored in e, from the otp value of KeePassXC or the TimeOtp-* and HmacOtp-* values of KeePass 2.
+func Load(e *kdbx.Entry) (*Key, error) {
+	if k, err := loadURI(e); err != nil || k != nil {
+		return k, err
+	}
+	if secret, err := keePassSecret(e, TimeOtpPrefix); err != nil || secret != nil {
+		if err != nil {
+			return nil, err
+		}
+		k := &Key{Type: TOTP, Secret: secret, Algorithm: SHA1, Digits: DefaultDigits, Period: DefaultPeriod}
+		if k.Digits, err = intValue(e, TimeOtpPrefix+LengthField, DefaultDigits); err != nil {
+			return nil, err
+		}
+		period, err := intValue(e, TimeOtpPrefix+PeriodField, int(DefaultPeriod/time.Second))
+		if err != nil {
+			return nil, err
+		}
+		k.Period = time.Duration(period) * time.Second
+		if algorithm := e.GetContent(TimeOtpPrefix + AlgorithmField); algorithm != "" {
+			if k.Algorithm, err = ParseAlgorithm(algorithm); err != nil {
+				return nil, fmt.Errorf("%s: %w", TimeOtpPrefix+AlgorithmField, err)
+			}
+		}
+		return k, k.validate()
+	}
+	if secret, err := keePassSecret(e, HmacOtpPrefix); err != nil || secret != nil {
+		if err != nil {
+			return nil, err
+		}
+		k := &Key{Type: HOTP, Secret: secret, Algorithm: SHA1, Digits: DefaultDigits}
+		counter, err := intValue(e, HmacOtpPrefix+CounterField, 0)
+		if err != nil {
+			return nil, err
+		}
+		k.Counter = uint64(counter)
+		return k, k.validate()
+	}
+	return nil, ErrNoKey
+}
+
+// Generate returns the code of the key stored in e at t. Generating a HOTP code advances the counter stored in e and
+// updates its modification time, e has to be saved so that the code cannot be generated again.
+func Generate(e *kdbx.Entry, t time.Time) (string, *Key, error) {
+	k, err := Load(e)
+	if err != nil {
+		return "", nil, err
+	}
+	code, err := k.Code(t)
+	if err != nil {
+		return "", nil, err
+	}
+	if k.Type == HOTP {
+		if err := setCounter(e, k.Counter+1); err != nil {
+			return "", nil, err
+		}
+		now := w.Now()
+		e.Times.LastModificationTime = &now
+	}
+	return code, k, nil
+}
+
+// MergeCounter is a kdbx.MergeRule that keeps the higher counter if both versions of an entry store the same HOTP
+// secret, so that a merge never makes generated codes valid again. Add it to the MergeRules of keyrings with HOTP
+// keys.
+func MergeCounter(merged, other *kdbx.Entry) bool {
+	k, err := Load(merged)
+	if err != nil || k.Type != HOTP {
+		return false
+	}
+	o, err := Load(other)
+	if err != nil || o.Type != HOTP || !bytes.Equal(k.Secret, o.Secret) || o.Counter <= k.Counter {
+		return false
+	}
+	return setCounter(merged, o.Counter) == nil
+}
+
+// Store writes k into the otp value of e as KeePassXC does.
+func Store(e *kdbx.Entry, k *Key) {
+	setProtected(e, URIField, k.URI())
+}
+
+// loadURI parses the otp value of e, or returns nil if there is none. The value is protected, its buffer is
+// destroyed after parsing.
+func loadURI(e *kdbx.Entry) (*Key, error) {
+	buf, err := e.OpenContent(URIField)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", URIField, err)
+	}
+	defer buf.Destroy()
+	if buf.Size() == 0 {
+		return nil, nil
+	}
+	k, err := ParseURI(string(buf.Bytes()))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", URIField, err)
+	}
+	return k, nil
+}
+
+// setCounter writes the counter of the HOTP key of e into the value it was read from.
+func setCounter(e *kdbx.Entry, counter uint64) error {
+	buf, err := e.OpenContent(URIField)
+	if err != nil {
+		return fmt.Errorf("%s: %w", URIField, err)
+	}
+	defer buf.Destroy()
+	if buf.Size() > 0 {
+		u, err := url.Parse(string(buf.Bytes()))
+		if err != nil {
+			return fmt.Errorf("%s: %w", URIField, err)
+		}
+		q := u.Query()
+		q.Set("counter", strconv.FormatUint(counter, 10))
+		u.RawQuery = q.Encode()
+		setContent(e, URIField, u.String())
+		return nil
+	}
+	setContent(e, HmacOtpPrefix+CounterField, strconv.FormatUint(counter, 10))
+	return nil
+}
+
+// secretEncodings decode the KeePass 2 secret values, by suffix.
+var secretEncodings = []struct {
+	suffix string
+	decode func(src []byte) ([]byte, error)
+}{
+	{"Secret", func(src []byte) ([]byte, error) { return append([]byte(nil), src...), nil }},
+	{"Secret-Hex", func(src []byte) ([]byte, error) {
+		b := make([]byte, hex.DecodedLen(len(src)))
+		_, err := hex.Decode(b, src)
+		return b, err
+	}},
+	{"Secret-Base32", decodeBase32},
+	{"Secret-Base64", func(src []byte) ([]byte, error) {
+		b := make([]byte, base64.StdEncoding.DecodedLen(len(src)))
+		n, err := base64.StdEncoding.Decode(b, src)
+		return b[:n], err
+	}},
+}
+
+// keePassSecret decodes the secret stored with prefix, or returns nil if there is none. The values are opened in
+// guarded memory, which is destroyed after decoding.
+func keePassSecret(e *kdbx.Entry, prefix string) ([]byte, error) {
+	for _, encoding := range secretEncodings {
+		buf, err := e.OpenContent(prefix + encoding.suffix)
+		if err != nil {
+			return nil, fmt.Errorf("%s%s: %w", prefix, encoding.suffix, err)
+		}
+		if buf.Size() == 0 {
+			buf.Destroy()
+			continue
+		}
+		b, err := encoding.decode(buf.Bytes())
+		buf.Destroy()
+		if err != nil {
+			return nil, fmt.Errorf("%s%s: %w", prefix, encoding.suffix, err)
+		}
+		return b, nil
+	}
+	return nil, nil
+}
+
+func intValue(e *kdbx.Entry, key string, def int) (int, error) {
+	s := e.GetContent(key)
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%s: invalid number %q", key, s)
+	}
+	return n, nil
+}
+
+// setContent replaces the content of the value key of e, keeping whether it is protected.
+func setContent(e *kdbx.Entry, key, content string) {
+	if i := e.GetIndex(key); i >= 0 {
+		e.Values[i].Value = kdbx.NewV(content, e.Values[i].Value.Protected.Bool)
+		return
+	}
+	e.Values = append(e.Values, kdbx.ValueData{Key: key, Value: kdbx.NewV(content, false)})
+}
+
+func setProtected(e *kdbx.Entry, key, value string) {
+	v := kdbx.NewV(value, true)
+	if i := e.GetIndex(key); i >= 0 {
+		e.Values[i].Value = v
+		return
+	}
+	e.Values = append(e.Values, kdbx.ValueData{Key: key, Value: v})
+}
@@ -0,0 +1,293 @@
+// Package otp generates HOTP (RFC 4226) and TOTP (RFC 6238) codes for keys given as otpauth:// URIs or in the
+// fields KeePassXC and KeePass 2 store them in.
+package otp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/malivvan/aegis/mgrd"
+)
+
+// Type is the type of a key.
+type Type int
+
+const (
+	TOTP Type = iota
+	HOTP
+)
+
+func (t Type) String() string {
+	if t == HOTP {
+		return "hotp"
+	}
+	return "totp"
+}
+
+// Algorithm is the HMAC algorithm of a key.
+type Algorithm int
+
+const (
+	SHA1 Algorithm = iota
+	SHA256
+	SHA512
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case SHA256:
+		return "SHA256"
+	case SHA512:
+		return "SHA512"
+	}
+	return "SHA1"
+}
+
+func (a Algorithm) hash() func() hash.Hash {
+	switch a {
+	case SHA256:
+		return sha256.New
+	case SHA512:
+		return sha512.New
+	}
+	return sha1.New
+}
+
+// ParseAlgorithm parses the name of an algorithm as otpauth:// URIs ("SHA256") and KeePass ("HMAC-SHA-256") write
+// them.
+func ParseAlgorithm(s string) (Algorithm, error) {
+	switch strings.ReplaceAll(strings.TrimPrefix(strings.ToUpper(s), "HMAC-"), "-", "") {
+	case "SHA1":
+		return SHA1, nil
+	case "SHA256":
+		return SHA256, nil
+	case "SHA512":
+		return SHA512, nil
+	}
+	return 0, fmt.Errorf("%w: unknown algorithm %q", ErrInvalidKey, s)
+}
+
+const (
+	DefaultDigits = 6
+	DefaultPeriod = 30 * time.Second
+	MinDigits     = 6
+	MaxDigits     = 8
+	// SteamDigits is the number of characters of Steam Guard codes.
+	SteamDigits = 5
+)
+
+// steamAlphabet are the characters of Steam Guard codes.
+const steamAlphabet = "23456789BCDFGHJKMNPQRTVWXY"
+
+var (
+	ErrInvalidKey = errors.New("invalid OTP key")
+	ErrInvalidURI = errors.New("invalid otpauth URI")
+)
+
+// Key is a shared secret with the parameters the codes are generated with.
+type Key struct {
+	Type      Type
+	Issuer    string
+	Account   string
+	Secret    []byte
+	Algorithm Algorithm
+	Digits    int
+	Period    time.Duration // time step of TOTP keys
+	Counter   uint64        // counter of the next HOTP code
+	// Steam keys generate Steam Guard codes: five characters from an alphabet of 26 instead of decimal digits.
+	Steam bool
+}
+
+// ParseURI parses a key in the otpauth:// URI format of Google Authenticator, with the encoder=steam parameter of
+// KeePassXC for Steam Guard keys.
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func ParseURI(uri string) (*Key, error) {
+	u, err := url.Parse(strings.TrimSpace(uri))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidURI, err)
+	}
+	if u.Scheme != "otpauth" {
+		return nil, fmt.Errorf("%w: scheme %q", ErrInvalidURI, u.Scheme)
+	}
+	k := &Key{Algorithm: SHA1, Digits: DefaultDigits, Period: DefaultPeriod}
+	switch strings.ToLower(u.Host) {
+	case "totp":
+		k.Type = TOTP
+	case "hotp":
+		k.Type = HOTP
+	default:
+		return nil, fmt.Errorf("%w: type %q", ErrInvalidURI, u.Host)
+	}
+	label := strings.TrimPrefix(u.Path, "/")
+	if issuer, account, ok := strings.Cut(label, ":"); ok {
+		k.Issuer, k.Account = strings.TrimSpace(issuer), strings.TrimSpace(account)
+	} else {
+		k.Account = label
+	}
+
+	q := u.Query()
+	if k.Secret, err = DecodeBase32(q.Get("secret")); err != nil {
+		return nil, err
+	}
+	if issuer := q.Get("issuer"); issuer != "" {
+		k.Issuer = issuer
+	}
+	if algorithm := q.Get("algorithm"); algorithm != "" {
+		if k.Algorithm, err = ParseAlgorithm(algorithm); err != nil {
+			return nil, err
+		}
+	}
+	if digits := q.Get("digits"); digits != "" {
+		if k.Digits, err = strconv.Atoi(digits); err != nil {
+			return nil, fmt.Errorf("%w: digits %q", ErrInvalidURI, digits)
+		}
+	}
+	if period := q.Get("period"); period != "" {
+		seconds, err := strconv.Atoi(period)
+		if err != nil || seconds <= 0 {
+			return nil, fmt.Errorf("%w: period %q", ErrInvalidURI, period)
+		}
+		k.Period = time.Duration(seconds) * time.Second
+	}
+	if counter := q.Get("counter"); counter != "" {
+		if k.Counter, err = strconv.ParseUint(counter, 10, 64); err != nil {
+			return nil, fmt.Errorf("%w: counter %q", ErrInvalidURI, counter)
+		}
+	} else if k.Type == HOTP {
+		return nil, fmt.Errorf("%w: missing counter", ErrInvalidURI)
+	}
+	if strings.EqualFold(q.Get("encoder"), "steam") {
+		k.Steam, k.Digits = true, SteamDigits
+	}
+	return k, k.validate()
+}
+
+// URI formats k as an otpauth:// URI.
+func (k *Key) URI() string {
+	label := k.Account
+	if k.Issuer != "" {
+		label = k.Issuer + ":" + k.Account
+	}
+	q := url.Values{}
+	q.Set("secret", EncodeBase32(k.Secret))
+	if k.Issuer != "" {
+		q.Set("issuer", k.Issuer)
+	}
+	q.Set("algorithm", k.Algorithm.String())
+	q.Set("digits", strconv.Itoa(k.Digits))
+	if k.Type == HOTP {
+		q.Set("counter", strconv.FormatUint(k.Counter, 10))
+	} else {
+		q.Set("period", strconv.Itoa(int(k.Period/time.Second)))
+	}
+	if k.Steam {
+		q.Set("encoder", "steam")
+	}
+	u := url.URL{Scheme: "otpauth", Host: k.Type.String(), Path: "/" + label, RawQuery: q.Encode()}
+	return u.String()
+}
+
+func (k *Key) validate() error {
+	if len(k.Secret) == 0 {
+		return fmt.Errorf("%w: empty secret", ErrInvalidKey)
+	}
+	if !k.Steam && (k.Digits < MinDigits || k.Digits > MaxDigits) {
+		return fmt.Errorf("%w: %d digits", ErrInvalidKey, k.Digits)
+	}
+	if k.Type == TOTP && k.Period < time.Second {
+		return fmt.Errorf("%w: period %s", ErrInvalidKey, k.Period)
+	}
+	return nil
+}
+
+// Code returns the code of a TOTP key at t or the code of a HOTP key for its counter, which the caller has to
+// advance after using the code.
+func (k *Key) Code(t time.Time) (string, error) {
+	if err := k.validate(); err != nil {
+		return "", err
+	}
+	counter := k.Counter
+	if k.Type == TOTP {
+		counter = uint64(t.Unix() / int64(k.Period/time.Second))
+	}
+	value := truncate(k.Algorithm, k.Secret, counter)
+	if k.Steam {
+		code := make([]byte, SteamDigits)
+		for i := range code {
+			code[i] = steamAlphabet[value%uint32(len(steamAlphabet))]
+			value /= uint32(len(steamAlphabet))
+		}
+		return string(code), nil
+	}
+	mod := uint32(1)
+	for range k.Digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", k.Digits, value%mod), nil
+}
+
+// Remaining returns how long the TOTP code at t stays valid.
+func (k *Key) Remaining(t time.Time) time.Duration {
+	if k.Type != TOTP || k.Period <= 0 {
+		return 0
+	}
+	seconds := int64(k.Period / time.Second)
+	end := time.Unix((t.Unix()/seconds+1)*seconds, 0)
+	return end.Sub(t)
+}
+
+// truncate computes the HMAC of counter and applies the dynamic truncation of RFC 4226.
+func truncate(a Algorithm, secret []byte, counter uint64) uint32 {
+	mac := hmac.New(a.hash(), secret)
+	mac.Write(binary.BigEndian.AppendUint64(nil, counter))
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+}
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// DecodeBase32 decodes a secret as authenticator apps show it: case insensitive, possibly grouped with spaces and
+// with or without padding.
+func DecodeBase32(s string) ([]byte, error) {
+	return decodeBase32([]byte(s))
+}
+
+// decodeBase32 decodes src like DecodeBase32, the copy it normalizes src in is wiped.
+func decodeBase32(src []byte) ([]byte, error) {
+	s := make([]byte, 0, len(src))
+	defer mgrd.WipeBytes(s[:cap(s)])
+	for _, c := range src {
+		switch {
+		case c == ' ' || c == '-':
+		case 'a' <= c && c <= 'z':
+			s = append(s, c-'a'+'A')
+		default:
+			s = append(s, c)
+		}
+	}
+	s = bytes.TrimRight(s, "=")
+	b := make([]byte, base32NoPadding.DecodedLen(len(s)))
+	n, err := base32NoPadding.Decode(b, s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: secret: %w", ErrInvalidKey, err)
+	}
+	return b[:n], nil
+}
+
+// EncodeBase32 encodes a secret without padding.
+func EncodeBase32(b []byte) string {
+	return base32NoPadding.EncodeToString(b)
+}
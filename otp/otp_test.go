@@ -0,0 +1,284 @@
+package otp
+
+import (
+	"encoding/base32"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/vault"
+)
+
+var seed = []byte("1234567890123456789012345678901234567890123456789012345678901234")
+
+// TestKey_Code checks the codes against the test vectors of RFC 4226 and RFC 6238.
+func TestKey_Code(t *testing.T) {
+	for _, test := range []struct {
+		algorithm Algorithm
+		key       []byte
+		unix      int64
+		code      string
+	}{
+		{SHA1, seed[:20], 59, "94287082"},
+		{SHA1, seed[:20], 1111111109, "07081804"},
+		{SHA1, seed[:20], 20000000000, "65353130"},
+		{SHA256, seed[:32], 59, "46119246"},
+		{SHA256, seed[:32], 1234567890, "91819424"},
+		{SHA512, seed[:64], 59, "90693936"},
+		{SHA512, seed[:64], 2000000000, "38618901"},
+	} {
+		k := &Key{Type: TOTP, Secret: test.key, Algorithm: test.algorithm, Digits: 8, Period: DefaultPeriod}
+		code, err := k.Code(time.Unix(test.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != test.code {
+			t.Fatalf("Expected %s for %s at %d, got %s", test.code, test.algorithm, test.unix, code)
+		}
+	}
+
+	k := &Key{Type: HOTP, Secret: seed[:20], Digits: 6}
+	for i, expected := range []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"} {
+		k.Counter = uint64(i)
+		if code, err := k.Code(time.Now()); err != nil || code != expected {
+			t.Fatalf("Expected HOTP code %s for counter %d, got %s %v", expected, i, code, err)
+		}
+	}
+
+	k = &Key{Type: TOTP, Secret: seed[:20], Digits: 6, Period: time.Minute}
+	if remaining := k.Remaining(time.Unix(100, 0)); remaining != 20*time.Second {
+		t.Fatalf("Expected 20s remaining, got %s", remaining)
+	}
+	k.Digits = 9
+	if _, err := k.Code(time.Now()); !errors.Is(err, ErrInvalidKey) {
+		t.Fatalf("Expected ErrInvalidKey for 9 digits, got %v", err)
+	}
+}
+
+func TestKey_Steam(t *testing.T) {
+	k, err := ParseURI("otpauth://totp/Steam:alice?secret=GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ&encoder=steam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !k.Steam || k.Digits != SteamDigits {
+		t.Fatalf("Expected a Steam key, got %+v", k)
+	}
+	code, err := k.Code(time.Unix(59, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(code) != SteamDigits || strings.Trim(code, steamAlphabet) != "" {
+		t.Fatalf("Expected a Steam Guard code, got %q", code)
+	}
+	// the characters are the base 26 digits of the truncated HMAC, least significant first
+	value := truncate(SHA1, k.Secret, 1)
+	if code[0] != steamAlphabet[value%26] || code[1] != steamAlphabet[value/26%26] {
+		t.Fatalf("Unexpected Steam Guard code %q for %d", code, value)
+	}
+}
+
+func TestParseURI(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString(seed[:20])
+	k, err := ParseURI("otpauth://totp/Example:alice@example.com?secret=" + strings.ToLower(strings.TrimRight(secret, "=")) +
+		"&issuer=Example&algorithm=SHA256&digits=8&period=60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Type != TOTP || k.Issuer != "Example" || k.Account != "alice@example.com" || string(k.Secret) != string(seed[:20]) ||
+		k.Algorithm != SHA256 || k.Digits != 8 || k.Period != time.Minute {
+		t.Fatalf("Unexpected key %+v", k)
+	}
+	parsed, err := ParseURI(k.URI())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.URI() != k.URI() {
+		t.Fatalf("Expected %s after round trip, got %s", k.URI(), parsed.URI())
+	}
+
+	k, err = ParseURI("otpauth://hotp/alice?secret=" + secret + "&counter=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Type != HOTP || k.Account != "alice" || k.Counter != 7 || k.Digits != DefaultDigits || k.Algorithm != SHA1 {
+		t.Fatalf("Unexpected key %+v", k)
+	}
+
+	for _, uri := range []string{
+		"https://totp/alice?secret=" + secret,
+		"otpauth://motp/alice?secret=" + secret,
+		"otpauth://hotp/alice?secret=" + secret,
+		"otpauth://totp/alice?secret=" + secret + "&period=0",
+		"otpauth://totp/alice?secret=" + secret + "&digits=x",
+	} {
+		if _, err := ParseURI(uri); !errors.Is(err, ErrInvalidURI) {
+			t.Fatalf("Expected ErrInvalidURI for %s, got %v", uri, err)
+		}
+	}
+	for _, uri := range []string{
+		"otpauth://totp/alice?secret=",
+		"otpauth://totp/alice?secret=" + secret + "&digits=4",
+		"otpauth://totp/alice?secret=" + secret + "&algorithm=MD5",
+	} {
+		if _, err := ParseURI(uri); !errors.Is(err, ErrInvalidKey) {
+			t.Fatalf("Expected ErrInvalidKey for %s, got %v", uri, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	for _, test := range []struct {
+		values map[string]string
+		key    Key
+	}{
+		{
+			map[string]string{"TimeOtp-Secret-Base32": "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"},
+			Key{Type: TOTP, Secret: seed[:20], Algorithm: SHA1, Digits: 6, Period: DefaultPeriod},
+		},
+		{
+			map[string]string{"TimeOtp-Secret": string(seed[:32]), "TimeOtp-Length": "8", "TimeOtp-Period": "60", "TimeOtp-Algorithm": "HMAC-SHA-256"},
+			Key{Type: TOTP, Secret: seed[:32], Algorithm: SHA256, Digits: 8, Period: time.Minute},
+		},
+		{
+			map[string]string{"HmacOtp-Secret-Hex": "3132333435363738393031323334353637383930", "HmacOtp-Counter": "3"},
+			Key{Type: HOTP, Secret: seed[:20], Algorithm: SHA1, Digits: 6, Counter: 3},
+		},
+		{
+			map[string]string{"HmacOtp-Secret-Base64": "MTIzNDU2Nzg5MDEyMzQ1Njc4OTA="},
+			Key{Type: HOTP, Secret: seed[:20], Algorithm: SHA1, Digits: 6},
+		},
+	} {
+		e := &kdbx.Entry{}
+		for key, value := range test.values {
+			setContent(e, key, value)
+		}
+		k, err := Load(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if k.URI() != test.key.URI() {
+			t.Fatalf("Expected %s for %v, got %s", test.key.URI(), test.values, k.URI())
+		}
+	}
+
+	e := &kdbx.Entry{}
+	if _, err := Load(e); !errors.Is(err, ErrNoKey) {
+		t.Fatalf("Expected ErrNoKey, got %v", err)
+	}
+	setContent(e, "TimeOtp-Secret-Hex", "xyz")
+	if _, err := Load(e); err == nil {
+		t.Fatal("Expected an error for an invalid hex secret")
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kdbx")
+	v, err := vault.Create(path, kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uriEntry, err := v.AddEntry("uri")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Store(uriEntry, &Key{Type: HOTP, Secret: seed[:20], Algorithm: SHA1, Digits: 6})
+	keePassEntry, err := v.AddEntry("keepass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	setProtected(keePassEntry, "HmacOtp-Secret", string(seed[:20]))
+	setContent(keePassEntry, "HmacOtp-Counter", "1")
+
+	for _, e := range []*kdbx.Entry{uriEntry, keePassEntry} {
+		if _, _, err := Generate(e, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err = vault.Open(path, kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, expected := range map[string]string{"uri": "287082", "keepass": "359152"} {
+		e, err := v.Entry(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, k, err := Generate(e, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != expected || k.Type != HOTP {
+			t.Fatalf("Expected %s for %s after reopening, got %s", expected, path, code)
+		}
+	}
+	e, _ := v.Entry("uri")
+	if !e.Get(URIField).Value.Protected.Bool {
+		t.Fatal("Expected the otp value to stay protected")
+	}
+	if k, err := Load(e); err != nil || k.Counter != 2 {
+		t.Fatalf("Expected counter 2, got %+v %v", k, err)
+	}
+
+	k := &Key{Type: TOTP, Secret: seed[:20], Algorithm: SHA1, Digits: 8, Period: DefaultPeriod}
+	Store(e, k)
+	if code, _, err := Generate(e, time.Unix(59, 0)); err != nil || code != "94287082" {
+		t.Fatalf("Expected 94287082, got %s %v", code, err)
+	}
+}
+
+func TestGenerate_Merge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kdbx")
+	v, err := vault.Create(path, kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := v.AddEntry("hotp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	Store(e, &Key{Type: HOTP, Secret: seed[:20], Algorithm: SHA1, Digits: 6})
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// two processes generate codes of the same key, the one with the lower counter saves last
+	var copies [2]*vault.Vault
+	for i := range copies {
+		if copies[i], err = vault.Open(path, kdbx.NewPasswordCredentials("secret")); err != nil {
+			t.Fatal(err)
+		}
+		copies[i].MergeRules = []kdbx.MergeRule{MergeCounter}
+	}
+	for i, n := range []int{2, 1} {
+		e, err := copies[i].Entry("hotp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range n {
+			if _, _, err := Generate(e, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := copies[i].Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v, err = vault.Open(path, kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, err = v.Entry("hotp"); err != nil {
+		t.Fatal(err)
+	}
+	if k, err := Load(e); err != nil || k.Counter != 2 {
+		t.Fatalf("Expected the higher counter 2 to be kept, got %+v %v", k, err)
+	}
+}
@@ -0,0 +1,340 @@
+//go:build !windows
+
+package pcsc_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/malivvan/aegis/pcsc"
+	"github.com/malivvan/aegis/pcsc/pcsctest"
+)
+
+var CMD_SELECT = []byte{
+	0x00, 0xA4, 0x04, 0x00, 0x08,
+	0x90, 0x72, 0x5A, 0x9E, 0x3B, 0x10, 0x70, 0xAA,
+}
+
+var CMD_10 = []byte{
+	0x00, 0x10, 0x00, 0x00, 0x0B,
+}
+
+func printHex(buffer []byte) {
+	for _, b := range buffer {
+		fmt.Printf("%02x", b)
+	}
+	fmt.Println("")
+}
+
+func TestClient(t *testing.T) {
+	fmt.Println("\n=================")
+	fmt.Println("PCSCD Client Test")
+	fmt.Printf("=================\n\n")
+	server := pcsctest.NewServer(t)
+	server.AttachReader("Fake Reader 00 00")
+	server.InsertCard("Fake Reader 00 00", pcsctest.ScriptedCard([]byte{0x3b, 0x8c, 0x80, 0x01},
+		pcsctest.Exchange{Command: CMD_SELECT, Response: []byte{0x90, 0x00}},
+		pcsctest.Exchange{Command: CMD_10, Response: []byte("Hello world\x90\x00")},
+	))
+	t.Setenv("PCSCLITE_CSOCK_NAME", server.Socket)
+
+	fmt.Println("Connect to daemon")
+	fmt.Printf("-----------------\n\n")
+	client, err := pcsc.PCSCLiteConnect()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer client.Close()
+	fmt.Println("OK")
+
+	fmt.Println("\nEstablish Context")
+	fmt.Printf("-----------------\n\n")
+	context, err := client.EstablishContext()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer client.ReleaseContext(context)
+	fmt.Println("OK")
+
+	fmt.Println("\nList Readers")
+	fmt.Printf("------------\n\n")
+	var selectedReader *pcsc.Reader = nil
+	readers, err := client.ListReaders()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for _, reader := range readers {
+		fmt.Println(reader)
+		if reader.IsCardPresent() && (selectedReader == nil) {
+			selectedReader = reader
+		}
+	}
+
+	if selectedReader == nil {
+		t.Error("No reader with card found")
+		return
+	}
+
+	fmt.Println("Connect to card")
+	fmt.Printf("---------------\n\n")
+	card, protocol, err := client.CardConnect(context, selectedReader.Name())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Println("OK")
+
+	fmt.Println("\nSelect applet")
+	fmt.Printf("-------------\n\n")
+	buffer := make([]byte, 258)
+	printHex(CMD_SELECT)
+	received, err := client.Transmit(card, protocol, CMD_SELECT, buffer)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	printHex(buffer[:received])
+
+	fmt.Println("\nSend CMD 10")
+	fmt.Printf("-----------\n\n")
+	printHex(CMD_10)
+	received, err = client.Transmit(card, protocol, CMD_10, buffer)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	printHex(buffer[:received])
+	fmt.Printf("Quoth the Applet, \"%s\"\n", string(buffer[:received-2]))
+	if string(buffer[:received-2]) != "Hello world" {
+		t.Errorf("Unexpected response %x", buffer[:received])
+	}
+
+	fmt.Println("\nDisconnect from card")
+	fmt.Printf("--------------------\n\n")
+	err = client.CardDisconnect(card)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Println("OK")
+}
+
+// connect returns a client with an established context connected to server.
+func connect(t *testing.T, server *pcsctest.Server) (*pcsc.PCSCLiteClient, uint32) {
+	t.Helper()
+	client, err := pcsc.PCSCLiteDial(server.Socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+	context, err := client.EstablishContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, context
+}
+
+func TestTransaction(t *testing.T) {
+	server := pcsctest.NewServer(t)
+	server.AttachReader("Fake Reader 00 00")
+	server.InsertCard("Fake Reader 00 00", pcsctest.ScriptedCard([]byte{0x3b, 0x8c, 0x80, 0x01},
+		pcsctest.Exchange{Command: CMD_SELECT, Response: []byte{0x90, 0x00}},
+	))
+	first, firstContext := connect(t, server)
+	second, secondContext := connect(t, server)
+	firstCard, protocol, err := first.CardConnect(firstContext, "Fake Reader 00 00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondCard, _, err := second.CardConnect(secondContext, "Fake Reader 00 00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := first.BeginTransaction(firstCard); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 258)
+	if _, err := second.Transmit(secondCard, protocol, CMD_SELECT, buffer); !errors.Is(err, pcsc.Error(pcsc.SCARD_E_SHARING_VIOLATION)) {
+		t.Fatalf("Expected SCARD_E_SHARING_VIOLATION during the transaction of another client, got %v", err)
+	}
+	began := make(chan error, 1)
+	go func() { began <- second.BeginTransaction(secondCard) }()
+	select {
+	case err := <-began:
+		t.Fatalf("Expected the second transaction to wait, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := first.Transmit(firstCard, protocol, CMD_SELECT, buffer); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.EndTransaction(firstCard, pcsc.SCARD_LEAVE_CARD); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-began; err != nil {
+		t.Fatal(err)
+	}
+
+	// the card is pulled in the middle of the transaction
+	server.RemoveCard("Fake Reader 00 00")
+	removed := pcsc.Error(pcsc.SCARD_W_REMOVED_CARD)
+	if _, err := second.Transmit(secondCard, protocol, CMD_10, buffer); !errors.Is(err, removed) {
+		t.Fatalf("Expected SCARD_W_REMOVED_CARD, got %v", err)
+	}
+	if err := second.EndTransaction(secondCard, pcsc.SCARD_LEAVE_CARD); !errors.Is(err, removed) {
+		t.Fatalf("Expected SCARD_W_REMOVED_CARD, got %v", err)
+	}
+	if _, err := first.CardReconnect(firstCard, pcsc.SCARD_LEAVE_CARD); !errors.Is(err, pcsc.Error(pcsc.SCARD_E_NO_SMARTCARD)) {
+		t.Fatalf("Expected SCARD_E_NO_SMARTCARD, got %v", err)
+	}
+}
+
+func TestReaderStateChange(t *testing.T) {
+	server := pcsctest.NewServer(t)
+	server.AttachReader("Fake Reader 00 00")
+	client, context := connect(t, server)
+
+	count, err := client.RegisterReaderStateChange()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Fatalf("Expected 1 reader, got %d", count)
+	}
+	go server.AttachReader("Fake Reader 01 00")
+	if err := client.WaitReaderStateChange(); err != nil {
+		t.Fatal(err)
+	}
+	if count, err = client.RegisterReaderStateChange(); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("Expected 2 readers after hot-plug, got %d", count)
+	}
+
+	if err := client.Cancel(context); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WaitReaderStateChange(); !errors.Is(err, pcsc.Error(pcsc.SCARD_E_CANCELLED)) {
+		t.Fatalf("Expected SCARD_E_CANCELLED, got %v", err)
+	}
+
+	if _, err := client.RegisterReaderStateChange(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.StopWaitingReaderStateChange(); err != nil {
+		t.Fatal(err)
+	}
+	server.DetachReader("Fake Reader 00 00")
+	readers, err := client.ListReaders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readers) != 1 || readers[0].Name() != "Fake Reader 01 00" {
+		t.Fatalf("Expected only the second reader, got %v", readers)
+	}
+}
+
+func TestProtocolVersion(t *testing.T) {
+	server := pcsctest.NewServer(t)
+	client, err := pcsc.PCSCLiteDial(server.Socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	// an old pcscd is answered with its version
+	server.SetVersion(4, 3)
+	client, err = pcsc.PCSCLiteDial(server.Socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.EstablishContext(); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+
+	for _, version := range [][2]int32{{4, 2}, {5, 0}} {
+		server.SetVersion(version[0], version[1])
+		_, err := pcsc.PCSCLiteDial(server.Socket)
+		if !errors.Is(err, pcsc.ErrProtocolMismatch) {
+			t.Fatalf("Expected ErrProtocolMismatch for %d.%d, got %v", version[0], version[1], err)
+		}
+		if !errors.Is(err, pcsc.Error(pcsc.SCARD_E_SERVICE_STOPPED)) {
+			t.Fatalf("Expected SCARD_E_SERVICE_STOPPED for %d.%d, got %v", version[0], version[1], err)
+		}
+	}
+}
+
+// oversizedServer starts a misbehaving pcscd that answers every transmit with resp, regardless of the size of the
+// buffer of the client, and returns its socket.
+func oversizedServer(t *testing.T, resp []byte) string {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "pcscd.comm")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var header [8]byte
+			if _, err := io.ReadFull(conn, header[:]); err != nil {
+				return
+			}
+			msg := make([]byte, binary.NativeEndian.Uint32(header[:4]))
+			if _, err := io.ReadFull(conn, msg); err != nil {
+				return
+			}
+			var data []byte
+			if binary.NativeEndian.Uint32(header[4:]) == 0x09 {
+				// the command APDU follows the transmit struct, whose recvLength is at offset 24
+				if _, err := io.CopyN(io.Discard, conn, int64(binary.NativeEndian.Uint32(msg[12:]))); err != nil {
+					return
+				}
+				binary.NativeEndian.PutUint32(msg[24:], uint32(len(resp)))
+				data = resp
+			}
+			if _, err := conn.Write(append(msg, data...)); err != nil {
+				return
+			}
+		}
+	}()
+	return socket
+}
+
+func TestTransmit_OversizedResponse(t *testing.T) {
+	resp := []byte("oversized response\x90\x00")
+	client, err := pcsc.PCSCLiteDial(oversizedServer(t, resp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	buffer := make([]byte, 258)
+	if _, err := client.Transmit(1, pcsc.SCARD_PROTOCOL_T1, CMD_SELECT, buffer[:2]); err == nil {
+		t.Fatal("Expected an error for a response exceeding the buffer")
+	}
+	// the skipped response does not end up in the next one
+	received, err := client.Transmit(1, pcsc.SCARD_PROTOCOL_T1, CMD_SELECT, buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buffer[:received], resp) {
+		t.Fatalf("Unexpected response %x", buffer[:received])
+	}
+}
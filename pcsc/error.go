@@ -1,5 +1,13 @@
 package pcsc
 
+// Error is a PC/SC return code, e.g. SCARD_W_RESET_CARD. Errors returned by the client wrap it, so that callers can
+// match specific codes with errors.Is.
+type Error uint32
+
+func (e Error) Error() string {
+	return errorString(uint32(e))
+}
+
 func errorString(code uint32) string {
 	if s, ok := errorMap[code]; ok {
 		return s
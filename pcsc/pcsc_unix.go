@@ -4,15 +4,21 @@ package pcsc
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"os"
+	"time"
 	"unsafe"
 )
 
 const (
-	// Protocol version
-	_PROTOCOL_VERSION_MAJOR = 4
-	_PROTOCOL_VERSION_MINOR = 3
+	// Protocol version: pcscd accepts only clients that speak its version. Current pcsc-lite releases serve 4.4, or
+	// 4.5 while still accepting 4.4 clients. Releases before 2018 serve 4.3, which the client falls back to.
+	_PROTOCOL_VERSION_MAJOR        = 4
+	_PROTOCOL_VERSION_MINOR        = 4
+	_PROTOCOL_VERSION_MINOR_LEGACY = 3
 	// Commands
 	_SCARD_ESTABLISH_CONTEXT  = 0x01
 	_SCARD_RELEASE_CONTEXT    = 0x02
@@ -32,13 +38,15 @@ const (
 	_SCARD_SET_ATTRIB         = 0x10
 	_CMD_VERSION              = 0x11
 	_CMD_GET_READERS_STATE    = 0x12
-	/*
-	   _CMD_WAIT_READER_STATE_CHANGE = 0x13
-	   _CMD_STOP_WAITING_READER_STATE_CHANGE = 0x14
-	*/
+	// Reader events
+	_CMD_WAIT_READER_STATE_CHANGE         = 0x13
+	_CMD_STOP_WAITING_READER_STATE_CHANGE = 0x14
 	// Limits
 	_PCSCLITE_MAX_READERS_CONTEXTS = 16
 	_MAX_READERNAME                = 128
+	_MAX_BUFFER_SIZE               = 264
+	// Polling interval while another client holds a transaction
+	_PCSCLITE_LOCK_POLL_RATE = 100 * time.Millisecond
 )
 
 type rxHeader struct {
@@ -90,6 +98,53 @@ type transmitStruct struct {
 	rv              uint32
 }
 
+type reconnectStruct struct {
+	card               int32
+	shareMode          uint32
+	preferredProtocols uint32
+	initialization     uint32
+	activeProtocol     uint32
+	rv                 uint32
+}
+
+type beginStruct struct {
+	card int32
+	rv   uint32
+}
+
+type endStruct struct {
+	card        int32
+	disposition uint32
+	rv          uint32
+}
+
+type cancelStruct struct {
+	context uint32
+	rv      uint32
+}
+
+type statusStruct struct {
+	card int32
+	rv   uint32
+}
+
+type controlStruct struct {
+	card          int32
+	controlCode   uint32
+	sendLength    uint32
+	recvLength    uint32
+	bytesReturned uint32
+	rv            uint32
+}
+
+type getSetStruct struct {
+	card    int32
+	attrID  uint32
+	attr    [_MAX_BUFFER_SIZE]byte
+	attrLen uint32
+	rv      uint32
+}
+
 type waitReaderStateChangeStruct struct {
 	timeOutMs uint32
 	rv        uint32
@@ -162,27 +217,78 @@ type PCSCLiteClient struct {
 	readerCount uint32
 }
 
+// DefaultSocket is the path of the pcscd socket unless PCSCLITE_CSOCK_NAME is set.
+const DefaultSocket = "/var/run/pcscd/pcscd.comm"
+
+// ErrProtocolMismatch is returned when pcscd does not accept the protocol version of the client.
+var ErrProtocolMismatch = errors.New("pcscd protocol version mismatch")
+
+// Socket returns the path of the pcscd socket: the value of PCSCLITE_CSOCK_NAME, as honoured by libpcsclite, or
+// DefaultSocket.
+func Socket() string {
+	if path := os.Getenv("PCSCLITE_CSOCK_NAME"); path != "" {
+		return path
+	}
+	return DefaultSocket
+}
+
+// PCSCLiteConnect connects to pcscd at the socket returned by Socket.
 func PCSCLiteConnect() (*PCSCLiteClient, error) {
-	var err error
-	client := &PCSCLiteClient{}
-	client.connection, err = net.Dial("unix", "/var/run/pcscd/pcscd.comm")
+	return PCSCLiteDial(Socket())
+}
+
+// PCSCLiteDial connects to pcscd at the socket path and negotiates the protocol version.
+func PCSCLiteDial(path string) (*PCSCLiteClient, error) {
+	client, server, err := dial(path, _PROTOCOL_VERSION_MINOR)
+	if errors.Is(err, ErrProtocolMismatch) && server.major == _PROTOCOL_VERSION_MAJOR &&
+		server.minor == _PROTOCOL_VERSION_MINOR_LEGACY {
+		// pcscd closes the connection after a mismatch
+		client, _, err = dial(path, _PROTOCOL_VERSION_MINOR_LEGACY)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("can't connect to PCSCD")
-	}
-	/*
-	   version := versionStruct{
-	       _PROTOCOL_VERSION_MAJOR, _PROTOCOL_VERSION_MINOR, 0,
-	   }
-	   ptr1 := (*[unsafe.Sizeof(version)]byte)(unsafe.Pointer(&version))
-	   err = client.ExchangeMessage(_CMD_VERSION, ptr1[:])
-	   if err != nil { return nil, err }
-	   if version.rv != SCARD_S_SUCCESS {
-	       return nil, fmt.Errorf("protocol version mismatch")
-	   }
-	*/
+		return nil, err
+	}
 	return client, nil
 }
 
+// dial connects to pcscd at the socket path with the protocol version 4.minor and returns the version of pcscd.
+func dial(path string, minor int32) (*PCSCLiteClient, versionStruct, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, versionStruct{}, fmt.Errorf("can't connect to pcscd at %s: %w", path, err)
+	}
+	client := &PCSCLiteClient{connection: conn}
+	server, err := client.exchangeVersion(minor)
+	if err != nil {
+		conn.Close()
+		return nil, server, fmt.Errorf("pcscd at %s: %w", path, err)
+	}
+	return client, server, nil
+}
+
+// exchangeVersion sends the protocol version of the client, which pcscd requires before any other command. pcscd
+// answers with its own version and fails the exchange if it cannot serve the client.
+func (client *PCSCLiteClient) exchangeVersion(minor int32) (versionStruct, error) {
+	version := versionStruct{
+		major: _PROTOCOL_VERSION_MAJOR,
+		minor: minor,
+	}
+	ptr := (*[unsafe.Sizeof(version)]byte)(unsafe.Pointer(&version))
+	if err := client.ExchangeMessage(_CMD_VERSION, ptr[:]); err != nil {
+		return versionStruct{}, fmt.Errorf("can't exchange protocol version: %w", err)
+	}
+	if version.rv != SCARD_S_SUCCESS {
+		return version, fmt.Errorf("%w: server speaks %d.%d, client %d.%d (%w)", ErrProtocolMismatch,
+			version.major, version.minor, _PROTOCOL_VERSION_MAJOR, minor, Error(version.rv))
+	}
+	return version, nil
+}
+
+// Clone connects another client to the daemon of client, e.g. to wait for reader events without blocking client.
+func (client *PCSCLiteClient) Clone() (*PCSCLiteClient, error) {
+	return PCSCLiteDial(client.connection.RemoteAddr().String())
+}
+
 func (client *PCSCLiteClient) Readers() ReaderArray {
 	return client.readers
 }
@@ -207,7 +313,7 @@ func (client *PCSCLiteClient) ExchangeMessage(cmd uint32, msg []byte) error {
 	if err != nil {
 		return err
 	}
-	_, err = client.connection.Read(msg)
+	_, err = io.ReadFull(client.connection, msg)
 	return err
 }
 
@@ -232,7 +338,7 @@ func (client *PCSCLiteClient) EstablishContext(scope ...uint32) (uint32, error)
 	}
 	if estruct.rv != SCARD_S_SUCCESS {
 		return 0, fmt.Errorf(
-			"can't establish context: %s", errorString(estruct.rv),
+			"can't establish context: %w", Error(estruct.rv),
 		)
 	}
 	return estruct.context, nil
@@ -246,29 +352,38 @@ func (client *PCSCLiteClient) ReleaseContext(context uint32) error {
 		return err
 	}
 	if rstruct.rv != SCARD_S_SUCCESS {
-		return fmt.Errorf("can't release context: %s", errorString(rstruct.rv))
+		return fmt.Errorf("can't release context: %w", Error(rstruct.rv))
 	}
 	return nil
 }
 
 func (client *PCSCLiteClient) SyncReaders() (
 	uint32, error) {
-	var count uint32
-	ptr := (*[unsafe.Sizeof(client.readers)]byte)(unsafe.Pointer(&client.readers))
 	err := client.SendHeader(_CMD_GET_READERS_STATE, 0)
 	if err != nil {
-		return count, err
+		return 0, err
 	}
-	_, err = client.Read(ptr[:])
+	return client.readReaders()
+}
+
+// readReaders receives the states of all reader slots that the daemon sends after some commands.
+func (client *PCSCLiteClient) readReaders() (uint32, error) {
+	var count uint32
+	ptr := (*[unsafe.Sizeof(client.readers)]byte)(unsafe.Pointer(&client.readers))
+	_, err := io.ReadFull(client.connection, ptr[:])
 	if err != nil {
 		return count, err
 	}
-	for count = 0; count < _PCSCLITE_MAX_READERS_CONTEXTS; count++ {
-		ri := client.readers[count]
-		if ri.ReaderName[0] == 0 {
-			break
+	// slots of detached readers stay empty until another reader is attached
+	for i := range client.readers {
+		if client.readers[i].ReaderName[0] != 0 {
+			client.readers[count] = client.readers[i]
+			count++
 		}
 	}
+	for i := count; i < _PCSCLITE_MAX_READERS_CONTEXTS; i++ {
+		client.readers[i] = Reader{}
+	}
 	client.readerCount = count
 	return count, nil
 }
@@ -301,8 +416,8 @@ func (client *PCSCLiteClient) CardConnect(context uint32, readerName string) (
 		return 0, 0, err
 	}
 	if cstruct.rv != SCARD_S_SUCCESS {
-		return 0, 0, fmt.Errorf("cant connect to card: %s",
-			errorString(cstruct.rv))
+		return 0, 0, fmt.Errorf("cant connect to card: %w",
+			Error(cstruct.rv))
 	}
 	return cstruct.card, cstruct.activeProtocol, nil
 }
@@ -318,8 +433,8 @@ func (client *PCSCLiteClient) CardDisconnect(card int32) error {
 		return err
 	}
 	if dstruct.rv != SCARD_S_SUCCESS {
-		return fmt.Errorf("cant disconnect from card: %s",
-			errorString(dstruct.rv))
+		return fmt.Errorf("cant disconnect from card: %w",
+			Error(dstruct.rv))
 	}
 	return nil
 }
@@ -348,39 +463,221 @@ func (client *PCSCLiteClient) Transmit(card int32, protocol uint32,
 	if err != nil {
 		return 0, err
 	}
-	_, err = client.connection.Read(tsBytes)
+	_, err = io.ReadFull(client.connection, tsBytes)
 	if err != nil {
 		return 0, err
 	}
 	if tstruct.rv != SCARD_S_SUCCESS {
-		return 0, fmt.Errorf("transmission failed: %s", errorString(tstruct.rv))
+		return 0, fmt.Errorf("transmission failed: %w", Error(tstruct.rv))
+	}
+	if tstruct.recvLength > uint32(len(recvBuffer)) {
+		// the response is skipped to keep the connection in sync for the next command
+		if _, err := io.CopyN(io.Discard, client.connection, int64(tstruct.recvLength)); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("transmission failed: response of %d bytes exceeds buffer", tstruct.recvLength)
 	}
-	_, err = client.connection.Read(recvBuffer)
+	// large responses arrive in more than one read
+	_, err = io.ReadFull(client.connection, recvBuffer[:tstruct.recvLength])
 	if err != nil {
 		return 0, err
 	}
 	return tstruct.recvLength, nil
 }
 
-/*
+// CardReconnect re-establishes the connection to a card, e.g. after another application reset it. initialization
+// is the action taken on the card: SCARD_LEAVE_CARD, SCARD_RESET_CARD or SCARD_UNPOWER_CARD.
+func (client *PCSCLiteClient) CardReconnect(card int32, initialization uint32) (uint32, error) {
+	rstruct := reconnectStruct{
+		card:               card,
+		shareMode:          SCARD_SHARE_SHARED,
+		preferredProtocols: SCARD_PROTOCOL_ANY,
+		initialization:     initialization,
+	}
+	ptr := (*[unsafe.Sizeof(rstruct)]byte)(unsafe.Pointer(&rstruct))
+	err := client.ExchangeMessage(_SCARD_RECONNECT, ptr[:])
+	if err != nil {
+		return 0, err
+	}
+	if rstruct.rv != SCARD_S_SUCCESS {
+		return 0, fmt.Errorf("can't reconnect to card: %w", Error(rstruct.rv))
+	}
+	return rstruct.activeProtocol, nil
+}
+
+// BeginTransaction gains exclusive access to a card. It blocks while another client holds a transaction.
+func (client *PCSCLiteClient) BeginTransaction(card int32) error {
+	for {
+		bstruct := beginStruct{card: card}
+		ptr := (*[unsafe.Sizeof(bstruct)]byte)(unsafe.Pointer(&bstruct))
+		err := client.ExchangeMessage(_SCARD_BEGIN_TRANSACTION, ptr[:])
+		if err != nil {
+			return err
+		}
+		switch bstruct.rv {
+		case SCARD_S_SUCCESS:
+			return nil
+		case SCARD_E_SHARING_VIOLATION:
+			time.Sleep(_PCSCLITE_LOCK_POLL_RATE)
+		default:
+			return fmt.Errorf("can't begin transaction: %w", Error(bstruct.rv))
+		}
+	}
+}
+
+// EndTransaction releases the exclusive access to a card and takes the action disposition on it.
+func (client *PCSCLiteClient) EndTransaction(card int32, disposition uint32) error {
+	estruct := endStruct{card: card, disposition: disposition}
+	ptr := (*[unsafe.Sizeof(estruct)]byte)(unsafe.Pointer(&estruct))
+	err := client.ExchangeMessage(_SCARD_END_TRANSACTION, ptr[:])
+	if err != nil {
+		return err
+	}
+	if estruct.rv != SCARD_S_SUCCESS {
+		return fmt.Errorf("can't end transaction: %w", Error(estruct.rv))
+	}
+	return nil
+}
+
+// Status checks that the connection to a card is still valid. The reader state, protocol and ATR of the card are
+// published by the daemon in the reader states, see SyncReaders.
+func (client *PCSCLiteClient) Status(card int32) error {
+	sstruct := statusStruct{card: card}
+	ptr := (*[unsafe.Sizeof(sstruct)]byte)(unsafe.Pointer(&sstruct))
+	err := client.ExchangeMessage(_SCARD_STATUS, ptr[:])
+	if err != nil {
+		return err
+	}
+	if sstruct.rv != SCARD_S_SUCCESS {
+		return fmt.Errorf("can't get card status: %w", Error(sstruct.rv))
+	}
+	return nil
+}
+
+// CtlCode returns the control code of a reader specific function, see Control.
+func CtlCode(code uint32) uint32 {
+	return 0x42000000 + code
+}
+
+// Control sends a command directly to the reader driver and returns the number of bytes written to recvBuffer.
+func (client *PCSCLiteClient) Control(card int32, controlCode uint32,
+	sendBuffer []byte, recvBuffer []byte) (uint32, error) {
+	cstruct := controlStruct{
+		card:        card,
+		controlCode: controlCode,
+		sendLength:  uint32(len(sendBuffer)),
+		recvLength:  uint32(len(recvBuffer)),
+	}
+	csBytes := (*[unsafe.Sizeof(cstruct)]byte)(unsafe.Pointer(&cstruct))[:]
+	err := client.SendHeader(_SCARD_CONTROL, uint32(len(csBytes)))
+	if err != nil {
+		return 0, err
+	}
+	_, err = client.connection.Write(csBytes)
+	if err != nil {
+		return 0, err
+	}
+	_, err = client.connection.Write(sendBuffer)
+	if err != nil {
+		return 0, err
+	}
+	_, err = io.ReadFull(client.connection, csBytes)
+	if err != nil {
+		return 0, err
+	}
+	if cstruct.rv != SCARD_S_SUCCESS {
+		return 0, fmt.Errorf("control failed: %w", Error(cstruct.rv))
+	}
+	if cstruct.bytesReturned > uint32(len(recvBuffer)) {
+		if _, err := io.CopyN(io.Discard, client.connection, int64(cstruct.bytesReturned)); err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("control failed: response of %d bytes exceeds buffer", cstruct.bytesReturned)
+	}
+	_, err = io.ReadFull(client.connection, recvBuffer[:cstruct.bytesReturned])
+	if err != nil {
+		return 0, err
+	}
+	return cstruct.bytesReturned, nil
+}
+
+// GetAttrib returns the value of a reader attribute, e.g. SCARD_ATTR_ATR_STRING.
+func (client *PCSCLiteClient) GetAttrib(card int32, attr uint32) ([]byte, error) {
+	gstruct := getSetStruct{card: card, attrID: attr, attrLen: _MAX_BUFFER_SIZE}
+	ptr := (*[unsafe.Sizeof(gstruct)]byte)(unsafe.Pointer(&gstruct))
+	err := client.ExchangeMessage(_SCARD_GET_ATTRIB, ptr[:])
+	if err != nil {
+		return nil, err
+	}
+	if gstruct.rv != SCARD_S_SUCCESS {
+		return nil, fmt.Errorf("can't get attribute: %w", Error(gstruct.rv))
+	}
+	if gstruct.attrLen > _MAX_BUFFER_SIZE {
+		return nil, fmt.Errorf("can't get attribute: %w", Error(SCARD_E_INSUFFICIENT_BUFFER))
+	}
+	return append([]byte(nil), gstruct.attr[:gstruct.attrLen]...), nil
+}
+
+// Cancel terminates a blocking wait for reader state changes of context. The request is sent on a connection of its
+// own, since the connection of the waiting client is busy.
+func (client *PCSCLiteClient) Cancel(context uint32) error {
+	canceller, err := client.Clone()
+	if err != nil {
+		return err
+	}
+	defer canceller.Close()
+	cstruct := cancelStruct{context: context}
+	ptr := (*[unsafe.Sizeof(cstruct)]byte)(unsafe.Pointer(&cstruct))
+	err = canceller.ExchangeMessage(_SCARD_CANCEL, ptr[:])
+	if err != nil {
+		return err
+	}
+	if cstruct.rv != SCARD_S_SUCCESS {
+		return fmt.Errorf("can't cancel: %w", Error(cstruct.rv))
+	}
+	return nil
+}
+
+// RegisterReaderStateChange subscribes the client to the next reader event and syncs the reader states, see
+// SyncReaders. The event is received with WaitReaderStateChange, after which the client has to register again.
+func (client *PCSCLiteClient) RegisterReaderStateChange() (uint32, error) {
+	err := client.SendHeader(_CMD_WAIT_READER_STATE_CHANGE, 0)
+	if err != nil {
+		return 0, err
+	}
+	return client.readReaders()
+}
+
+// WaitReaderStateChange blocks until the daemon signals a reader event to a client registered with
+// RegisterReaderStateChange. A wait terminated by Cancel returns SCARD_E_CANCELLED.
 func (client *PCSCLiteClient) WaitReaderStateChange() error {
-    wrstruct := waitReaderStateChangeStruct{ timeOutMs: uint32(60000) }
-    ptr := (*[unsafe.Sizeof(wrstruct)]byte)(unsafe.Pointer(&wrstruct))
-    err := client.ExchangeMessage(_CMD_WAIT_READER_STATE_CHANGE, ptr[:])
-    if err != nil {
-        return err
-    }
-    if wrstruct.rv == SCARD_E_TIMEOUT {
-        err = client.ExchangeMessage(
-            _CMD_STOP_WAITING_READER_STATE_CHANGE, ptr[:],
-        )
-        if err != nil {
-            return err
-        }
-    }
-    if wrstruct.rv != SCARD_S_SUCCESS {
-        return fmt.Errorf("wait failed: %s", errorString(wrstruct.rv))
-    }
-    return nil
-}
-*/
+	var wrstruct waitReaderStateChangeStruct
+	ptr := (*[unsafe.Sizeof(wrstruct)]byte)(unsafe.Pointer(&wrstruct))
+	_, err := io.ReadFull(client.connection, ptr[:])
+	if err != nil {
+		return err
+	}
+	if wrstruct.rv != SCARD_S_SUCCESS {
+		return fmt.Errorf("wait failed: %w", Error(wrstruct.rv))
+	}
+	return nil
+}
+
+// StopWaitingReaderStateChange unsubscribes a client registered with RegisterReaderStateChange.
+func (client *PCSCLiteClient) StopWaitingReaderStateChange() error {
+	var wrstruct waitReaderStateChangeStruct
+	ptr := (*[unsafe.Sizeof(wrstruct)]byte)(unsafe.Pointer(&wrstruct))
+	err := client.SendHeader(_CMD_STOP_WAITING_READER_STATE_CHANGE, 0)
+	if err != nil {
+		return err
+	}
+	_, err = io.ReadFull(client.connection, ptr[:])
+	if err != nil {
+		return err
+	}
+	// the event may have been signalled right before
+	if wrstruct.rv != SCARD_S_SUCCESS && wrstruct.rv != SCARD_E_NO_READERS_AVAILABLE {
+		return fmt.Errorf("stop waiting failed: %w", Error(wrstruct.rv))
+	}
+	return nil
+}
@@ -3,107 +3,76 @@
 package pcsc
 
 import (
-	"fmt"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"path/filepath"
 	"testing"
+	"unsafe"
 )
 
-var CMD_SELECT = []byte{
-	0x00, 0xA4, 0x04, 0x00, 0x08,
-	0x90, 0x72, 0x5A, 0x9E, 0x3B, 0x10, 0x70, 0xAA,
-}
-
-var CMD_10 = []byte{
-	0x00, 0x10, 0x00, 0x00, 0x0B,
-}
-
-func printHex(buffer []byte) {
-	for _, b := range buffer {
-		fmt.Printf("%02x", b)
+// TestMessageSizes checks the message layouts against the structs of pcsc-lite's winscard_msg.h.
+func TestMessageSizes(t *testing.T) {
+	for name, size := range map[string][2]uintptr{
+		"reconnect_struct": {unsafe.Sizeof(reconnectStruct{}), 24},
+		"begin_struct":     {unsafe.Sizeof(beginStruct{}), 8},
+		"end_struct":       {unsafe.Sizeof(endStruct{}), 12},
+		"cancel_struct":    {unsafe.Sizeof(cancelStruct{}), 8},
+		"status_struct":    {unsafe.Sizeof(statusStruct{}), 8},
+		"control_struct":   {unsafe.Sizeof(controlStruct{}), 24},
+		"getset_struct":    {unsafe.Sizeof(getSetStruct{}), 280},
+	} {
+		if size[0] != size[1] {
+			t.Errorf("%s: expected %d bytes, got %d", name, size[1], size[0])
+		}
 	}
-	fmt.Println("")
 }
 
-func TestClient(t *testing.T) {
-	fmt.Println("\n=================")
-	fmt.Println("PCSCD Client Test")
-	fmt.Printf("=================\n\n")
-	fmt.Println("Connect to daemon")
-	fmt.Printf("-----------------\n\n")
-	client, err := PCSCLiteConnect()
+// serveVersion accepts one connection on a unix socket and answers its version exchange with rv.
+func serveVersion(t *testing.T, rv uint32) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pcscd.comm")
+	listener, err := net.Listen("unix", path)
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
 	}
-	defer client.Close()
-	fmt.Println("OK")
-
-	fmt.Println("\nEstablish Context")
-	fmt.Printf("-----------------\n\n")
-	context, err := client.EstablishContext()
-	if err != nil {
-		t.Error(err)
-		return
-	}
-	defer client.ReleaseContext(context)
-	fmt.Println("OK")
-
-	fmt.Println("\nList Readers")
-	fmt.Printf("------------\n\n")
-	var selectedReader *Reader = nil
-	readers, err := client.ListReaders()
-	if err != nil {
-		t.Error(err)
-		return
-	}
-	for _, reader := range readers {
-		fmt.Println(reader)
-		if reader.IsCardPresent() && (selectedReader == nil) {
-			selectedReader = reader
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
 		}
-	}
-
-	if selectedReader == nil {
-		fmt.Println("No reader with card found")
-		return
-	}
-
-	fmt.Println("Connect to card")
-	fmt.Printf("---------------\n\n")
-	card, protocol, err := client.CardConnect(context, selectedReader.Name())
-	if err != nil {
-		t.Error(err)
-		return
-	}
-	fmt.Println("OK")
+		defer conn.Close()
+		msg := make([]byte, 8+12)
+		if _, err := io.ReadFull(conn, msg); err != nil {
+			return
+		}
+		if binary.NativeEndian.Uint32(msg[4:]) != _CMD_VERSION {
+			return
+		}
+		binary.NativeEndian.PutUint32(msg[8:], 4)
+		binary.NativeEndian.PutUint32(msg[12:], 5)
+		binary.NativeEndian.PutUint32(msg[16:], rv)
+		conn.Write(msg[8:])
+		io.Copy(io.Discard, conn)
+	}()
+	return path
+}
 
-	fmt.Println("\nSelect applet")
-	fmt.Printf("-------------\n\n")
-	buffer := make([]byte, 258)
-	printHex(CMD_SELECT)
-	received, err := client.Transmit(card, protocol, CMD_SELECT, buffer)
+func TestPCSCLiteConnect(t *testing.T) {
+	t.Setenv("PCSCLITE_CSOCK_NAME", serveVersion(t, SCARD_S_SUCCESS))
+	client, err := PCSCLiteConnect()
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
 	}
-	printHex(buffer[:received])
+	client.Close()
 
-	fmt.Println("\nSend CMD 10")
-	fmt.Printf("-----------\n\n")
-	printHex(CMD_10)
-	received, err = client.Transmit(card, protocol, CMD_10, buffer)
-	if err != nil {
-		t.Error(err)
-		return
+	if _, err := PCSCLiteDial(serveVersion(t, SCARD_E_NO_SERVICE)); !errors.Is(err, ErrProtocolMismatch) ||
+		!errors.Is(err, Error(SCARD_E_NO_SERVICE)) {
+		t.Fatalf("Expected ErrProtocolMismatch, got %v", err)
 	}
-	printHex(buffer[:received])
-	fmt.Printf("Quoth the Applet, \"%s\"\n", string(buffer[:received-2]))
-
-	fmt.Println("\nDisconnect from card")
-	fmt.Printf("--------------------\n\n")
-	err = client.CardDisconnect(card)
-	if err != nil {
-		t.Error(err)
-		return
+	if _, err := PCSCLiteDial(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("Expected an error for a missing socket")
 	}
-	fmt.Println("OK")
 }
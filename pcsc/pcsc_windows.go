@@ -36,6 +36,12 @@ type WinscardWrapper struct {
 	transmit         *syscall.LazyProc
 	getStatusChange  *syscall.LazyProc
 	getAttrib        *syscall.LazyProc
+	cardReconnect    *syscall.LazyProc
+	beginTransaction *syscall.LazyProc
+	endTransaction   *syscall.LazyProc
+	cardStatus       *syscall.LazyProc
+	control          *syscall.LazyProc
+	cancel           *syscall.LazyProc
 	t0PCI            uintptr
 	t1PCI            uintptr
 }
@@ -61,6 +67,12 @@ func Winscard() (*WinscardWrapper, error) {
 	winscard.transmit = dll.NewProc("SCardTransmit")
 	winscard.getStatusChange = dll.NewProc("SCardGetStatusChangeA")
 	winscard.getAttrib = dll.NewProc("SCardGetAttrib")
+	winscard.cardReconnect = dll.NewProc("SCardReconnect")
+	winscard.beginTransaction = dll.NewProc("SCardBeginTransaction")
+	winscard.endTransaction = dll.NewProc("SCardEndTransaction")
+	winscard.cardStatus = dll.NewProc("SCardStatusA")
+	winscard.control = dll.NewProc("SCardControl")
+	winscard.cancel = dll.NewProc("SCardCancel")
 	t0 := dll.NewProc("g_rgSCardT0Pci")
 	t1 := dll.NewProc("g_rgSCardT1Pci")
 	if t0.Find() != nil || t1.Find() != nil {
@@ -95,8 +107,8 @@ func (ww *WinscardWrapper) EstablishContext(scope ...uint32) (uintptr, error) {
 	rv, _, _ := ww.establishContext.Call(uintptr(scp), uintptr(0),
 		uintptr(0), uintptr(unsafe.Pointer(&ctx)))
 	if rv != SCARD_S_SUCCESS {
-		return 0, fmt.Errorf("can't establish context: %s",
-			errorString(uint32(rv)))
+		return 0, fmt.Errorf("can't establish context: %w",
+			Error(uint32(rv)))
 	}
 	return ctx, nil
 }
@@ -104,8 +116,8 @@ func (ww *WinscardWrapper) EstablishContext(scope ...uint32) (uintptr, error) {
 func (ww *WinscardWrapper) ReleaseContext(ctx uintptr) error {
 	rv, _, _ := ww.releaseContext.Call(uintptr(ctx))
 	if rv != SCARD_S_SUCCESS {
-		return fmt.Errorf("can't release context: %s",
-			errorString(uint32(rv)))
+		return fmt.Errorf("can't release context: %w",
+			Error(uint32(rv)))
 	}
 	return nil
 }
@@ -119,16 +131,16 @@ func (ww *WinscardWrapper) ListReaders(ctx uintptr) ([]string, error) {
 		if rv == SCARD_E_NO_READERS_AVAILABLE {
 			return readers, nil
 		}
-		return nil, fmt.Errorf("can't list readers: %s",
-			errorString(uint32(rv)))
+		return nil, fmt.Errorf("can't list readers: %w",
+			Error(uint32(rv)))
 	}
 	buffer := make([]byte, bufferSize)
 	rv, _, _ = ww.listReaders.Call(ctx, 0,
 		uintptr(unsafe.Pointer(&buffer[0])),
 		uintptr(unsafe.Pointer(&bufferSize)))
 	if rv != SCARD_S_SUCCESS {
-		return nil, fmt.Errorf("can't list readers: %s",
-			errorString(uint32(rv)))
+		return nil, fmt.Errorf("can't list readers: %w",
+			Error(uint32(rv)))
 	}
 	n := bytes.IndexByte(buffer, 0)
 	for n != 0 {
@@ -152,8 +164,8 @@ func (ww *WinscardWrapper) GetStatusChange(ctx uintptr, timeout uint32,
 	rv, _, _ := ww.getStatusChange.Call(ctx, uintptr(timeout),
 		uintptr(unsafe.Pointer(&_states[0])), uintptr(len(_states)))
 	if rv != SCARD_S_SUCCESS {
-		return fmt.Errorf("get status change failed: %s",
-			errorString(uint32(rv)))
+		return fmt.Errorf("get status change failed: %w",
+			Error(uint32(rv)))
 	}
 	for i := 0; i < len(states); i++ {
 		states[i].UserData = _states[i].userData
@@ -197,8 +209,8 @@ func (ww *WinscardWrapper) CardConnect(ctx uintptr, reader string) (
 		uintptr(unsafe.Pointer(&activeProtocol)),
 	)
 	if rv != SCARD_S_SUCCESS {
-		return 0, 0, fmt.Errorf("can't connect to card: %s",
-			errorString(uint32(rv)))
+		return 0, 0, fmt.Errorf("can't connect to card: %w",
+			Error(uint32(rv)))
 	}
 	return card, activeProtocol, nil
 }
@@ -206,8 +218,8 @@ func (ww *WinscardWrapper) CardConnect(ctx uintptr, reader string) (
 func (ww *WinscardWrapper) CardDisconnect(card uintptr) error {
 	rv, _, _ := ww.cardDisconnect.Call(card, uintptr(SCARD_RESET_CARD))
 	if rv != SCARD_S_SUCCESS {
-		return fmt.Errorf("can't disconnect from card: %s",
-			errorString(uint32(rv)))
+		return fmt.Errorf("can't disconnect from card: %w",
+			Error(uint32(rv)))
 	}
 	return nil
 }
@@ -223,8 +235,8 @@ func (ww *WinscardWrapper) Transmit(card uintptr, sendPCI uintptr,
 		uintptr(unsafe.Pointer(&recvBuffer[0])),
 		uintptr(unsafe.Pointer(&received)))
 	if rv != SCARD_S_SUCCESS {
-		return 0, fmt.Errorf("transmission failed: %s",
-			errorString(uint32(rv)))
+		return 0, fmt.Errorf("transmission failed: %w",
+			Error(uint32(rv)))
 	}
 	return received, nil
 }
@@ -236,8 +248,8 @@ func (ww WinscardWrapper) GetAttrib(card uintptr, attr uint32) ([]byte, error) {
 		uintptr(unsafe.Pointer(&size)),
 	)
 	if rv != SCARD_S_SUCCESS {
-		return nil, fmt.Errorf("can't get attribute : %s",
-			errorString(uint32(rv)))
+		return nil, fmt.Errorf("can't get attribute : %w",
+			Error(uint32(rv)))
 	}
 	buffer := make([]byte, size)
 	rv, _, _ = ww.getAttrib.Call(
@@ -246,8 +258,110 @@ func (ww WinscardWrapper) GetAttrib(card uintptr, attr uint32) ([]byte, error) {
 		uintptr(unsafe.Pointer(&size)),
 	)
 	if rv != SCARD_S_SUCCESS {
-		return nil, fmt.Errorf("can't get attribute : %s",
-			errorString(uint32(rv)))
+		return nil, fmt.Errorf("can't get attribute : %w",
+			Error(uint32(rv)))
 	}
 	return buffer[:size], nil
 }
+
+func (ww *WinscardWrapper) CardReconnect(card uintptr, initialization uint32) (uintptr, error) {
+	var activeProtocol uintptr
+	rv, _, _ := ww.cardReconnect.Call(card,
+		uintptr(SCARD_SHARE_SHARED), uintptr(SCARD_PROTOCOL_ANY),
+		uintptr(initialization),
+		uintptr(unsafe.Pointer(&activeProtocol)))
+	if rv != SCARD_S_SUCCESS {
+		return 0, fmt.Errorf("can't reconnect to card: %w",
+			Error(uint32(rv)))
+	}
+	return activeProtocol, nil
+}
+
+func (ww *WinscardWrapper) BeginTransaction(card uintptr) error {
+	rv, _, _ := ww.beginTransaction.Call(card)
+	if rv != SCARD_S_SUCCESS {
+		return fmt.Errorf("can't begin transaction: %w",
+			Error(uint32(rv)))
+	}
+	return nil
+}
+
+func (ww *WinscardWrapper) EndTransaction(card uintptr, disposition uint32) error {
+	rv, _, _ := ww.endTransaction.Call(card, uintptr(disposition))
+	if rv != SCARD_S_SUCCESS {
+		return fmt.Errorf("can't end transaction: %w",
+			Error(uint32(rv)))
+	}
+	return nil
+}
+
+// cardStates maps the states returned by SCardStatus, which winscard enumerates from SCARD_UNKNOWN (0) to
+// SCARD_SPECIFIC (6), to the pcsc-lite bit masks.
+var cardStates = map[uint32]uint32{
+	0: SCARD_UNKNOWN,
+	1: SCARD_ABSENT,
+	2: SCARD_PRESENT,
+	3: SCARD_PRESENT | SCARD_SWALLOWED,
+	4: SCARD_PRESENT | SCARD_SWALLOWED | SCARD_POWERED,
+	5: SCARD_PRESENT | SCARD_SWALLOWED | SCARD_POWERED | SCARD_NEGOTIABLE,
+	6: SCARD_PRESENT | SCARD_SWALLOWED | SCARD_POWERED | SCARD_SPECIFIC,
+}
+
+// Status returns the reader name, the state (SCARD_PRESENT, SCARD_POWERED, ...), the protocol and the ATR of a card.
+func (ww *WinscardWrapper) Status(card uintptr) (string, uint32, uint32, []byte, error) {
+	var state, protocol uint32
+	reader := make([]byte, 256)
+	readerLen := uint32(len(reader))
+	atr := make([]byte, _MAX_ATR_SIZE)
+	atrLen := uint32(len(atr))
+	rv, _, _ := ww.cardStatus.Call(card,
+		uintptr(unsafe.Pointer(&reader[0])),
+		uintptr(unsafe.Pointer(&readerLen)),
+		uintptr(unsafe.Pointer(&state)),
+		uintptr(unsafe.Pointer(&protocol)),
+		uintptr(unsafe.Pointer(&atr[0])),
+		uintptr(unsafe.Pointer(&atrLen)))
+	if rv != SCARD_S_SUCCESS {
+		return "", 0, 0, nil, fmt.Errorf("can't get card status: %w",
+			Error(uint32(rv)))
+	}
+	if n := bytes.IndexByte(reader, 0); n >= 0 {
+		reader = reader[:n]
+	}
+	return string(reader), cardStates[state], protocol, atr[:atrLen], nil
+}
+
+// CtlCode returns the control code of a reader specific function, see Control.
+func CtlCode(code uint32) uint32 {
+	return 0x00310000 | code<<2
+}
+
+func (ww *WinscardWrapper) Control(card uintptr, controlCode uint32,
+	sendBuffer []byte, recvBuffer []byte) (uint32, error) {
+	var send, recv uintptr
+	if len(sendBuffer) > 0 {
+		send = uintptr(unsafe.Pointer(&sendBuffer[0]))
+	}
+	if len(recvBuffer) > 0 {
+		recv = uintptr(unsafe.Pointer(&recvBuffer[0]))
+	}
+	var received uint32
+	rv, _, _ := ww.control.Call(card, uintptr(controlCode),
+		send, uintptr(len(sendBuffer)),
+		recv, uintptr(len(recvBuffer)),
+		uintptr(unsafe.Pointer(&received)))
+	if rv != SCARD_S_SUCCESS {
+		return 0, fmt.Errorf("control failed: %w",
+			Error(uint32(rv)))
+	}
+	return received, nil
+}
+
+func (ww *WinscardWrapper) Cancel(ctx uintptr) error {
+	rv, _, _ := ww.cancel.Call(ctx)
+	if rv != SCARD_S_SUCCESS {
+		return fmt.Errorf("can't cancel: %w",
+			Error(uint32(rv)))
+	}
+	return nil
+}
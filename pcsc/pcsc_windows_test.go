@@ -0,0 +1,25 @@
+package pcsc
+
+import "testing"
+
+// TestCardStates checks the mapping against the card states of winscard.h, where SCARD_UNKNOWN is 0 and every
+// following state implies the ones before it, starting with SCARD_PRESENT.
+func TestCardStates(t *testing.T) {
+	for state, flag := range []uint32{
+		SCARD_UNKNOWN, SCARD_ABSENT, SCARD_PRESENT, SCARD_SWALLOWED, SCARD_POWERED, SCARD_NEGOTIABLE, SCARD_SPECIFIC,
+	} {
+		mask, ok := cardStates[uint32(state)]
+		if !ok {
+			t.Fatalf("State %d is not mapped", state)
+		}
+		if mask&flag == 0 {
+			t.Errorf("State %d: expected %#x to be set in %#x", state, flag, mask)
+		}
+		if state >= 2 && mask&SCARD_PRESENT == 0 || state < 2 && mask&SCARD_PRESENT != 0 {
+			t.Errorf("State %d: unexpected presence in %#x", state, mask)
+		}
+	}
+	if len(cardStates) != 7 {
+		t.Errorf("Expected 7 states, got %d", len(cardStates))
+	}
+}
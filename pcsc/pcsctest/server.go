@@ -0,0 +1,730 @@
+//go:build !windows
+
+// Package pcsctest provides a fake pcscd for tests. It speaks the pcsc-lite wire protocol on a unix socket and
+// emulates readers and cards in memory, so that pcsc.PCSCLiteClient and scard.Context can be tested end to end
+// without hardware. Point the client at the server with PCSCLITE_CSOCK_NAME or pcsc.PCSCLiteDial.
+package pcsctest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"unsafe"
+
+	"github.com/malivvan/aegis/pcsc"
+)
+
+const (
+	// Protocol version served by default, see pcsc.PCSCLiteDial and Server.SetVersion
+	protocolMajor = 4
+	protocolMinor = 4
+	// Commands
+	cmdEstablishContext = 0x01
+	cmdReleaseContext   = 0x02
+	cmdConnect          = 0x04
+	cmdReconnect        = 0x05
+	cmdDisconnect       = 0x06
+	cmdBeginTransaction = 0x07
+	cmdEndTransaction   = 0x08
+	cmdTransmit         = 0x09
+	cmdControl          = 0x0A
+	cmdStatus           = 0x0B
+	cmdCancel           = 0x0D
+	cmdGetAttrib        = 0x0F
+	cmdVersion          = 0x11
+	cmdGetReadersState  = 0x12
+	cmdWaitReaderState  = 0x13
+	cmdStopWaiting      = 0x14
+	// Limits
+	maxReaders    = 16
+	maxReaderName = 128
+	maxAttribSize = 264
+	maxBufferSize = 1 << 17
+)
+
+// Message layouts of pcsc-lite's winscard_msg.h, as used by pcsc.PCSCLiteClient.
+type (
+	rxHeader struct {
+		size    uint32
+		command uint32
+	}
+	versionStruct struct {
+		major int32
+		minor int32
+		rv    uint32
+	}
+	establishStruct struct {
+		scope   uint32
+		context uint32
+		rv      uint32
+	}
+	releaseStruct struct {
+		context uint32
+		rv      uint32
+	}
+	connectStruct struct {
+		context            uint32
+		readerName         [maxReaderName]byte
+		shareMode          uint32
+		preferredProtocols uint32
+		card               int32
+		activeProtocol     uint32
+		rv                 uint32
+	}
+	reconnectStruct struct {
+		card               int32
+		shareMode          uint32
+		preferredProtocols uint32
+		initialization     uint32
+		activeProtocol     uint32
+		rv                 uint32
+	}
+	disconnectStruct struct {
+		card        int32
+		disposition uint32
+		rv          uint32
+	}
+	beginStruct struct {
+		card int32
+		rv   uint32
+	}
+	endStruct struct {
+		card        int32
+		disposition uint32
+		rv          uint32
+	}
+	cancelStruct struct {
+		context uint32
+		rv      uint32
+	}
+	statusStruct struct {
+		card int32
+		rv   uint32
+	}
+	transmitStruct struct {
+		card            int32
+		sendPciProtocol uint32
+		sendPciLength   uint32
+		sendLength      uint32
+		recvPciProtocol uint32
+		recvPciLength   uint32
+		recvLength      uint32
+		rv              uint32
+	}
+	controlStruct struct {
+		card          int32
+		controlCode   uint32
+		sendLength    uint32
+		recvLength    uint32
+		bytesReturned uint32
+		rv            uint32
+	}
+	getSetStruct struct {
+		card    int32
+		attrID  uint32
+		attr    [maxAttribSize]byte
+		attrLen uint32
+		rv      uint32
+	}
+	waitStruct struct {
+		timeOutMs uint32
+		rv        uint32
+	}
+)
+
+// raw returns the memory of a message struct, which is sent as is like pcsc-lite does.
+func raw[T any](msg *T) []byte {
+	return unsafe.Slice((*byte)(unsafe.Pointer(msg)), unsafe.Sizeof(*msg))
+}
+
+// Card is a card inserted into an emulated reader.
+type Card struct {
+	ATR []byte
+	// Transmit answers the command APDU cmd by writing the response APDU to resp, like scard.Transport.
+	Transmit func(cmd, resp []byte) (int, error)
+	// Reset is called, if set, when a client resets the card.
+	Reset func()
+}
+
+// Exchange is a command APDU and the response APDU that a scripted card answers it with.
+type Exchange struct {
+	Command  []byte
+	Response []byte
+}
+
+// ScriptedCard returns a card that expects the commands of script in order. Commands that deviate from the script
+// are answered with 6F00.
+func ScriptedCard(atr []byte, script ...Exchange) *Card {
+	var mu sync.Mutex
+	return &Card{ATR: atr, Transmit: func(cmd, resp []byte) (int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if len(script) == 0 || !bytes.Equal(cmd, script[0].Command) {
+			return copy(resp, []byte{0x6f, 0x00}), nil
+		}
+		next := script[0]
+		script = script[1:]
+		if len(next.Response) > len(resp) {
+			return 0, io.ErrShortBuffer
+		}
+		return copy(resp, next.Response), nil
+	}}
+}
+
+// Server is a fake pcscd listening on a unix socket in a temporary directory.
+type Server struct {
+	// Socket is the path of the unix socket.
+	Socket string
+
+	t        testing.TB
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	readers  [maxReaders]*reader
+	sessions map[*session]bool
+	contexts map[uint32]*session
+	handles  map[int32]*handle
+	waiting  map[*session]bool // sessions registered for the next reader event
+	last     uint32            // last context or card handle issued
+	major    int32             // protocol version
+	minor    int32
+}
+
+type reader struct {
+	name    string
+	card    *Card
+	counter uint32  // number of card insertions and removals
+	owner   *handle // handle holding a transaction
+}
+
+type handle struct {
+	session *session
+	reader  *reader
+	rv      uint32 // SCARD_W_REMOVED_CARD or SCARD_W_RESET_CARD once the card was removed or reset
+}
+
+type session struct {
+	conn net.Conn
+	mu   sync.Mutex // serializes responses and reader event signals
+}
+
+func (sess *session) read(b []byte) error {
+	_, err := io.ReadFull(sess.conn, b)
+	return err
+}
+
+func (sess *session) write(b ...[]byte) error {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	for _, p := range b {
+		if _, err := sess.conn.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewServer starts a server without readers. It is closed when the test finishes.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "pcscd.comm")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("pcsctest: %v", err)
+	}
+	s := &Server{
+		Socket:   socket,
+		t:        t,
+		listener: listener,
+		sessions: make(map[*session]bool),
+		contexts: make(map[uint32]*session),
+		handles:  make(map[int32]*handle),
+		waiting:  make(map[*session]bool),
+		major:    protocolMajor,
+		minor:    protocolMinor,
+	}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Close stops the server and closes the connections of all clients.
+func (s *Server) Close() {
+	s.listener.Close()
+	s.mu.Lock()
+	for sess := range s.sessions {
+		sess.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// AttachReader plugs in an empty reader.
+func (s *Server) AttachReader(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.reader(name) != nil {
+		s.t.Errorf("pcsctest: reader %q already attached", name)
+		return
+	}
+	for i, r := range s.readers {
+		if r == nil {
+			s.readers[i] = &reader{name: name}
+			s.signal(pcsc.SCARD_S_SUCCESS)
+			return
+		}
+	}
+	s.t.Errorf("pcsctest: no free reader slot for %q", name)
+}
+
+// DetachReader unplugs a reader, removing its card.
+func (s *Server) DetachReader(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, r := range s.readers {
+		if r != nil && r.name == name {
+			s.invalidate(r, nil, pcsc.SCARD_W_REMOVED_CARD)
+			s.readers[i] = nil
+			s.signal(pcsc.SCARD_S_SUCCESS)
+			return
+		}
+	}
+	s.t.Errorf("pcsctest: unknown reader %q", name)
+}
+
+// InsertCard inserts card into an empty reader.
+func (s *Server) InsertCard(name string, card *Card) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.reader(name)
+	switch {
+	case r == nil:
+		s.t.Errorf("pcsctest: unknown reader %q", name)
+	case r.card != nil:
+		s.t.Errorf("pcsctest: reader %q already holds a card", name)
+	default:
+		r.card = card
+		r.counter++
+		s.signal(pcsc.SCARD_S_SUCCESS)
+	}
+}
+
+// RemoveCard removes the card from a reader. Clients connected to the card, even in the middle of a transaction,
+// get SCARD_W_REMOVED_CARD from then on.
+func (s *Server) RemoveCard(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.reader(name)
+	switch {
+	case r == nil:
+		s.t.Errorf("pcsctest: unknown reader %q", name)
+	case r.card == nil:
+		s.t.Errorf("pcsctest: reader %q holds no card", name)
+	default:
+		s.invalidate(r, nil, pcsc.SCARD_W_REMOVED_CARD)
+		r.card = nil
+		r.counter++
+		s.signal(pcsc.SCARD_S_SUCCESS)
+	}
+}
+
+func (s *Server) reader(name string) *reader {
+	for _, r := range s.readers {
+		if r != nil && r.name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+// invalidate fails all handles to the card in r except keep with rv and ends their transaction.
+func (s *Server) invalidate(r *reader, keep *handle, rv uint32) {
+	for _, h := range s.handles {
+		if h.reader == r && h != keep && h.rv == 0 {
+			h.rv = rv
+		}
+	}
+	if r.owner != keep {
+		r.owner = nil
+	}
+}
+
+// signal ends the wait of all sessions registered for reader events with rv.
+func (s *Server) signal(rv uint32) {
+	for sess := range s.waiting {
+		s.signalSession(sess, rv)
+	}
+}
+
+func (s *Server) signalSession(sess *session, rv uint32) {
+	delete(s.waiting, sess)
+	msg := waitStruct{rv: rv}
+	sess.write(raw(&msg))
+}
+
+// readerStates encodes the states of all reader slots.
+func (s *Server) readerStates() []byte {
+	var states pcsc.ReaderArray
+	for i, r := range s.readers {
+		if r == nil {
+			continue
+		}
+		state := &states[i]
+		copy(state.ReaderName[:maxReaderName-1], r.name)
+		state.EventCounter = r.counter
+		state.ReaderState = pcsc.SCARD_ABSENT
+		for _, h := range s.handles {
+			if h.reader == r {
+				state.ReaderSharing++
+			}
+		}
+		if r.card != nil {
+			state.ReaderState = pcsc.SCARD_PRESENT | pcsc.SCARD_POWERED | pcsc.SCARD_NEGOTIABLE
+			state.CardAtrLength = uint32(copy(state.CardAtr[:], r.card.ATR))
+			state.CardProtocol = pcsc.SCARD_PROTOCOL_T1
+		}
+	}
+	return raw(&states)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		sess := &session{conn: conn}
+		s.mu.Lock()
+		s.sessions[sess] = true
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serveSession(sess)
+	}
+}
+
+func (s *Server) serveSession(sess *session) {
+	defer s.wg.Done()
+	defer s.drop(sess)
+	var header rxHeader
+	if err := sess.read(raw(&header)); err != nil || header.command != cmdVersion {
+		return
+	}
+	if !s.version(sess) {
+		return
+	}
+	for {
+		if err := sess.read(raw(&header)); err != nil {
+			return
+		}
+		if err := s.dispatch(sess, header.command); err != nil {
+			return
+		}
+	}
+}
+
+// drop forgets the contexts and card handles of a session whose client disconnected.
+func (s *Server) drop(sess *session) {
+	sess.conn.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.sessions, sess)
+	delete(s.waiting, sess)
+	for id, owner := range s.contexts {
+		if owner == sess {
+			delete(s.contexts, id)
+		}
+	}
+	for id, h := range s.handles {
+		if h.session == sess {
+			if h.reader.owner == h {
+				h.reader.owner = nil
+			}
+			delete(s.handles, id)
+		}
+	}
+}
+
+// SetVersion changes the protocol version the server speaks to clients connecting afterwards, e.g. to emulate an
+// older pcscd.
+func (s *Server) SetVersion(major, minor int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.major, s.minor = major, minor
+}
+
+// version answers the protocol version exchange, which clients have to start with. Like pcscd, it rejects clients
+// of another version and closes their connection.
+func (s *Server) version(sess *session) bool {
+	var msg versionStruct
+	if sess.read(raw(&msg)) != nil {
+		return false
+	}
+	s.mu.Lock()
+	major, minor := s.major, s.minor
+	s.mu.Unlock()
+	if msg.major != major || msg.minor != minor {
+		msg.rv = pcsc.SCARD_E_SERVICE_STOPPED
+	}
+	msg.major, msg.minor = major, minor
+	return sess.write(raw(&msg)) == nil && msg.rv == pcsc.SCARD_S_SUCCESS
+}
+
+// handle returns the handle of a connected card or the error that commands on it fail with.
+func (s *Server) handle(sess *session, card int32) (*handle, uint32) {
+	h, ok := s.handles[card]
+	if !ok || h.session != sess {
+		return nil, pcsc.SCARD_E_INVALID_HANDLE
+	}
+	if h.rv != 0 {
+		return h, h.rv
+	}
+	if h.reader.owner != nil && h.reader.owner != h {
+		return h, pcsc.SCARD_E_SHARING_VIOLATION
+	}
+	return h, pcsc.SCARD_S_SUCCESS
+}
+
+// reset resets the card of h for all other handles, if disposition asks for it.
+func (s *Server) reset(h *handle, disposition uint32) {
+	if disposition == pcsc.SCARD_LEAVE_CARD || h.reader.card == nil {
+		return
+	}
+	s.invalidate(h.reader, h, pcsc.SCARD_W_RESET_CARD)
+	if h.reader.card.Reset != nil {
+		h.reader.card.Reset()
+	}
+}
+
+var errUnsupportedCommand = errors.New("unsupported command")
+
+func (s *Server) dispatch(sess *session, command uint32) error {
+	switch command {
+	case cmdTransmit:
+		return s.transmit(sess)
+	case cmdControl:
+		return s.control(sess)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch command {
+	case cmdEstablishContext:
+		var msg establishStruct
+		if err := sess.read(raw(&msg)); err != nil {
+			return err
+		}
+		s.last++
+		msg.context = s.last
+		s.contexts[msg.context] = sess
+		return sess.write(raw(&msg))
+	case cmdReleaseContext:
+		var msg releaseStruct
+		if err := sess.read(raw(&msg)); err != nil {
+			return err
+		}
+		if s.contexts[msg.context] != sess {
+			msg.rv = pcsc.SCARD_E_INVALID_HANDLE
+		}
+		delete(s.contexts, msg.context)
+		return sess.write(raw(&msg))
+	case cmdGetReadersState:
+		return sess.write(s.readerStates())
+	case cmdWaitReaderState:
+		s.waiting[sess] = true
+		return sess.write(s.readerStates())
+	case cmdStopWaiting:
+		// a session that was signalled already takes the signal as the answer
+		if s.waiting[sess] {
+			s.signalSession(sess, pcsc.SCARD_S_SUCCESS)
+		}
+		return nil
+	case cmdCancel:
+		var msg cancelStruct
+		if err := sess.read(raw(&msg)); err != nil {
+			return err
+		}
+		if target, ok := s.contexts[msg.context]; !ok {
+			msg.rv = pcsc.SCARD_E_INVALID_HANDLE
+		} else if s.waiting[target] {
+			s.signalSession(target, pcsc.SCARD_E_CANCELLED)
+		}
+		return sess.write(raw(&msg))
+	case cmdConnect:
+		var msg connectStruct
+		if err := sess.read(raw(&msg)); err != nil {
+			return err
+		}
+		name, _, _ := bytes.Cut(msg.readerName[:], []byte{0})
+		r := s.reader(string(name))
+		switch {
+		case s.contexts[msg.context] != sess:
+			msg.rv = pcsc.SCARD_E_INVALID_HANDLE
+		case r == nil:
+			msg.rv = pcsc.SCARD_E_UNKNOWN_READER
+		case r.card == nil:
+			msg.rv = pcsc.SCARD_E_NO_SMARTCARD
+		default:
+			s.last++
+			msg.card = int32(s.last)
+			msg.activeProtocol = pcsc.SCARD_PROTOCOL_T1
+			s.handles[msg.card] = &handle{session: sess, reader: r}
+		}
+		return sess.write(raw(&msg))
+	case cmdReconnect:
+		var msg reconnectStruct
+		if err := sess.read(raw(&msg)); err != nil {
+			return err
+		}
+		h, rv := s.handle(sess, msg.card)
+		switch {
+		case h == nil || rv == pcsc.SCARD_E_SHARING_VIOLATION:
+			msg.rv = rv
+		case h.reader.card == nil:
+			msg.rv = pcsc.SCARD_E_NO_SMARTCARD
+		default:
+			h.rv = 0
+			s.reset(h, msg.initialization)
+			msg.activeProtocol = pcsc.SCARD_PROTOCOL_T1
+		}
+		return sess.write(raw(&msg))
+	case cmdDisconnect:
+		var msg disconnectStruct
+		if err := sess.read(raw(&msg)); err != nil {
+			return err
+		}
+		if h, _ := s.handle(sess, msg.card); h == nil {
+			msg.rv = pcsc.SCARD_E_INVALID_HANDLE
+		} else {
+			if h.reader.owner == h {
+				h.reader.owner = nil
+			}
+			if h.rv == 0 {
+				s.reset(h, msg.disposition)
+			}
+			delete(s.handles, msg.card)
+		}
+		return sess.write(raw(&msg))
+	case cmdBeginTransaction:
+		var msg beginStruct
+		if err := sess.read(raw(&msg)); err != nil {
+			return err
+		}
+		var h *handle
+		if h, msg.rv = s.handle(sess, msg.card); msg.rv == pcsc.SCARD_S_SUCCESS {
+			h.reader.owner = h
+		}
+		return sess.write(raw(&msg))
+	case cmdEndTransaction:
+		var msg endStruct
+		if err := sess.read(raw(&msg)); err != nil {
+			return err
+		}
+		h, rv := s.handle(sess, msg.card)
+		switch {
+		case rv != pcsc.SCARD_S_SUCCESS:
+			msg.rv = rv
+		case h.reader.owner != h:
+			msg.rv = pcsc.SCARD_E_NOT_TRANSACTED
+		default:
+			h.reader.owner = nil
+			s.reset(h, msg.disposition)
+		}
+		return sess.write(raw(&msg))
+	case cmdStatus:
+		var msg statusStruct
+		if err := sess.read(raw(&msg)); err != nil {
+			return err
+		}
+		if _, msg.rv = s.handle(sess, msg.card); msg.rv == pcsc.SCARD_E_SHARING_VIOLATION {
+			msg.rv = pcsc.SCARD_S_SUCCESS
+		}
+		return sess.write(raw(&msg))
+	case cmdGetAttrib:
+		var msg getSetStruct
+		if err := sess.read(raw(&msg)); err != nil {
+			return err
+		}
+		var h *handle
+		if h, msg.rv = s.handle(sess, msg.card); msg.rv == pcsc.SCARD_S_SUCCESS {
+			if msg.attrID == pcsc.SCARD_ATTR_ATR_STRING {
+				msg.attrLen = uint32(copy(msg.attr[:], h.reader.card.ATR))
+			} else {
+				msg.rv = pcsc.SCARD_E_UNSUPPORTED_FEATURE
+			}
+		}
+		return sess.write(raw(&msg))
+	}
+	return fmt.Errorf("%w: %#x", errUnsupportedCommand, command)
+}
+
+// transmit passes a command APDU to the card. The card is called without holding the lock of the server, so that
+// it may change the readers, e.g. to remove itself in the middle of a transaction.
+func (s *Server) transmit(sess *session) error {
+	var msg transmitStruct
+	if err := sess.read(raw(&msg)); err != nil {
+		return err
+	}
+	if msg.sendLength > maxBufferSize || msg.recvLength > maxBufferSize {
+		return fmt.Errorf("transmit of %d bytes exceeds buffer", max(msg.sendLength, msg.recvLength))
+	}
+	cmd := make([]byte, msg.sendLength)
+	if err := sess.read(cmd); err != nil {
+		return err
+	}
+	resp := make([]byte, msg.recvLength)
+
+	var card *Card
+	s.mu.Lock()
+	h, rv := s.handle(sess, msg.card)
+	if rv == pcsc.SCARD_S_SUCCESS {
+		card = h.reader.card
+	}
+	s.mu.Unlock()
+	msg.rv = rv
+	if card != nil {
+		n, err := card.Transmit(cmd, resp)
+		switch {
+		case errors.Is(err, io.ErrShortBuffer):
+			msg.rv = pcsc.SCARD_E_INSUFFICIENT_BUFFER
+		case err != nil:
+			msg.rv = pcsc.SCARD_F_COMM_ERROR
+		default:
+			resp = resp[:n]
+		}
+	}
+	if msg.rv != pcsc.SCARD_S_SUCCESS {
+		resp = nil
+	}
+	msg.recvLength = uint32(len(resp))
+	return sess.write(raw(&msg), resp)
+}
+
+// control answers control commands for the reader driver, which the emulated readers do not support.
+func (s *Server) control(sess *session) error {
+	var msg controlStruct
+	if err := sess.read(raw(&msg)); err != nil {
+		return err
+	}
+	if msg.sendLength > maxBufferSize {
+		return fmt.Errorf("control of %d bytes exceeds buffer", msg.sendLength)
+	}
+	if err := sess.read(make([]byte, msg.sendLength)); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	_, msg.rv = s.handle(sess, msg.card)
+	s.mu.Unlock()
+	if msg.rv == pcsc.SCARD_S_SUCCESS {
+		msg.rv = pcsc.SCARD_E_UNSUPPORTED_FEATURE
+	}
+	msg.bytesReturned = 0
+	return sess.write(raw(&msg))
+}
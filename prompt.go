@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+var (
+	errPasswordMismatch = errors.New("passwords do not match")
+	stdin               = bufio.NewReader(os.Stdin)
+)
+
+// readLine prints prompt to stderr and reads a single line from stdin.
+func readLine(prompt string) (string, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readPassword prints prompt to stderr and reads a line from stdin without echoing it
+// if stdin is a terminal.
+func readPassword(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readLine(prompt)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(password), nil
+}
+
+// readNewPassword reads a password twice and fails if both inputs differ.
+func readNewPassword(prompt string) (string, error) {
+	password, err := readPassword(prompt)
+	if err != nil {
+		return "", err
+	}
+	confirm, err := readPassword("Repeat " + strings.ToLower(prompt[:1]) + prompt[1:])
+	if err != nil {
+		return "", err
+	}
+	if password != confirm {
+		return "", errPasswordMismatch
+	}
+	return password, nil
+}
@@ -0,0 +1,135 @@
+package scard
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestAPDU_Bytes(t *testing.T) {
+	long := bytes.Repeat([]byte{0xaa}, 300)
+	for _, test := range []struct {
+		name string
+		apdu APDU
+		want []byte
+	}{
+		{"no data", APDU{Ins: 0xca, P2: 0x6e, Le: maxShortLe}, []byte{0x00, 0xca, 0x00, 0x6e, 0x00}},
+		{"no response", APDU{Ins: 0x20, P2: 0x81, Data: []byte{0x31, 0x32}}, []byte{0x00, 0x20, 0x00, 0x81, 0x02, 0x31, 0x32}},
+		{"no data and no response", APDU{Ins: 0x44, Elf: true}, []byte{0x00, 0x44, 0x00, 0x00}},
+		{"short", APDU{Ins: 0x20, P2: 0x81, Data: []byte{0x31, 0x32}, Le: 0x10}, []byte{0x00, 0x20, 0x00, 0x81, 0x02, 0x31, 0x32, 0x10}},
+		{"maximum short le", APDU{Ins: 0xc0, Le: 256}, []byte{0x00, 0xc0, 0x00, 0x00, 0x00}},
+		{"extended le", APDU{Ins: 0xca, P1: 0x7f, P2: 0x21, Le: 0x1000}, []byte{0x00, 0xca, 0x7f, 0x21, 0x00, 0x10, 0x00}},
+		{"extended flag", APDU{Ins: 0x47, P1: 0x81, Data: []byte{0xb6, 0x00}, Elf: true, Le: maxExtendedLe}, []byte{0x00, 0x47, 0x81, 0x00, 0x00, 0x00, 0x02, 0xb6, 0x00, 0x00, 0x00}},
+		{"extended lc", APDU{Ins: 0xdb, Data: long}, concat([]byte{0x00, 0xdb, 0x00, 0x00, 0x00, 0x01, 0x2c}, long...)},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.apdu.Bytes()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, test.want) {
+				t.Fatalf("Expected %x, got %x", test.want, got)
+			}
+		})
+	}
+	if _, err := (APDU{Le: maxExtendedLe + 1}).Bytes(); !errors.Is(err, ErrWrongLength) {
+		t.Fatalf("Expected ErrWrongLength, got %v", err)
+	}
+}
+
+// rawCard replays raw command and response APDUs.
+type rawCard struct {
+	t      *testing.T
+	script [][2][]byte
+}
+
+func (c *rawCard) transmit(cmd, resp []byte) (int, error) {
+	c.t.Helper()
+	if len(c.script) == 0 {
+		c.t.Fatalf("Unexpected command %x", cmd)
+	}
+	next := c.script[0]
+	c.script = c.script[1:]
+	if !bytes.Equal(cmd, next[0]) {
+		c.t.Fatalf("Unexpected command %x, expected %x", cmd, next[0])
+	}
+	return copy(resp, next[1]), nil
+}
+
+func TestTransceive(t *testing.T) {
+	part1, part2 := bytes.Repeat([]byte{0x01}, 256), bytes.Repeat([]byte{0x02}, 16)
+	card := &rawCard{t: t, script: [][2][]byte{
+		// command chaining
+		{concat([]byte{0x10, 0xdb, 0x3f, 0xff, 0xff}, bytes.Repeat([]byte{0xaa}, 255)...), {0x90, 0x00}},
+		{[]byte{0x00, 0xdb, 0x3f, 0xff, 0x01, 0xaa}, {0x90, 0x00}},
+		// wrong le followed by get response
+		{[]byte{0x00, 0xca, 0x7f, 0x21, 0x00}, {0x6c, 0x00}},
+		{[]byte{0x00, 0xca, 0x7f, 0x21, 0x00}, concat(part1, 0x61, 0x10)},
+		{[]byte{0x00, 0xc0, 0x00, 0x00, 0x10}, concat(part2, 0x90, 0x00)},
+		// warning status with data
+		{[]byte{0x00, 0xb0, 0x00, 0x00, 0x10}, {0x01, 0x02, 0x62, 0x82}},
+		// error status
+		{[]byte{0x00, 0x20, 0x00, 0x81, 0x01, 0x30}, {0x69, 0x82}},
+	}}
+
+	apdu := APDU{Ins: 0xdb, P1: 0x3f, P2: 0xff, Data: bytes.Repeat([]byte{0xaa}, 256)}
+	if _, err := transceive(card.transmit, &apdu); err != nil || apdu.SW != SwOK {
+		t.Fatalf("Chained command failed with %s: %v", apdu.SW, err)
+	}
+
+	apdu = APDU{Ins: 0xca, P1: 0x7f, P2: 0x21, Le: maxShortLe}
+	resp, err := transceive(card.transmit, &apdu)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp, concat(part1, part2...)) {
+		t.Fatalf("Unexpected response %x", resp)
+	}
+
+	apdu = APDU{Ins: 0xb0, Le: 0x10}
+	if resp, err = transceive(card.transmit, &apdu); !errors.Is(err, ErrEOF) || !bytes.Equal(resp, []byte{0x01, 0x02}) {
+		t.Fatalf("Expected the data read before the end of the file with ErrEOF, got %x %v", resp, err)
+	}
+
+	apdu = APDU{Ins: 0x20, P2: 0x81, Data: []byte{0x30}}
+	if _, err := transceive(card.transmit, &apdu); !errors.Is(err, ErrSecurityStatusNotSatisfied) {
+		t.Fatalf("Expected ErrSecurityStatusNotSatisfied, got %v", err)
+	}
+	if apdu.SW != 0x6982 || apdu.SW.SW1() != 0x69 || apdu.SW.SW2() != 0x82 {
+		t.Fatalf("Unexpected status word %s", apdu.SW)
+	}
+	if len(card.script) != 0 {
+		t.Fatalf("%d scripted commands were not sent", len(card.script))
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	for _, test := range []struct {
+		sw      StatusWord
+		want    error
+		retries int
+	}{
+		{0x63c2, ErrVerificationFailed, 2},
+		{0x63c0, ErrVerificationFailed, 0},
+		{0x6982, ErrSecurityStatusNotSatisfied, -1},
+		{0x6983, ErrAuthenticationMethodBlocked, -1},
+		{0x6a99, ErrWrongParamsNoInfo, -1},
+		{0x9abc, ErrUnknownStatus, -1},
+	} {
+		err := test.sw.err()
+		if !errors.Is(err, test.want) {
+			t.Fatalf("%s: expected %v, got %v", test.sw, test.want, err)
+		}
+		var serr *StatusError
+		if !errors.As(err, &serr) || StatusWord(serr.SW1)<<8|StatusWord(serr.SW2) != test.sw {
+			t.Fatalf("%s: expected status error, got %#v", test.sw, err)
+		}
+		n, ok := RetriesLeft(err)
+		if ok != (test.retries >= 0) || ok && n != test.retries {
+			t.Fatalf("%s: expected %d retries, got %d %v", test.sw, test.retries, n, ok)
+		}
+	}
+	if err := SwOK.err(); err != nil {
+		t.Fatalf("Expected no error for 9000, got %v", err)
+	}
+}
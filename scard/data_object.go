@@ -39,7 +39,7 @@ var (
 	DoUIFSig      = DataObject{tag: 0x00D6, constructed: false, parent: 0x6E, binary: true, extLen: 0, desc: "UIF for Signature"}
 	DoUIFDec      = DataObject{tag: 0x00D7, constructed: false, parent: 0x6E, binary: true, extLen: 0, desc: "UIF for Decryption"}
 	DoUIFAut      = DataObject{tag: 0x00D8, constructed: false, parent: 0x6E, binary: true, extLen: 0, desc: "UIF for Authentication"}
-	DoUIFAtt      = DataObject{tag: 0x00D8, constructed: false, parent: 0x6E, binary: true, extLen: 0, desc: "UIF for Yubico Attestation key"}
+	DoUIFAtt      = DataObject{tag: 0x00D9, constructed: false, parent: 0x6E, binary: true, extLen: 0, desc: "UIF for Yubico Attestation key"}
 	DoKDFDO       = DataObject{tag: 0x00F9, constructed: false, parent: 0, binary: true, extLen: 0, desc: "KDF data object"}
 	DoAlgoInfo    = DataObject{tag: 0x00FA, constructed: false, parent: 0, binary: true, extLen: 2, desc: "Algorithm Information"}
 )
@@ -59,8 +59,26 @@ type DataObject struct {
 	desc        string
 }
 
+// Tag returns the tag of the data object.
+func (do DataObject) Tag() uint16 {
+	return do.tag
+}
+
+// Constructed reports whether the data object is a template containing other data objects.
+func (do DataObject) Constructed() bool {
+	return do.constructed
+}
+
+func (do DataObject) String() string {
+	return do.desc
+}
+
 func (c *Card) GetChallenge(length uint8) ([]byte, error) {
-	return c.Transmit(APDU{Cla: 0, Ins: 0x84, P1: 0, P2: 0, Data: []byte{length}, Len: length})
+	le := int(length)
+	if le == 0 {
+		le = maxShortLe
+	}
+	return c.Transmit(APDU{Cla: 0, Ins: 0x84, P1: 0, P2: 0, Le: le})
 }
 
 func (do *DataObject) tagBytes() []byte {
@@ -138,7 +156,7 @@ func doFindTLV(data []byte, tag uint16, nestLevel int) []byte {
 		if tagLen < 0x80 {
 			// do nothing
 		} else if tagLen == 0x81 { // One byte length follows.
-			if n != 0 { // we expected 1 more bytes with the length
+			if n < 1 { // We expected 1 more byte with the length.
 				return nil
 			}
 
@@ -157,8 +175,12 @@ func doFindTLV(data []byte, tag uint16, nestLevel int) []byte {
 			return nil
 		}
 
+		if int(tagLen) > n { // Buffer too short for the value.
+			return nil
+		}
+
 		if composite && nestLevel < 100 { // Dive into this composite DO after checking for a too deep nesting
-			tmpData := doFindTLV(data[o:], tag, nestLevel+1)
+			tmpData := doFindTLV(data[o:o+int(tagLen)], tag, nestLevel+1)
 
 			if len(tmpData) > 0 {
 				return tmpData
@@ -167,8 +189,6 @@ func doFindTLV(data []byte, tag uint16, nestLevel int) []byte {
 
 		if thisTag == tag {
 			tagFound = true
-		} else if int(tagLen) > n { // Buffer too short to skip to the next tag.
-			return nil
 		} else {
 			o += int(tagLen)
 			n -= int(tagLen)
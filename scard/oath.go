@@ -0,0 +1,510 @@
+package scard
+
+import (
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OathType is the type of an OATH credential.
+type OathType byte
+
+const (
+	OathHOTP OathType = 0x10
+	OathTOTP OathType = 0x20
+)
+
+func (t OathType) String() string {
+	switch t {
+	case OathHOTP:
+		return "HOTP"
+	case OathTOTP:
+		return "TOTP"
+	}
+	return "<unknown>"
+}
+
+// OathAlgorithm is the HMAC algorithm of an OATH credential.
+type OathAlgorithm byte
+
+const (
+	OathSHA1   OathAlgorithm = 0x01
+	OathSHA256 OathAlgorithm = 0x02
+	OathSHA512 OathAlgorithm = 0x03
+)
+
+func (a OathAlgorithm) String() string {
+	switch a {
+	case OathSHA1:
+		return "SHA1"
+	case OathSHA256:
+		return "SHA256"
+	case OathSHA512:
+		return "SHA512"
+	}
+	return "<unknown>"
+}
+
+func (a OathAlgorithm) hash() func() hash.Hash {
+	switch a {
+	case OathSHA256:
+		return sha256.New
+	case OathSHA512:
+		return sha512.New
+	}
+	return sha1.New
+}
+
+// OathDefaultPeriod is the time step of TOTP credentials without a period prefix in their name.
+const OathDefaultPeriod = 30 * time.Second
+
+const (
+	oathTagName         = 0x71
+	oathTagNameList     = 0x72
+	oathTagKey          = 0x73
+	oathTagChallenge    = 0x74
+	oathTagResponse     = 0x75
+	oathTagTruncated    = 0x76
+	oathTagHOTP         = 0x77
+	oathTagProperty     = 0x78
+	oathTagVersion      = 0x79
+	oathTagIMF          = 0x7a
+	oathTagAlgorithm    = 0x7b
+	oathTagTouch        = 0x7c
+	oathPropertyTouch   = 0x02
+	oathInsPut          = 0x01
+	oathInsDelete       = 0x02
+	oathInsSetCode      = 0x03
+	oathInsList         = 0xa1
+	oathInsCalculate    = 0xa2
+	oathInsValidate     = 0xa3
+	oathInsCalculateAll = 0xa4
+	oathInsSendRemain   = 0xa5
+	oathMinKeySize      = 14
+	oathMaxNameSize     = 64
+	oathChallengeSize   = 8
+	oathIterations      = 1000
+	oathAccessKeySize   = 16
+)
+
+var (
+	ErrOathWrongPassword    = errors.New("wrong OATH password")
+	ErrOathAuthentication   = errors.New("OATH application failed to authenticate")
+	ErrInvalidOathResponse  = errors.New("invalid OATH response")
+	ErrInvalidOathName      = errors.New("invalid OATH credential name")
+	ErrInvalidOathParameter = errors.New("invalid OATH parameter")
+)
+
+// OATH provides the commands of the YKOATH application, which stores OATH credentials on the token and calculates
+// their codes.
+// https://developers.yubico.com/OATH/YKOATH_Protocol.html
+type OATH struct {
+	card Transmitter
+
+	Version   []byte
+	salt      []byte        // device ID, the salt of the access key
+	challenge []byte        // challenge to validate against, nil if the application is not locked
+	algorithm OathAlgorithm // algorithm of the access key
+}
+
+// OathCredential is a credential stored in the OATH application. Its name is "[period/][issuer:]account", the
+// period prefix is only present for TOTP credentials with another time step than OathDefaultPeriod. The algorithm
+// is only known from List, whether the credential requires touch only from CalculateAll.
+type OathCredential struct {
+	Name      string
+	Type      OathType
+	Algorithm OathAlgorithm
+	Touch     bool
+}
+
+// OathCredentialName returns the name of a credential, the period is omitted for HOTP credentials.
+func OathCredentialName(typ OathType, issuer, account string, period time.Duration) string {
+	name := account
+	if issuer != "" {
+		name = issuer + ":" + account
+	}
+	if typ == OathTOTP && period != 0 && period != OathDefaultPeriod {
+		name = strconv.Itoa(int(period/time.Second)) + "/" + name
+	}
+	return name
+}
+
+// Period returns the time step of a TOTP credential.
+func (c OathCredential) Period() time.Duration {
+	if prefix, _, ok := strings.Cut(c.Name, "/"); ok && c.Type == OathTOTP {
+		if n, err := strconv.Atoi(prefix); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return OathDefaultPeriod
+}
+
+// Issuer returns the issuer part of the name, if any.
+func (c OathCredential) Issuer() string {
+	issuer, _, ok := strings.Cut(c.label(), ":")
+	if !ok {
+		return ""
+	}
+	return issuer
+}
+
+// Account returns the account part of the name.
+func (c OathCredential) Account() string {
+	label := c.label()
+	if _, account, ok := strings.Cut(label, ":"); ok {
+		return account
+	}
+	return label
+}
+
+// label returns the name without the period prefix.
+func (c OathCredential) label() string {
+	if prefix, label, ok := strings.Cut(c.Name, "/"); ok && c.Type == OathTOTP {
+		if _, err := strconv.Atoi(prefix); err == nil {
+			return label
+		}
+	}
+	return c.Name
+}
+
+// OathCode is a code calculated by the OATH application.
+type OathCode struct {
+	Credential OathCredential
+	// Value is empty if the code was not calculated: CalculateAll skips HOTP credentials, which would advance their
+	// counter, and credentials that require touch.
+	Value string
+	// ValidFrom and ValidTo are the time step of a TOTP code.
+	ValidFrom time.Time
+	ValidTo   time.Time
+}
+
+// OathSecret is the secret of a credential stored with Put.
+type OathSecret struct {
+	Key     []byte
+	Digits  int    // 6 to 8
+	Counter uint32 // initial moving factor of HOTP credentials
+}
+
+// NewOATH selects the OATH application on card.
+func NewOATH(card Transmitter) (*OATH, error) {
+	resp, err := card.Transmit(APDU{Cla: 0, Ins: 0xa4, P1: 0x04, P2: 0, Data: AidYubicoOATH, Le: maxShortLe, Remaining: oathInsSendRemain})
+	if err != nil {
+		return nil, err
+	}
+	tlvs, err := parseOathTLVs(resp)
+	if err != nil {
+		return nil, err
+	}
+	o := &OATH{card: card, algorithm: OathSHA1}
+	for _, t := range tlvs {
+		switch t.tag {
+		case oathTagVersion:
+			o.Version = t.value
+		case oathTagName:
+			o.salt = t.value
+		case oathTagChallenge:
+			o.challenge = t.value
+		case oathTagAlgorithm:
+			if len(t.value) == 1 {
+				o.algorithm = OathAlgorithm(t.value[0])
+			}
+		}
+	}
+	return o, nil
+}
+
+// OATH selects the OATH application on the card.
+func (c *Card) OATH() (*OATH, error) {
+	return NewOATH(c)
+}
+
+// Locked reports whether the application is protected by a password that has not been validated yet.
+func (o *OATH) Locked() bool {
+	return o.challenge != nil
+}
+
+// Validate unlocks the application with its password and checks that the application knows the password as well.
+func (o *OATH) Validate(password string) error {
+	if o.challenge == nil {
+		return nil
+	}
+	key, err := o.accessKey(password)
+	if err != nil {
+		return err
+	}
+	challenge := make([]byte, oathChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return err
+	}
+	data := concat(oathTLV(oathTagResponse, o.hmac(key, o.challenge)), oathTLV(oathTagChallenge, challenge)...)
+	resp, err := o.transmit(oathInsValidate, 0, 0, data)
+	if errors.Is(err, ErrIncorrectData) {
+		return ErrOathWrongPassword
+	} else if err != nil {
+		return err
+	}
+	tlvs, err := parseOathTLVs(resp)
+	if err != nil {
+		return err
+	}
+	if len(tlvs) != 1 || tlvs[0].tag != oathTagResponse || !hmac.Equal(tlvs[0].value, o.hmac(key, challenge)) {
+		return ErrOathAuthentication
+	}
+	o.challenge = nil
+	return nil
+}
+
+// SetPassword protects the application with password, an empty password removes the protection. A locked
+// application has to be validated first.
+func (o *OATH) SetPassword(password string) error {
+	if password == "" {
+		_, err := o.transmit(oathInsSetCode, 0, 0, oathTLV(oathTagKey, nil))
+		return err
+	}
+	key, err := o.accessKey(password)
+	if err != nil {
+		return err
+	}
+	challenge := make([]byte, oathChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return err
+	}
+	data := oathTLV(oathTagKey, concat([]byte{byte(OathTOTP) | byte(OathSHA1)}, key...))
+	data = append(data, oathTLV(oathTagChallenge, challenge)...)
+	data = append(data, oathTLV(oathTagResponse, hmacSum(OathSHA1, key, challenge))...)
+	if _, err := o.transmit(oathInsSetCode, 0, 0, data); err != nil {
+		return err
+	}
+	o.algorithm = OathSHA1
+	return nil
+}
+
+// List returns the credentials stored in the application.
+func (o *OATH) List() ([]OathCredential, error) {
+	resp, err := o.transmit(oathInsList, 0, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	tlvs, err := parseOathTLVs(resp)
+	if err != nil {
+		return nil, err
+	}
+	credentials := make([]OathCredential, 0, len(tlvs))
+	for _, t := range tlvs {
+		if t.tag != oathTagNameList || len(t.value) == 0 {
+			return nil, ErrInvalidOathResponse
+		}
+		credentials = append(credentials, OathCredential{
+			Name:      string(t.value[1:]),
+			Type:      OathType(t.value[0] & 0xf0),
+			Algorithm: OathAlgorithm(t.value[0] & 0x0f),
+		})
+	}
+	return credentials, nil
+}
+
+// Put stores a credential, replacing one with the same name.
+func (o *OATH) Put(c OathCredential, secret OathSecret) error {
+	if len(c.Name) == 0 || len(c.Name) > oathMaxNameSize {
+		return ErrInvalidOathName
+	}
+	if c.Type != OathHOTP && c.Type != OathTOTP || secret.Digits < 6 || secret.Digits > 8 || len(secret.Key) == 0 {
+		return ErrInvalidOathParameter
+	}
+	key := secret.Key
+	h := c.Algorithm.hash()()
+	if len(key) > h.BlockSize() {
+		h.Write(key)
+		key = h.Sum(nil)
+	}
+	if len(key) < oathMinKeySize {
+		key = append(append([]byte(nil), key...), make([]byte, oathMinKeySize-len(key))...)
+	}
+	data := oathTLV(oathTagName, []byte(c.Name))
+	data = append(data, oathTLV(oathTagKey, concat([]byte{byte(c.Type) | byte(c.Algorithm), byte(secret.Digits)}, key...))...)
+	if c.Touch {
+		// the property is a tag followed by its value without a length
+		data = append(data, oathTagProperty, oathPropertyTouch)
+	}
+	if c.Type == OathHOTP && secret.Counter > 0 {
+		data = append(data, oathTLV(oathTagIMF, binary.BigEndian.AppendUint32(nil, secret.Counter))...)
+	}
+	_, err := o.transmit(oathInsPut, 0, 0, data)
+	return err
+}
+
+// Delete removes the credential with name.
+func (o *OATH) Delete(name string) error {
+	_, err := o.transmit(oathInsDelete, 0, 0, oathTLV(oathTagName, []byte(name)))
+	return err
+}
+
+// Calculate returns the code of a credential at t, which is ignored for HOTP credentials. Calculating the code of a
+// credential that requires touch blocks until the token is touched and fails with ErrConditionsOfUseNotSatisfied
+// if it is not touched in time.
+func (o *OATH) Calculate(c OathCredential, t time.Time) (OathCode, error) {
+	code := OathCode{Credential: c}
+	challenge := []byte{}
+	if c.Type == OathTOTP {
+		challenge, code.ValidFrom, code.ValidTo = oathTimeStep(t, c.Period())
+	}
+	data := concat(oathTLV(oathTagName, []byte(c.Name)), oathTLV(oathTagChallenge, challenge)...)
+	resp, err := o.transmit(oathInsCalculate, 0, 0x01, data)
+	if err != nil {
+		return OathCode{}, err
+	}
+	tlvs, err := parseOathTLVs(resp)
+	if err != nil {
+		return OathCode{}, err
+	}
+	if len(tlvs) != 1 || tlvs[0].tag != oathTagTruncated {
+		return OathCode{}, ErrInvalidOathResponse
+	}
+	if code.Value, err = oathCodeValue(tlvs[0].value); err != nil {
+		return OathCode{}, err
+	}
+	return code, nil
+}
+
+// CalculateAll returns the codes of all credentials at t. Codes of HOTP credentials and credentials that require
+// touch are left empty to be calculated one by one with Calculate. TOTP credentials with another period than
+// OathDefaultPeriod are calculated again with their own.
+func (o *OATH) CalculateAll(t time.Time) ([]OathCode, error) {
+	challenge, from, to := oathTimeStep(t, OathDefaultPeriod)
+	resp, err := o.transmit(oathInsCalculateAll, 0, 0x01, oathTLV(oathTagChallenge, challenge))
+	if err != nil {
+		return nil, err
+	}
+	tlvs, err := parseOathTLVs(resp)
+	if err != nil {
+		return nil, err
+	}
+	if len(tlvs)%2 != 0 {
+		return nil, ErrInvalidOathResponse
+	}
+	codes := make([]OathCode, 0, len(tlvs)/2)
+	for i := 0; i < len(tlvs); i += 2 {
+		name, result := tlvs[i], tlvs[i+1]
+		if name.tag != oathTagName {
+			return nil, ErrInvalidOathResponse
+		}
+		code := OathCode{Credential: OathCredential{Name: string(name.value), Type: OathTOTP}}
+		switch result.tag {
+		case oathTagHOTP:
+			code.Credential.Type = OathHOTP
+		case oathTagTouch:
+			code.Credential.Touch = true
+		case oathTagTruncated:
+			if code.Credential.Period() != OathDefaultPeriod {
+				if code, err = o.Calculate(code.Credential, t); err != nil {
+					return nil, err
+				}
+				break
+			}
+			if code.Value, err = oathCodeValue(result.value); err != nil {
+				return nil, err
+			}
+			code.ValidFrom, code.ValidTo = from, to
+		default:
+			return nil, ErrInvalidOathResponse
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func (o *OATH) transmit(ins, p1, p2 byte, data []byte) ([]byte, error) {
+	return o.card.Transmit(APDU{Cla: 0, Ins: ins, P1: p1, P2: p2, Data: data, Le: maxShortLe, Remaining: oathInsSendRemain})
+}
+
+// accessKey derives the access key from password and the device ID, the salt of the application.
+func (o *OATH) accessKey(password string) ([]byte, error) {
+	return pbkdf2.Key(sha1.New, password, o.salt, oathIterations, oathAccessKeySize)
+}
+
+func (o *OATH) hmac(key, message []byte) []byte {
+	return hmacSum(o.algorithm, key, message)
+}
+
+func hmacSum(a OathAlgorithm, key, message []byte) []byte {
+	mac := hmac.New(a.hash(), key)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+// oathTimeStep returns the challenge of a TOTP credential at t, which is the number of the time step, with the
+// start and end of the time step.
+func oathTimeStep(t time.Time, period time.Duration) ([]byte, time.Time, time.Time) {
+	seconds := int64(period / time.Second)
+	step := t.Unix() / seconds
+	from := time.Unix(step*seconds, 0)
+	return binary.BigEndian.AppendUint64(nil, uint64(step)), from, from.Add(period)
+}
+
+// oathCodeValue formats a truncated response, the number of digits followed by the dynamically truncated HMAC.
+func oathCodeValue(b []byte) (string, error) {
+	if len(b) != 5 || b[0] < 6 || b[0] > 10 {
+		return "", ErrInvalidOathResponse
+	}
+	digits := int(b[0])
+	value := uint64(binary.BigEndian.Uint32(b[1:]) & 0x7fffffff)
+	mod := uint64(1)
+	for range digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod), nil
+}
+
+type oathTLVValue struct {
+	tag   byte
+	value []byte
+}
+
+// oathTLV encodes a TLV with a one byte tag, as the OATH application uses them.
+func oathTLV(tag byte, value []byte) []byte {
+	return tlv(uint16(tag), value)
+}
+
+// parseOathTLVs splits data into its sequence of TLVs, in which tags may repeat.
+func parseOathTLVs(data []byte) ([]oathTLVValue, error) {
+	var tlvs []oathTLVValue
+	for len(data) > 0 {
+		t, rest, err := nextOathTLV(data)
+		if err != nil {
+			return nil, err
+		}
+		tlvs = append(tlvs, t)
+		data = rest
+	}
+	return tlvs, nil
+}
+
+// nextOathTLV returns the first TLV of data and the data that follows it.
+func nextOathTLV(data []byte) (oathTLVValue, []byte, error) {
+	if len(data) < 2 {
+		return oathTLVValue{}, nil, ErrInvalidOathResponse
+	}
+	tag, n, rest := data[0], int(data[1]), data[2:]
+	switch {
+	case n == 0x81 && len(rest) >= 1:
+		n, rest = int(rest[0]), rest[1:]
+	case n == 0x82 && len(rest) >= 2:
+		n, rest = int(binary.BigEndian.Uint16(rest)), rest[2:]
+	case n >= 0x80:
+		return oathTLVValue{}, nil, ErrInvalidOathResponse
+	}
+	if len(rest) < n {
+		return oathTLVValue{}, nil, ErrInvalidOathResponse
+	}
+	return oathTLVValue{tag: tag, value: rest[:n]}, rest[n:], nil
+}
@@ -0,0 +1,201 @@
+package scard
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newSimulatedOATH(t *testing.T) (*Simulator, *OATH) {
+	t.Helper()
+	sim := NewSimulator()
+	o, err := NewCard(sim).OATH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim, o
+}
+
+func TestOathCredential_Name(t *testing.T) {
+	for _, test := range []struct {
+		typ                   OathType
+		issuer, account, name string
+		period                time.Duration
+	}{
+		{OathTOTP, "Example", "alice", "Example:alice", OathDefaultPeriod},
+		{OathTOTP, "", "alice", "alice", OathDefaultPeriod},
+		{OathTOTP, "Example", "alice", "60/Example:alice", time.Minute},
+		{OathHOTP, "Example", "alice", "Example:alice", OathDefaultPeriod},
+	} {
+		name := OathCredentialName(test.typ, test.issuer, test.account, test.period)
+		if name != test.name {
+			t.Fatalf("Expected name %q, got %q", test.name, name)
+		}
+		c := OathCredential{Name: name, Type: test.typ}
+		if c.Issuer() != test.issuer || c.Account() != test.account || c.Period() != test.period {
+			t.Fatalf("Unexpected parts of %q: %q %q %s", name, c.Issuer(), c.Account(), c.Period())
+		}
+	}
+}
+
+// TestOATH_Calculate checks the codes against the test vectors of RFC 4226 and RFC 6238.
+func TestOATH_Calculate(t *testing.T) {
+	_, o := newSimulatedOATH(t)
+	seed := []byte("1234567890123456789012345678901234567890123456789012345678901234")
+	for _, test := range []struct {
+		algorithm OathAlgorithm
+		key       []byte
+		unix      int64
+		code      string
+	}{
+		{OathSHA1, seed[:20], 59, "94287082"},
+		{OathSHA1, seed[:20], 1111111109, "07081804"},
+		{OathSHA256, seed[:32], 59, "46119246"},
+		{OathSHA512, seed[:64], 59, "90693936"},
+	} {
+		c := OathCredential{Name: "totp-" + test.algorithm.String(), Type: OathTOTP, Algorithm: test.algorithm}
+		if err := o.Put(c, OathSecret{Key: test.key, Digits: 8}); err != nil {
+			t.Fatal(err)
+		}
+		code, err := o.Calculate(c, time.Unix(test.unix, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code.Value != test.code || code.ValidTo.Sub(code.ValidFrom) != OathDefaultPeriod {
+			t.Fatalf("Expected %s for %s at %d, got %+v", test.code, test.algorithm, test.unix, code)
+		}
+	}
+
+	hotp := OathCredential{Name: "hotp", Type: OathHOTP, Algorithm: OathSHA1}
+	if err := o.Put(hotp, OathSecret{Key: seed[:20], Digits: 6}); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"755224", "287082", "359152"} {
+		code, err := o.Calculate(hotp, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code.Value != expected {
+			t.Fatalf("Expected HOTP code %s, got %s", expected, code.Value)
+		}
+	}
+	if err := o.Put(hotp, OathSecret{Key: seed[:20], Digits: 6, Counter: 9}); err != nil {
+		t.Fatal(err)
+	}
+	if code, err := o.Calculate(hotp, time.Now()); err != nil || code.Value != "520489" {
+		t.Fatalf("Expected HOTP code 520489 for counter 9, got %+v %v", code, err)
+	}
+}
+
+func TestOATH_CalculateAll(t *testing.T) {
+	sim, o := newSimulatedOATH(t)
+	key := []byte("12345678901234567890")
+	credentials := []OathCredential{
+		{Name: OathCredentialName(OathTOTP, "Example", "touch", 0), Type: OathTOTP, Algorithm: OathSHA1, Touch: true},
+		{Name: OathCredentialName(OathTOTP, "Example", "minute", time.Minute), Type: OathTOTP, Algorithm: OathSHA1},
+		{Name: OathCredentialName(OathHOTP, "Example", "counter", 0), Type: OathHOTP, Algorithm: OathSHA1},
+	}
+	// enough credentials for a response that is collected with SEND REMAINING
+	for i := range 20 {
+		name := OathCredentialName(OathTOTP, "Example", fmt.Sprintf("account-with-a-long-name-%02d", i), 0)
+		credentials = append(credentials, OathCredential{Name: name, Type: OathTOTP, Algorithm: OathSHA1})
+	}
+	for _, c := range credentials {
+		if err := o.Put(c, OathSecret{Key: key, Digits: 6}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := o.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != len(credentials) || list[2].Type != OathHOTP || list[1].Algorithm != OathSHA1 {
+		t.Fatalf("Unexpected credentials %+v", list)
+	}
+
+	now := time.Unix(1111111109, 0)
+	codes, err := o.CalculateAll(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != len(credentials) {
+		t.Fatalf("Expected %d codes, got %d", len(credentials), len(codes))
+	}
+	if !codes[0].Credential.Touch || codes[0].Value != "" {
+		t.Fatalf("Expected the touch credential not to be calculated, got %+v", codes[0])
+	}
+	if codes[1].Value == "" || codes[1].ValidTo.Sub(codes[1].ValidFrom) != time.Minute {
+		t.Fatalf("Expected the minute credential to be calculated with its period, got %+v", codes[1])
+	}
+	if codes[2].Credential.Type != OathHOTP || codes[2].Value != "" {
+		t.Fatalf("Expected the HOTP credential not to be calculated, got %+v", codes[2])
+	}
+	for _, code := range codes[3:] {
+		if code.Value != "081804" {
+			t.Fatalf("Expected 081804 for %s, got %q", code.Credential.Name, code.Value)
+		}
+	}
+
+	if code, err := o.Calculate(codes[0].Credential, now); err != nil || code.Value != "081804" {
+		t.Fatalf("Expected 081804 after touch, got %+v %v", code, err)
+	}
+	sim.SetOathTouch(func() bool { return false })
+	if _, err := o.Calculate(codes[0].Credential, now); !errors.Is(err, ErrConditionsOfUseNotSatisfied) {
+		t.Fatalf("Expected ErrConditionsOfUseNotSatisfied without touch, got %v", err)
+	}
+
+	if err := o.Delete(codes[0].Credential.Name); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Delete(codes[0].Credential.Name); !errors.Is(err, ErrReferenceDataNotUsable) {
+		t.Fatalf("Expected ErrReferenceDataNotUsable for a deleted credential, got %v", err)
+	}
+	if list, err = o.List(); err != nil || len(list) != len(credentials)-1 {
+		t.Fatalf("Expected %d credentials after delete, got %d %v", len(credentials)-1, len(list), err)
+	}
+}
+
+func TestOATH_Password(t *testing.T) {
+	sim, o := newSimulatedOATH(t)
+	if o.Locked() {
+		t.Fatal("Expected a new application not to be locked")
+	}
+	if err := o.SetPassword("secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new session has to validate the password
+	sim.Close()
+	o, err := NewCard(sim).OATH()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !o.Locked() {
+		t.Fatal("Expected the application to be locked")
+	}
+	if _, err := o.List(); !errors.Is(err, ErrSecurityStatusNotSatisfied) {
+		t.Fatalf("Expected ErrSecurityStatusNotSatisfied, got %v", err)
+	}
+	if err := o.Validate("wrong"); !errors.Is(err, ErrOathWrongPassword) {
+		t.Fatalf("Expected ErrOathWrongPassword, got %v", err)
+	}
+	if o, err = NewCard(sim).OATH(); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Validate("secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.List(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.SetPassword(""); err != nil {
+		t.Fatal(err)
+	}
+	sim.Close()
+	if o, err = NewCard(sim).OATH(); err != nil || o.Locked() {
+		t.Fatalf("Expected the password to be removed, got %v", err)
+	}
+}
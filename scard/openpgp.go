@@ -0,0 +1,211 @@
+package scard
+
+import (
+	"errors"
+)
+
+// Transmitter sends an APDU to a card and returns the response data without the status word.
+type Transmitter interface {
+	Transmit(apdu APDU) ([]byte, error)
+}
+
+// Pin references a password of the OpenPGP application.
+type Pin byte
+
+const (
+	PW1CDS Pin = 0x81 // user PIN for PSO:COMPUTE DIGITAL SIGNATURE
+	PW1    Pin = 0x82 // user PIN for all other commands
+	PW3    Pin = 0x83 // admin PIN
+)
+
+// KeySlot references a key of the OpenPGP application by its control reference template.
+type KeySlot byte
+
+const (
+	KeySign    KeySlot = 0xB6
+	KeyDecrypt KeySlot = 0xB8
+	KeyAuth    KeySlot = 0xA4
+)
+
+func (k KeySlot) String() string {
+	switch k {
+	case KeySign:
+		return "signature"
+	case KeyDecrypt:
+		return "decryption"
+	case KeyAuth:
+		return "authentication"
+	}
+	return "<unknown>"
+}
+
+var (
+	ErrDataObjectNotFound = errors.New("data object not found")
+	ErrInvalidDataObject  = errors.New("invalid data object")
+)
+
+// OpenPGP provides the commands of the OpenPGP card application.
+// https://gnupg.org/ftp/specs/OpenPGP-smart-card-application-3.4.1.pdf
+type OpenPGP struct {
+	card Transmitter
+}
+
+// NewOpenPGP selects the OpenPGP application on card.
+func NewOpenPGP(card Transmitter) (*OpenPGP, error) {
+	if _, err := card.Transmit(APDU{Cla: 0, Ins: 0xa4, P1: 0x04, P2: 0, Data: AidOpenPGP}); err != nil {
+		return nil, err
+	}
+	return &OpenPGP{card: card}, nil
+}
+
+// OpenPGP selects the OpenPGP application on the card.
+func (c *Card) OpenPGP() (*OpenPGP, error) {
+	return NewOpenPGP(c)
+}
+
+// Verify presents a PIN to the card.
+func (o *OpenPGP) Verify(pin Pin, value []byte) error {
+	_, err := o.card.Transmit(APDU{Cla: 0, Ins: 0x20, P1: 0, P2: byte(pin), Data: value})
+	return err
+}
+
+// ChangePIN replaces the user PIN (PW1) or the admin PIN (PW3) after verifying its current value.
+func (o *OpenPGP) ChangePIN(pin Pin, old, new []byte) error {
+	p2 := byte(PW1CDS) // both user PIN references change PW1
+	if pin == PW3 {
+		p2 = byte(PW3)
+	}
+	_, err := o.card.Transmit(APDU{Cla: 0, Ins: 0x24, P1: 0, P2: p2, Data: concat(old, new...)})
+	return err
+}
+
+// ResetPIN sets a new user PIN and resets its retry counter, e.g. after it has been blocked. PW3 must have been
+// verified.
+func (o *OpenPGP) ResetPIN(new []byte) error {
+	_, err := o.card.Transmit(APDU{Cla: 0, Ins: 0x2c, P1: 0x02, P2: byte(PW1CDS), Data: new})
+	return err
+}
+
+// PutData writes the value of a data object. Most data objects require PW3 to be verified.
+func (o *OpenPGP) PutData(do DataObject, value []byte) error {
+	_, err := o.card.Transmit(APDU{Cla: 0, Ins: 0xda, P1: do.tagP1(), P2: do.tagP2(), Data: value})
+	return err
+}
+
+// GetData returns the value of a data object. Data objects nested in a constructed parent are looked up in the
+// parent first, since not every card allows reading them directly.
+func (o *OpenPGP) GetData(do DataObject) ([]byte, error) {
+	if do.parent != 0 {
+		if parent, ok := findDataObject(do.parent); ok {
+			data, err := o.GetData(parent)
+			if err != nil {
+				return nil, err
+			}
+			if value := doFindTLV(data, do.tag, 0); value != nil {
+				return value, nil
+			}
+		}
+	}
+	return o.card.Transmit(APDU{Cla: 0, Ins: 0xca, P1: do.tagP1(), P2: do.tagP2(), Le: maxShortLe})
+}
+
+// ApplicationRelatedData reads and parses the application related data.
+func (o *OpenPGP) ApplicationRelatedData() (*ApplicationRelatedData, error) {
+	data, err := o.GetData(DoAppRelData)
+	if err != nil {
+		return nil, err
+	}
+	return parseApplicationRelatedData(data)
+}
+
+// CardholderRelatedData reads and parses the cardholder related data.
+func (o *OpenPGP) CardholderRelatedData() (*CardholderRelatedData, error) {
+	data, err := o.GetData(DoCardRelData)
+	if err != nil {
+		return nil, err
+	}
+	return parseCardholderRelatedData(data), nil
+}
+
+// SignatureCounter returns the number of signatures computed with the signature key.
+func (o *OpenPGP) SignatureCounter() (int, error) {
+	data, err := o.GetData(DoDigSigCtr)
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 3 {
+		return 0, ErrInvalidDataObject
+	}
+	return int(data[0])<<16 | int(data[1])<<8 | int(data[2]), nil
+}
+
+// Sign computes a digital signature with the signature key (PSO:COMPUTE DIGITAL SIGNATURE). For RSA keys data is
+// the DigestInfo of the hash, for ECDSA and EdDSA keys the hash or message itself. PW1CDS must have been verified.
+func (o *OpenPGP) Sign(data []byte) ([]byte, error) {
+	return o.card.Transmit(APDU{Cla: 0, Ins: 0x2a, P1: 0x9e, P2: 0x9a, Data: data, Le: maxShortLe})
+}
+
+// DecipherRSA decrypts a PKCS#1 v1.5 cryptogram with the decryption key (PSO:DECIPHER). PW1 must have been verified.
+func (o *OpenPGP) DecipherRSA(cryptogram []byte) ([]byte, error) {
+	return o.decipher(concat([]byte{0x00}, cryptogram...)) // padding indicator byte for RSA
+}
+
+// DecipherECDH returns the shared secret of the decryption key and the given ephemeral public key point
+// (PSO:DECIPHER). PW1 must have been verified.
+func (o *OpenPGP) DecipherECDH(point []byte) ([]byte, error) {
+	return o.decipher(tlv(0xa6, tlv(0x7f49, tlv(0x86, point))))
+}
+
+func (o *OpenPGP) decipher(data []byte) ([]byte, error) {
+	return o.card.Transmit(APDU{Cla: 0, Ins: 0x2a, P1: 0x80, P2: 0x86, Data: data, Le: maxShortLe})
+}
+
+// InternalAuthenticate signs data with the authentication key. PW1 must have been verified.
+func (o *OpenPGP) InternalAuthenticate(data []byte) ([]byte, error) {
+	return o.card.Transmit(APDU{Cla: 0, Ins: 0x88, P1: 0, P2: 0, Data: data, Le: maxShortLe})
+}
+
+// GenerateKeyPair generates a new key pair in slot and returns its public key. PW3 must have been verified.
+func (o *OpenPGP) GenerateKeyPair(slot KeySlot) (*PublicKey, error) {
+	return o.generateAsymmetricKeyPair(0x80, slot)
+}
+
+// PublicKey reads the public key of the key pair in slot.
+func (o *OpenPGP) PublicKey(slot KeySlot) (*PublicKey, error) {
+	return o.generateAsymmetricKeyPair(0x81, slot)
+}
+
+func (o *OpenPGP) generateAsymmetricKeyPair(p1 byte, slot KeySlot) (*PublicKey, error) {
+	data, err := o.card.Transmit(APDU{Cla: 0, Ins: 0x47, P1: p1, P2: 0, Data: []byte{byte(slot), 0x00}, Le: maxShortLe})
+	if err != nil {
+		return nil, err
+	}
+	return parsePublicKey(data)
+}
+
+func findDataObject(tag uint16) (DataObject, bool) {
+	for _, do := range DataObjects {
+		if do.tag == tag {
+			return do, true
+		}
+	}
+	return DataObject{}, false
+}
+
+// tlv encodes a BER-TLV data object with a one or two byte tag.
+func tlv(tag uint16, value []byte) []byte {
+	var b []byte
+	if tag > 0xff {
+		b = append(b, byte(tag>>8))
+	}
+	b = append(b, byte(tag))
+	switch n := len(value); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n <= 0xff:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+	return append(b, value...)
+}
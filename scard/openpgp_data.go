@@ -0,0 +1,402 @@
+package scard
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Algorithm identifies the public key algorithm of a key slot.
+type Algorithm byte
+
+const (
+	AlgoRSA   Algorithm = 0x01
+	AlgoECDH  Algorithm = 0x12
+	AlgoECDSA Algorithm = 0x13
+	AlgoEdDSA Algorithm = 0x16
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case AlgoRSA:
+		return "RSA"
+	case AlgoECDH:
+		return "ECDH"
+	case AlgoECDSA:
+		return "ECDSA"
+	case AlgoEdDSA:
+		return "EdDSA"
+	}
+	return "<unknown>"
+}
+
+var (
+	OidP256       = []byte{0x2a, 0x86, 0x48, 0xce, 0x3d, 0x03, 0x01, 0x07}
+	OidP384       = []byte{0x2b, 0x81, 0x04, 0x00, 0x22}
+	OidP521       = []byte{0x2b, 0x81, 0x04, 0x00, 0x23}
+	OidEd25519    = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0xda, 0x47, 0x0f, 0x01}
+	OidCurve25519 = []byte{0x2b, 0x06, 0x01, 0x04, 0x01, 0x97, 0x55, 0x01, 0x05, 0x01}
+)
+
+var curveNames = map[string]string{
+	string(OidP256):       "P-256",
+	string(OidP384):       "P-384",
+	string(OidP521):       "P-521",
+	string(OidEd25519):    "Ed25519",
+	string(OidCurve25519): "Curve25519",
+}
+
+// AlgorithmAttributes describes the key type of a key slot.
+type AlgorithmAttributes struct {
+	Algorithm    Algorithm
+	ModulusBits  uint16 // RSA only
+	ExponentBits uint16 // RSA only
+	ImportFormat byte
+	OID          []byte // ECC only
+}
+
+func parseAlgorithmAttributes(data []byte) (AlgorithmAttributes, error) {
+	if len(data) < 1 {
+		return AlgorithmAttributes{}, ErrInvalidDataObject
+	}
+	attrs := AlgorithmAttributes{Algorithm: Algorithm(data[0])}
+	switch attrs.Algorithm {
+	case AlgoRSA:
+		if len(data) < 5 {
+			return AlgorithmAttributes{}, ErrInvalidDataObject
+		}
+		attrs.ModulusBits = binary.BigEndian.Uint16(data[1:3])
+		attrs.ExponentBits = binary.BigEndian.Uint16(data[3:5])
+		if len(data) > 5 {
+			attrs.ImportFormat = data[5]
+		}
+	default:
+		attrs.OID = data[1:]
+		if n := len(attrs.OID); n > 0 && attrs.OID[n-1] == 0xff {
+			attrs.OID, attrs.ImportFormat = attrs.OID[:n-1], 0xff
+		}
+	}
+	return attrs, nil
+}
+
+// Bytes encodes the attributes as stored in the algorithm attributes data objects (C1 to C3).
+func (a AlgorithmAttributes) Bytes() []byte {
+	if a.Algorithm == AlgoRSA {
+		b := []byte{byte(a.Algorithm)}
+		b = binary.BigEndian.AppendUint16(b, a.ModulusBits)
+		b = binary.BigEndian.AppendUint16(b, a.ExponentBits)
+		return append(b, a.ImportFormat)
+	}
+	b := concat([]byte{byte(a.Algorithm)}, a.OID...)
+	if a.ImportFormat == 0xff {
+		b = append(b, 0xff)
+	}
+	return b
+}
+
+// Curve returns the name of the elliptic curve or an empty string for RSA keys.
+func (a AlgorithmAttributes) Curve() string {
+	if a.Algorithm == AlgoRSA {
+		return ""
+	}
+	if name, ok := curveNames[string(a.OID)]; ok {
+		return name
+	}
+	return "<unknown>"
+}
+
+func (a AlgorithmAttributes) String() string {
+	if a.Algorithm == AlgoRSA {
+		return fmt.Sprintf("RSA-%d", a.ModulusBits)
+	}
+	return a.Algorithm.String() + " " + a.Curve()
+}
+
+// ApplicationIdentifier is the parsed AID of an OpenPGP card, carrying version, manufacturer and serial number.
+type ApplicationIdentifier struct {
+	Raw          []byte
+	Version      [2]byte
+	Manufacturer uint16
+	Serial       uint32
+}
+
+func parseApplicationIdentifier(data []byte) (ApplicationIdentifier, error) {
+	if len(data) != 16 || !bytes.HasPrefix(data, AidOpenPGP) {
+		return ApplicationIdentifier{}, ErrInvalidDataObject
+	}
+	return ApplicationIdentifier{
+		Raw:          data,
+		Version:      [2]byte{data[6], data[7]},
+		Manufacturer: binary.BigEndian.Uint16(data[8:10]),
+		Serial:       binary.BigEndian.Uint32(data[10:14]),
+	}, nil
+}
+
+// SerialNumber returns the serial number as printed by GnuPG.
+func (a ApplicationIdentifier) SerialNumber() string {
+	return fmt.Sprintf("%08X", a.Serial)
+}
+
+func (a ApplicationIdentifier) String() string {
+	return fmt.Sprintf("%X", a.Raw)
+}
+
+// ExtendedCapabilities lists the optional features supported by the card.
+type ExtendedCapabilities struct {
+	SecureMessaging       bool
+	GetChallenge          bool
+	KeyImport             bool
+	PWStatusChangeable    bool
+	PrivateDOs            bool
+	AlgorithmChangeable   bool
+	AES                   bool
+	KDF                   bool
+	MaxChallengeLength    uint16
+	MaxCertificateLength  uint16
+	MaxSpecialDOLength    uint16
+	PinBlock2Format       bool
+	ManageSecurityEnviron bool
+}
+
+func parseExtendedCapabilities(data []byte) (ExtendedCapabilities, error) {
+	if len(data) < 8 {
+		return ExtendedCapabilities{}, ErrInvalidDataObject
+	}
+	caps := ExtendedCapabilities{
+		SecureMessaging:      data[0]&0x80 != 0,
+		GetChallenge:         data[0]&0x40 != 0,
+		KeyImport:            data[0]&0x20 != 0,
+		PWStatusChangeable:   data[0]&0x10 != 0,
+		PrivateDOs:           data[0]&0x08 != 0,
+		AlgorithmChangeable:  data[0]&0x04 != 0,
+		AES:                  data[0]&0x02 != 0,
+		KDF:                  data[0]&0x01 != 0,
+		MaxChallengeLength:   binary.BigEndian.Uint16(data[2:4]),
+		MaxCertificateLength: binary.BigEndian.Uint16(data[4:6]),
+		MaxSpecialDOLength:   binary.BigEndian.Uint16(data[6:8]),
+	}
+	if len(data) >= 10 {
+		caps.PinBlock2Format = data[8] == 1
+		caps.ManageSecurityEnviron = data[9] == 1
+	}
+	return caps, nil
+}
+
+// PWStatus holds the PIN policy and the remaining retries of the card passwords.
+type PWStatus struct {
+	PW1ValidMultiple bool // PW1CDS stays valid for more than one signature
+	PW1MaxLength     int
+	RCMaxLength      int
+	PW3MaxLength     int
+	PW1Retries       int
+	RCRetries        int
+	PW3Retries       int
+}
+
+func parsePWStatus(data []byte) (PWStatus, error) {
+	if len(data) < 7 {
+		return PWStatus{}, ErrInvalidDataObject
+	}
+	return PWStatus{
+		PW1ValidMultiple: data[0] == 0x01,
+		PW1MaxLength:     int(data[1] & 0x7f),
+		RCMaxLength:      int(data[2]),
+		PW3MaxLength:     int(data[3] & 0x7f),
+		PW1Retries:       int(data[4]),
+		RCRetries:        int(data[5]),
+		PW3Retries:       int(data[6]),
+	}, nil
+}
+
+// KeyInfo describes the key in one slot of the card.
+type KeyInfo struct {
+	Attributes    AlgorithmAttributes
+	Fingerprint   []byte
+	CAFingerprint []byte
+	Generated     time.Time // zero if unknown
+	Touch         byte      // user interaction flag, 0 if disabled or unsupported
+}
+
+// Present reports whether a key has been generated or imported into the slot.
+func (k KeyInfo) Present() bool {
+	return len(k.Fingerprint) > 0 && !bytes.Equal(k.Fingerprint, make([]byte, len(k.Fingerprint)))
+}
+
+// ApplicationRelatedData is the parsed application related data object (6E).
+type ApplicationRelatedData struct {
+	AID                  ApplicationIdentifier
+	HistoricalBytes      []byte
+	ExtendedCapabilities ExtendedCapabilities
+	PWStatus             PWStatus
+	Sign                 KeyInfo
+	Decrypt              KeyInfo
+	Auth                 KeyInfo
+}
+
+// Key returns the information about the key in slot.
+func (d *ApplicationRelatedData) Key(slot KeySlot) KeyInfo {
+	switch slot {
+	case KeySign:
+		return d.Sign
+	case KeyDecrypt:
+		return d.Decrypt
+	default:
+		return d.Auth
+	}
+}
+
+func parseApplicationRelatedData(data []byte) (*ApplicationRelatedData, error) {
+	var err error
+	ard := &ApplicationRelatedData{}
+	if ard.AID, err = parseApplicationIdentifier(doFindTLV(data, DoAID.tag, 0)); err != nil {
+		return nil, err
+	}
+	ard.HistoricalBytes = doFindTLV(data, DoHistBytes.tag, 0)
+	if ard.ExtendedCapabilities, err = parseExtendedCapabilities(doFindTLV(data, DoExtLenCaps.tag, 0)); err != nil {
+		return nil, err
+	}
+	if ard.PWStatus, err = parsePWStatus(doFindTLV(data, DoPWStatus.tag, 0)); err != nil {
+		return nil, err
+	}
+
+	fingerprints := doFindTLV(data, DoFingerprints.tag, 0)
+	caFingerprints := doFindTLV(data, DoCAFingerprints.tag, 0)
+	dates := doFindTLV(data, DoKeyGenDate.tag, 0)
+	for i, key := range []struct {
+		info *KeyInfo
+		attr DataObject
+		uif  DataObject
+	}{
+		{&ard.Sign, DoAlgoAttrSign, DoUIFSig},
+		{&ard.Decrypt, DoAlgoAttrEnc, DoUIFDec},
+		{&ard.Auth, DoAlgoAttrAuth, DoUIFAut},
+	} {
+		if key.info.Attributes, err = parseAlgorithmAttributes(doFindTLV(data, key.attr.tag, 0)); err != nil {
+			return nil, err
+		}
+		if len(fingerprints) >= 20*(i+1) {
+			key.info.Fingerprint = fingerprints[20*i : 20*(i+1)]
+		}
+		if len(caFingerprints) >= 20*(i+1) {
+			key.info.CAFingerprint = caFingerprints[20*i : 20*(i+1)]
+		}
+		if len(dates) >= 4*(i+1) {
+			if t := binary.BigEndian.Uint32(dates[4*i : 4*(i+1)]); t != 0 {
+				key.info.Generated = time.Unix(int64(t), 0).UTC()
+			}
+		}
+		if uif := doFindTLV(data, key.uif.tag, 0); len(uif) > 0 {
+			key.info.Touch = uif[0]
+		}
+	}
+	return ard, nil
+}
+
+// CardholderRelatedData is the parsed cardholder related data object (65).
+type CardholderRelatedData struct {
+	Name       string
+	Language   string
+	Salutation byte // ISO 5218: '1' male, '2' female, '9' not applicable
+}
+
+func parseCardholderRelatedData(data []byte) *CardholderRelatedData {
+	crd := &CardholderRelatedData{
+		Name:     string(doFindTLV(data, DoName.tag, 0)),
+		Language: string(doFindTLV(data, DoLangPrefs.tag, 0)),
+	}
+	if salutation := doFindTLV(data, DoSalutation.tag, 0); len(salutation) > 0 {
+		crd.Salutation = salutation[0]
+	}
+	return crd
+}
+
+// PublicKey is the public part of a key pair returned by GENERATE ASYMMETRIC KEY PAIR.
+type PublicKey struct {
+	Modulus  []byte // RSA only
+	Exponent []byte // RSA only
+	Point    []byte // ECC only
+}
+
+func parsePublicKey(data []byte) (*PublicKey, error) {
+	template := doFindTLV(data, 0x7f49, 0)
+	if template == nil {
+		return nil, ErrInvalidDataObject
+	}
+	key := &PublicKey{
+		Modulus:  doFindTLV(template, 0x81, 0),
+		Exponent: doFindTLV(template, 0x82, 0),
+		Point:    doFindTLV(template, 0x86, 0),
+	}
+	if key.Point == nil && (key.Modulus == nil || key.Exponent == nil) {
+		return nil, ErrInvalidDataObject
+	}
+	return key, nil
+}
+
+// CryptoPublicKey converts the key to an *rsa.PublicKey, *ecdsa.PublicKey, *ecdh.PublicKey or ed25519.PublicKey
+// according to the algorithm attributes of its slot.
+func (k *PublicKey) CryptoPublicKey(attrs AlgorithmAttributes) (crypto.PublicKey, error) {
+	switch attrs.Algorithm {
+	case AlgoRSA:
+		if k.Modulus == nil || k.Exponent == nil {
+			return nil, ErrInvalidDataObject
+		}
+		e := new(big.Int).SetBytes(k.Exponent)
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("unsupported RSA exponent: %s", e)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(k.Modulus), E: int(e.Int64())}, nil
+	case AlgoEdDSA:
+		if len(k.Point) != ed25519.PublicKeySize {
+			return nil, ErrInvalidDataObject
+		}
+		return ed25519.PublicKey(k.Point), nil
+	case AlgoECDSA:
+		curve, err := ecdsaCurve(attrs)
+		if err != nil {
+			return nil, err
+		}
+		return ecdsa.ParseUncompressedPublicKey(curve, k.Point)
+	case AlgoECDH:
+		curve, err := ecdhCurve(attrs)
+		if err != nil {
+			return nil, err
+		}
+		return curve.NewPublicKey(k.Point)
+	}
+	return nil, fmt.Errorf("unsupported algorithm: %s", attrs.Algorithm)
+}
+
+func ecdsaCurve(attrs AlgorithmAttributes) (elliptic.Curve, error) {
+	switch attrs.Curve() {
+	case "P-256":
+		return elliptic.P256(), nil
+	case "P-384":
+		return elliptic.P384(), nil
+	case "P-521":
+		return elliptic.P521(), nil
+	}
+	return nil, fmt.Errorf("unsupported curve: %s", attrs.Curve())
+}
+
+func ecdhCurve(attrs AlgorithmAttributes) (ecdh.Curve, error) {
+	switch attrs.Curve() {
+	case "P-256":
+		return ecdh.P256(), nil
+	case "P-384":
+		return ecdh.P384(), nil
+	case "P-521":
+		return ecdh.P521(), nil
+	case "Curve25519":
+		return ecdh.X25519(), nil
+	}
+	return nil, fmt.Errorf("unsupported curve: %s", attrs.Curve())
+}
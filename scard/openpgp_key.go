@@ -0,0 +1,145 @@
+package scard
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+var ErrKeyNotPresent = errors.New("key not present")
+
+// digestInfoPrefixes are the DER encoded DigestInfo headers that precede the hash in PKCS#1 v1.5 signatures.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA224: {0x30, 0x2d, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x04, 0x05, 0x00, 0x04, 0x1c},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// Key is a private key held in a key slot of the OpenPGP application. It implements crypto.Signer for the signature
+// and authentication keys, and crypto.Decrypter (RSA) or Decaps (ECDH) for the decryption key, so that the private
+// key operations are carried out by the card. The PIN required by the slot must have been verified before use.
+type Key struct {
+	card       *OpenPGP
+	slot       KeySlot
+	attributes AlgorithmAttributes
+	public     crypto.PublicKey
+}
+
+// Key reads the algorithm attributes and the public key of the key pair in slot.
+func (o *OpenPGP) Key(slot KeySlot) (*Key, error) {
+	data, err := o.ApplicationRelatedData()
+	if err != nil {
+		return nil, err
+	}
+	info := data.Key(slot)
+	if !info.Present() {
+		return nil, fmt.Errorf("%s %w", slot, ErrKeyNotPresent)
+	}
+	pub, err := o.PublicKey(slot)
+	if err != nil {
+		return nil, err
+	}
+	public, err := pub.CryptoPublicKey(info.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{card: o, slot: slot, attributes: info.Attributes, public: public}, nil
+}
+
+// Slot returns the key slot of the key.
+func (k *Key) Slot() KeySlot {
+	return k.slot
+}
+
+// Attributes returns the algorithm attributes of the key.
+func (k *Key) Attributes() AlgorithmAttributes {
+	return k.attributes
+}
+
+// Public returns the public key of the key pair.
+func (k *Key) Public() crypto.PublicKey {
+	return k.public
+}
+
+// Sign signs digest with the signature or authentication key. RSA keys sign the PKCS#1 v1.5 DigestInfo of digest,
+// ECDSA signatures are returned ASN.1 encoded and EdDSA keys sign digest itself as the message (opts.HashFunc()
+// must be zero).
+func (k *Key) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	data := digest
+	switch k.attributes.Algorithm {
+	case AlgoRSA:
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash function: %s", opts.HashFunc())
+		}
+		data = concat(prefix, digest...)
+	case AlgoECDSA:
+	case AlgoEdDSA:
+		if opts.HashFunc() != 0 {
+			return nil, errors.New("EdDSA signs the message, not a hash")
+		}
+	default:
+		return nil, fmt.Errorf("%s key cannot sign", k.attributes.Algorithm)
+	}
+
+	var sig []byte
+	var err error
+	switch k.slot {
+	case KeySign:
+		sig, err = k.card.Sign(data)
+	case KeyAuth:
+		sig, err = k.card.InternalAuthenticate(data)
+	default:
+		return nil, fmt.Errorf("%s key cannot sign", k.slot)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if k.attributes.Algorithm == AlgoECDSA {
+		// the card returns r || s
+		if len(sig) == 0 || len(sig)%2 != 0 {
+			return nil, ErrInvalidDataObject
+		}
+		n := len(sig) / 2
+		return asn1.Marshal(struct{ R, S *big.Int }{
+			R: new(big.Int).SetBytes(sig[:n]),
+			S: new(big.Int).SetBytes(sig[n:]),
+		})
+	}
+	return sig, nil
+}
+
+// Decrypt decrypts a PKCS#1 v1.5 cryptogram with the RSA decryption key. opts is ignored.
+func (k *Key) Decrypt(_ io.Reader, msg []byte, _ crypto.DecrypterOpts) ([]byte, error) {
+	if k.slot != KeyDecrypt || k.attributes.Algorithm != AlgoRSA {
+		return nil, fmt.Errorf("%s %s key cannot decrypt", k.attributes.Algorithm, k.slot)
+	}
+	return k.card.DecipherRSA(msg)
+}
+
+// Decaps returns the shared secret of the ECDH decryption key and an ephemeral public key: the u-coordinate for
+// Curve25519 and the x-coordinate for NIST curves. The ephemeral point is expected in its native encoding.
+func (k *Key) Decaps(ephemeral []byte) ([]byte, error) {
+	if k.slot != KeyDecrypt || k.attributes.Algorithm != AlgoECDH {
+		return nil, fmt.Errorf("%s %s key cannot decrypt", k.attributes.Algorithm, k.slot)
+	}
+	secret, err := k.card.DecipherECDH(ephemeral)
+	if err != nil {
+		return nil, err
+	}
+	// some cards return the complete uncompressed point for NIST curves
+	if pub, ok := k.public.(*ecdh.PublicKey); ok && pub.Curve() != ecdh.X25519() {
+		size := (len(pub.Bytes()) - 1) / 2
+		if len(secret) == 1+2*size && secret[0] == 0x04 {
+			secret = secret[1 : 1+size]
+		}
+	}
+	return secret, nil
+}
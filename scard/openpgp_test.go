@@ -0,0 +1,377 @@
+package scard
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"testing"
+	"time"
+)
+
+// exchange is one scripted command and the response of the card to it.
+type exchange struct {
+	cmd  APDU
+	resp []byte
+	err  error
+}
+
+// scriptedCard replays a fixed sequence of exchanges and fails the test on any unexpected command.
+type scriptedCard struct {
+	t      *testing.T
+	script []exchange
+}
+
+func (c *scriptedCard) Transmit(apdu APDU) ([]byte, error) {
+	c.t.Helper()
+	if len(c.script) == 0 {
+		c.t.Fatalf("Unexpected command %02X %02X %02X %02X", apdu.Cla, apdu.Ins, apdu.P1, apdu.P2)
+	}
+	next := c.script[0]
+	c.script = c.script[1:]
+	if apdu.Cla != next.cmd.Cla || apdu.Ins != next.cmd.Ins || apdu.P1 != next.cmd.P1 || apdu.P2 != next.cmd.P2 ||
+		!bytes.Equal(apdu.Data, next.cmd.Data) {
+		c.t.Fatalf("Unexpected command %+v, expected %+v", apdu, next.cmd)
+	}
+	return next.resp, next.err
+}
+
+func (c *scriptedCard) done() {
+	c.t.Helper()
+	if len(c.script) > 0 {
+		c.t.Fatalf("%d scripted commands were not sent", len(c.script))
+	}
+}
+
+func newScriptedOpenPGP(t *testing.T, script ...exchange) (*OpenPGP, *scriptedCard) {
+	card := &scriptedCard{t: t, script: append([]exchange{
+		{cmd: APDU{Ins: 0xa4, P1: 0x04, Data: AidOpenPGP}},
+	}, script...)}
+	o, err := NewOpenPGP(card)
+	if err != nil {
+		t.Fatalf("Failed to select OpenPGP application: %s", err)
+	}
+	return o, card
+}
+
+var (
+	testAID         = []byte{0xd2, 0x76, 0x00, 0x01, 0x24, 0x01, 0x03, 0x04, 0x00, 0x06, 0x12, 0x34, 0x56, 0x78, 0x00, 0x00}
+	testFingerprint = bytes.Repeat([]byte{0xab}, 20)
+)
+
+func testApplicationRelatedData() []byte {
+	algoSign := []byte{0x01, 0x08, 0x00, 0x00, 0x11, 0x00}
+	algoDec := append([]byte{byte(AlgoECDH)}, OidCurve25519...)
+	algoAuth := append(append([]byte{byte(AlgoEdDSA)}, OidEd25519...), 0xff)
+	fingerprints := append(append(append([]byte{}, testFingerprint...), make([]byte, 20)...), testFingerprint...)
+	dates := []byte{0x5f, 0x5e, 0x10, 0x00, 0, 0, 0, 0, 0x5f, 0x5e, 0x10, 0x00}
+
+	discretionary := bytes.Join([][]byte{
+		tlv(0xc0, []byte{0x7d, 0x00, 0x0b, 0xfe, 0x08, 0x00, 0x00, 0xff, 0x00, 0x00}),
+		tlv(0xc1, algoSign),
+		tlv(0xc2, algoDec),
+		tlv(0xc3, algoAuth),
+		tlv(0xc4, []byte{0x00, 0x7f, 0x7f, 0x7f, 0x03, 0x00, 0x02}),
+		tlv(0xc5, fingerprints),
+		tlv(0xc6, make([]byte, 60)),
+		tlv(0xcd, dates),
+		tlv(0xd6, []byte{0x01, 0x20}),
+	}, nil)
+	return tlv(0x6e, bytes.Join([][]byte{
+		tlv(0x4f, testAID),
+		tlv(0x5f52, []byte{0x00, 0x73, 0x00, 0x00, 0xe0, 0x05, 0x90, 0x00}),
+		tlv(0x73, discretionary),
+	}, nil))
+}
+
+func TestOpenPGP_ApplicationRelatedData(t *testing.T) {
+	o, card := newScriptedOpenPGP(t,
+		exchange{cmd: APDU{Ins: 0xca, P1: 0x00, P2: 0x6e}, resp: testApplicationRelatedData()},
+	)
+	ard, err := o.ApplicationRelatedData()
+	if err != nil {
+		t.Fatalf("Failed to get application related data: %s", err)
+	}
+	card.done()
+
+	if ard.AID.SerialNumber() != "12345678" || ard.AID.Manufacturer != 0x0006 || ard.AID.Version != [2]byte{3, 4} {
+		t.Fatalf("Unexpected AID: %+v", ard.AID)
+	}
+	if !ard.ExtendedCapabilities.KeyImport || ard.ExtendedCapabilities.SecureMessaging ||
+		ard.ExtendedCapabilities.MaxCertificateLength != 0x0800 {
+		t.Fatalf("Unexpected extended capabilities: %+v", ard.ExtendedCapabilities)
+	}
+	if ard.PWStatus.PW1Retries != 3 || ard.PWStatus.RCRetries != 0 || ard.PWStatus.PW3Retries != 2 ||
+		ard.PWStatus.PW1ValidMultiple || ard.PWStatus.PW1MaxLength != 127 {
+		t.Fatalf("Unexpected PW status: %+v", ard.PWStatus)
+	}
+	if s := ard.Sign.Attributes.String(); s != "RSA-2048" {
+		t.Fatalf("Unexpected signature key attributes: %s", s)
+	}
+	if s := ard.Key(KeyDecrypt).Attributes.String(); s != "ECDH Curve25519" {
+		t.Fatalf("Unexpected decryption key attributes: %s", s)
+	}
+	if attrs := ard.Auth.Attributes; attrs.Curve() != "Ed25519" || attrs.ImportFormat != 0xff {
+		t.Fatalf("Unexpected authentication key attributes: %+v", attrs)
+	}
+	if !ard.Sign.Present() || ard.Decrypt.Present() || !bytes.Equal(ard.Auth.Fingerprint, testFingerprint) {
+		t.Fatalf("Unexpected fingerprints: %x %x %x", ard.Sign.Fingerprint, ard.Decrypt.Fingerprint, ard.Auth.Fingerprint)
+	}
+	if !ard.Sign.Generated.Equal(time.Unix(0x5f5e1000, 0)) || !ard.Decrypt.Generated.IsZero() {
+		t.Fatalf("Unexpected generation dates: %s %s", ard.Sign.Generated, ard.Decrypt.Generated)
+	}
+	if ard.Sign.Touch != 0x01 || ard.Decrypt.Touch != 0x00 {
+		t.Fatalf("Unexpected touch policies: %d %d", ard.Sign.Touch, ard.Decrypt.Touch)
+	}
+}
+
+func TestOpenPGP_GetData(t *testing.T) {
+	crd := tlv(0x65, bytes.Join([][]byte{
+		tlv(0x5b, []byte("Doe<<John")),
+		tlv(0x5f2d, []byte("deen")),
+		tlv(0x5f35, []byte{'9'}),
+	}, nil))
+	o, card := newScriptedOpenPGP(t,
+		exchange{cmd: APDU{Ins: 0xca, P1: 0x00, P2: 0x65}, resp: crd},
+		exchange{cmd: APDU{Ins: 0xca, P1: 0x00, P2: 0x65}, resp: crd},
+		exchange{cmd: APDU{Ins: 0xca, P1: 0x00, P2: 0x7a}, resp: tlv(0x7a, tlv(0x93, []byte{0x00, 0x01, 0x02}))},
+		exchange{cmd: APDU{Ins: 0xca, P1: 0x5f, P2: 0x50}, resp: []byte("https://example.com/key.asc")},
+		exchange{cmd: APDU{Ins: 0xca, P1: 0x00, P2: 0x6e}, resp: testApplicationRelatedData()},
+		exchange{cmd: APDU{Ins: 0xca, P1: 0x00, P2: 0xd9}, err: ErrReferenceNotFound},
+	)
+
+	name, err := o.GetData(DoName)
+	if err != nil || string(name) != "Doe<<John" {
+		t.Fatalf("Unexpected name %q: %v", name, err)
+	}
+	holder, err := o.CardholderRelatedData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder.Name != "Doe<<John" || holder.Language != "deen" || holder.Salutation != '9' {
+		t.Fatalf("Unexpected cardholder related data: %+v", holder)
+	}
+	counter, err := o.SignatureCounter()
+	if err != nil || counter != 258 {
+		t.Fatalf("Unexpected signature counter %d: %v", counter, err)
+	}
+	url, err := o.GetData(DoURL)
+	if err != nil || string(url) != "https://example.com/key.asc" {
+		t.Fatalf("Unexpected URL %q: %v", url, err)
+	}
+	// not part of the application related data, so it is requested directly
+	if _, err := o.GetData(DoUIFAtt); !errors.Is(err, ErrReferenceNotFound) {
+		t.Fatalf("Expected ErrReferenceNotFound, got %v", err)
+	}
+	card.done()
+}
+
+func TestOpenPGP_Operations(t *testing.T) {
+	digestInfo := []byte{0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01}
+	point := bytes.Repeat([]byte{0x42}, 32)
+	o, card := newScriptedOpenPGP(t,
+		exchange{cmd: APDU{Ins: 0x20, P2: 0x81, Data: []byte("123456")}, err: ErrSecurityStatusNotSatisfied},
+		exchange{cmd: APDU{Ins: 0x20, P2: 0x81, Data: []byte("654321")}},
+		exchange{cmd: APDU{Ins: 0x2a, P1: 0x9e, P2: 0x9a, Data: digestInfo}, resp: []byte{0x01, 0x02}},
+		exchange{cmd: APDU{Ins: 0x2a, P1: 0x80, P2: 0x86, Data: []byte{0x00, 0xc0, 0xff, 0xee}}, resp: []byte("session key")},
+		exchange{cmd: APDU{Ins: 0x2a, P1: 0x80, P2: 0x86, Data: concat([]byte{0xa6, 0x25, 0x7f, 0x49, 0x22, 0x86, 0x20}, point...)}, resp: []byte("shared secret")},
+		exchange{cmd: APDU{Ins: 0x88, Data: []byte("challenge")}, resp: []byte{0x03, 0x04}},
+	)
+
+	if err := o.Verify(PW1CDS, []byte("123456")); !errors.Is(err, ErrSecurityStatusNotSatisfied) {
+		t.Fatalf("Expected ErrSecurityStatusNotSatisfied, got %v", err)
+	}
+	if err := o.Verify(PW1CDS, []byte("654321")); err != nil {
+		t.Fatal(err)
+	}
+	if sig, err := o.Sign(digestInfo); err != nil || !bytes.Equal(sig, []byte{0x01, 0x02}) {
+		t.Fatalf("Unexpected signature %x: %v", sig, err)
+	}
+	if key, err := o.DecipherRSA([]byte{0xc0, 0xff, 0xee}); err != nil || string(key) != "session key" {
+		t.Fatalf("Unexpected plaintext %q: %v", key, err)
+	}
+	if secret, err := o.DecipherECDH(point); err != nil || string(secret) != "shared secret" {
+		t.Fatalf("Unexpected shared secret %q: %v", secret, err)
+	}
+	if sig, err := o.InternalAuthenticate([]byte("challenge")); err != nil || !bytes.Equal(sig, []byte{0x03, 0x04}) {
+		t.Fatalf("Unexpected authentication signature %x: %v", sig, err)
+	}
+	card.done()
+}
+
+func TestOpenPGP_GenerateKeyPair(t *testing.T) {
+	modulus := append([]byte{0xc5}, bytes.Repeat([]byte{0x11}, 255)...)
+	point := bytes.Repeat([]byte{0x24}, 32)
+	o, card := newScriptedOpenPGP(t,
+		exchange{cmd: APDU{Ins: 0x47, P1: 0x80, Data: []byte{0xb6, 0x00}},
+			resp: tlv(0x7f49, concat(tlv(0x81, modulus), tlv(0x82, []byte{0x01, 0x00, 0x01})...))},
+		exchange{cmd: APDU{Ins: 0x47, P1: 0x81, Data: []byte{0xa4, 0x00}}, resp: tlv(0x7f49, tlv(0x86, point))},
+		exchange{cmd: APDU{Ins: 0x47, P1: 0x81, Data: []byte{0xb8, 0x00}}, err: ErrReferenceNotFound},
+	)
+
+	key, err := o.GenerateKeyPair(KeySign)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %s", err)
+	}
+	pub, err := key.CryptoPublicKey(AlgorithmAttributes{Algorithm: AlgoRSA, ModulusBits: 2048})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsaKey := pub.(*rsa.PublicKey); rsaKey.E != 65537 || rsaKey.N.BitLen() != 2048 {
+		t.Fatalf("Unexpected RSA key: e=%d, %d bits", rsaKey.E, rsaKey.N.BitLen())
+	}
+
+	key, err = o.PublicKey(KeyAuth)
+	if err != nil {
+		t.Fatalf("Failed to read public key: %s", err)
+	}
+	pub, err = key.CryptoPublicKey(AlgorithmAttributes{Algorithm: AlgoEdDSA, OID: OidEd25519})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pub.(ed25519.PublicKey), point) {
+		t.Fatalf("Unexpected Ed25519 key: %x", pub)
+	}
+
+	if _, err := o.PublicKey(KeyDecrypt); !errors.Is(err, ErrReferenceNotFound) {
+		t.Fatalf("Expected ErrReferenceNotFound, got %v", err)
+	}
+	card.done()
+}
+
+func TestOpenPGP_Key(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modulus := append([]byte{0xc5}, bytes.Repeat([]byte{0x11}, 255)...)
+	digest := sha256.Sum256([]byte("message"))
+	message := []byte("message")
+	o, card := newScriptedOpenPGP(t,
+		exchange{cmd: APDU{Ins: 0xca, P1: 0x00, P2: 0x6e}, resp: testApplicationRelatedData()},
+		exchange{cmd: APDU{Ins: 0x47, P1: 0x81, Data: []byte{0xb6, 0x00}},
+			resp: tlv(0x7f49, concat(tlv(0x81, modulus), tlv(0x82, []byte{0x01, 0x00, 0x01})...))},
+		exchange{cmd: APDU{Ins: 0x2a, P1: 0x9e, P2: 0x9a, Data: concat(digestInfoPrefixes[crypto.SHA256], digest[:]...)},
+			resp: []byte{0x01, 0x02}},
+		exchange{cmd: APDU{Ins: 0xca, P1: 0x00, P2: 0x6e}, resp: testApplicationRelatedData()},
+		exchange{cmd: APDU{Ins: 0xca, P1: 0x00, P2: 0x6e}, resp: testApplicationRelatedData()},
+		exchange{cmd: APDU{Ins: 0x47, P1: 0x81, Data: []byte{0xa4, 0x00}},
+			resp: tlv(0x7f49, tlv(0x86, priv.Public().(ed25519.PublicKey)))},
+		exchange{cmd: APDU{Ins: 0x88, Data: message}, resp: ed25519.Sign(priv, message)},
+	)
+
+	key, err := o.Key(KeySign)
+	if err != nil {
+		t.Fatalf("Failed to read signature key: %s", err)
+	}
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		t.Fatalf("Unexpected public key %T", key.Public())
+	}
+	if sig, err := key.Sign(nil, digest[:], crypto.SHA256); err != nil || !bytes.Equal(sig, []byte{0x01, 0x02}) {
+		t.Fatalf("Unexpected signature %x: %v", sig, err)
+	}
+
+	if _, err := o.Key(KeyDecrypt); !errors.Is(err, ErrKeyNotPresent) {
+		t.Fatalf("Expected ErrKeyNotPresent, got %v", err)
+	}
+
+	key, err = o.Key(KeyAuth)
+	if err != nil {
+		t.Fatalf("Failed to read authentication key: %s", err)
+	}
+	if _, err := key.Sign(nil, digest[:], crypto.SHA256); err == nil {
+		t.Fatal("Expected EdDSA signature of a hash to fail")
+	}
+	sig, err := key.Sign(nil, message, crypto.Hash(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(key.Public().(ed25519.PublicKey), message, sig) {
+		t.Fatal("Failed to verify EdDSA signature")
+	}
+	card.done()
+}
+
+func TestKey_ECDSA(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("message"))
+	r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, card := newScriptedOpenPGP(t,
+		exchange{cmd: APDU{Ins: 0x2a, P1: 0x9e, P2: 0x9a, Data: digest[:]}, resp: concat(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)},
+	)
+	key := &Key{card: o, slot: KeySign, attributes: AlgorithmAttributes{Algorithm: AlgoECDSA, OID: OidP256}, public: &priv.PublicKey}
+	sig, err := key.Sign(nil, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&priv.PublicKey, digest[:], sig) {
+		t.Fatal("Failed to verify ECDSA signature")
+	}
+	card.done()
+}
+
+func TestKey_Decaps(t *testing.T) {
+	priv, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ephemeral, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	point := ephemeral.PublicKey().Bytes()
+	shared, err := ephemeral.ECDH(priv.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// cards return either the x-coordinate or the full point
+	full := concat([]byte{0x04}, append(shared, bytes.Repeat([]byte{0x55}, 32)...)...)
+	o, card := newScriptedOpenPGP(t,
+		exchange{cmd: APDU{Ins: 0x2a, P1: 0x80, P2: 0x86, Data: tlv(0xa6, tlv(0x7f49, tlv(0x86, point)))}, resp: shared},
+		exchange{cmd: APDU{Ins: 0x2a, P1: 0x80, P2: 0x86, Data: tlv(0xa6, tlv(0x7f49, tlv(0x86, point)))}, resp: full},
+	)
+	key := &Key{card: o, slot: KeyDecrypt, attributes: AlgorithmAttributes{Algorithm: AlgoECDH, OID: OidP256}, public: priv.PublicKey()}
+	for range 2 {
+		if got, err := key.Decaps(point); err != nil || !bytes.Equal(got, shared) {
+			t.Fatalf("Unexpected shared secret %x: %v", got, err)
+		}
+	}
+	if _, err := key.Decrypt(nil, point, nil); err == nil {
+		t.Fatal("Expected RSA decryption with an ECDH key to fail")
+	}
+	card.done()
+}
+
+func TestDoFindTLV(t *testing.T) {
+	long := bytes.Repeat([]byte{0x55}, 200)
+	data := tlv(0x6e, bytes.Join([][]byte{
+		tlv(0x4f, []byte{0x01}),
+		tlv(0x7f21, tlv(0x5b, long)),
+		tlv(0xc4, []byte{0x02}),
+	}, nil))
+
+	if value := doFindTLV(data, 0x5b, 0); !bytes.Equal(value, long) {
+		t.Fatalf("Failed to find value with one byte length field: %x", value)
+	}
+	if value := doFindTLV(data, 0xc4, 0); !bytes.Equal(value, []byte{0x02}) {
+		t.Fatalf("Failed to find value after nested template: %x", value)
+	}
+	if value := doFindTLV(data, 0xc5, 0); value != nil {
+		t.Fatalf("Found missing tag: %x", value)
+	}
+	if value := doFindTLV(data[:20], 0xc4, 0); value != nil {
+		t.Fatalf("Found tag in truncated data: %x", value)
+	}
+}
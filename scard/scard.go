@@ -14,6 +14,18 @@ const (
 	SCOPE_USER     = pcsc.CARD_SCOPE_USER
 	SCOPE_TERMINAL = pcsc.CARD_SCOPE_TERMINAL
 	SCOPE_SYSTEM   = pcsc.CARD_SCOPE_SYSTEM
+	// Disposition
+	LEAVE_CARD   = pcsc.SCARD_LEAVE_CARD
+	RESET_CARD   = pcsc.SCARD_RESET_CARD
+	UNPOWER_CARD = pcsc.SCARD_UNPOWER_CARD
+	EJECT_CARD   = pcsc.SCARD_EJECT_CARD
+)
+
+var (
+	// ErrCardReset is returned when another application reset the card, see Card.Reconnect.
+	ErrCardReset = pcsc.Error(pcsc.SCARD_W_RESET_CARD)
+	// ErrCardRemoved is returned when the card has been removed from the reader.
+	ErrCardRemoved = pcsc.Error(pcsc.SCARD_W_REMOVED_CARD)
 )
 
 type ATR []byte
@@ -89,14 +101,78 @@ func (r RID) String() string {
 
 // APDU represents an application data unit sent to a smart-card.
 type APDU struct {
-	Cla  uint8  // Class
-	Ins  uint8  // Instruction
-	P1   uint8  // Parameter 1
-	P2   uint8  // Parameter 2
-	Data []byte // Command data
-	Len  uint8  // Command data length
-	Pib  bool   // Padding indicator byte present
-	Elf  bool   // Use extended length fields
+	Cla  uint8      // Class
+	Ins  uint8      // Instruction
+	P1   uint8      // Parameter 1
+	P2   uint8      // Parameter 2
+	Data []byte     // Command data
+	Le   int        // Expected response length, 0 if no response data is expected, at most 256 (65536 with Elf)
+	Elf  bool       // Use extended length fields
+	SW   StatusWord // Status word of the response, set by Card.Exchange
+	// Remaining is the instruction that collects response data announced with 61xx, 0 for GET RESPONSE (C0). Some
+	// applications use their own, e.g. SEND REMAINING (A5) of the OATH application.
+	Remaining uint8
+}
+
+// StatusWord is the status word (SW1 SW2) that ends every response APDU.
+type StatusWord uint16
+
+const (
+	SwOK StatusWord = 0x9000
+)
+
+// SW1 returns the first byte of the status word.
+func (sw StatusWord) SW1() uint8 {
+	return uint8(sw >> 8)
+}
+
+// SW2 returns the second byte of the status word.
+func (sw StatusWord) SW2() uint8 {
+	return uint8(sw)
+}
+
+func (sw StatusWord) String() string {
+	return fmt.Sprintf("%04X", uint16(sw))
+}
+
+const (
+	maxShortLc    = 0xff
+	maxShortLe    = 0x100
+	maxExtendedLc = 0xffff
+	maxExtendedLe = 0x10000
+	// maximum size of a reader response to Card.Control, MAX_BUFFER_SIZE_EXTENDED of pcsc-lite
+	maxControlLength = 4 + 3 + (1 << 16) + 3 + 2
+)
+
+// Bytes encodes the command APDU. Short length fields are used unless Elf is set or Data or Le exceed them. The Le
+// field is only present if response data is expected, with its maximum value encoded as zero.
+func (apdu APDU) Bytes() ([]byte, error) {
+	extended := apdu.Elf || len(apdu.Data) > maxShortLc || apdu.Le > maxShortLe
+	if len(apdu.Data) > maxExtendedLc || apdu.Le < 0 || apdu.Le > maxExtendedLe {
+		return nil, ErrWrongLength
+	}
+	cmd := []byte{apdu.Cla, apdu.Ins, apdu.P1, apdu.P2}
+	if len(apdu.Data) == 0 && apdu.Le == 0 {
+		return cmd, nil
+	}
+	if extended {
+		cmd = append(cmd, 0x00)
+	}
+	if len(apdu.Data) > 0 {
+		if extended {
+			cmd = binary.BigEndian.AppendUint16(cmd, uint16(len(apdu.Data)))
+		} else {
+			cmd = append(cmd, uint8(len(apdu.Data)))
+		}
+		cmd = append(cmd, apdu.Data...)
+	}
+	switch {
+	case apdu.Le == 0:
+		return cmd, nil
+	case extended:
+		return binary.BigEndian.AppendUint16(cmd, uint16(apdu.Le)), nil
+	}
+	return append(cmd, uint8(apdu.Le)), nil
 }
 
 var (
@@ -145,6 +221,9 @@ var (
 	ErrReferenceNotFound                   = errors.New("referenced data or reference data not found (exact meaning depending on the command)")
 	ErrFileAlreadyExists                   = errors.New("file already exists")
 	ErrNameAlreadyExists                   = errors.New("DF name already exists")
+	ErrVerificationFailed                  = errors.New("verification failed")
+	ErrWrongLe                             = errors.New("wrong length Le")
+	ErrUnknownStatus                       = errors.New("unknown status word")
 )
 
 var errorCodes = map[[2]byte]error{
@@ -195,47 +274,199 @@ var errorCodes = map[[2]byte]error{
 	[2]byte{0x6A, 0x8A}: ErrNameAlreadyExists,
 }
 
+// CardStatus is the state of a connected card.
+type CardStatus struct {
+	Reader   string
+	State    uint32 // pcsc.SCARD_PRESENT, pcsc.SCARD_POWERED, ...
+	Protocol uint32
+	ATR      ATR
+}
+
+// Present reports whether the card is still inserted and powered.
+func (s CardStatus) Present() bool {
+	present := uint32(pcsc.SCARD_POWERED | pcsc.SCARD_PRESENT)
+	return s.State&present == present
+}
+
+// Transaction calls fn while holding exclusive access to the card, so that other applications, e.g. gpg-agent,
+// cannot interleave their commands with a sequence like select, verify and sign.
+func (c *Card) Transaction(fn func() error) error {
+	if err := c.BeginTransaction(); err != nil {
+		return err
+	}
+	err := fn()
+	if endErr := c.EndTransaction(LEAVE_CARD); err == nil {
+		err = endErr
+	}
+	return err
+}
+
+// Transmit sends apdu to the card and returns the response data without the status word. For a warning status
+// (62xx, 63xx) the response data is returned together with the StatusError.
 func (c *Card) Transmit(apdu APDU) ([]byte, error) {
-	resp := make([]byte, 258)
-	cmd := new(bytes.Buffer)
-	if _, err := cmd.Write([]byte{apdu.Cla, apdu.Ins, apdu.P1, apdu.P2}); err != nil { // write 4 header bytes to buffer
+	return c.Exchange(&apdu)
+}
+
+// Exchange sends apdu to the card and returns the complete response data, storing the final status word in
+// apdu.SW. Data that exceeds the length fields is sent with command chaining, responses that announce more data
+// (61xx) are collected with GET RESPONSE and a wrong Le (6Cxx) is corrected by repeating the command. For a warning
+// status (62xx, 63xx) the response data is returned together with the StatusError.
+func (c *Card) Exchange(apdu *APDU) ([]byte, error) {
+	return transceive(c.transmit, apdu)
+}
+
+func transceive(transmit func(cmd, resp []byte) (int, error), apdu *APDU) ([]byte, error) {
+	send := func(cmd APDU) ([]byte, StatusWord, error) {
+		b, err := cmd.Bytes()
+		if err != nil {
+			return nil, 0, err
+		}
+		resp := make([]byte, maxShortLe+2)
+		if cmd.Elf || len(cmd.Data) > maxShortLc || cmd.Le > maxShortLe {
+			resp = make([]byte, maxExtendedLe+2)
+		}
+		n, err := transmit(b, resp)
+		if err != nil {
+			return nil, 0, err
+		}
+		if n < 2 {
+			return nil, 0, ErrRespTooShort
+		}
+		return resp[:n-2], StatusWord(binary.BigEndian.Uint16(resp[n-2 : n])), nil
+	}
+
+	maxLc := maxShortLc
+	if apdu.Elf {
+		maxLc = maxExtendedLc
+	}
+	data := apdu.Data
+	for len(data) > maxLc {
+		// all but the last command of a chain have bit 5 of CLA set
+		_, sw, err := send(APDU{Cla: apdu.Cla | 0x10, Ins: apdu.Ins, P1: apdu.P1, P2: apdu.P2, Data: data[:maxLc], Elf: apdu.Elf})
+		if err != nil {
+			return nil, err
+		}
+		if sw != SwOK {
+			apdu.SW = sw
+			return nil, sw.err()
+		}
+		data = data[maxLc:]
+	}
+
+	cmd := *apdu
+	cmd.Data = data
+	resp, sw, err := send(cmd)
+	if err != nil {
 		return nil, err
 	}
-	if len(apdu.Data) > 0 { // if a payload exists, calculate the length, prepend it to the payload, and write to buffer
-		lc := len(apdu.Data)
-		if apdu.Pib { // subtract one byte from length if padding indicator byte present
-			lc--
+	if sw.SW1() == 0x6c {
+		cmd.Le = int(sw.SW2())
+		if cmd.Le == 0 {
+			cmd.Le = maxShortLe
 		}
-		if apdu.Elf { // check if extended length fields (3 bytes) should be used
-			lcElf := make([]byte, 2)
-			binary.BigEndian.PutUint16(lcElf, uint16(lc))
-			if _, err := cmd.Write(append([]byte{0}, lcElf...)); err != nil {
-				return nil, err
-			}
-		} else {
-			if _, err := cmd.Write([]byte{uint8(lc)}); err != nil {
-				return nil, err
-			}
+		if resp, sw, err = send(cmd); err != nil {
+			return nil, err
+		}
+	}
+	remaining := apdu.Remaining
+	if remaining == 0 {
+		remaining = 0xc0
+	}
+	for sw.SW1() == 0x61 {
+		le := int(sw.SW2())
+		if le == 0 {
+			le = maxShortLe
 		}
-		if _, err := cmd.Write(apdu.Data); err != nil {
+		var more []byte
+		if more, sw, err = send(APDU{Cla: apdu.Cla &^ 0x10, Ins: remaining, Le: le}); err != nil {
 			return nil, err
 		}
+		resp = append(resp, more...)
 	}
-	if _, err := cmd.Write([]byte{apdu.Len}); err != nil {
+	apdu.SW = sw
+	if err := sw.err(); err != nil {
+		if sw.warning() {
+			return resp, err
+		}
 		return nil, err
 	}
-	n, err := c.context.client.Transmit(c.cardID, c.protocol, cmd.Bytes(), resp)
-	if err != nil {
-		return nil, err
+	return resp, nil
+}
+
+// errorClasses are the errors of status words without an exact entry in errorCodes, by SW1.
+var errorClasses = map[byte]error{
+	0x62: ErrUnspecifiedWarning,
+	0x63: ErrUnspecifiedWarningModified,
+	0x64: ErrUnspecifiedError,
+	0x65: ErrUnspecifiedErrorModified,
+	0x67: ErrWrongLength,
+	0x68: ErrUnsupportedFunction,
+	0x69: ErrCommandNotAllowed,
+	0x6A: ErrWrongParamsNoInfo,
+	0x6B: ErrWrongParams,
+	0x6C: ErrWrongLe,
+	0x6D: ErrUnsupportedInstruction,
+	0x6E: ErrUnsupportedClass,
+	0x6F: ErrNoDiag,
+}
+
+// StatusError is returned for a response whose status word is not 9000. It matches the error the status word is
+// classified as with errors.Is, e.g. ErrSecurityStatusNotSatisfied for 6982.
+type StatusError struct {
+	SW1 uint8
+	SW2 uint8
+	Err error
+}
+
+func (e *StatusError) Error() string {
+	if n, ok := e.RetriesLeft(); ok {
+		return fmt.Sprintf("%s (%d retries left)", e.Err, n)
 	}
-	resp = resp[:n]
-	if len(resp) < 2 {
-		return nil, ErrRespTooShort
+	return fmt.Sprintf("%s (%02X%02X)", e.Err, e.SW1, e.SW2)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// RetriesLeft returns the number of remaining tries that a 63Cx status word reports after a failed verification,
+// e.g. of a PIN.
+func (e *StatusError) RetriesLeft() (int, bool) {
+	if e.SW1 == 0x63 && e.SW2&0xf0 == 0xc0 {
+		return int(e.SW2 & 0x0f), true
 	}
-	if err = errorCodes[[2]byte{resp[len(resp)-2], resp[len(resp)-1]}]; err != nil {
-		return nil, err
+	return 0, false
+}
+
+// RetriesLeft returns the number of remaining tries if err is a StatusError reporting them.
+func RetriesLeft(err error) (int, bool) {
+	var serr *StatusError
+	if errors.As(err, &serr) {
+		return serr.RetriesLeft()
+	}
+	return 0, false
+}
+
+// warning reports whether the status word is a warning (62xx, 63xx), which completes the command with response data.
+func (sw StatusWord) warning() bool {
+	return sw.SW1() == 0x62 || sw.SW1() == 0x63
+}
+
+func (sw StatusWord) err() error {
+	if sw == SwOK {
+		return nil
+	}
+	e := &StatusError{SW1: sw.SW1(), SW2: sw.SW2()}
+	if err, ok := errorCodes[[2]byte{e.SW1, e.SW2}]; ok {
+		e.Err = err
+	} else if e.SW1 == 0x63 && e.SW2&0xf0 == 0xc0 {
+		e.Err = ErrVerificationFailed
+	} else if err, ok := errorClasses[e.SW1]; ok {
+		e.Err = err
+	} else {
+		e.Err = ErrUnknownStatus
 	}
-	return resp[:len(resp)-2], nil
+	return e
 }
 
 func concat(prefix []byte, rest ...byte) (r []byte) {
@@ -1,10 +1,37 @@
+//go:build !windows
+
 package scard
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
+
+	"github.com/malivvan/aegis/pcsc/pcsctest"
 )
 
+const testReader = "Fake Reader 00 00"
+
+// simulatedCard inserts a Simulator into a reader of the fake pcscd.
+func simulatedCard(sim *Simulator) *pcsctest.Card {
+	return &pcsctest.Card{ATR: sim.ATR(), Transmit: sim.Transmit, Reset: func() { sim.Close() }}
+}
+
+// newTestServer starts a fake pcscd with one reader and points EstablishContext at it. The reader holds a simulated
+// OpenPGP card if withCard is set.
+func newTestServer(t *testing.T, withCard bool) *pcsctest.Server {
+	t.Helper()
+	server := pcsctest.NewServer(t)
+	server.AttachReader(testReader)
+	if withCard {
+		server.InsertCard(testReader, simulatedCard(NewSimulator()))
+	}
+	t.Setenv("PCSCLITE_CSOCK_NAME", server.Socket)
+	return server
+}
+
 func TestInfo(t *testing.T) {
 	fmt.Println("\n===================")
 	fmt.Println("High Level API Test")
@@ -15,6 +42,7 @@ func TestEstablishReleaseUserContext(t *testing.T) {
 	fmt.Println("------------------------------")
 	fmt.Println("Test establish/release User Context")
 	fmt.Printf("------------------------------\n\n")
+	newTestServer(t, false)
 	ctx, err := EstablishContext(SCOPE_USER)
 	if err != nil {
 		t.Error(err)
@@ -32,6 +60,7 @@ func TestEstablishReleaseSystemContext(t *testing.T) {
 	fmt.Println("------------------------------")
 	fmt.Println("Test establish/release System Context")
 	fmt.Printf("------------------------------\n\n")
+	newTestServer(t, false)
 	ctx, err := EstablishContext(SCOPE_SYSTEM)
 	if err != nil {
 		t.Error(err)
@@ -49,6 +78,7 @@ func TestListReaders(t *testing.T) {
 	fmt.Println("-----------------")
 	fmt.Println("Test list readers")
 	fmt.Printf("-----------------\n\n")
+	newTestServer(t, false)
 	ctx, err := EstablishContext()
 	if err != nil {
 		t.Error(err)
@@ -64,12 +94,17 @@ func TestListReaders(t *testing.T) {
 		fmt.Println(reader.Name())
 		fmt.Printf("- Card present: %t\n\n", reader.IsCardPresent())
 	}
+	if len(readers) != 1 || readers[0].IsCardPresent() {
+		t.Errorf("Expected one empty reader, got %d", len(readers))
+	}
 }
 
 func TestListReadersWithCard(t *testing.T) {
 	fmt.Println("---------------------------")
 	fmt.Println("Test list readers with card")
 	fmt.Printf("---------------------------\n\n")
+	server := newTestServer(t, true)
+	server.AttachReader("Fake Reader 01 00")
 	ctx, err := EstablishContext()
 	if err != nil {
 		t.Error(err)
@@ -85,12 +120,16 @@ func TestListReadersWithCard(t *testing.T) {
 		fmt.Println(reader.Name())
 		fmt.Printf("- Card present: %t\n\n", reader.IsCardPresent())
 	}
+	if len(readers) != 1 || readers[0].Name() != testReader {
+		t.Errorf("Expected only %s, got %d readers", testReader, len(readers))
+	}
 }
 
 func TestWaitForCardPresentRemoved(t *testing.T) {
 	fmt.Println("----------------------------------------------------")
 	fmt.Println("Test wait for card present / wait until card removed")
 	fmt.Printf("----------------------------------------------------\n\n")
+	server := newTestServer(t, false)
 	ctx, err := EstablishContext()
 	if err != nil {
 		t.Error(err)
@@ -98,6 +137,7 @@ func TestWaitForCardPresentRemoved(t *testing.T) {
 	}
 	defer ctx.Release()
 	fmt.Printf("Insert card now...")
+	go server.InsertCard(testReader, simulatedCard(NewSimulator()))
 	reader, err := ctx.WaitForCardPresent()
 	if err != nil {
 		t.Error(err)
@@ -106,6 +146,7 @@ func TestWaitForCardPresentRemoved(t *testing.T) {
 	fmt.Printf("\n\n%s\n", reader.Name())
 	fmt.Printf("- Card present: %t\n\n", reader.IsCardPresent())
 	fmt.Printf("Remove card now...")
+	go server.RemoveCard(testReader)
 	reader.WaitUntilCardRemoved()
 	fmt.Printf("\n\nCard was removed\n\n")
 }
@@ -114,6 +155,7 @@ func TestCardCommunication(t *testing.T) {
 	fmt.Println("-----------------------")
 	fmt.Println("Test card communication")
 	fmt.Printf("-----------------------\n\n")
+	newTestServer(t, true)
 	ctx, err := EstablishContext()
 	if err != nil {
 		t.Error(err)
@@ -141,26 +183,21 @@ func TestCardCommunication(t *testing.T) {
 
 	fmt.Println("Select applet")
 	fmt.Printf("-------------\n\n")
-	cmd := SelectCommand(0x90, 0x72, 0x5A, 0x9E, 0x3B, 0x10, 0x70, 0xAA)
-	fmt.Printf(">> %s\n", cmd)
-	response, err := card.TransmitAPDU(cmd)
+	fmt.Printf(">> %x\n", AidOpenPGP)
+	err = card.Select(AidOpenPGP)
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	fmt.Printf("<< %s\n", response.String())
 
-	fmt.Println("\nSend CMD 10")
-	fmt.Printf("-----------\n\n")
-	cmd = Command2(0x00, 0x10, 0x00, 0x00, 0x0b)
-	fmt.Printf(">> %s\n", cmd)
-	response, err = card.TransmitAPDU(cmd)
+	fmt.Println("\nGet application related data")
+	fmt.Printf("----------------------------\n\n")
+	response, err := card.Transmit(APDU{Cla: 0x00, Ins: 0xca, P1: 0x00, P2: 0x6e})
 	if err != nil {
 		t.Error(err)
 		return
 	}
-	fmt.Printf("<< %s\n", response)
-	fmt.Printf("\nQuoth the Applet, \"%s\"\n\n", string(response.Data()))
+	fmt.Printf("<< %x\n\n", response)
 
 	fmt.Println("Disconnect from card")
 	fmt.Printf("--------------------\n\n")
@@ -171,3 +208,113 @@ func TestCardCommunication(t *testing.T) {
 	}
 	fmt.Printf("OK\n\n")
 }
+
+func TestWatch(t *testing.T) {
+	server := newTestServer(t, false)
+	ctx, err := EstablishContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Release()
+	done, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := ctx.Watch(done)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := func(want EventType, reader string) {
+		t.Helper()
+		select {
+		case event := <-events:
+			if event.Err != nil || event.Type != want || event.Reader != reader {
+				t.Fatalf("Expected %s in %s, got %+v", want, reader, event)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timeout waiting for %s", want)
+		}
+	}
+
+	next(EventReaderAttached, testReader)
+	server.AttachReader("Fake Reader 01 00")
+	next(EventReaderAttached, "Fake Reader 01 00")
+	server.InsertCard("Fake Reader 01 00", simulatedCard(NewSimulator()))
+	next(EventCardInserted, "Fake Reader 01 00")
+	server.DetachReader("Fake Reader 01 00")
+	next(EventCardRemoved, "Fake Reader 01 00")
+	next(EventReaderDetached, "Fake Reader 01 00")
+
+	cancel()
+	for event := range events {
+		t.Fatalf("Unexpected event after cancel: %+v", event)
+	}
+}
+
+func TestWaitForCardRemoved(t *testing.T) {
+	server := newTestServer(t, true)
+	ctx, err := EstablishContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Release()
+	readers, err := ctx.ListReadersWithCard()
+	if err != nil || len(readers) != 1 {
+		t.Fatalf("Expected 1 reader with card, got %d: %v", len(readers), err)
+	}
+	reader := readers[0]
+
+	// waiting for a card that stays in the reader ends with the context
+	done, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := reader.WaitForCardRemoved(done); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// a card swapped before waiting counts as removed
+	server.RemoveCard(testReader)
+	server.InsertCard(testReader, simulatedCard(NewSimulator()))
+	done, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := reader.WaitForCardRemoved(done); err != nil {
+		t.Fatalf("Expected the swapped card to count as removed, got %v", err)
+	}
+}
+
+func TestTransactionCardRemoved(t *testing.T) {
+	server := newTestServer(t, true)
+	ctx, err := EstablishContext()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Release()
+	readers, err := ctx.ListReadersWithCard()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(readers) != 1 {
+		t.Fatalf("Expected 1 reader with card, got %d", len(readers))
+	}
+	card, err := readers[0].Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer card.Disconnect()
+
+	err = card.Transaction(func() error {
+		o, err := card.OpenPGP()
+		if err != nil {
+			return err
+		}
+		if err := o.Verify(PW1, []byte(DefaultPW1)); err != nil {
+			return err
+		}
+		server.RemoveCard(testReader)
+		_, err = o.GetData(DoAID)
+		return err
+	})
+	if !errors.Is(err, ErrCardRemoved) {
+		t.Fatalf("Expected ErrCardRemoved, got %v", err)
+	}
+	if _, err := card.Status(); !errors.Is(err, ErrCardRemoved) {
+		t.Fatalf("Expected ErrCardRemoved from status, got %v", err)
+	}
+}
@@ -3,8 +3,6 @@
 package scard
 
 import (
-	"time"
-
 	"github.com/malivvan/aegis/pcsc"
 )
 
@@ -38,6 +36,11 @@ func (ctx *Context) Release() error {
 	return ctx.client.ReleaseContext(ctx.ctxID)
 }
 
+// Cancel terminates a blocking wait for reader state changes of the context.
+func (ctx *Context) Cancel() error {
+	return ctx.client.Cancel(ctx.ctxID)
+}
+
 // ListReaders lists all smart card readers.
 func (ctx *Context) ListReaders() ([]*Reader, error) {
 	return ctx.listReaders(false)
@@ -58,38 +61,74 @@ func (ctx *Context) listReaders(withCard bool) ([]*Reader, error) {
 		if withCard {
 			if readers[i].IsCardPresent() {
 				result = append(result, &Reader{
-					context: ctx, reader: *readers[i]})
+					context: ctx, reader: *readers[i], card: stateOf(readers[i])})
 			}
 		} else {
 			result = append(result, &Reader{
-				context: ctx, reader: *readers[i]})
+				context: ctx, reader: *readers[i], card: stateOf(readers[i])})
 		}
 	}
 	return result, nil
 }
 
-// WaitForCardPresent blocks until a smart card is inserted into any reader or returns immediately if a card is already
-// present.
-func (ctx *Context) WaitForCardPresent() (*Reader, error) {
-	var reader *Reader
-	for reader == nil {
-		count, err := ctx.client.SyncReaders()
-		if err != nil {
+// watcher waits for reader events on a connection of its own.
+type watcher struct {
+	client     *pcsc.PCSCLiteClient
+	ctxID      uint32
+	registered bool
+}
+
+func (ctx *Context) newWatcher() (*watcher, error) {
+	client, err := ctx.client.Clone()
+	if err != nil {
+		return nil, err
+	}
+	ctxID, err := client.EstablishContext(SCOPE_SYSTEM)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	return &watcher{client: client, ctxID: ctxID}, nil
+}
+
+// wait returns the reader states, after the first call only once the daemon signalled a reader event.
+func (w *watcher) wait() (map[string]readerState, error) {
+	if w.registered {
+		if err := w.client.WaitReaderStateChange(); err != nil {
 			return nil, err
 		}
-		for i := uint32(0); i < count; i++ {
-			r := ctx.client.Readers()[i]
-			if r.IsCardPresent() {
-				reader = &Reader{context: ctx, reader: r}
-				break
-			}
-		}
-		if reader != nil {
-			break
-		}
-		time.Sleep(250 * time.Millisecond)
 	}
-	return reader, nil
+	count, err := w.client.RegisterReaderStateChange()
+	if err != nil {
+		return nil, err
+	}
+	w.registered = true
+	readers := w.client.Readers()
+	states := make(map[string]readerState, count)
+	for i := uint32(0); i < count; i++ {
+		states[readers[i].Name()] = stateOf(&readers[i])
+	}
+	return states, nil
+}
+
+func stateOf(reader *pcsc.Reader) readerState {
+	return readerState{
+		present: reader.IsCardPresent(),
+		counter: reader.EventCounter,
+		atr:     append(ATR(nil), reader.CardAtr[:reader.CardAtrLength]...),
+	}
+}
+
+// cancel terminates a blocking wait.
+func (w *watcher) cancel() {
+	if err := w.client.Cancel(w.ctxID); err != nil {
+		w.client.Close()
+	}
+}
+
+func (w *watcher) close() {
+	w.client.ReleaseContext(w.ctxID)
+	w.client.Close()
 }
 
 // Reader represents a smart card reader.
@@ -97,6 +136,7 @@ func (ctx *Context) WaitForCardPresent() (*Reader, error) {
 type Reader struct {
 	context *Context
 	reader  pcsc.Reader
+	card    readerState // state of the reader when it was listed, checked or connected
 }
 
 // Name returns the name of the card reader.
@@ -114,6 +154,7 @@ func (r *Reader) IsCardPresent() bool {
 	for i := uint32(0); i < count; i++ {
 		if r.Name() == readers[i].Name() {
 			r.reader = readers[i]
+			r.card = stateOf(&r.reader)
 			if r.reader.IsCardPresent() {
 				return true
 			}
@@ -122,13 +163,6 @@ func (r *Reader) IsCardPresent() bool {
 	return false
 }
 
-// WaitUntilCardRemoved blocks until the card is removed from the reader.
-func (r *Reader) WaitUntilCardRemoved() {
-	for r.IsCardPresent() {
-		time.Sleep(250 * time.Millisecond)
-	}
-}
-
 // Connect to card.
 func (r *Reader) Connect() (*Card, error) {
 	cardID, protocol, err := r.context.client.CardConnect(
@@ -136,32 +170,100 @@ func (r *Reader) Connect() (*Card, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Card{
+	// capture the ATR and event counter of the connected card
+	r.IsCardPresent()
+	return NewCard(&pcscTransport{
 		context:  r.context,
+		reader:   r.reader.Name(),
 		cardID:   cardID,
 		protocol: protocol,
 		atr:      r.reader.CardAtr[:r.reader.CardAtrLength],
-	}, nil
+	}), nil
 }
 
-// Card represents a connection to a smart card.
-type Card struct {
+var _ ReaderTransport = (*pcscTransport)(nil)
+
+// pcscTransport is the ReaderTransport of a card connected through pcscd.
+type pcscTransport struct {
 	context  *Context
+	reader   string
 	cardID   int32
 	protocol uint32
 	atr      ATR
 }
 
 // ATR returns the card ATR (Answer To Reset).
-func (c *Card) ATR() ATR {
+func (c *pcscTransport) ATR() ATR {
 	return c.atr
 }
 
-// Disconnect from card.
-func (c *Card) Disconnect() error {
+func (c *pcscTransport) Transmit(cmd, resp []byte) (int, error) {
+	n, err := c.context.client.Transmit(c.cardID, c.protocol, cmd, resp)
+	return int(n), err
+}
+
+// Close disconnects from the card.
+func (c *pcscTransport) Close() error {
 	err := c.context.client.CardDisconnect(c.cardID)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+func (c *pcscTransport) Reconnect(initialization uint32) error {
+	protocol, err := c.context.client.CardReconnect(c.cardID, initialization)
+	if err != nil {
+		return err
+	}
+	c.protocol = protocol
+	status, err := c.Status()
+	if err != nil {
+		return err
+	}
+	c.atr = status.ATR
+	return nil
+}
+
+func (c *pcscTransport) BeginTransaction() error {
+	return c.context.client.BeginTransaction(c.cardID)
+}
+
+func (c *pcscTransport) EndTransaction(disposition uint32) error {
+	return c.context.client.EndTransaction(c.cardID, disposition)
+}
+
+func (c *pcscTransport) Status() (CardStatus, error) {
+	if err := c.context.client.Status(c.cardID); err != nil {
+		return CardStatus{}, err
+	}
+	count, err := c.context.client.SyncReaders()
+	if err != nil {
+		return CardStatus{}, err
+	}
+	readers := c.context.client.Readers()
+	for i := uint32(0); i < count; i++ {
+		if readers[i].Name() == c.reader {
+			return CardStatus{
+				Reader:   c.reader,
+				State:    readers[i].ReaderState,
+				Protocol: c.protocol,
+				ATR:      append(ATR(nil), readers[i].CardAtr[:readers[i].CardAtrLength]...),
+			}, nil
+		}
+	}
+	return CardStatus{}, pcsc.Error(pcsc.SCARD_E_READER_UNAVAILABLE)
+}
+
+func (c *pcscTransport) Control(code uint32, data []byte) ([]byte, error) {
+	resp := make([]byte, maxControlLength)
+	n, err := c.context.client.Control(c.cardID, pcsc.CtlCode(code), data, resp)
+	if err != nil {
+		return nil, err
+	}
+	return resp[:n], nil
+}
+
+func (c *pcscTransport) GetAttrib(attr uint32) ([]byte, error) {
+	return c.context.client.GetAttrib(c.cardID, attr)
+}
@@ -3,8 +3,8 @@
 package scard
 
 import (
+	"errors"
 	"fmt"
-	"time"
 
 	"github.com/malivvan/aegis/pcsc"
 )
@@ -38,6 +38,11 @@ func (ctx *Context) Release() error {
 	return ctx.winscard.ReleaseContext(ctx.ctxID)
 }
 
+// Cancel terminates a blocking wait for reader state changes of the context.
+func (ctx *Context) Cancel() error {
+	return ctx.winscard.Cancel(ctx.ctxID)
+}
+
 // List all smart card readers.
 func (ctx *Context) ListReaders() ([]*Reader, error) {
 	readerNames, err := ctx.winscard.ListReaders(ctx.ctxID)
@@ -64,49 +69,82 @@ func (ctx *Context) ListReadersWithCard() ([]*Reader, error) {
 			continue
 		}
 		if state.EventState&pcsc.SCARD_STATE_PRESENT != 0 {
-			readers = append(readers, &Reader{context: ctx, name: state.Reader})
+			readers = append(readers, &Reader{context: ctx, name: state.Reader, card: stateOf(state)})
 		}
 	}
 	return readers, nil
 }
 
-// Block until a smart card is inserted into any reader.
-// Returns immediately if card already present.
-func (ctx *Context) WaitForCardPresent() (*Reader, error) {
-	var err error
-	var reader *Reader = nil
-	var states []pcsc.ReaderState
-	for reader == nil {
-		states, err = ctx.winscard.GetStatusChangeAll(
-			ctx.ctxID, pcsc.SCARD_INFINITE, pcsc.SCARD_STATE_UNAWARE)
+// pnpNotification is the pseudo reader whose state changes when readers are attached or detached.
+const pnpNotification = `\\?PnP?\Notification`
+
+// watcher waits for reader events on a context of its own.
+type watcher struct {
+	context *Context
+	states  map[string]uint32 // last event state by reader name
+	pnp     uint32
+}
+
+func (ctx *Context) newWatcher() (*watcher, error) {
+	ctxID, err := ctx.winscard.EstablishContext(SCOPE_SYSTEM)
+	if err != nil {
+		return nil, err
+	}
+	return &watcher{context: &Context{ctxID, ctx.winscard}}, nil
+}
+
+// wait returns the reader states, after the first call only once a reader or card changed.
+func (w *watcher) wait() (map[string]readerState, error) {
+	for {
+		names, err := w.context.winscard.ListReaders(w.context.ctxID)
 		if err != nil {
 			return nil, err
 		}
-		if states == nil {
-			time.Sleep(500 * time.Millisecond)
+		states := make([]pcsc.ReaderState, len(names)+1)
+		for i, name := range names {
+			states[i].Reader = name
+			states[i].CurrentState = w.states[name]
+		}
+		states[len(names)].Reader = pnpNotification
+		states[len(names)].CurrentState = w.pnp
+		err = w.context.winscard.GetStatusChange(w.context.ctxID, pcsc.SCARD_INFINITE, states)
+		if errors.Is(err, pcsc.Error(pcsc.SCARD_E_UNKNOWN_READER)) {
+			// detached after listing the readers
 			continue
 		}
-		for _, state := range states {
-			state.CurrentState = state.EventState
-			if state.EventState&pcsc.SCARD_STATE_MUTE != 0 {
-				continue
-			}
-			if state.EventState&pcsc.SCARD_STATE_PRESENT != 0 {
-				reader = &Reader{context: ctx, name: state.Reader}
-				break
-			}
+		if err != nil {
+			return nil, err
 		}
-		if reader == nil {
-			err = ctx.winscard.GetStatusChange(
-				ctx.ctxID, pcsc.SCARD_INFINITE, states,
-			)
-			if err != nil {
-				return nil, err
+
+		w.states = make(map[string]uint32, len(names))
+		result := make(map[string]readerState, len(names))
+		for _, state := range states[:len(names)] {
+			if state.EventState&pcsc.SCARD_STATE_UNKNOWN != 0 {
+				continue
 			}
-			time.Sleep(500 * time.Millisecond)
+			w.states[state.Reader] = state.EventState &^ pcsc.SCARD_STATE_CHANGED
+			result[state.Reader] = stateOf(state)
 		}
+		w.pnp = states[len(names)].EventState &^ pcsc.SCARD_STATE_CHANGED
+		return result, nil
 	}
-	return reader, nil
+}
+
+func stateOf(state pcsc.ReaderState) readerState {
+	return readerState{
+		present: state.EventState&pcsc.SCARD_STATE_PRESENT != 0 && state.EventState&pcsc.SCARD_STATE_MUTE == 0,
+		counter: state.EventState >> 16,
+		atr:     append(ATR(nil), state.Atr[:min(int(state.AtrLen), len(state.Atr))]...),
+	}
+}
+
+// cancel terminates a blocking wait.
+func (w *watcher) cancel() {
+	w.context.Cancel()
+}
+
+func (w *watcher) close() {
+	w.context.Release()
 }
 
 // Smart card reader.
@@ -115,6 +153,7 @@ func (ctx *Context) WaitForCardPresent() (*Reader, error) {
 type Reader struct {
 	context *Context
 	name    string
+	card    readerState // state of the reader when it was listed, checked or connected
 }
 
 // Return name of card reader.
@@ -132,64 +171,52 @@ func (r *Reader) IsCardPresent() bool {
 	if err != nil {
 		return false
 	}
-	if states[0].EventState&pcsc.SCARD_STATE_MUTE != 0 {
-		return false
-	}
-	return states[0].EventState&pcsc.SCARD_STATE_PRESENT != 0
-}
-
-// Wait until card removed
-func (r *Reader) WaitUntilCardRemoved() {
-	states := make([]pcsc.ReaderState, 1)
-	for {
-		states[0].Reader = r.name
-		states[0].CurrentState = pcsc.SCARD_STATE_UNAWARE
-		err := r.context.winscard.GetStatusChange(r.context.ctxID,
-			pcsc.SCARD_INFINITE, states)
-		if err != nil {
-			return
-		}
-		if states[0].EventState&pcsc.SCARD_STATE_MUTE != 0 ||
-			states[0].EventState&pcsc.SCARD_STATE_PRESENT == 0 {
-			return
-		}
-		time.Sleep(500 * time.Millisecond)
-	}
+	r.card = stateOf(states[0])
+	return r.card.present
 }
 
 // Connect to card.
 func (r *Reader) Connect() (*Card, error) {
-	var pci uintptr
 	cardID, protocol, err := r.context.winscard.CardConnect(
 		r.context.ctxID, r.name)
 	if err != nil {
 		return nil, err
 	}
-	switch protocol {
-	case pcsc.SCARD_PROTOCOL_T0:
-		pci = r.context.winscard.T0PCI()
-	case pcsc.SCARD_PROTOCOL_T1:
-		pci = r.context.winscard.T1PCI()
-	default:
-		return nil, fmt.Errorf("Unknown protocol: %08x", protocol)
+	// capture the ATR and event counter of the connected card
+	r.IsCardPresent()
+	pci, err := r.context.pci(protocol)
+	if err != nil {
+		return nil, err
 	}
-	return &Card{
+	return NewCard(&pcscTransport{
 		context: r.context,
 		cardID:  cardID,
 		sendPCI: pci,
-	}, nil
+	}), nil
+}
+
+func (ctx *Context) pci(protocol uintptr) (uintptr, error) {
+	switch protocol {
+	case pcsc.SCARD_PROTOCOL_T0:
+		return ctx.winscard.T0PCI(), nil
+	case pcsc.SCARD_PROTOCOL_T1:
+		return ctx.winscard.T1PCI(), nil
+	}
+	return 0, fmt.Errorf("Unknown protocol: %08x", protocol)
 }
 
-// Smart card.
-type Card struct {
+var _ ReaderTransport = (*pcscTransport)(nil)
+
+// pcscTransport is the ReaderTransport of a card connected through winscard.
+type pcscTransport struct {
 	context *Context
 	cardID  uintptr
 	sendPCI uintptr
 	atr     ATR
 }
 
-// Disconnect from card.
-func (c *Card) Disconnect() error {
+// Close disconnects from the card.
+func (c *pcscTransport) Close() error {
 	err := c.context.winscard.CardDisconnect(c.cardID)
 	if err != nil {
 		return err
@@ -198,7 +225,7 @@ func (c *Card) Disconnect() error {
 }
 
 // Return card ATR (answer to reset).
-func (c *Card) ATR() ATR {
+func (c *pcscTransport) ATR() ATR {
 	var err error
 	if c.atr != nil {
 		return c.atr
@@ -210,13 +237,50 @@ func (c *Card) ATR() ATR {
 	return c.atr
 }
 
-// Trasmit bytes to card and return response.
-func (c *Card) Transmit(command []byte) ([]byte, error) {
-	response := make([]byte, 258)
-	received, err := c.context.winscard.Transmit(c.cardID, c.sendPCI,
-		command, response)
+func (c *pcscTransport) Transmit(cmd, resp []byte) (int, error) {
+	n, err := c.context.winscard.Transmit(c.cardID, c.sendPCI, cmd, resp)
+	return int(n), err
+}
+
+func (c *pcscTransport) Reconnect(initialization uint32) error {
+	protocol, err := c.context.winscard.CardReconnect(c.cardID, initialization)
+	if err != nil {
+		return err
+	}
+	pci, err := c.context.pci(protocol)
+	if err != nil {
+		return err
+	}
+	c.sendPCI = pci
+	c.atr = nil
+	return nil
+}
+
+func (c *pcscTransport) BeginTransaction() error {
+	return c.context.winscard.BeginTransaction(c.cardID)
+}
+
+func (c *pcscTransport) EndTransaction(disposition uint32) error {
+	return c.context.winscard.EndTransaction(c.cardID, disposition)
+}
+
+func (c *pcscTransport) Status() (CardStatus, error) {
+	reader, state, protocol, atr, err := c.context.winscard.Status(c.cardID)
+	if err != nil {
+		return CardStatus{}, err
+	}
+	return CardStatus{Reader: reader, State: state, Protocol: protocol, ATR: atr}, nil
+}
+
+func (c *pcscTransport) Control(code uint32, data []byte) ([]byte, error) {
+	resp := make([]byte, maxControlLength)
+	n, err := c.context.winscard.Control(c.cardID, pcsc.CtlCode(code), data, resp)
 	if err != nil {
 		return nil, err
 	}
-	return response[:received], nil
+	return resp[:n], nil
+}
+
+func (c *pcscTransport) GetAttrib(attr uint32) ([]byte, error) {
+	return c.context.winscard.GetAttrib(c.cardID, attr)
 }
@@ -0,0 +1,700 @@
+package scard
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// Factory default PINs of OpenPGP cards, which a new Simulator starts with.
+const (
+	DefaultPW1 = "123456"
+	DefaultPW3 = "12345678"
+)
+
+const simulatorRetries = 3
+
+var (
+	// simulatorATR is the ATR of a YubiKey 4
+	simulatorATR = ATR{0x3b, 0xf8, 0x13, 0x00, 0x00, 0x81, 0x31, 0xfe, 0x15, 0x59, 0x75, 0x62, 0x69, 0x6b, 0x65, 0x79, 0x34, 0xd4}
+	// simulatorAID carries version 3.4, the manufacturer ID reserved for test cards and serial number 1
+	simulatorAID = concat(AidOpenPGP, 0x03, 0x04, 0xff, 0xfe, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00)
+)
+
+// Simulator is a software OpenPGP card. It implements Transport, so that NewCard(NewSimulator()) stands in for a
+// card in a reader, e.g. to test higher layers without hardware. It keeps the state of the OpenPGP application: the
+// PINs and their retry counters, the data objects and the keys of the three key slots. Like a YubiKey it also has
+// an OATH application.
+//
+// Keys are generated with the algorithm attributes of their slot, which can be changed with PUT DATA. The slots
+// start with Ed25519 for signature and authentication and Curve25519 for decryption. Since the fingerprint of an
+// OpenPGP key is computed by the host, generated and imported keys get a placeholder fingerprint until the host
+// writes the real one.
+type Simulator struct {
+	mu          sync.Mutex
+	selected    bool
+	pins        map[Pin][]byte // PW1 and PW3, PW1CDS is checked against PW1
+	retries     map[Pin]int
+	verified    map[Pin]bool
+	pw1Multiple bool // PW1CDS stays verified after a signature
+	data        map[uint16][]byte
+	attributes  map[KeySlot]AlgorithmAttributes
+	keys        map[KeySlot]crypto.PrivateKey
+	counter     int    // digital signature counter
+	chain       []byte // data of the previous commands of a command chain
+	pending     []byte // response data left for GET RESPONSE
+	oath        *oathApplet
+	oathActive  bool // the OATH application is selected instead of OpenPGP
+	oathTouch   func() bool
+}
+
+// NewSimulator returns a simulated card with the factory default PINs and no keys.
+func NewSimulator() *Simulator {
+	return &Simulator{
+		pins:     map[Pin][]byte{PW1: []byte(DefaultPW1), PW3: []byte(DefaultPW3)},
+		retries:  map[Pin]int{PW1: simulatorRetries, PW3: simulatorRetries},
+		verified: make(map[Pin]bool),
+		data: map[uint16][]byte{
+			DoAID.tag:            simulatorAID,
+			DoHistBytes.tag:      {0x00, 0x73, 0x00, 0x00, 0xe0, 0x05, 0x90, 0x00},
+			DoCardCaps.tag:       {0x00, 0x00, 0xc0}, // command chaining, extended length
+			DoExtLenCaps.tag:     {0x7c, 0x00, 0x00, 0xff, 0x08, 0x00, 0x00, 0xff, 0x00, 0x00},
+			DoLangPrefs.tag:      []byte("en"),
+			DoFingerprints.tag:   make([]byte, 60),
+			DoCAFingerprints.tag: make([]byte, 60),
+			DoKeyGenDate.tag:     make([]byte, 12),
+		},
+		attributes: map[KeySlot]AlgorithmAttributes{
+			KeySign:    {Algorithm: AlgoEdDSA, OID: OidEd25519},
+			KeyDecrypt: {Algorithm: AlgoECDH, OID: OidCurve25519},
+			KeyAuth:    {Algorithm: AlgoEdDSA, OID: OidEd25519},
+		},
+		keys: make(map[KeySlot]crypto.PrivateKey),
+		oath: newOathApplet(),
+	}
+}
+
+// ATR returns the ATR of the simulated card.
+func (s *Simulator) ATR() ATR {
+	return simulatorATR
+}
+
+// Close ends the session like removing the card: the application is deselected and the PINs have to be verified
+// again.
+func (s *Simulator) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.selected, s.oathActive = false, false
+	s.verified = make(map[Pin]bool)
+	s.oath.validated = false
+	s.chain, s.pending = nil, nil
+	return nil
+}
+
+// Transmit processes the command APDU cmd and writes the response APDU to resp.
+func (s *Simulator) Transmit(cmd, resp []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var data []byte
+	var sw StatusWord
+	if apdu, err := parseAPDU(cmd); err != nil {
+		sw = 0x6700
+	} else {
+		data, sw = s.process(apdu)
+	}
+	if len(data)+2 > len(resp) {
+		return 0, io.ErrShortBuffer
+	}
+	n := copy(resp, data)
+	binary.BigEndian.PutUint16(resp[n:], uint16(sw))
+	return n + 2, nil
+}
+
+// ImportKey stores key in slot and sets the algorithm attributes of the slot to match it. key is an *rsa.PrivateKey,
+// *ecdsa.PrivateKey, ed25519.PrivateKey or *ecdh.PrivateKey.
+func (s *Simulator) ImportKey(slot KeySlot, key crypto.PrivateKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.attributes[slot]; !ok {
+		return fmt.Errorf("unknown key slot %02X", byte(slot))
+	}
+	var attrs AlgorithmAttributes
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		attrs = AlgorithmAttributes{
+			Algorithm:    AlgoRSA,
+			ModulusBits:  uint16(k.N.BitLen()),
+			ExponentBits: uint16(big.NewInt(int64(k.E)).BitLen()),
+		}
+	case *ecdsa.PrivateKey:
+		oid, ok := curveOIDs[k.Curve.Params().Name]
+		if !ok {
+			return fmt.Errorf("unsupported curve: %s", k.Curve.Params().Name)
+		}
+		attrs = AlgorithmAttributes{Algorithm: AlgoECDSA, OID: oid}
+	case ed25519.PrivateKey:
+		attrs = AlgorithmAttributes{Algorithm: AlgoEdDSA, OID: OidEd25519}
+	case *ecdh.PrivateKey:
+		oid, ok := curveOIDs[fmt.Sprint(k.Curve())]
+		if !ok {
+			return fmt.Errorf("unsupported curve: %s", k.Curve())
+		}
+		attrs = AlgorithmAttributes{Algorithm: AlgoECDH, OID: oid}
+	default:
+		return fmt.Errorf("unsupported key type %T", key)
+	}
+	s.attributes[slot] = attrs
+	s.setKey(slot, key)
+	return nil
+}
+
+// curveOIDs maps the names of the curves of crypto/elliptic and crypto/ecdh to their OIDs.
+var curveOIDs = map[string][]byte{
+	"P-256":  OidP256,
+	"P-384":  OidP384,
+	"P-521":  OidP521,
+	"X25519": OidCurve25519,
+}
+
+func (s *Simulator) setKey(slot KeySlot, key crypto.PrivateKey) {
+	s.keys[slot] = key
+	i := slotIndex(slot)
+	fingerprint := sha1.Sum(publicKeyTemplate(key))
+	copy(s.data[DoFingerprints.tag][20*i:], fingerprint[:])
+	binary.BigEndian.PutUint32(s.data[DoKeyGenDate.tag][4*i:], uint32(time.Now().Unix()))
+}
+
+func slotIndex(slot KeySlot) int {
+	switch slot {
+	case KeySign:
+		return 0
+	case KeyDecrypt:
+		return 1
+	}
+	return 2
+}
+
+func (s *Simulator) process(apdu APDU) ([]byte, StatusWord) {
+	if apdu.Cla&0x10 != 0 {
+		s.chain = append(s.chain, apdu.Data...)
+		return nil, SwOK
+	}
+	if s.chain != nil {
+		apdu.Data, s.chain = append(s.chain, apdu.Data...), nil
+	}
+	if apdu.Ins == 0xc0 || s.oathActive && apdu.Ins == oathInsSendRemain {
+		return s.getResponse(apdu)
+	}
+	s.pending = nil
+
+	// CALCULATE ALL of the OATH application shares the instruction with SELECT
+	if apdu.Ins == 0xa4 && (apdu.P1 == 0x04 || !s.oathActive) {
+		return s.selectApplication(apdu)
+	}
+	if s.oathActive {
+		return s.processOath(apdu)
+	}
+	if !s.selected {
+		return nil, 0x6985
+	}
+	switch apdu.Ins {
+	case 0xca:
+		return s.getData(apdu)
+	case 0xda:
+		return nil, s.putData(apdu)
+	case 0x20:
+		return nil, s.verify(apdu)
+	case 0x24:
+		return nil, s.changeReferenceData(apdu)
+	case 0x2c:
+		return nil, s.resetRetryCounter(apdu)
+	case 0x47:
+		return s.generateKeyPair(apdu)
+	case 0x2a:
+		return s.performSecurityOperation(apdu)
+	case 0x88:
+		return s.internalAuthenticate(apdu)
+	case 0x84:
+		return s.getChallenge(apdu)
+	}
+	return nil, 0x6d00
+}
+
+// respond returns as much of data as the command expects and keeps the rest for GET RESPONSE.
+func (s *Simulator) respond(apdu APDU, data []byte) ([]byte, StatusWord) {
+	max := apdu.Le
+	if max == 0 {
+		max = maxShortLe
+		if apdu.Elf {
+			max = maxExtendedLe
+		}
+	}
+	if len(data) <= max {
+		return data, SwOK
+	}
+	s.pending = data[max:]
+	return data[:max], 0x6100 | StatusWord(min(len(s.pending), 0x100)&0xff)
+}
+
+func (s *Simulator) getResponse(apdu APDU) ([]byte, StatusWord) {
+	if s.pending == nil {
+		return nil, 0x6985
+	}
+	data := s.pending
+	s.pending = nil
+	return s.respond(apdu, data)
+}
+
+func (s *Simulator) selectApplication(apdu APDU) ([]byte, StatusWord) {
+	s.oathActive = apdu.P1 == 0x04 && bytes.Equal(apdu.Data, AidYubicoOATH)
+	if s.oathActive {
+		s.selected = false
+		return s.selectOath()
+	}
+	s.selected = apdu.P1 == 0x04 && len(apdu.Data) > 0 && bytes.HasPrefix(simulatorAID, apdu.Data)
+	if !s.selected {
+		return nil, 0x6a82
+	}
+	return nil, SwOK
+}
+
+// value returns the value of a data object, composing constructed data objects from their children.
+func (s *Simulator) value(tag uint16) []byte {
+	switch tag {
+	case DoAlgoAttrSign.tag:
+		return s.attributes[KeySign].Bytes()
+	case DoAlgoAttrEnc.tag:
+		return s.attributes[KeyDecrypt].Bytes()
+	case DoAlgoAttrAuth.tag:
+		return s.attributes[KeyAuth].Bytes()
+	case DoPWStatus.tag:
+		multiple := byte(0)
+		if s.pw1Multiple {
+			multiple = 1
+		}
+		return []byte{multiple, 0x7f, 0x7f, 0x7f, byte(s.retries[PW1]), 0, byte(s.retries[PW3])}
+	case DoDigSigCtr.tag:
+		return []byte{byte(s.counter >> 16), byte(s.counter >> 8), byte(s.counter)}
+	}
+	if do, ok := findDataObject(tag); ok && do.constructed {
+		var b []byte
+		for _, child := range do.children() {
+			if v := s.value(child.tag); v != nil {
+				b = append(b, tlv(child.tag, v)...)
+			}
+		}
+		return b
+	}
+	return s.data[tag]
+}
+
+func (s *Simulator) getData(apdu APDU) ([]byte, StatusWord) {
+	value := s.value(uint16(apdu.P1)<<8 | uint16(apdu.P2))
+	if value == nil {
+		return nil, 0x6a88
+	}
+	return s.respond(apdu, value)
+}
+
+// fingerprint and generation date data objects that the host writes after generating or importing a key
+var keyDataObjects = map[uint16]struct {
+	parent uint16
+	offset int
+	size   int
+}{
+	0xc7: {DoFingerprints.tag, 0, 20},
+	0xc8: {DoFingerprints.tag, 20, 20},
+	0xc9: {DoFingerprints.tag, 40, 20},
+	0xca: {DoCAFingerprints.tag, 0, 20},
+	0xcb: {DoCAFingerprints.tag, 20, 20},
+	0xcc: {DoCAFingerprints.tag, 40, 20},
+	0xce: {DoKeyGenDate.tag, 0, 4},
+	0xcf: {DoKeyGenDate.tag, 4, 4},
+	0xd0: {DoKeyGenDate.tag, 8, 4},
+}
+
+func (s *Simulator) putData(apdu APDU) StatusWord {
+	tag := uint16(apdu.P1)<<8 | uint16(apdu.P2)
+	access := PW3
+	if tag == DoPrivateDO1.tag || tag == DoPrivateDO3.tag {
+		access = PW1
+	}
+	if !s.verified[access] {
+		return 0x6982
+	}
+
+	slots := map[uint16]KeySlot{DoAlgoAttrSign.tag: KeySign, DoAlgoAttrEnc.tag: KeyDecrypt, DoAlgoAttrAuth.tag: KeyAuth}
+	if slot, ok := slots[tag]; ok {
+		attrs, err := parseAlgorithmAttributes(apdu.Data)
+		if err != nil || !supportedAttributes(slot, attrs) {
+			return 0x6a80
+		}
+		s.attributes[slot] = attrs
+		delete(s.keys, slot)
+		return SwOK
+	}
+	if tag == DoPWStatus.tag {
+		if len(apdu.Data) < 1 {
+			return 0x6700
+		}
+		s.pw1Multiple = apdu.Data[0] == 1
+		return SwOK
+	}
+	if do, ok := keyDataObjects[tag]; ok {
+		if len(apdu.Data) != do.size {
+			return 0x6700
+		}
+		copy(s.data[do.parent][do.offset:], apdu.Data)
+		return SwOK
+	}
+	do, ok := findDataObject(tag)
+	if !ok || do.constructed || tag == DoAID.tag || tag == DoDigSigCtr.tag {
+		return 0x6a88
+	}
+	if len(apdu.Data) == 0 {
+		delete(s.data, tag)
+	} else {
+		s.data[tag] = append([]byte(nil), apdu.Data...)
+	}
+	return SwOK
+}
+
+func supportedAttributes(slot KeySlot, attrs AlgorithmAttributes) bool {
+	switch attrs.Algorithm {
+	case AlgoRSA:
+		return attrs.ModulusBits >= 1024 && attrs.ModulusBits <= 4096 && attrs.ModulusBits%8 == 0
+	case AlgoECDSA:
+		_, err := ecdsaCurve(attrs)
+		return slot != KeyDecrypt && err == nil
+	case AlgoEdDSA:
+		return slot != KeyDecrypt && attrs.Curve() == "Ed25519"
+	case AlgoECDH:
+		_, err := ecdhCurve(attrs)
+		return slot == KeyDecrypt && err == nil
+	}
+	return false
+}
+
+// pinReference returns the password that pin is checked against.
+func pinReference(pin Pin) (Pin, bool) {
+	switch pin {
+	case PW1CDS, PW1:
+		return PW1, true
+	case PW3:
+		return PW3, true
+	}
+	return 0, false
+}
+
+// checkPIN compares value with the password ref and counts failed attempts.
+func (s *Simulator) checkPIN(ref Pin, value []byte) StatusWord {
+	if s.retries[ref] == 0 {
+		return 0x6983
+	}
+	if subtle.ConstantTimeCompare(value, s.pins[ref]) != 1 {
+		s.retries[ref]--
+		return 0x63c0 | StatusWord(s.retries[ref])
+	}
+	s.retries[ref] = simulatorRetries
+	return SwOK
+}
+
+func (s *Simulator) verify(apdu APDU) StatusWord {
+	pin := Pin(apdu.P2)
+	ref, ok := pinReference(pin)
+	if !ok || (apdu.P1 != 0 && apdu.P1 != 0xff) {
+		return 0x6a86
+	}
+	if apdu.P1 == 0xff {
+		delete(s.verified, pin)
+		return SwOK
+	}
+	if len(apdu.Data) == 0 {
+		if s.verified[pin] {
+			return SwOK
+		}
+		return 0x63c0 | StatusWord(s.retries[ref])
+	}
+	delete(s.verified, pin)
+	sw := s.checkPIN(ref, apdu.Data)
+	if sw == SwOK {
+		s.verified[pin] = true
+	}
+	return sw
+}
+
+func (s *Simulator) changeReferenceData(apdu APDU) StatusWord {
+	ref, ok := pinReference(Pin(apdu.P2))
+	if !ok || apdu.P1 != 0 || Pin(apdu.P2) == PW1 {
+		return 0x6a86
+	}
+	minLength := len(DefaultPW1)
+	if ref == PW3 {
+		minLength = len(DefaultPW3)
+	}
+	current := len(s.pins[ref])
+	if len(apdu.Data) < current+minLength {
+		return 0x6a80
+	}
+	if sw := s.checkPIN(ref, apdu.Data[:current]); sw != SwOK {
+		return sw
+	}
+	s.pins[ref] = append([]byte(nil), apdu.Data[current:]...)
+	return SwOK
+}
+
+func (s *Simulator) resetRetryCounter(apdu APDU) StatusWord {
+	if apdu.P1 != 0x02 || Pin(apdu.P2) != PW1CDS {
+		return 0x6a81 // resetting code not supported
+	}
+	if !s.verified[PW3] {
+		return 0x6982
+	}
+	if len(apdu.Data) < len(DefaultPW1) {
+		return 0x6a80
+	}
+	s.pins[PW1] = append([]byte(nil), apdu.Data...)
+	s.retries[PW1] = simulatorRetries
+	return SwOK
+}
+
+func (s *Simulator) generateKeyPair(apdu APDU) ([]byte, StatusWord) {
+	if len(apdu.Data) < 1 {
+		return nil, 0x6a80
+	}
+	slot := KeySlot(apdu.Data[0])
+	attrs, ok := s.attributes[slot]
+	if !ok {
+		return nil, 0x6a80
+	}
+	switch apdu.P1 {
+	case 0x80:
+		if !s.verified[PW3] {
+			return nil, 0x6982
+		}
+		key, err := generateKey(attrs)
+		if err != nil {
+			return nil, 0x6f00
+		}
+		s.setKey(slot, key)
+	case 0x81:
+	default:
+		return nil, 0x6a86
+	}
+	key, ok := s.keys[slot]
+	if !ok {
+		return nil, 0x6a88
+	}
+	return s.respond(apdu, publicKeyTemplate(key))
+}
+
+func generateKey(attrs AlgorithmAttributes) (crypto.PrivateKey, error) {
+	switch attrs.Algorithm {
+	case AlgoRSA:
+		return rsa.GenerateKey(rand.Reader, int(attrs.ModulusBits))
+	case AlgoECDSA:
+		curve, err := ecdsaCurve(attrs)
+		if err != nil {
+			return nil, err
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case AlgoEdDSA:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	case AlgoECDH:
+		curve, err := ecdhCurve(attrs)
+		if err != nil {
+			return nil, err
+		}
+		return curve.GenerateKey(rand.Reader)
+	}
+	return nil, fmt.Errorf("unsupported algorithm: %s", attrs.Algorithm)
+}
+
+// publicKeyTemplate encodes the public key of key as returned by GENERATE ASYMMETRIC KEY PAIR.
+func publicKeyTemplate(key crypto.PrivateKey) []byte {
+	var template []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		template = concat(tlv(0x81, k.N.Bytes()), tlv(0x82, big.NewInt(int64(k.E)).Bytes())...)
+	case *ecdsa.PrivateKey:
+		template = tlv(0x86, elliptic.Marshal(k.Curve, k.X, k.Y))
+	case ed25519.PrivateKey:
+		template = tlv(0x86, k.Public().(ed25519.PublicKey))
+	case *ecdh.PrivateKey:
+		template = tlv(0x86, k.PublicKey().Bytes())
+	}
+	return tlv(0x7f49, template)
+}
+
+func (s *Simulator) performSecurityOperation(apdu APDU) ([]byte, StatusWord) {
+	switch {
+	case apdu.P1 == 0x9e && apdu.P2 == 0x9a:
+		if !s.verified[PW1CDS] {
+			return nil, 0x6982
+		}
+		sig, sw := s.sign(KeySign, apdu.Data)
+		if sw != SwOK {
+			return nil, sw
+		}
+		s.counter++
+		if !s.pw1Multiple {
+			delete(s.verified, PW1CDS)
+		}
+		return s.respond(apdu, sig)
+	case apdu.P1 == 0x80 && apdu.P2 == 0x86:
+		if !s.verified[PW1] {
+			return nil, 0x6982
+		}
+		plain, sw := s.decipher(apdu.Data)
+		if sw != SwOK {
+			return nil, sw
+		}
+		return s.respond(apdu, plain)
+	}
+	return nil, 0x6a86
+}
+
+func (s *Simulator) internalAuthenticate(apdu APDU) ([]byte, StatusWord) {
+	if !s.verified[PW1] {
+		return nil, 0x6982
+	}
+	sig, sw := s.sign(KeyAuth, apdu.Data)
+	if sw != SwOK {
+		return nil, sw
+	}
+	return s.respond(apdu, sig)
+}
+
+// sign signs data like a card: the DigestInfo for RSA, the hash for ECDSA and the message for EdDSA.
+func (s *Simulator) sign(slot KeySlot, data []byte) ([]byte, StatusWord) {
+	var sig []byte
+	var err error
+	switch k := s.keys[slot].(type) {
+	case nil:
+		return nil, 0x6a88
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(nil, k, 0, data)
+	case *ecdsa.PrivateKey:
+		var r, ss *big.Int
+		if r, ss, err = ecdsa.Sign(rand.Reader, k, data); err == nil {
+			size := (k.Curve.Params().BitSize + 7) / 8
+			sig = concat(r.FillBytes(make([]byte, size)), ss.FillBytes(make([]byte, size))...)
+		}
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, data)
+	default:
+		return nil, 0x6985
+	}
+	if err != nil {
+		return nil, 0x6a80
+	}
+	return sig, SwOK
+}
+
+func (s *Simulator) decipher(data []byte) ([]byte, StatusWord) {
+	switch k := s.keys[KeyDecrypt].(type) {
+	case nil:
+		return nil, 0x6a88
+	case *rsa.PrivateKey:
+		if len(data) < 1 || data[0] != 0x00 {
+			return nil, 0x6a80
+		}
+		plain, err := rsa.DecryptPKCS1v15(nil, k, data[1:])
+		if err != nil {
+			return nil, 0x6a80
+		}
+		return plain, SwOK
+	case *ecdh.PrivateKey:
+		public, err := k.Curve().NewPublicKey(doFindTLV(data, 0x86, 0))
+		if err != nil {
+			return nil, 0x6a80
+		}
+		secret, err := k.ECDH(public)
+		if err != nil {
+			return nil, 0x6a80
+		}
+		return secret, SwOK
+	}
+	return nil, 0x6985
+}
+
+func (s *Simulator) getChallenge(apdu APDU) ([]byte, StatusWord) {
+	length := apdu.Le
+	if length == 0 {
+		length = maxShortLe
+	}
+	challenge := make([]byte, length)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, 0x6f00
+	}
+	return challenge, SwOK
+}
+
+// parseAPDU decodes a command APDU with short or extended length fields.
+func parseAPDU(cmd []byte) (APDU, error) {
+	if len(cmd) < 4 {
+		return APDU{}, ErrWrongLength
+	}
+	apdu := APDU{Cla: cmd[0], Ins: cmd[1], P1: cmd[2], P2: cmd[3]}
+	body := cmd[4:]
+	switch {
+	case len(body) == 0:
+	case len(body) == 1:
+		apdu.Le = shortLe(body[0])
+	case body[0] == 0 && len(body) == 3:
+		apdu.Elf = true
+		apdu.Le = extendedLe(body[1:])
+	case body[0] == 0:
+		apdu.Elf = true
+		lc := int(binary.BigEndian.Uint16(body[1:3]))
+		rest := body[3:]
+		if lc == 0 || len(rest) != lc && len(rest) != lc+2 {
+			return APDU{}, ErrWrongLength
+		}
+		apdu.Data = rest[:lc]
+		if len(rest) == lc+2 {
+			apdu.Le = extendedLe(rest[lc:])
+		}
+	default:
+		lc := int(body[0])
+		rest := body[1:]
+		if len(rest) != lc && len(rest) != lc+1 {
+			return APDU{}, ErrWrongLength
+		}
+		apdu.Data = rest[:lc]
+		if len(rest) == lc+1 {
+			apdu.Le = shortLe(rest[lc])
+		}
+	}
+	return apdu, nil
+}
+
+// shortLe decodes a short Le field, whose maximum value is encoded as zero.
+func shortLe(b byte) int {
+	if b == 0 {
+		return maxShortLe
+	}
+	return int(b)
+}
+
+// extendedLe decodes an extended Le field, whose maximum value is encoded as zero.
+func extendedLe(b []byte) int {
+	if le := int(binary.BigEndian.Uint16(b)); le > 0 {
+		return le
+	}
+	return maxExtendedLe
+}
@@ -0,0 +1,220 @@
+package scard
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"slices"
+)
+
+// simulatorOathVersion is the version of the OATH application of a YubiKey 5
+var simulatorOathVersion = []byte{0x05, 0x04, 0x03}
+
+// oathApplet is the state of the OATH application of a Simulator.
+type oathApplet struct {
+	salt        []byte
+	key         []byte // access key, nil without a password
+	validated   bool
+	challenge   []byte // challenge sent with the last SELECT
+	credentials []*oathSimCredential
+}
+
+type oathSimCredential struct {
+	name    string
+	kind    byte // type and algorithm
+	digits  byte
+	key     []byte
+	touch   bool
+	counter uint64 // moving factor of HOTP credentials
+}
+
+func newOathApplet() *oathApplet {
+	salt := make([]byte, 8)
+	_, _ = rand.Read(salt)
+	return &oathApplet{salt: salt}
+}
+
+// SetOathTouch sets the function that decides whether the user touches the simulated token when the OATH
+// application calculates the code of a credential that requires touch. By default it is touched.
+func (s *Simulator) SetOathTouch(touch func() bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.oathTouch = touch
+}
+
+func (s *Simulator) selectOath() ([]byte, StatusWord) {
+	a := s.oath
+	a.validated = a.key == nil
+	resp := concat(oathTLV(oathTagVersion, simulatorOathVersion), oathTLV(oathTagName, a.salt)...)
+	if a.key != nil {
+		a.challenge = make([]byte, oathChallengeSize)
+		if _, err := rand.Read(a.challenge); err != nil {
+			return nil, 0x6f00
+		}
+		resp = append(resp, oathTLV(oathTagChallenge, a.challenge)...)
+		resp = append(resp, oathTLV(oathTagAlgorithm, []byte{byte(OathSHA1)})...)
+	}
+	return resp, SwOK
+}
+
+func (s *Simulator) processOath(apdu APDU) ([]byte, StatusWord) {
+	a := s.oath
+	if apdu.Ins == oathInsValidate {
+		return a.validate(apdu.Data)
+	}
+	if !a.validated {
+		return nil, 0x6982
+	}
+	if apdu.Ins == oathInsPut {
+		return nil, a.put(apdu.Data)
+	}
+	tlvs, err := parseOathTLVs(apdu.Data)
+	if err != nil {
+		return nil, 0x6a80
+	}
+	switch apdu.Ins {
+	case oathInsDelete:
+		if len(tlvs) != 1 || tlvs[0].tag != oathTagName {
+			return nil, 0x6a80
+		}
+		i := a.find(string(tlvs[0].value))
+		if i < 0 {
+			return nil, 0x6984
+		}
+		a.credentials = slices.Delete(a.credentials, i, i+1)
+		return nil, SwOK
+	case oathInsSetCode:
+		return nil, a.setCode(tlvs)
+	case oathInsList:
+		var resp []byte
+		for _, c := range a.credentials {
+			resp = append(resp, oathTLV(oathTagNameList, concat([]byte{c.kind}, []byte(c.name)...))...)
+		}
+		return s.respond(apdu, resp)
+	case oathInsCalculate:
+		if len(tlvs) != 2 || tlvs[0].tag != oathTagName || tlvs[1].tag != oathTagChallenge {
+			return nil, 0x6a80
+		}
+		i := a.find(string(tlvs[0].value))
+		if i < 0 {
+			return nil, 0x6984
+		}
+		c := a.credentials[i]
+		if c.touch && s.oathTouch != nil && !s.oathTouch() {
+			return nil, 0x6985
+		}
+		return s.respond(apdu, oathTLV(oathTagTruncated, c.calculate(tlvs[1].value)))
+	case oathInsCalculateAll:
+		if len(tlvs) != 1 || tlvs[0].tag != oathTagChallenge {
+			return nil, 0x6a80
+		}
+		var resp []byte
+		for _, c := range a.credentials {
+			resp = append(resp, oathTLV(oathTagName, []byte(c.name))...)
+			switch {
+			case OathType(c.kind&0xf0) == OathHOTP:
+				resp = append(resp, oathTLV(oathTagHOTP, []byte{c.digits})...)
+			case c.touch:
+				resp = append(resp, oathTLV(oathTagTouch, []byte{c.digits})...)
+			default:
+				resp = append(resp, oathTLV(oathTagTruncated, c.calculate(tlvs[0].value))...)
+			}
+		}
+		return s.respond(apdu, resp)
+	}
+	return nil, 0x6d00
+}
+
+func (a *oathApplet) find(name string) int {
+	return slices.IndexFunc(a.credentials, func(c *oathSimCredential) bool { return c.name == name })
+}
+
+func (a *oathApplet) validate(data []byte) ([]byte, StatusWord) {
+	tlvs, err := parseOathTLVs(data)
+	if err != nil || len(tlvs) != 2 || tlvs[0].tag != oathTagResponse || tlvs[1].tag != oathTagChallenge {
+		return nil, 0x6a80
+	}
+	if a.key == nil || a.challenge == nil {
+		return nil, 0x6984
+	}
+	if !hmac.Equal(tlvs[0].value, hmacSum(OathSHA1, a.key, a.challenge)) {
+		return nil, 0x6a80
+	}
+	a.validated, a.challenge = true, nil
+	return oathTLV(oathTagResponse, hmacSum(OathSHA1, a.key, tlvs[1].value)), SwOK
+}
+
+func (a *oathApplet) setCode(tlvs []oathTLVValue) StatusWord {
+	if len(tlvs) == 1 && tlvs[0].tag == oathTagKey && len(tlvs[0].value) == 0 {
+		a.key = nil
+		return SwOK
+	}
+	if len(tlvs) != 3 || tlvs[0].tag != oathTagKey || tlvs[1].tag != oathTagChallenge || tlvs[2].tag != oathTagResponse ||
+		len(tlvs[0].value) != 1+oathAccessKeySize {
+		return 0x6a80
+	}
+	key := tlvs[0].value[1:]
+	if !hmac.Equal(tlvs[2].value, hmacSum(OathAlgorithm(tlvs[0].value[0]&0x0f), key, tlvs[1].value)) {
+		return 0x6a80
+	}
+	a.key = bytes.Clone(key)
+	return SwOK
+}
+
+func (a *oathApplet) put(data []byte) StatusWord {
+	c := &oathSimCredential{}
+	for len(data) > 0 {
+		// the property is a tag followed by its value without a length
+		if data[0] == oathTagProperty {
+			if len(data) < 2 {
+				return 0x6a80
+			}
+			c.touch = data[1]&oathPropertyTouch != 0
+			data = data[2:]
+			continue
+		}
+		t, rest, err := nextOathTLV(data)
+		if err != nil {
+			return 0x6a80
+		}
+		data = rest
+		switch t.tag {
+		case oathTagName:
+			c.name = string(t.value)
+		case oathTagKey:
+			if len(t.value) < 2+oathMinKeySize {
+				return 0x6a80
+			}
+			c.kind, c.digits, c.key = t.value[0], t.value[1], bytes.Clone(t.value[2:])
+		case oathTagIMF:
+			if len(t.value) != 4 {
+				return 0x6a80
+			}
+			c.counter = uint64(binary.BigEndian.Uint32(t.value))
+		default:
+			return 0x6a80
+		}
+	}
+	if c.name == "" || c.key == nil {
+		return 0x6a80
+	}
+	if i := a.find(c.name); i >= 0 {
+		a.credentials[i] = c
+	} else {
+		a.credentials = append(a.credentials, c)
+	}
+	return SwOK
+}
+
+// calculate returns the truncated response to challenge, the time step of TOTP credentials, or to the next counter
+// value of HOTP credentials.
+func (c *oathSimCredential) calculate(challenge []byte) []byte {
+	if OathType(c.kind&0xf0) == OathHOTP {
+		challenge = binary.BigEndian.AppendUint64(nil, c.counter)
+		c.counter++
+	}
+	sum := hmacSum(OathAlgorithm(c.kind&0x0f), c.key, challenge)
+	offset := sum[len(sum)-1] & 0x0f
+	return concat([]byte{c.digits}, sum[offset:offset+4]...)
+}
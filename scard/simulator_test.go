@@ -0,0 +1,217 @@
+package scard
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func newSimulatedOpenPGP(t *testing.T) (*Simulator, *OpenPGP) {
+	t.Helper()
+	sim := NewSimulator()
+	o, err := NewCard(sim).OpenPGP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sim, o
+}
+
+func TestSimulator_GenerateSignDecipher(t *testing.T) {
+	_, o := newSimulatedOpenPGP(t)
+	if _, err := o.GenerateKeyPair(KeySign); !errors.Is(err, ErrSecurityStatusNotSatisfied) {
+		t.Fatalf("Expected ErrSecurityStatusNotSatisfied without PW3, got %v", err)
+	}
+	if err := o.Verify(PW3, []byte(DefaultPW3)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := o.ApplicationRelatedData()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signKey, err := o.GenerateKeyPair(KeySign)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := signKey.CryptoPublicKey(data.Key(KeySign).Attributes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Verify(PW1CDS, []byte(DefaultPW1)); err != nil {
+		t.Fatal(err)
+	}
+	message := []byte("message")
+	sig, err := o.Sign(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(public.(ed25519.PublicKey), message, sig) {
+		t.Fatal("Invalid signature")
+	}
+	if _, err := o.Sign(message); !errors.Is(err, ErrSecurityStatusNotSatisfied) {
+		t.Fatalf("Expected PW1CDS to be valid for one signature, got %v", err)
+	}
+	if counter, err := o.SignatureCounter(); err != nil || counter != 1 {
+		t.Fatalf("Expected signature counter 1, got %d (%v)", counter, err)
+	}
+
+	decryptKey, err := o.GenerateKeyPair(KeyDecrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := ecdh.X25519().NewPublicKey(decryptKey.Point)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ephemeral.ECDH(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Verify(PW1, []byte(DefaultPW1)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := o.DecipherECDH(ephemeral.PublicKey().Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("Expected shared secret %x, got %x", want, got)
+	}
+
+	data, err = o.ApplicationRelatedData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, slot := range []KeySlot{KeySign, KeyDecrypt} {
+		if !data.Key(slot).Present() {
+			t.Fatalf("Expected %s key to be present", slot)
+		}
+	}
+	if data.Key(KeyAuth).Present() {
+		t.Fatal("Expected no authentication key")
+	}
+}
+
+func TestSimulator_PIN(t *testing.T) {
+	_, o := newSimulatedOpenPGP(t)
+	for left := 2; left >= 0; left-- {
+		err := o.Verify(PW1, []byte("000000"))
+		if n, ok := RetriesLeft(err); !ok || n != left {
+			t.Fatalf("Expected %d retries left, got %v", left, err)
+		}
+	}
+	if err := o.Verify(PW1, []byte(DefaultPW1)); !errors.Is(err, ErrAuthenticationMethodBlocked) {
+		t.Fatalf("Expected ErrAuthenticationMethodBlocked, got %v", err)
+	}
+
+	if err := o.ResetPIN([]byte("654321")); !errors.Is(err, ErrSecurityStatusNotSatisfied) {
+		t.Fatalf("Expected ErrSecurityStatusNotSatisfied without PW3, got %v", err)
+	}
+	if err := o.Verify(PW3, []byte(DefaultPW3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.ResetPIN([]byte("654321")); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Verify(PW1, []byte("654321")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := o.ChangePIN(PW1, []byte("654321"), []byte("112233")); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Verify(PW1, []byte("654321")); err == nil {
+		t.Fatal("Expected the old PIN to be rejected")
+	}
+	if err := o.Verify(PW1, []byte("112233")); err != nil {
+		t.Fatal(err)
+	}
+	status, err := o.GetData(DoPWStatus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pw, err := parsePWStatus(status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw.PW1Retries != 3 || pw.PW3Retries != 3 {
+		t.Fatalf("Expected reset retry counters, got %+v", pw)
+	}
+}
+
+func TestSimulator_ImportRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim, o := newSimulatedOpenPGP(t)
+	if err := sim.ImportKey(KeyDecrypt, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.ImportKey(KeyAuth, key); err != nil {
+		t.Fatal(err)
+	}
+
+	// the public key and the cryptogram exceed the short length fields
+	public, err := o.PublicKey(KeyDecrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(public.Modulus, key.N.Bytes()) {
+		t.Fatal("Unexpected modulus")
+	}
+	secret := []byte("session key")
+	cryptogram, err := rsa.EncryptPKCS1v15(rand.Reader, &key.PublicKey, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Verify(PW1, []byte(DefaultPW1)); err != nil {
+		t.Fatal(err)
+	}
+	plain, err := o.DecipherRSA(cryptogram)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plain, secret) {
+		t.Fatalf("Expected %q, got %q", secret, plain)
+	}
+
+	digest := sha256.Sum256([]byte("challenge"))
+	prefix := []byte{0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20}
+	sig, err := o.InternalAuthenticate(concat(prefix, digest[:]...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSimulator_Close(t *testing.T) {
+	sim, o := newSimulatedOpenPGP(t)
+	if err := o.Verify(PW3, []byte(DefaultPW3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sim.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.GetData(DoAID); !errors.Is(err, ErrConditionsOfUseNotSatisfied) {
+		t.Fatalf("Expected ErrConditionsOfUseNotSatisfied without selected application, got %v", err)
+	}
+	if _, err := NewOpenPGP(NewCard(sim)); err != nil {
+		t.Fatal(err)
+	}
+	if err := o.PutData(DoName, []byte("Doe<<John")); !errors.Is(err, ErrSecurityStatusNotSatisfied) {
+		t.Fatalf("Expected ErrSecurityStatusNotSatisfied after the session ended, got %v", err)
+	}
+}
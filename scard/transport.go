@@ -0,0 +1,103 @@
+package scard
+
+import (
+	"errors"
+)
+
+var ErrNotSupported = errors.New("not supported by the card transport")
+
+// Transport carries raw APDUs between the host and a card, e.g. through a PC/SC reader or to a Simulator.
+type Transport interface {
+	// Transmit sends the command APDU cmd and writes the response APDU including the status word to resp.
+	Transmit(cmd, resp []byte) (int, error)
+	// ATR returns the answer to reset of the card.
+	ATR() ATR
+	// Close ends the connection to the card.
+	Close() error
+}
+
+// ReaderTransport is a Transport to a card in a reader that is shared with other applications.
+type ReaderTransport interface {
+	Transport
+	Reconnect(initialization uint32) error
+	BeginTransaction() error
+	EndTransaction(disposition uint32) error
+	Status() (CardStatus, error)
+	Control(code uint32, data []byte) ([]byte, error)
+	GetAttrib(attr uint32) ([]byte, error)
+}
+
+// Card represents a connection to a smart card.
+type Card struct {
+	transport Transport
+}
+
+// NewCard returns a card that exchanges APDUs through transport.
+func NewCard(transport Transport) *Card {
+	return &Card{transport: transport}
+}
+
+// ATR returns the card ATR (Answer To Reset).
+func (c *Card) ATR() ATR {
+	return c.transport.ATR()
+}
+
+// Disconnect from card.
+func (c *Card) Disconnect() error {
+	return c.transport.Close()
+}
+
+func (c *Card) transmit(cmd, resp []byte) (int, error) {
+	return c.transport.Transmit(cmd, resp)
+}
+
+// Reconnect re-establishes the connection to the card, e.g. after ErrCardReset. initialization is the action taken
+// on the card first: LEAVE_CARD, RESET_CARD or UNPOWER_CARD.
+func (c *Card) Reconnect(initialization uint32) error {
+	if t, ok := c.transport.(ReaderTransport); ok {
+		return t.Reconnect(initialization)
+	}
+	return ErrNotSupported
+}
+
+// BeginTransaction gains exclusive access to the card. It blocks while another application holds a transaction.
+// Cards that are not shared through a reader are always accessed exclusively.
+func (c *Card) BeginTransaction() error {
+	if t, ok := c.transport.(ReaderTransport); ok {
+		return t.BeginTransaction()
+	}
+	return nil
+}
+
+// EndTransaction releases the exclusive access to the card and takes the action disposition on it.
+func (c *Card) EndTransaction(disposition uint32) error {
+	if t, ok := c.transport.(ReaderTransport); ok {
+		return t.EndTransaction(disposition)
+	}
+	return nil
+}
+
+// Status returns the current state of the card.
+func (c *Card) Status() (CardStatus, error) {
+	if t, ok := c.transport.(ReaderTransport); ok {
+		return t.Status()
+	}
+	return CardStatus{}, ErrNotSupported
+}
+
+// Control sends a command for the reader driver, e.g. a PC/SC part 10 feature request, and returns its response.
+// code is the reader specific function, it is turned into the control code of the platform.
+func (c *Card) Control(code uint32, data []byte) ([]byte, error) {
+	if t, ok := c.transport.(ReaderTransport); ok {
+		return t.Control(code, data)
+	}
+	return nil, ErrNotSupported
+}
+
+// GetAttrib returns the value of a reader attribute, e.g. pcsc.SCARD_ATTR_ATR_STRING.
+func (c *Card) GetAttrib(attr uint32) ([]byte, error) {
+	if t, ok := c.transport.(ReaderTransport); ok {
+		return t.GetAttrib(attr)
+	}
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,205 @@
+package scard
+
+import (
+	"bytes"
+	"context"
+	"sort"
+)
+
+// EventType is the kind of change reported by Context.Watch.
+type EventType int
+
+const (
+	EventReaderAttached EventType = iota
+	EventReaderDetached
+	EventCardInserted
+	EventCardRemoved
+)
+
+var eventTypeNames = map[EventType]string{
+	EventReaderAttached: "reader attached",
+	EventReaderDetached: "reader detached",
+	EventCardInserted:   "card inserted",
+	EventCardRemoved:    "card removed",
+}
+
+func (t EventType) String() string {
+	if s, ok := eventTypeNames[t]; ok {
+		return s
+	}
+	return "<unknown>"
+}
+
+// Event is a change of the readers or cards reported by Context.Watch. The last event of a watch that failed
+// carries the error in Err.
+type Event struct {
+	Type   EventType
+	Reader string
+	ATR    ATR // ATR of an inserted card
+	Err    error
+}
+
+// readerState is the state of a reader that Watch compares between reader events.
+type readerState struct {
+	present bool
+	counter uint32 // number of card insertions and removals
+	atr     ATR
+}
+
+// Watch streams reader and card events until done is cancelled or waiting for events fails, and closes the channel
+// then. The readers and cards present when Watch is called are reported first. Watch waits for events on a
+// connection of its own, so that the context can still be used to connect to cards.
+func (ctx *Context) Watch(done context.Context) (<-chan Event, error) {
+	w, err := ctx.newWatcher()
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan Event)
+	go func() {
+		defer close(events)
+		defer w.close()
+		stop := context.AfterFunc(done, w.cancel)
+		defer stop()
+
+		send := func(event Event) bool {
+			select {
+			case events <- event:
+				return true
+			case <-done.Done():
+				return false
+			}
+		}
+		var prev map[string]readerState
+		for {
+			next, err := w.wait()
+			if done.Err() != nil {
+				return
+			}
+			if err != nil {
+				send(Event{Err: err})
+				return
+			}
+			for _, event := range diffReaders(prev, next) {
+				if !send(event) {
+					return
+				}
+			}
+			prev = next
+		}
+	}()
+	return events, nil
+}
+
+// diffReaders returns the events that turn the reader states prev into next, ordered by reader name.
+func diffReaders(prev, next map[string]readerState) []Event {
+	names := make([]string, 0, len(prev)+len(next))
+	for name := range prev {
+		names = append(names, name)
+	}
+	for name := range next {
+		if _, ok := prev[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var events []Event
+	for _, name := range names {
+		p, attached := prev[name]
+		n, ok := next[name]
+		switch {
+		case !attached:
+			events = append(events, Event{Type: EventReaderAttached, Reader: name})
+			if n.present {
+				events = append(events, Event{Type: EventCardInserted, Reader: name, ATR: n.atr})
+			}
+		case !ok:
+			if p.present {
+				events = append(events, Event{Type: EventCardRemoved, Reader: name})
+			}
+			events = append(events, Event{Type: EventReaderDetached, Reader: name})
+		default:
+			// a changed counter means the card was swapped between two events
+			swapped := p.present && n.present && p.counter != n.counter
+			if p.present && (!n.present || swapped) {
+				events = append(events, Event{Type: EventCardRemoved, Reader: name})
+			}
+			if n.present && (!p.present || swapped) {
+				events = append(events, Event{Type: EventCardInserted, Reader: name, ATR: n.atr})
+			}
+		}
+	}
+	return events
+}
+
+// WaitForCardPresent blocks until a smart card is inserted into any reader or returns immediately if a card is already
+// present. It cannot be cancelled, use WaitForCard for that.
+func (ctx *Context) WaitForCardPresent() (*Reader, error) {
+	return ctx.WaitForCard(context.Background())
+}
+
+// WaitForCard blocks until a smart card is inserted into any reader or done is cancelled. It returns immediately if a
+// card is already present.
+func (ctx *Context) WaitForCard(done context.Context) (*Reader, error) {
+	watch, cancel := context.WithCancel(done)
+	defer cancel()
+	events, err := ctx.Watch(watch)
+	if err != nil {
+		return nil, err
+	}
+	for event := range events {
+		if event.Err != nil {
+			return nil, event.Err
+		}
+		if event.Type != EventCardInserted {
+			continue
+		}
+		readers, err := ctx.ListReadersWithCard()
+		if err != nil {
+			return nil, err
+		}
+		for _, reader := range readers {
+			if reader.Name() == event.Reader {
+				return reader, nil
+			}
+		}
+	}
+	return nil, done.Err()
+}
+
+// WaitUntilCardRemoved blocks until the card is removed from the reader. It cannot be cancelled, use
+// WaitForCardRemoved for that.
+func (r *Reader) WaitUntilCardRemoved() {
+	r.WaitForCardRemoved(context.Background())
+}
+
+// WaitForCardRemoved blocks until the card is removed from the reader or done is cancelled. The card is the one the
+// reader held when it was listed, checked with IsCardPresent or connected, so that a card swapped since then, told
+// apart by the event counter and ATR of the reader, counts as removed.
+func (r *Reader) WaitForCardRemoved(done context.Context) error {
+	if !r.card.present && !r.IsCardPresent() {
+		return nil
+	}
+	card := r.card
+	w, err := r.context.newWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.close()
+	stop := context.AfterFunc(done, w.cancel)
+	defer stop()
+
+	for {
+		states, err := w.wait()
+		if done.Err() != nil {
+			return done.Err()
+		}
+		if err != nil {
+			return err
+		}
+		state, ok := states[r.Name()]
+		if !ok || !state.present || state.counter != card.counter || !bytes.Equal(state.atr, card.atr) {
+			return nil
+		}
+	}
+}
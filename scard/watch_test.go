@@ -0,0 +1,60 @@
+package scard
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffReaders(t *testing.T) {
+	atr := ATR{0x3b, 0x8d}
+	for _, test := range []struct {
+		name       string
+		prev, next map[string]readerState
+		want       []Event
+	}{
+		{
+			name: "initial",
+			next: map[string]readerState{"b": {}, "a": {present: true, counter: 1, atr: atr}},
+			want: []Event{
+				{Type: EventReaderAttached, Reader: "a"},
+				{Type: EventCardInserted, Reader: "a", ATR: atr},
+				{Type: EventReaderAttached, Reader: "b"},
+			},
+		},
+		{
+			name: "insert",
+			prev: map[string]readerState{"a": {counter: 2}},
+			next: map[string]readerState{"a": {present: true, counter: 3, atr: atr}},
+			want: []Event{{Type: EventCardInserted, Reader: "a", ATR: atr}},
+		},
+		{
+			name: "remove",
+			prev: map[string]readerState{"a": {present: true, counter: 3, atr: atr}},
+			next: map[string]readerState{"a": {counter: 4}},
+			want: []Event{{Type: EventCardRemoved, Reader: "a"}},
+		},
+		{
+			name: "swap",
+			prev: map[string]readerState{"a": {present: true, counter: 3, atr: atr}},
+			next: map[string]readerState{"a": {present: true, counter: 5, atr: atr}},
+			want: []Event{{Type: EventCardRemoved, Reader: "a"}, {Type: EventCardInserted, Reader: "a", ATR: atr}},
+		},
+		{
+			name: "detach",
+			prev: map[string]readerState{"a": {present: true, counter: 3, atr: atr}, "b": {}},
+			next: map[string]readerState{"b": {}},
+			want: []Event{{Type: EventCardRemoved, Reader: "a"}, {Type: EventReaderDetached, Reader: "a"}},
+		},
+		{
+			name: "unchanged",
+			prev: map[string]readerState{"a": {present: true, counter: 3, atr: atr}},
+			next: map[string]readerState{"a": {present: true, counter: 3, atr: atr}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := diffReaders(test.prev, test.next); !reflect.DeepEqual(got, test.want) {
+				t.Fatalf("Expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package vault
+
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/mgrd"
+	"github.com/malivvan/aegis/opgp/armor"
+	"github.com/malivvan/aegis/opgp/constants"
+	"github.com/malivvan/aegis/opgp/crypto"
+)
+
+// cardKeySize is the size of the random key file that is encrypted to the cards.
+const cardKeySize = 32
+
+var ErrNoCardRecipient = errors.New("card key is not encrypted to this card")
+
+// CardKeyPath returns the path of the key file of the keyring at path that is encrypted to OpenPGP cards.
+func CardKeyPath(path string) string {
+	return path + ".key.asc"
+}
+
+// CardRecipientsPath returns the path of the public keys the card key file of the keyring at path is encrypted to.
+func CardRecipientsPath(path string) string {
+	return path + ".pub.asc"
+}
+
+// NewCardKey generates a random key file for the keyring at path and stores it next to the keyring, encrypted to
+// the encryption keys of recipients. Every recipient, e.g. a primary and a backup token, can decrypt it on its own.
+func NewCardKey(path string, recipients *crypto.KeyRing) ([]byte, error) {
+	for _, p := range []string{CardKeyPath(path), CardRecipientsPath(path)} {
+		if _, err := os.Stat(p); err == nil {
+			return nil, os.ErrExist
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	key := make([]byte, cardKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := writeCardKey(path, key, recipients); err != nil {
+		mgrd.WipeBytes(key)
+		os.Remove(CardKeyPath(path))
+		os.Remove(CardRecipientsPath(path))
+		return nil, err
+	}
+	return key, nil
+}
+
+// OpenCardKey decrypts the card key file of the keyring at path with the decryption key of an OpenPGP card, e.g. a
+// *scard.Key whose PIN has been verified.
+func OpenCardKey(path string, decrypter crypto.Decrypter) ([]byte, error) {
+	recipients, err := readCardRecipients(path)
+	if err != nil {
+		return nil, err
+	}
+	message, err := os.ReadFile(CardKeyPath(path))
+	if err != nil {
+		return nil, err
+	}
+	for _, recipient := range recipients.GetKeys() {
+		key, err := crypto.NewKeyWithDecrypter(recipient, decrypter)
+		if err != nil {
+			continue
+		}
+		decryption, err := crypto.PGP().Decryption().DecryptionKey(key).New()
+		if err != nil {
+			return nil, err
+		}
+		result, err := decryption.Decrypt(message, crypto.Armor)
+		if err != nil {
+			return nil, err
+		}
+		return result.Bytes(), nil
+	}
+	return nil, ErrNoCardRecipient
+}
+
+// AddCardRecipients decrypts the card key file of the keyring at path with decrypter and encrypts it again to the
+// current and the given recipients.
+func AddCardRecipients(path string, decrypter crypto.Decrypter, keys ...*crypto.Key) error {
+	recipients, err := readCardRecipients(path)
+	if err != nil {
+		return err
+	}
+	key, err := OpenCardKey(path, decrypter)
+	if err != nil {
+		return err
+	}
+	defer mgrd.WipeBytes(key)
+	for _, k := range keys {
+		if err := recipients.AddKey(k); err != nil {
+			return err
+		}
+	}
+	return writeCardKey(path, key, recipients)
+}
+
+// CardCredentials returns the credentials of the keyring at path by decrypting its card key file with decrypter.
+func CardCredentials(path string, decrypter crypto.Decrypter) (*kdbx.DBCredentials, error) {
+	key, err := OpenCardKey(path, decrypter)
+	if err != nil {
+		return nil, err
+	}
+	defer mgrd.WipeBytes(key)
+	return kdbx.NewKeyDataCredentials(key)
+}
+
+// writeCardKey encrypts key to recipients and replaces the card key file and the recipients of the keyring at path.
+// Both files are written before either is replaced, the key file first, so that it is always encrypted to at least
+// the recipients listed next to it when extending them.
+func writeCardKey(path string, key []byte, recipients *crypto.KeyRing) error {
+	encryption, err := crypto.PGP().Encryption().Recipients(recipients).New()
+	if err != nil {
+		return err
+	}
+	message, err := encryption.Encrypt(key)
+	if err != nil {
+		return err
+	}
+	armored, err := message.ArmorBytes()
+	if err != nil {
+		return err
+	}
+	var public []byte
+	for _, recipient := range recipients.GetKeys() {
+		b, err := recipient.GetPublicKey()
+		if err != nil {
+			return err
+		}
+		public = append(public, b...)
+	}
+	armoredPublic, err := armor.ArmorWithTypeBytes(public, constants.PublicKeyHeader)
+	if err != nil {
+		return err
+	}
+	keyTmp, err := writeTemp(CardKeyPath(path), armored)
+	if err != nil {
+		return err
+	}
+	publicTmp, err := writeTemp(CardRecipientsPath(path), armoredPublic)
+	if err != nil {
+		os.Remove(keyTmp)
+		return err
+	}
+	if err := renameTemp(keyTmp, CardKeyPath(path)); err != nil {
+		os.Remove(publicTmp)
+		return err
+	}
+	return renameTemp(publicTmp, CardRecipientsPath(path))
+}
+
+func readCardRecipients(path string) (*crypto.KeyRing, error) {
+	data, err := os.ReadFile(CardRecipientsPath(path))
+	if err != nil {
+		return nil, err
+	}
+	public, err := armor.UnarmorBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.NewKeyRingFromBinary(public)
+}
@@ -0,0 +1,118 @@
+package vault
+
+import (
+	stdcrypto "crypto"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/opgp/crypto"
+	"github.com/malivvan/aegis/opgp/profile"
+	"github.com/malivvan/aegis/scard"
+)
+
+// testCard holds the decryption key of a generated OpenPGP key behind the crypto.Decrypter interface, like a card.
+type testCard struct {
+	public  *crypto.Key
+	decrypt stdcrypto.Decrypter
+}
+
+func newTestCard(t *testing.T, name string) testCard {
+	t.Helper()
+	key, err := crypto.PGPWithProfile(profile.RFC4880()).KeyGeneration().AddUserId(name, name+"@example.com").New().GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	public, err := key.ToPublic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypter := key.GetEntity().Subkeys[0].PrivateKey.PrivateKey.(stdcrypto.Decrypter)
+	return testCard{public: public, decrypt: struct{ stdcrypto.Decrypter }{decrypter}}
+}
+
+func TestCardKey(t *testing.T) {
+	primary, backup, other := newTestCard(t, "primary"), newTestCard(t, "backup"), newTestCard(t, "other")
+	path := filepath.Join(t.TempDir(), "test.kdbx")
+
+	recipients, err := crypto.NewKeyRing(primary.public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := NewCardKey(path, recipients)
+	if err != nil {
+		t.Fatalf("Failed to create card key: %s", err)
+	}
+	creds, err := kdbx.NewKeyDataCredentials(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := Create(path, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCardKey(path, recipients); err == nil {
+		t.Fatal("Expected existing card key not to be overwritten")
+	}
+
+	if _, err := CardCredentials(path, backup.decrypt); !errors.Is(err, ErrNoCardRecipient) {
+		t.Fatalf("Expected ErrNoCardRecipient, got %v", err)
+	}
+	if err := AddCardRecipients(path, primary.decrypt, backup.public); err != nil {
+		t.Fatalf("Failed to add recipient: %s", err)
+	}
+	for _, card := range []testCard{primary, backup} {
+		creds, err := CardCredentials(path, card.decrypt)
+		if err != nil {
+			t.Fatalf("Failed to decrypt card key: %s", err)
+		}
+		if _, err := Open(path, creds); err != nil {
+			t.Fatalf("Failed to open vault: %s", err)
+		}
+	}
+	if _, err := CardCredentials(path, other.decrypt); !errors.Is(err, ErrNoCardRecipient) {
+		t.Fatalf("Expected ErrNoCardRecipient, got %v", err)
+	}
+}
+
+func TestCardKey_Simulator(t *testing.T) {
+	key, err := crypto.PGPWithProfile(profile.RFC4880()).KeyGeneration().AddUserId("card", "card@example.com").New().GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %s", err)
+	}
+	public, err := key.ToPublic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sim := scard.NewSimulator()
+	if err := sim.ImportKey(scard.KeyDecrypt, key.GetEntity().Subkeys[0].PrivateKey.PrivateKey); err != nil {
+		t.Fatal(err)
+	}
+	card, err := scard.NewCard(sim).OpenPGP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := card.Verify(scard.PW1, []byte(scard.DefaultPW1)); err != nil {
+		t.Fatal(err)
+	}
+	decrypter, err := card.Key(scard.KeyDecrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "test.kdbx")
+	recipients, err := crypto.NewKeyRing(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCardKey(path, recipients); err != nil {
+		t.Fatalf("Failed to create card key: %s", err)
+	}
+	if _, err := CardCredentials(path, decrypter); err != nil {
+		t.Fatalf("Failed to decrypt card key on the simulator: %s", err)
+	}
+}
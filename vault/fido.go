@@ -0,0 +1,260 @@
+package vault
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/malivvan/aegis/fido"
+	"github.com/malivvan/aegis/hid"
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/mgrd"
+)
+
+// FIDORelyingParty is the relying party ID of the credentials that protect FIDO key files.
+const FIDORelyingParty = "aegis"
+
+// fidoSaltSize is the size of the hmac-secret salt, the largest one the extension accepts in a single salt.
+const fidoSaltSize = 32
+
+var ErrNoFIDOCredential = errors.New("FIDO key is not sealed to this security key")
+
+// FIDOPin asks for the PIN of a security key. Its result is wiped after use.
+type FIDOPin func() ([]byte, error)
+
+// fidoKey is the FIDO key file: the random key of the keyring sealed with the hmac-secret output of every
+// credential, so any of the security keys can unlock the keyring on its own.
+type fidoKey struct {
+	Salt        []byte           `json:"salt"`
+	Credentials []fidoCredential `json:"credentials"`
+}
+
+type fidoCredential struct {
+	ID     []byte `json:"id"`
+	Sealed []byte `json:"sealed"` // AES-256-GCM with the hmac-secret output, nonce first
+}
+
+// FIDOKeyPath returns the path of the key file of the keyring at path that is sealed to FIDO2 security keys.
+func FIDOKeyPath(path string) string {
+	return path + ".fido.json"
+}
+
+// NewFIDOKey generates a random key file for the keyring at path and seals it to a new hmac-secret credential of the
+// security key d. Registering the credential and deriving its secret both require touch, onKeepalive is notified
+// while the security key waits for it. If d has a PIN, pin is called to ask for it.
+func NewFIDOKey(ctx context.Context, path string, d *fido.Device, pin FIDOPin, onKeepalive hid.Keepalive) ([]byte, error) {
+	if _, err := os.Stat(FIDOKeyPath(path)); err == nil {
+		return nil, os.ErrExist
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	key := make([]byte, cardKeySize)
+	salt := make([]byte, fidoSaltSize)
+	for _, b := range [][]byte{key, salt} {
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+	}
+	f := &fidoKey{Salt: salt}
+	if err := f.seal(ctx, key, d, pin, onKeepalive); err != nil {
+		mgrd.WipeBytes(key)
+		return nil, err
+	}
+	if err := writeFIDOKey(path, f); err != nil {
+		mgrd.WipeBytes(key)
+		return nil, err
+	}
+	return key, nil
+}
+
+// OpenFIDOKey unseals the FIDO key file of the keyring at path with the hmac-secret output of the security key d.
+func OpenFIDOKey(ctx context.Context, path string, d *fido.Device, onKeepalive hid.Keepalive) ([]byte, error) {
+	f, err := readFIDOKey(path)
+	if err != nil {
+		return nil, err
+	}
+	return f.open(ctx, d, onKeepalive)
+}
+
+// AddFIDOKey unseals the FIDO key file of the keyring at path with the security key d and seals it to a new
+// credential of the security key other, e.g. a backup token. If other has a PIN, pin is called to ask for it.
+func AddFIDOKey(ctx context.Context, path string, d, other *fido.Device, pin FIDOPin, onKeepalive hid.Keepalive) error {
+	f, err := readFIDOKey(path)
+	if err != nil {
+		return err
+	}
+	key, err := f.open(ctx, d, onKeepalive)
+	if err != nil {
+		return err
+	}
+	defer mgrd.WipeBytes(key)
+	if err := f.seal(ctx, key, other, pin, onKeepalive); err != nil {
+		return err
+	}
+	return writeFIDOKey(path, f)
+}
+
+// FIDOCredentials returns the credentials of the keyring at path by unsealing its FIDO key file with d.
+func FIDOCredentials(ctx context.Context, path string, d *fido.Device, onKeepalive hid.Keepalive) (*kdbx.DBCredentials, error) {
+	key, err := OpenFIDOKey(ctx, path, d, onKeepalive)
+	if err != nil {
+		return nil, err
+	}
+	defer mgrd.WipeBytes(key)
+	return kdbx.NewKeyDataCredentials(key)
+}
+
+// seal registers a new credential on d and appends key sealed with its hmac-secret output.
+func (f *fidoKey) seal(ctx context.Context, key []byte, d *fido.Device, pin FIDOPin, onKeepalive hid.Keepalive) error {
+	userID := make([]byte, 16)
+	if _, err := rand.Read(userID); err != nil {
+		return err
+	}
+	token, err := fidoPinToken(ctx, d, pin)
+	if err != nil {
+		return err
+	}
+	if token != nil {
+		defer token.Destroy()
+	}
+	c, err := d.MakeCredential(ctx, &fido.MakeCredentialRequest{
+		ClientDataHash: make([]byte, 32),
+		RP:             fido.RelyingParty{ID: FIDORelyingParty, Name: FIDORelyingParty},
+		User:           fido.User{ID: userID, Name: FIDORelyingParty},
+		HMACSecret:     true,
+		PinToken:       token,
+	}, onKeepalive)
+	if err != nil {
+		return err
+	}
+	if !c.HMACSecret {
+		return fido.ErrUnsupportedExtension
+	}
+	_, secret, err := f.secret(ctx, d, [][]byte{c.ID}, onKeepalive)
+	if err != nil {
+		return err
+	}
+	defer mgrd.WipeBytes(secret)
+	sealed, err := sealFIDOKey(secret, key)
+	if err != nil {
+		return err
+	}
+	f.Credentials = append(f.Credentials, fidoCredential{ID: c.ID, Sealed: sealed})
+	return nil
+}
+
+// fidoPinToken returns a PIN token of d if it has a PIN, which it requires to register credentials. Without pin
+// the registration fails with fido.ErrPinRequired.
+func fidoPinToken(ctx context.Context, d *fido.Device, pin FIDOPin) (*fido.PinToken, error) {
+	info, err := d.GetInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Options["clientPin"] || pin == nil {
+		return nil, nil
+	}
+	p, err := pin()
+	if err != nil {
+		return nil, err
+	}
+	defer mgrd.WipeBytes(p)
+	return d.GetPinToken(ctx, p)
+}
+
+// open asks d for an assertion with any of the credentials and unseals the key of the one it answers with.
+func (f *fidoKey) open(ctx context.Context, d *fido.Device, onKeepalive hid.Keepalive) ([]byte, error) {
+	ids := make([][]byte, len(f.Credentials))
+	for i, c := range f.Credentials {
+		ids[i] = c.ID
+	}
+	id, secret, err := f.secret(ctx, d, ids, onKeepalive)
+	if errors.Is(err, fido.ErrNoCredentials) {
+		return nil, ErrNoFIDOCredential
+	} else if err != nil {
+		return nil, err
+	}
+	defer mgrd.WipeBytes(secret)
+	for _, c := range f.Credentials {
+		if bytes.Equal(c.ID, id) {
+			return openFIDOKey(secret, c.Sealed)
+		}
+	}
+	return nil, ErrNoFIDOCredential
+}
+
+// secret returns the ID of the credential d chooses from allowList and its hmac-secret output of the salt.
+func (f *fidoKey) secret(ctx context.Context, d *fido.Device, allowList [][]byte, onKeepalive hid.Keepalive) ([]byte, []byte, error) {
+	a, err := d.GetAssertion(ctx, &fido.GetAssertionRequest{
+		RPID:           FIDORelyingParty,
+		ClientDataHash: make([]byte, 32),
+		AllowList:      allowList,
+		HMACSecretSalt: f.Salt,
+	}, onKeepalive)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(a.HMACSecret) != fidoSaltSize {
+		return nil, nil, fido.ErrUnsupportedExtension
+	}
+	return a.CredentialID, a.HMACSecret, nil
+}
+
+func sealFIDOKey(secret, key []byte) ([]byte, error) {
+	aead, err := newFIDOCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, key, nil), nil
+}
+
+func openFIDOKey(secret, sealed []byte) ([]byte, error) {
+	aead, err := newFIDOCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrNoFIDOCredential
+	}
+	key, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrNoFIDOCredential
+	}
+	return key, nil
+}
+
+func newFIDOCipher(secret []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func writeFIDOKey(path string, f *fidoKey) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(FIDOKeyPath(path), data)
+}
+
+func readFIDOKey(path string) (*fidoKey, error) {
+	data, err := os.ReadFile(FIDOKeyPath(path))
+	if err != nil {
+		return nil, err
+	}
+	f := new(fidoKey)
+	if err := json.Unmarshal(data, f); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
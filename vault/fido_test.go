@@ -0,0 +1,74 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/malivvan/aegis/fido"
+	"github.com/malivvan/aegis/kdbx"
+)
+
+// newTestFIDODevice opens a simulated security key, with pin unless it is empty.
+func newTestFIDODevice(t *testing.T, pin string) *fido.Device {
+	t.Helper()
+	sim := fido.NewSimulator()
+	if pin != "" {
+		sim.SetPIN(pin)
+	}
+	d, err := fido.Open(sim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	return d
+}
+
+func TestFIDOKey(t *testing.T) {
+	ctx := context.Background()
+	primary, backup, other := newTestFIDODevice(t, ""), newTestFIDODevice(t, "1234"), newTestFIDODevice(t, "")
+	path := filepath.Join(t.TempDir(), "test.kdbx")
+
+	key, err := NewFIDOKey(ctx, path, primary, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create FIDO key: %s", err)
+	}
+	creds, err := kdbx.NewKeyDataCredentials(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := Create(path, creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFIDOKey(ctx, path, primary, nil, nil); err == nil {
+		t.Fatal("Expected existing FIDO key not to be overwritten")
+	}
+
+	if _, err := FIDOCredentials(ctx, path, backup, nil); !errors.Is(err, ErrNoFIDOCredential) {
+		t.Fatalf("Expected ErrNoFIDOCredential, got %v", err)
+	}
+	if err := AddFIDOKey(ctx, path, primary, backup, nil, nil); !errors.Is(err, fido.ErrPinRequired) {
+		t.Fatalf("Expected ErrPinRequired for a security key with a PIN, got %v", err)
+	}
+	pin := func() ([]byte, error) { return []byte("1234"), nil }
+	if err := AddFIDOKey(ctx, path, primary, backup, pin, nil); err != nil {
+		t.Fatalf("Failed to add security key: %s", err)
+	}
+	for _, d := range []*fido.Device{primary, backup} {
+		creds, err := FIDOCredentials(ctx, path, d, nil)
+		if err != nil {
+			t.Fatalf("Failed to unseal FIDO key: %s", err)
+		}
+		if _, err := Open(path, creds); err != nil {
+			t.Fatalf("Failed to open vault: %s", err)
+		}
+	}
+	if _, err := FIDOCredentials(ctx, path, other, nil); !errors.Is(err, ErrNoFIDOCredential) {
+		t.Fatalf("Expected ErrNoFIDOCredential, got %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// DefaultBackups is the number of backups Save keeps of a keyring unless Vault.Backups is changed.
+const DefaultBackups = 3
+
+// lockTimeout is how long Save waits for another process to release the lock of a keyring.
+var lockTimeout = 10 * time.Second
+
+const lockRetry = 50 * time.Millisecond
+
+// LockPath returns the path of the file whose advisory lock is held while the keyring at path is saved. The keyring
+// itself cannot be locked, because saving replaces it with a new file. The lock only serializes saving, it is not held
+// while a keyring is open: changes saved by another process in the meantime are merged by Save instead.
+func LockPath(path string) string {
+	return path + ".lock"
+}
+
+// BackupPath returns the path of the nth backup of the keyring at path, the first being the most recent.
+func BackupPath(path string, n int) string {
+	return path + ".bak" + strconv.Itoa(n)
+}
+
+// lock takes the advisory lock of the keyring at path, waiting for another process to release it for up to
+// lockTimeout. The returned function releases the lock.
+func lock(path string) (func(), error) {
+	f, err := os.OpenFile(LockPath(path), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err := lockFile(f)
+		if err == nil {
+			return func() {
+				unlockFile(f)
+				f.Close()
+			}, nil
+		}
+		if !errors.Is(err, ErrLocked) || time.Now().After(deadline) {
+			f.Close()
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		time.Sleep(lockRetry)
+	}
+}
+
+// resolve returns path with its symbolic links evaluated, so that the file they point to is replaced instead of the
+// links. A path that does not exist yet is returned as is.
+func resolve(path string) (string, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return path, nil
+	}
+	return resolved, err
+}
+
+// writeFile writes data to a temporary file next to path, flushes it to disk and renames it to path, so that path
+// holds either its previous or its new content if writing fails or the system crashes.
+func writeFile(path string, data []byte) error {
+	tmp, err := writeTemp(path, data)
+	if err != nil {
+		return err
+	}
+	return renameTemp(tmp, path)
+}
+
+// writeTemp writes data to a new temporary file next to path, flushes it to disk and returns its name. The temporary
+// file gets the permissions of path if it exists and is only accessible by the owner otherwise.
+func writeTemp(path string, data []byte) (string, error) {
+	path, err := resolve(path)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	if info, statErr := os.Stat(path); statErr == nil {
+		err = f.Chmod(info.Mode().Perm())
+	} else if !errors.Is(statErr, os.ErrNotExist) {
+		err = statErr
+	}
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// renameTemp renames the temporary file tmp written by writeTemp to path and flushes the directory entry.
+func renameTemp(tmp, path string) error {
+	path, err := resolve(path)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// rotateBackups shifts the backups of the keyring at path by one, dropping the oldest, and stores data as the most
+// recent backup.
+func rotateBackups(path string, data []byte, backups int) error {
+	if backups <= 0 {
+		return nil
+	}
+	if err := os.Remove(BackupPath(path, backups)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for n := backups - 1; n > 0; n-- {
+		if err := os.Rename(BackupPath(path, n), BackupPath(path, n+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return writeFile(BackupPath(path, 1), data)
+}
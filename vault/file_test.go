@@ -0,0 +1,123 @@
+package vault
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/malivvan/aegis/kdbx"
+)
+
+func TestVault_SaveBackups(t *testing.T) {
+	v := newTestVault(t)
+	v.Backups = 2
+	for _, path := range []string{"a", "b", "c", "d"} {
+		if _, err := v.AddEntry(path); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the most recent backup holds the keyring as it was before the last save
+	backup, err := Open(BackupPath(v.Path, 1), kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backup.Entry("c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backup.Entry("d"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := os.Stat(BackupPath(v.Path, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(BackupPath(v.Path, 3)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected os.ErrNotExist, got %v", err)
+	}
+
+	files, err := os.ReadDir(filepath.Dir(v.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".tmp") {
+			t.Fatalf("Temporary file %s was left behind", file.Name())
+		}
+	}
+}
+
+func TestVault_SaveLocked(t *testing.T) {
+	defer func(timeout time.Duration) { lockTimeout = timeout }(lockTimeout)
+	lockTimeout = 100 * time.Millisecond
+
+	v := newTestVault(t)
+	unlock, err := lock(v.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Save(); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected ErrLocked, got %v", err)
+	}
+	if _, err := os.Stat(v.Path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("Expected the locked keyring not to be written, got %v", err)
+	}
+
+	// a lock released while waiting is taken over
+	time.AfterFunc(50*time.Millisecond, unlock)
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVault_SaveSymlink(t *testing.T) {
+	v := newTestVault(t)
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(v.Path, 0640); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(t.TempDir(), "link.kdbx")
+	if err := os.Symlink(v.Path, link); err != nil {
+		t.Skipf("Symbolic links are not supported: %s", err)
+	}
+
+	linked, err := Open(link, kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := linked.AddEntry("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := linked.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the link still points to the keyring, which was replaced keeping its permissions and backed up next to it
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Fatalf("Expected %s to remain a symbolic link, got %v", link, err)
+	}
+	info, err := os.Stat(v.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Fatalf("Expected the keyring to keep mode 0640, got %o", info.Mode().Perm())
+	}
+	if _, err := os.Stat(BackupPath(v.Path, 1)); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := Open(v.Path, kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Entry("a"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,442 @@
+package vault
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/malivvan/aegis/kdbx"
+	w "github.com/malivvan/aegis/kdbx/wrappers"
+	"github.com/malivvan/aegis/mgrd"
+)
+
+// standardFields are the values every KeePass entry has, in display order.
+var standardFields = []string{"Title", "UserName", "Password", "URL", "Notes"}
+
+// OathField holds the name of a credential in the OATH application of a token, whose code is shown with the entry.
+const OathField = "OATH Credential"
+
+// Split splits a slash separated path into its parent group path and base name. The base name is unescaped, see
+// Escape.
+func Split(path string) (string, string) {
+	elems := splitPath(path)
+	if len(elems) == 0 {
+		return "", ""
+	}
+	return joinPath(elems[:len(elems)-1]), elems[len(elems)-1]
+}
+
+// Join appends name to the group path dir, escaping it.
+func Join(dir, name string) string {
+	return joinPath(append(splitPath(dir), name))
+}
+
+// Escape escapes a group name or entry title as an element of a path: "/" is written as "\/" and "\" as "\\".
+func Escape(name string) string {
+	return strings.NewReplacer(`\`, `\\`, "/", `\/`).Replace(name)
+}
+
+// splitPath returns the unescaped elements of path.
+func splitPath(path string) []string {
+	var elems []string
+	var elem strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; {
+		case c == '\\' && i+1 < len(path):
+			i++
+			elem.WriteByte(path[i])
+		case c == '/':
+			if elem.Len() > 0 {
+				elems = append(elems, elem.String())
+			}
+			elem.Reset()
+		default:
+			elem.WriteByte(c)
+		}
+	}
+	if elem.Len() > 0 {
+		elems = append(elems, elem.String())
+	}
+	return elems
+}
+
+func joinPath(elems []string) string {
+	escaped := make([]string, len(elems))
+	for i, elem := range elems {
+		escaped[i] = Escape(elem)
+	}
+	return strings.Join(escaped, "/")
+}
+
+// groupIndex returns the index of the subgroup of g called name, -1 if there is none, or ErrAmbiguous if there are
+// several.
+func groupIndex(g *kdbx.Group, name string) (int, error) {
+	index := -1
+	for i := range g.Groups {
+		if g.Groups[i].Name == name {
+			if index >= 0 {
+				return -1, fmt.Errorf("%s: %w", name, ErrAmbiguous)
+			}
+			index = i
+		}
+	}
+	return index, nil
+}
+
+// entryIndex returns the index of the entry of g with the title, -1 if there is none, or ErrAmbiguous if there are
+// several.
+func entryIndex(g *kdbx.Group, title string) (int, error) {
+	index := -1
+	for i := range g.Entries {
+		if g.Entries[i].GetTitle() == title {
+			if index >= 0 {
+				return -1, fmt.Errorf("%s: %w", title, ErrAmbiguous)
+			}
+			index = i
+		}
+	}
+	return index, nil
+}
+
+// exists reports whether g has a subgroup or an entry called name.
+func exists(g *kdbx.Group, name string) bool {
+	for i := range g.Groups {
+		if g.Groups[i].Name == name {
+			return true
+		}
+	}
+	for i := range g.Entries {
+		if g.Entries[i].GetTitle() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// child returns the index of the entry or of the subgroup of g called name, the other one is -1. It returns
+// ErrAmbiguous if several entries or groups have the name.
+func child(g *kdbx.Group, name string) (int, int, error) {
+	entry, err := entryIndex(g, name)
+	if err != nil {
+		return -1, -1, err
+	}
+	group, err := groupIndex(g, name)
+	if err != nil {
+		return -1, -1, err
+	}
+	if entry >= 0 && group >= 0 {
+		return -1, -1, fmt.Errorf("%s: %w", name, ErrAmbiguous)
+	}
+	return entry, group, nil
+}
+
+// Group returns the group at path. An empty path or "/" refers to the root group.
+func (v *Vault) Group(path string) (*kdbx.Group, error) {
+	g := v.Root()
+	for _, name := range splitPath(path) {
+		i, err := groupIndex(g, name)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 {
+			if exists(g, name) {
+				return nil, fmt.Errorf("%s: %w", path, ErrNotGroup)
+			}
+			return nil, fmt.Errorf("%s: %w", path, ErrNotFound)
+		}
+		g = &g.Groups[i]
+	}
+	return g, nil
+}
+
+// Entry returns the entry at path, identified by its title within the parent group.
+func (v *Vault) Entry(path string) (*kdbx.Entry, error) {
+	dir, title := Split(path)
+	if title == "" {
+		return nil, fmt.Errorf("%s: %w", path, ErrNotEntry)
+	}
+	parent, err := v.Group(dir)
+	if err != nil {
+		return nil, err
+	}
+	i, err := entryIndex(parent, title)
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 {
+		if exists(parent, title) {
+			return nil, fmt.Errorf("%s: %w", path, ErrNotEntry)
+		}
+		return nil, fmt.Errorf("%s: %w", path, ErrNotFound)
+	}
+	return &parent.Entries[i], nil
+}
+
+// MakeGroup creates the group at path. With parents set, missing parent groups are created
+// and an existing group is not an error.
+func (v *Vault) MakeGroup(path string, parents bool) (*kdbx.Group, error) {
+	elems := splitPath(path)
+	if len(elems) == 0 {
+		return nil, fmt.Errorf("%s: %w", path, ErrInvalidPath)
+	}
+	g := v.Root()
+	for n, name := range elems {
+		last := n == len(elems)-1
+		i, err := groupIndex(g, name)
+		if err != nil {
+			return nil, err
+		}
+		if i >= 0 {
+			if last && !parents {
+				return nil, fmt.Errorf("%s: %w", path, ErrExists)
+			}
+			g = &g.Groups[i]
+			continue
+		}
+		if exists(g, name) {
+			return nil, fmt.Errorf("%s: %w", path, ErrExists)
+		}
+		if !last && !parents {
+			return nil, fmt.Errorf("%s: %w", joinPath(elems[:n+1]), ErrNotFound)
+		}
+		group := kdbx.NewGroup()
+		group.Name = name
+		g.Groups = append(g.Groups, group)
+		g = &g.Groups[len(g.Groups)-1]
+	}
+	return g, nil
+}
+
+// AddEntry creates an entry at path whose title is the base name of path.
+func (v *Vault) AddEntry(path string) (*kdbx.Entry, error) {
+	dir, title := Split(path)
+	if title == "" {
+		return nil, fmt.Errorf("%s: %w", path, ErrInvalidPath)
+	}
+	parent, err := v.Group(dir)
+	if err != nil {
+		return nil, err
+	}
+	if exists(parent, title) {
+		return nil, fmt.Errorf("%s: %w", path, ErrExists)
+	}
+	entry := kdbx.NewEntry()
+	v.SetValue(&entry, "Title", title)
+	parent.Entries = append(parent.Entries, entry)
+	return &parent.Entries[len(parent.Entries)-1], nil
+}
+
+// SetValue sets the value of key in e, protecting it according to the database memory protection settings.
+func (v *Vault) SetValue(e *kdbx.Entry, key, value string) {
+	protected := key == "Password" || v.isProtected(key)
+	if i := e.GetIndex(key); i >= 0 {
+		e.Values[i].Value = kdbx.NewV(value, protected)
+		return
+	}
+	e.Values = append(e.Values, kdbx.ValueData{Key: key, Value: kdbx.NewV(value, protected)})
+}
+
+// SetSecret sets the value of key in e to the content of buf as a protected value. buf is sealed and destroyed.
+func (v *Vault) SetSecret(e *kdbx.Entry, key string, buf *mgrd.LockedBuffer) {
+	if i := e.GetIndex(key); i >= 0 {
+		e.Values[i].Value = kdbx.NewProtectedV(buf)
+		return
+	}
+	e.Values = append(e.Values, kdbx.ValueData{Key: key, Value: kdbx.NewProtectedV(buf)})
+}
+
+// Fields returns the keys of the values of e, standard fields first followed by custom fields in
+// alphabetical order.
+func Fields(e *kdbx.Entry) []string {
+	fields := append([]string(nil), standardFields...)
+	var custom []string
+	for _, value := range e.Values {
+		if !isStandardField(value.Key) {
+			custom = append(custom, value.Key)
+		}
+	}
+	sort.Strings(custom)
+	return append(fields, custom...)
+}
+
+func isStandardField(key string) bool {
+	for _, field := range standardFields {
+		if field == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Vault) isProtected(key string) bool {
+	mp := v.DB.Content.Meta.MemoryProtection
+	switch key {
+	case "Title":
+		return mp.ProtectTitle.Bool
+	case "UserName":
+		return mp.ProtectUserName.Bool
+	case "Password":
+		return mp.ProtectPassword.Bool
+	case "URL":
+		return mp.ProtectURL.Bool
+	case "Notes":
+		return mp.ProtectNotes.Bool
+	}
+	return false
+}
+
+// UpdateEntry applies update to the entry at path after pushing its current state into the history.
+func (v *Vault) UpdateEntry(path string, update func(*kdbx.Entry)) error {
+	e, err := v.Entry(path)
+	if err != nil {
+		return err
+	}
+	v.updateEntry(e, update)
+	return nil
+}
+
+func (v *Vault) updateEntry(e *kdbx.Entry, update func(*kdbx.Entry)) {
+	backup := *e
+	backup.Histories = nil
+	backup.Values = append([]kdbx.ValueData(nil), e.Values...)
+	backup.Binaries = append([]kdbx.BinaryReference(nil), e.Binaries...)
+	backup.CustomData = append([]kdbx.CustomData(nil), e.CustomData...)
+	if len(e.Histories) == 0 {
+		e.Histories = []kdbx.History{{}}
+	}
+	e.Histories[0].Entries = append(e.Histories[0].Entries, backup)
+	v.DB.TruncateHistory(e)
+
+	update(e)
+
+	now := w.Now()
+	e.Times.LastModificationTime = &now
+}
+
+// Remove deletes the entry or group at path and records it as a deleted object.
+// Groups which are not empty are only removed when recursive is set.
+func (v *Vault) Remove(path string, recursive bool) error {
+	dir, name := Split(path)
+	if name == "" {
+		return fmt.Errorf("%s: %w", path, ErrInvalidPath)
+	}
+	parent, err := v.Group(dir)
+	if err != nil {
+		return err
+	}
+	ei, gi, err := child(parent, name)
+	if err != nil {
+		return err
+	}
+	if ei >= 0 {
+		v.deleted(parent.Entries[ei].UUID)
+		parent.Entries = append(parent.Entries[:ei], parent.Entries[ei+1:]...)
+		return nil
+	}
+	if gi < 0 {
+		return fmt.Errorf("%s: %w", path, ErrNotFound)
+	}
+	g := &parent.Groups[gi]
+	if !recursive && (len(g.Groups) > 0 || len(g.Entries) > 0) {
+		return fmt.Errorf("%s: %w", path, ErrGroupNotEmpty)
+	}
+	v.deletedGroup(g)
+	parent.Groups = append(parent.Groups[:gi], parent.Groups[gi+1:]...)
+	return nil
+}
+
+func (v *Vault) deletedGroup(g *kdbx.Group) {
+	for i := range g.Groups {
+		v.deletedGroup(&g.Groups[i])
+	}
+	for i := range g.Entries {
+		v.deleted(g.Entries[i].UUID)
+	}
+	v.deleted(g.UUID)
+}
+
+func (v *Vault) deleted(uuid kdbx.UUID) {
+	now := w.Now()
+	v.DB.Content.Root.DeletedObjects = append(v.DB.Content.Root.DeletedObjects, kdbx.DeletedObjectData{
+		UUID:         uuid,
+		DeletionTime: &now,
+	})
+}
+
+// Move moves the entry or group at src to dst. If dst is an existing group the source is moved
+// into it, otherwise dst names the new parent group and base name. Renaming an entry pushes its
+// previous state into the history, like UpdateEntry.
+func (v *Vault) Move(src, dst string) error {
+	srcDir, srcName := Split(src)
+	if srcName == "" {
+		return fmt.Errorf("%s: %w", src, ErrInvalidPath)
+	}
+	dstDir, dstName := Split(dst)
+	if target, err := v.Group(dst); err == nil {
+		dstDir, dstName = joinPath(splitPath(dst)), srcName
+		if target == v.Root() {
+			dstDir = ""
+		}
+	}
+
+	from, err := v.Group(srcDir)
+	if err != nil {
+		return err
+	}
+	if srcDir == dstDir && srcName == dstName {
+		return nil
+	}
+	to, err := v.Group(dstDir)
+	if err != nil {
+		return err
+	}
+	if exists(to, dstName) {
+		return fmt.Errorf("%s: %w", dst, ErrExists)
+	}
+	ei, gi, err := child(from, srcName)
+	if err != nil {
+		return err
+	}
+	now := w.Now()
+
+	if ei >= 0 {
+		entry := from.Entries[ei]
+		if srcName != dstName {
+			v.updateEntry(&entry, func(e *kdbx.Entry) { v.SetValue(e, "Title", dstName) })
+		}
+		if from != to {
+			entry.Times.LocationChanged = &now
+		}
+		from.Entries = append(from.Entries[:ei], from.Entries[ei+1:]...)
+		// from and to may share the same parent slice, so resolve again after removal
+		if to, err = v.Group(dstDir); err != nil {
+			return err
+		}
+		to.Entries = append(to.Entries, entry)
+		return nil
+	}
+
+	if gi < 0 {
+		return fmt.Errorf("%s: %w", src, ErrNotFound)
+	}
+	srcPath := joinPath(append(splitPath(srcDir), srcName))
+	dstPath := joinPath(splitPath(dstDir))
+	if dstPath == srcPath || strings.HasPrefix(dstPath, srcPath+"/") {
+		return fmt.Errorf("%s: cannot move a group into itself: %w", dst, ErrInvalidPath)
+	}
+	group := from.Groups[gi]
+	if group.Name != dstName {
+		group.Name = dstName
+		group.Times.LastModificationTime = &now
+	}
+	if from != to {
+		group.Times.LocationChanged = &now
+	}
+	from.Groups = append(from.Groups[:gi], from.Groups[gi+1:]...)
+	// removing the group shifts its siblings, which may contain the destination
+	if to, err = v.Group(dstDir); err != nil {
+		return err
+	}
+	to.Groups = append(to.Groups, group)
+	return nil
+}
@@ -0,0 +1,163 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/malivvan/aegis/kdbx"
+	w "github.com/malivvan/aegis/kdbx/wrappers"
+)
+
+const (
+	rootGroupName = "Root"
+	generator     = "aegis"
+)
+
+var (
+	ErrNotFound      = errors.New("no such group or entry")
+	ErrExists        = errors.New("group or entry already exists")
+	ErrNotGroup      = errors.New("not a group")
+	ErrNotEntry      = errors.New("not an entry")
+	ErrGroupNotEmpty = errors.New("group not empty")
+	ErrInvalidPath   = errors.New("invalid path")
+	ErrAmbiguous     = errors.New("several groups or entries have the same name")
+	ErrLocked        = errors.New("keyring is locked by another process")
+	ErrVerify        = errors.New("encoded keyring cannot be decoded")
+)
+
+// Vault is an unlocked keyring database backed by a file.
+type Vault struct {
+	Path string
+	DB   *kdbx.Database
+	// Merged holds the changes the last Save merged from the file, because it was changed since it was opened.
+	Merged []kdbx.Change
+	// Backups is the number of previous versions of the file Save keeps next to it, see BackupPath.
+	Backups int
+	// MergeRules are applied by Save and Merge to entries found in both keyrings, see kdbx.Merge.
+	MergeRules []kdbx.MergeRule
+	digest     [sha256.Size]byte // of the file as it was read or written last
+}
+
+// Open decodes the keyring at path and unlocks its protected values.
+func Open(path string, credentials *kdbx.DBCredentials) (*Vault, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := decode(data, credentials)
+	if err != nil {
+		return nil, err
+	}
+	return &Vault{Path: path, DB: db, Backups: DefaultBackups, digest: sha256.Sum256(data)}, nil
+}
+
+func decode(data []byte, credentials *kdbx.DBCredentials) (*kdbx.Database, error) {
+	db := kdbx.NewDatabase()
+	db.Credentials = credentials
+	if err := kdbx.NewDecoder(bytes.NewReader(data)).Decode(db); err != nil {
+		return nil, err
+	}
+	if err := db.UnlockProtectedEntries(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Create initializes an empty KDBX 4 keyring for path. It is not written until Save is called.
+func Create(path string, credentials *kdbx.DBCredentials, options ...kdbx.DatabaseOption) (*Vault, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, os.ErrExist
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	db := kdbx.NewDatabase(append([]kdbx.DatabaseOption{kdbx.WithDatabaseKDBXVersion4()}, options...)...)
+	db.Credentials = credentials
+	db.Content.Meta.Generator = generator
+	db.Content.Meta.MemoryProtection.ProtectPassword = w.NewBoolWrapper(true)
+
+	root := kdbx.NewGroup()
+	root.Name = rootGroupName
+	db.Content.Root.Groups = []kdbx.Group{root}
+
+	return &Vault{Path: path, DB: db, Backups: DefaultBackups}, nil
+}
+
+// Save locks the protected values, writes the keyring to its path and unlocks them again. If the file was changed
+// since the keyring was opened, the changes are merged into the keyring first and recorded in Merged.
+//
+// While saving, the advisory lock at LockPath is held, so that other aegis processes wait for the keyring to be
+// written before merging it. The keyring is only written after it was decoded again with its credentials, to a
+// temporary file that replaces the previous version, which is kept as the first of Backups backups. If Path is a
+// symbolic link, the file it points to is replaced and backed up, keeping its permissions.
+func (v *Vault) Save() error {
+	path, err := resolve(v.Path)
+	if err != nil {
+		return err
+	}
+	unlock, err := lock(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	v.Merged = nil
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := v.sync(data); err != nil {
+		return err
+	}
+
+	if err := v.DB.LockProtectedEntries(); err != nil {
+		return err
+	}
+	defer v.DB.UnlockProtectedEntries()
+
+	var buf bytes.Buffer
+	if err := kdbx.NewEncoder(&buf).Encode(v.DB); err != nil {
+		return err
+	}
+	if _, err := decode(buf.Bytes(), v.DB.Credentials); err != nil {
+		return fmt.Errorf("%w: %w", ErrVerify, err)
+	}
+	if data != nil {
+		if err := rotateBackups(path, data, v.Backups); err != nil {
+			return err
+		}
+	}
+	if err := writeFile(path, buf.Bytes()); err != nil {
+		return err
+	}
+	v.digest = sha256.Sum256(buf.Bytes())
+	return nil
+}
+
+// sync merges data, the content of the file, into the keyring if it was changed since it was read or written last.
+func (v *Vault) sync(data []byte) error {
+	if data == nil || sha256.Sum256(data) == v.digest {
+		return nil
+	}
+	db, err := decode(data, v.DB.Credentials)
+	if err != nil {
+		return fmt.Errorf("%s was changed and cannot be merged: %w", v.Path, err)
+	}
+	if v.Merged, err = kdbx.Merge(v.DB, db, v.MergeRules...); err != nil {
+		return fmt.Errorf("%s was changed and cannot be merged: %w", v.Path, err)
+	}
+	return nil
+}
+
+// Merge merges the keyring other into v and returns the changes made to v.
+func (v *Vault) Merge(other *Vault) ([]kdbx.Change, error) {
+	return kdbx.Merge(v.DB, other.DB, v.MergeRules...)
+}
+
+// Root returns the root group of the keyring.
+func (v *Vault) Root() *kdbx.Group {
+	return &v.DB.Content.Root.Groups[0]
+}
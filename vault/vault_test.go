@@ -0,0 +1,294 @@
+package vault
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/mgrd"
+)
+
+func newTestVault(t *testing.T) *Vault {
+	t.Helper()
+	v, err := Create(filepath.Join(t.TempDir(), "test.kdbx"), kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatalf("Failed to create vault: %s", err)
+	}
+	return v
+}
+
+func TestVault_SaveOpen(t *testing.T) {
+	v := newTestVault(t)
+	if _, err := v.MakeGroup("web/mail", true); err != nil {
+		t.Fatal(err)
+	}
+	e, err := v.AddEntry("web/mail/example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.SetValue(e, "UserName", "alice")
+	v.SetValue(e, "Password", "hunter2")
+	if err := v.Save(); err != nil {
+		t.Fatalf("Failed to save vault: %s", err)
+	}
+	// the password is unlocked again after saving
+	expectPassword(t, e, "hunter2")
+	if _, err := Create(v.Path, kdbx.NewPasswordCredentials("secret")); !errors.Is(err, os.ErrExist) {
+		t.Fatalf("Expected os.ErrExist, got %v", err)
+	}
+
+	if _, err := Open(v.Path, kdbx.NewPasswordCredentials("wrong")); err == nil {
+		t.Fatal("Opened vault with wrong password")
+	}
+	v, err = Open(v.Path, kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatalf("Failed to open vault: %s", err)
+	}
+	e, err = v.Entry("/web/mail/example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.GetContent("UserName") != "alice" {
+		t.Fatalf("Unexpected entry values: %+v", e.Values)
+	}
+	if !e.Get("Password").Value.Protected.Bool {
+		t.Fatal("Password was not protected")
+	}
+	if value := e.Get("Password").Value; !value.Sealed() || value.Content != "" {
+		t.Fatalf("Expected the password to be held in an enclave only, got %q", value.Content)
+	}
+	buf, err := e.OpenPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Destroy()
+	if !buf.EqualTo([]byte("hunter2")) {
+		t.Fatalf("Unexpected password %q", buf.Bytes())
+	}
+}
+
+func TestVault_SaveMerge(t *testing.T) {
+	v := newTestVault(t)
+	if _, err := v.AddEntry("shared"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+	a, err := Open(v.Path, kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Open(v.Path, kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := a.AddEntry("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Remove("shared", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.Merged) != 0 {
+		t.Fatalf("Expected no merged changes, got %v", a.Merged)
+	}
+
+	// b was opened before a saved, so the changes of a are merged instead of overwritten
+	if _, err := b.AddEntry("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Merged) != 2 || b.Merged[0].String() != "added entry a" || b.Merged[1].String() != "deleted entry shared" {
+		t.Fatalf("Expected the changes of a to be merged, got %v", b.Merged)
+	}
+
+	v, err = Open(v.Path, kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"a", "b"} {
+		if _, err := v.Entry(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := v.Entry("shared"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestVault_Paths(t *testing.T) {
+	v := newTestVault(t)
+
+	if _, err := v.MakeGroup("a/b", false); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	if _, err := v.MakeGroup("a/b", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.MakeGroup("a", false); !errors.Is(err, ErrExists) {
+		t.Fatalf("Expected ErrExists, got %v", err)
+	}
+	if _, err := v.AddEntry("a/b/entry"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.AddEntry("a/b/entry"); !errors.Is(err, ErrExists) {
+		t.Fatalf("Expected ErrExists, got %v", err)
+	}
+	if _, err := v.AddEntry("a/b"); !errors.Is(err, ErrExists) {
+		t.Fatalf("Expected ErrExists, got %v", err)
+	}
+	if _, err := v.Group("a/b/entry"); !errors.Is(err, ErrNotGroup) {
+		t.Fatalf("Expected ErrNotGroup, got %v", err)
+	}
+	if _, err := v.Entry("a/b"); !errors.Is(err, ErrNotEntry) {
+		t.Fatalf("Expected ErrNotEntry, got %v", err)
+	}
+	if _, err := v.Entry("a/missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	if g, err := v.Group("/"); err != nil || g != v.Root() {
+		t.Fatalf("Expected root group, got %v", err)
+	}
+
+	// titles containing a slash are addressed by escaping it
+	if _, err := v.AddEntry(Join("a", "x/y")); err != nil {
+		t.Fatal(err)
+	}
+	if e, err := v.Entry(`a/x\/y`); err != nil || e.GetTitle() != "x/y" {
+		t.Fatalf("Expected entry x/y, got %v", err)
+	}
+	if dir, name := Split(`a\\b/c\/d`); dir != `a\\b` || name != "c/d" {
+		t.Fatalf("Unexpected split %q %q", dir, name)
+	}
+	// several entries with the same title cannot be addressed
+	g, err := v.Group("a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Entries = append(g.Entries, g.Entries[0])
+	if _, err := v.Entry("a/b/entry"); !errors.Is(err, ErrAmbiguous) {
+		t.Fatalf("Expected ErrAmbiguous, got %v", err)
+	}
+	if err := v.Remove("a/b/entry", false); !errors.Is(err, ErrAmbiguous) {
+		t.Fatalf("Expected ErrAmbiguous, got %v", err)
+	}
+}
+
+func TestVault_UpdateEntry(t *testing.T) {
+	v := newTestVault(t)
+	e, err := v.AddEntry("entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.SetValue(e, "Password", "old")
+	err = v.UpdateEntry("entry", func(e *kdbx.Entry) {
+		v.SetSecret(e, "Password", mgrd.NewBufferFromBytes([]byte("new")))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPassword(t, e, "new")
+	if len(e.Histories) != 1 || len(e.Histories[0].Entries) != 1 {
+		t.Fatalf("Expected one history entry, got %+v", e.Histories)
+	}
+	if old := &e.Histories[0].Entries[0]; old.UUID != e.UUID {
+		t.Fatalf("Unexpected history entry: %+v", old)
+	} else {
+		expectPassword(t, old, "old")
+	}
+}
+
+func expectPassword(t *testing.T, e *kdbx.Entry, expected string) {
+	t.Helper()
+	buf, err := e.OpenPassword()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Destroy()
+	if !buf.EqualTo([]byte(expected)) {
+		t.Fatalf("Expected password %q, got %q", expected, buf.Bytes())
+	}
+}
+
+func TestVault_Remove(t *testing.T) {
+	v := newTestVault(t)
+	if _, err := v.MakeGroup("a/b", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.AddEntry("a/b/entry"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Remove("a", false); !errors.Is(err, ErrGroupNotEmpty) {
+		t.Fatalf("Expected ErrGroupNotEmpty, got %v", err)
+	}
+	if err := v.Remove("a", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Group("a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	if n := len(v.DB.Content.Root.DeletedObjects); n != 3 {
+		t.Fatalf("Expected 3 deleted objects, got %d", n)
+	}
+	if err := v.Remove("a", true); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestVault_Move(t *testing.T) {
+	v := newTestVault(t)
+	for _, path := range []string{"a/b", "c"} {
+		if _, err := v.MakeGroup(path, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := v.AddEntry("a/b/entry"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.Move("a/b/entry", "c"); err != nil {
+		t.Fatal(err)
+	}
+	e, err := v.Entry("c/entry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Times.LocationChanged == nil {
+		t.Fatal("LocationChanged not set")
+	}
+	if err := v.Move("c/entry", "c/renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if e, err = v.Entry("c/renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Histories) != 1 || len(e.Histories[0].Entries) != 1 || e.Histories[0].Entries[0].GetTitle() != "entry" {
+		t.Fatalf("Expected the previous title in the history, got %+v", e.Histories)
+	}
+	if err := v.Move("a", "a/b"); !errors.Is(err, ErrInvalidPath) {
+		t.Fatalf("Expected ErrInvalidPath, got %v", err)
+	}
+	if err := v.Move("a/b", "/"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Move("c", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Entry("b/c/renamed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Move("a", "b/c"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Group("b/c/a"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/malivvan/aegis/cli"
+	"github.com/malivvan/aegis/hid"
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/mhex"
+	"github.com/malivvan/aegis/yubiotp"
+)
+
+var yubiotpCommand = &cli.Command{
+	Name:  "yubiotp",
+	Usage: "verify Yubico OTPs against credentials stored in the keyring",
+	Subcommands: []*cli.Command{
+		{
+			Name:      "add",
+			Usage:     "generate a Yubico OTP credential for an entry and print it for programming a key",
+			ArgsUsage: "<entry>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{Name: "public-id", Usage: "modhex public ID, random with the vv prefix if empty"},
+			},
+			Action: yubiotpAddAction,
+		},
+		{
+			Name:      "verify",
+			Usage:     "verify an OTP and record its counter to reject replays",
+			ArgsUsage: "<otp>",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "layout",
+					Value: "us",
+					Usage: "keyboard layout the OTP was typed with (" + strings.Join(hid.LayoutNames(), ", ") + ")",
+				},
+			},
+			Action: yubiotpVerifyAction,
+		},
+	},
+}
+
+func yubiotpAddAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 1); err != nil {
+		return err
+	}
+	publicID, err := yubiotpPublicID(ctx.String("public-id"))
+	if err != nil {
+		return err
+	}
+	c, err := yubiotp.NewCredential(publicID)
+	if err != nil {
+		return err
+	}
+	v, err := openVault(ctx)
+	if err != nil {
+		return err
+	}
+	path := ctx.Args().First()
+	if e := yubiotp.Find(v.Root(), publicID); e != nil {
+		return fmt.Errorf("public ID %s is already used by %s", mhex.Encode(publicID), e.GetTitle())
+	}
+	if _, err := v.Entry(path); err != nil {
+		return err
+	}
+	if err := v.UpdateEntry(path, func(e *kdbx.Entry) { yubiotp.Store(e, c) }); err != nil {
+		return err
+	}
+	if err := saveVault(v); err != nil {
+		return err
+	}
+	fmt.Printf("public ID:  %s\n", mhex.Encode(c.PublicID))
+	fmt.Printf("private ID: %s\n", hex.EncodeToString(c.PrivateID[:]))
+	fmt.Printf("secret key: %s\n", hex.EncodeToString(c.Key[:]))
+	return nil
+}
+
+// yubiotpPublicID decodes the modhex public ID s or generates a random public ID with the vv prefix that Yubico
+// reserves for self-programmed keys.
+func yubiotpPublicID(s string) ([]byte, error) {
+	if s != "" {
+		publicID, err := mhex.Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("public ID: %w", err)
+		}
+		if len(publicID) == 0 || len(publicID) > yubiotp.MaxPublicIDSize {
+			return nil, fmt.Errorf("public ID must be 1 to %d bytes", yubiotp.MaxPublicIDSize)
+		}
+		return publicID, nil
+	}
+	publicID := make([]byte, 6)
+	if _, err := rand.Read(publicID[1:]); err != nil {
+		return nil, err
+	}
+	publicID[0] = 0xff
+	return publicID, nil
+}
+
+func yubiotpVerifyAction(ctx *cli.Context) error {
+	if err := requireArgs(ctx, 1); err != nil {
+		return err
+	}
+	layout, ok := hid.LookupLayout(ctx.String("layout"))
+	if !ok {
+		return fmt.Errorf("unknown keyboard layout %q", ctx.String("layout"))
+	}
+	otp, err := yubiotp.ParseEncoding(ctx.Args().First(), layout.Modhex())
+	if err != nil {
+		return err
+	}
+	v, err := openVault(ctx)
+	if err != nil {
+		return err
+	}
+	e := yubiotp.Find(v.Root(), otp.PublicID)
+	if e == nil {
+		return errors.New("no credential for public ID " + mhex.Encode(otp.PublicID))
+	}
+	token, err := yubiotp.Verify(e, otp.String())
+	if err != nil {
+		return fmt.Errorf("%s: %w", e.GetTitle(), err)
+	}
+	if err := saveVault(v); err != nil {
+		return err
+	}
+	counter := token.Counter()
+	fmt.Printf("OK %s (usage %d, session %d)\n", e.GetTitle(), counter.Usage, counter.Session)
+	return nil
+}
@@ -0,0 +1,204 @@
+package yubiotp
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/malivvan/aegis/kdbx"
+	w "github.com/malivvan/aegis/kdbx/wrappers"
+	"github.com/malivvan/aegis/mhex"
+)
+
+// Keys of the entry values and custom data a credential is stored in. The private ID and the AES key are protected
+// values, the public ID and the counter of the last accepted OTP are custom data.
+const (
+	PrivateIDField = "YubicoOTP PrivateID"
+	KeyField       = "YubicoOTP Key"
+	PublicIDData   = "YubicoOTP.PublicID"
+	CounterData    = "YubicoOTP.Counter"
+)
+
+var ErrNoCredential = errors.New("entry has no Yubico OTP credential")
+
+// Store writes c into e. The counter is reset if the public ID changes.
+func Store(e *kdbx.Entry, c *Credential) {
+	publicID := mhex.Encode(c.PublicID)
+	if getCustomData(e, PublicIDData) != publicID {
+		deleteCustomData(e, CounterData)
+	}
+	setCustomData(e, PublicIDData, publicID)
+	setProtected(e, PrivateIDField, hex.EncodeToString(c.PrivateID[:]))
+	setProtected(e, KeyField, hex.EncodeToString(c.Key[:]))
+}
+
+// Load reads the credential stored in e.
+func Load(e *kdbx.Entry) (*Credential, error) {
+	publicID, ok := lookupCustomData(e, PublicIDData)
+	if !ok {
+		return nil, ErrNoCredential
+	}
+	c := &Credential{}
+	var err error
+	if c.PublicID, err = mhex.Decode(publicID); err != nil {
+		return nil, fmt.Errorf("%s: %w", PublicIDData, err)
+	}
+	if err := decodeHex(e, PrivateIDField, c.PrivateID[:]); err != nil {
+		return nil, err
+	}
+	if err := decodeHex(e, KeyField, c.Key[:]); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// decodeHex decodes the protected value key of e into dst, without copying it out of guarded memory first.
+func decodeHex(e *kdbx.Entry, key string, dst []byte) error {
+	buf, err := e.OpenContent(key)
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	defer buf.Destroy()
+	if n := hex.DecodedLen(buf.Size()); n != len(dst) {
+		return fmt.Errorf("%s: expected %d bytes, got %d", key, len(dst), n)
+	}
+	if _, err := hex.Decode(dst, buf.Bytes()); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+// LastCounter returns the counter of the last OTP accepted for e, or false if there is none.
+func LastCounter(e *kdbx.Entry) (Counter, bool, error) {
+	value, ok := lookupCustomData(e, CounterData)
+	if !ok {
+		return Counter{}, false, nil
+	}
+	usage, session, found := strings.Cut(value, ":")
+	if !found {
+		return Counter{}, false, fmt.Errorf("%s: invalid counter %q", CounterData, value)
+	}
+	u, err := strconv.ParseUint(usage, 10, 16)
+	if err != nil {
+		return Counter{}, false, fmt.Errorf("%s: %w", CounterData, err)
+	}
+	s, err := strconv.ParseUint(session, 10, 8)
+	if err != nil {
+		return Counter{}, false, fmt.Errorf("%s: %w", CounterData, err)
+	}
+	return Counter{Usage: uint16(u), Session: uint8(s)}, true, nil
+}
+
+// Verify validates otp against the credential stored in e and rejects OTPs whose counter is not greater than the
+// counter of the last accepted OTP. The counter of an accepted OTP is written into e and its modification time
+// updated, e has to be saved to protect against replays across sessions.
+func Verify(e *kdbx.Entry, otp string) (*Token, error) {
+	o, err := Parse(otp)
+	if err != nil {
+		return nil, err
+	}
+	c, err := Load(e)
+	if err != nil {
+		return nil, err
+	}
+	t, err := c.Decrypt(o)
+	if err != nil {
+		return nil, err
+	}
+	last, ok, err := LastCounter(e)
+	if err != nil {
+		return nil, err
+	}
+	counter := t.Counter()
+	if ok && !last.Less(counter) {
+		return nil, ErrReplayed
+	}
+	setCustomData(e, CounterData, fmt.Sprintf("%d:%d", counter.Usage, counter.Session))
+	now := w.Now()
+	e.Times.LastModificationTime = &now
+	return t, nil
+}
+
+// MergeCounter is a kdbx.MergeRule that keeps the higher counter of the last accepted OTP if both versions of an
+// entry store a credential with the same public ID, so that a merge never makes replayed OTPs valid again. Add it
+// to the MergeRules of keyrings with Yubico OTP credentials.
+func MergeCounter(merged, other *kdbx.Entry) bool {
+	publicID, ok := lookupCustomData(merged, PublicIDData)
+	if !ok || publicID != getCustomData(other, PublicIDData) {
+		return false
+	}
+	counter, ok, err := LastCounter(other)
+	if err != nil || !ok {
+		return false
+	}
+	if last, ok, err := LastCounter(merged); err == nil && ok && !last.Less(counter) {
+		return false
+	}
+	setCustomData(merged, CounterData, getCustomData(other, CounterData))
+	return true
+}
+
+// Find returns the entry in g or its subgroups that stores a credential for publicID, or nil.
+func Find(g *kdbx.Group, publicID []byte) *kdbx.Entry {
+	id := mhex.Encode(publicID)
+	for i := range g.Entries {
+		if value, ok := lookupCustomData(&g.Entries[i], PublicIDData); ok && value == id {
+			return &g.Entries[i]
+		}
+	}
+	for i := range g.Groups {
+		if e := Find(&g.Groups[i], publicID); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func lookupCustomData(e *kdbx.Entry, key string) (string, bool) {
+	for _, item := range e.CustomData {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+	return "", false
+}
+
+func getCustomData(e *kdbx.Entry, key string) string {
+	value, _ := lookupCustomData(e, key)
+	return value
+}
+
+// setCustomData sets the item key of e to value and updates its modification time if it has one, as in KDBX 4.1.
+func setCustomData(e *kdbx.Entry, key, value string) {
+	for i := range e.CustomData {
+		if item := &e.CustomData[i]; item.Key == key {
+			item.Value = value
+			if item.LastModificationTime != nil {
+				now := w.Now()
+				item.LastModificationTime = &now
+			}
+			return
+		}
+	}
+	e.CustomData = append(e.CustomData, kdbx.CustomData{Key: key, Value: value})
+}
+
+func deleteCustomData(e *kdbx.Entry, key string) {
+	for i := range e.CustomData {
+		if e.CustomData[i].Key == key {
+			e.CustomData = append(e.CustomData[:i], e.CustomData[i+1:]...)
+			return
+		}
+	}
+}
+
+func setProtected(e *kdbx.Entry, key, value string) {
+	v := kdbx.NewV(value, true)
+	if i := e.GetIndex(key); i >= 0 {
+		e.Values[i].Value = v
+		return
+	}
+	e.Values = append(e.Values, kdbx.ValueData{Key: key, Value: v})
+}
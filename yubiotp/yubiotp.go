@@ -0,0 +1,208 @@
+// Package yubiotp decodes and validates Yubico OTPs, the one-time passwords a YubiKey types when its OTP slot is
+// configured for Yubico OTP.
+//
+// An OTP is the modhex encoded public ID of the key followed by a modhex encoded token of 16 bytes, which is
+// encrypted with the AES key of the slot. The decrypted token carries the private ID, the usage and session
+// counters and a CRC. An OTP is valid if it decrypts to the expected private ID with a correct CRC and its counters
+// are greater than those of the last accepted OTP.
+package yubiotp
+
+import (
+	"crypto/aes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/malivvan/aegis/mhex"
+)
+
+const (
+	// TokenSize is the size of the token of an OTP.
+	TokenSize = 16
+	// KeySize is the size of the AES key that encrypts the token.
+	KeySize = 16
+	// PrivateIDSize is the size of the private ID in the token.
+	PrivateIDSize = 6
+	// MaxPublicIDSize is the maximum size of the public ID of an OTP.
+	MaxPublicIDSize = 16
+
+	// crcOKResidual is the CRC over a token including its CRC
+	crcOKResidual = 0xf0b8
+	// usageMask masks the usage counter, the most significant bit is reserved
+	usageMask = 0x7fff
+)
+
+var modhex = mhex.New(mhex.Alphabet)
+
+var (
+	ErrInvalidOTP        = errors.New("invalid Yubico OTP")
+	ErrInvalidCRC        = errors.New("token CRC mismatch")
+	ErrPrivateIDMismatch = errors.New("private ID mismatch")
+	ErrReplayed          = errors.New("OTP has already been used")
+)
+
+// OTP is a Yubico OTP split into its public ID and the encrypted token.
+type OTP struct {
+	PublicID []byte
+	Token    [TokenSize]byte
+}
+
+// Parse decodes otp, typically 44 characters of which the first 12 are the public ID. Upper case OTPs, as typed
+// with caps lock on, are accepted.
+func Parse(otp string) (*OTP, error) {
+	return ParseEncoding(otp, modhex)
+}
+
+// ParseEncoding is like Parse for an OTP that arrived as encoding, the modhex alphabet of the keyboard layout of
+// the host as returned by hid.Layout.Modhex.
+func ParseEncoding(otp string, encoding mhex.Encoding) (*OTP, error) {
+	otp = strings.ToLower(strings.TrimSpace(otp))
+	if len(otp) < 2*TokenSize || len(otp) > 2*(MaxPublicIDSize+TokenSize) || len(otp)%2 != 0 {
+		return nil, fmt.Errorf("%w: length %d", ErrInvalidOTP, len(otp))
+	}
+	b, err := encoding.Decode(otp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidOTP, err)
+	}
+	o := &OTP{PublicID: b[:len(b)-TokenSize]}
+	copy(o.Token[:], b[len(b)-TokenSize:])
+	return o, nil
+}
+
+// String returns the modhex encoding of o.
+func (o *OTP) String() string {
+	return mhex.Encode(o.PublicID) + mhex.Encode(o.Token[:])
+}
+
+// Decrypt decrypts the token of o with key and verifies its CRC.
+func (o *OTP) Decrypt(key []byte) (*Token, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	var b [TokenSize]byte
+	block.Decrypt(b[:], o.Token[:])
+	if crc16(b[:]) != crcOKResidual {
+		return nil, ErrInvalidCRC
+	}
+	t := &Token{
+		Usage:     binary.LittleEndian.Uint16(b[6:]),
+		Timestamp: uint32(b[8]) | uint32(b[9])<<8 | uint32(b[10])<<16,
+		Session:   b[11],
+		Random:    binary.LittleEndian.Uint16(b[12:]),
+	}
+	copy(t.PrivateID[:], b[:6])
+	return t, nil
+}
+
+// Token is a decrypted OTP token.
+type Token struct {
+	PrivateID [PrivateIDSize]byte
+	// Usage is incremented when the key is powered up and the session counter has been used.
+	Usage uint16
+	// Timestamp is a 24 bit counter at 8Hz that starts at a random value on power up.
+	Timestamp uint32
+	// Session is incremented for every OTP and restarts at 0 when Usage is incremented.
+	Session uint8
+	Random  uint16
+}
+
+// Counter returns the usage and session counters of t.
+func (t *Token) Counter() Counter {
+	return Counter{Usage: t.Usage & usageMask, Session: t.Session}
+}
+
+// Bytes returns the plain text of t including its CRC.
+func (t *Token) Bytes() []byte {
+	b := make([]byte, TokenSize)
+	copy(b, t.PrivateID[:])
+	binary.LittleEndian.PutUint16(b[6:], t.Usage)
+	b[8], b[9], b[10] = byte(t.Timestamp), byte(t.Timestamp>>8), byte(t.Timestamp>>16)
+	b[11] = t.Session
+	binary.LittleEndian.PutUint16(b[12:], t.Random)
+	binary.LittleEndian.PutUint16(b[14:], ^crc16(b[:14]))
+	return b
+}
+
+// Encrypt returns the OTP of t for publicID encrypted with key, like a YubiKey would type it.
+func (t *Token) Encrypt(publicID, key []byte) (*OTP, error) {
+	if len(publicID) > MaxPublicIDSize {
+		return nil, fmt.Errorf("public ID exceeds %d bytes", MaxPublicIDSize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	o := &OTP{PublicID: append([]byte(nil), publicID...)}
+	block.Encrypt(o.Token[:], t.Bytes())
+	return o, nil
+}
+
+// Counter orders the OTPs of a key.
+type Counter struct {
+	Usage   uint16
+	Session uint8
+}
+
+// Less reports whether c was generated before other.
+func (c Counter) Less(other Counter) bool {
+	if c.Usage != other.Usage {
+		return c.Usage < other.Usage
+	}
+	return c.Session < other.Session
+}
+
+// Credential is the secret configuration of a Yubico OTP slot.
+type Credential struct {
+	PublicID  []byte
+	PrivateID [PrivateIDSize]byte
+	Key       [KeySize]byte
+}
+
+// NewCredential returns a credential for publicID with a random private ID and key.
+func NewCredential(publicID []byte) (*Credential, error) {
+	if len(publicID) > MaxPublicIDSize {
+		return nil, fmt.Errorf("public ID exceeds %d bytes", MaxPublicIDSize)
+	}
+	c := &Credential{PublicID: append([]byte(nil), publicID...)}
+	if _, err := rand.Read(c.PrivateID[:]); err != nil {
+		return nil, err
+	}
+	if _, err := rand.Read(c.Key[:]); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Decrypt decrypts otp and verifies that it was generated for c. It does not check the counters.
+func (c *Credential) Decrypt(otp *OTP) (*Token, error) {
+	if string(otp.PublicID) != string(c.PublicID) {
+		return nil, fmt.Errorf("%w: unexpected public ID %s", ErrInvalidOTP, mhex.Encode(otp.PublicID))
+	}
+	t, err := otp.Decrypt(c.Key[:])
+	if err != nil {
+		return nil, err
+	}
+	if t.PrivateID != c.PrivateID {
+		return nil, ErrPrivateIDMismatch
+	}
+	return t, nil
+}
+
+// crc16 computes the CRC-16/ISO-13239 used by YubiKeys.
+func crc16(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			j := crc & 1
+			crc >>= 1
+			if j != 0 {
+				crc ^= 0x8408
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,191 @@
+package yubiotp
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/malivvan/aegis/kdbx"
+	"github.com/malivvan/aegis/mhex"
+	"github.com/malivvan/aegis/vault"
+)
+
+func generate(t *testing.T, c *Credential, usage uint16, session uint8) string {
+	t.Helper()
+	token := &Token{PrivateID: c.PrivateID, Usage: usage, Timestamp: 0x123456, Session: session, Random: 0xbeef}
+	otp, err := token.Encrypt(c.PublicID, c.Key[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return otp.String()
+}
+
+func TestParseDecrypt(t *testing.T) {
+	publicID, err := mhex.Decode("vvccccbhlnlr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCredential(publicID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := generate(t, c, 0x8013, 9)
+	if len(s) != 44 {
+		t.Fatalf("Expected 44 characters, got %q", s)
+	}
+
+	otp, err := Parse(strings.ToUpper(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otp.String() != s {
+		t.Fatalf("Expected %s, got %s", s, otp.String())
+	}
+	dvorak := mhex.New("jxe.uidchtnbpygk")
+	typed, err := ParseEncoding(dvorak.Encode(c.PublicID)+dvorak.Encode(otp.Token[:]), dvorak)
+	if err != nil || typed.String() != s {
+		t.Fatalf("Expected %s from an OTP typed on Dvorak, got %v (%v)", s, typed, err)
+	}
+	token, err := c.Decrypt(otp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Timestamp != 0x123456 || token.Random != 0xbeef {
+		t.Fatalf("Unexpected token %+v", token)
+	}
+	if counter := token.Counter(); counter != (Counter{Usage: 0x13, Session: 9}) {
+		t.Fatalf("Unexpected counter %+v", counter)
+	}
+
+	other := *c
+	other.Key[0] ^= 1
+	if _, err := other.Decrypt(otp); !errors.Is(err, ErrInvalidCRC) {
+		t.Fatalf("Expected ErrInvalidCRC, got %v", err)
+	}
+	other = *c
+	other.PrivateID[0] ^= 1
+	if _, err := other.Decrypt(otp); !errors.Is(err, ErrPrivateIDMismatch) {
+		t.Fatalf("Expected ErrPrivateIDMismatch, got %v", err)
+	}
+
+	for _, invalid := range []string{"", s[:30], s + "c", s[:43] + "a", s + strings.Repeat("c", 30)} {
+		if _, err := Parse(invalid); !errors.Is(err, ErrInvalidOTP) {
+			t.Errorf("Expected ErrInvalidOTP for %q, got %v", invalid, err)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kdbx")
+	v, err := vault.Create(path, kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := v.AddEntry("otp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCredential([]byte{1, 2, 3, 4, 5, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Store(e, c)
+
+	first := generate(t, c, 1, 0)
+	if _, err := Verify(e, first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Verify(e, first); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("Expected ErrReplayed, got %v", err)
+	}
+	if _, err := Verify(e, generate(t, c, 1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err = vault.Open(path, kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = Find(v.Root(), c.PublicID)
+	if e == nil {
+		t.Fatal("Expected to find the entry by public ID")
+	}
+	if !e.Get(KeyField).Value.Protected.Bool || !e.Get(PrivateIDField).Value.Protected.Bool {
+		t.Fatal("Expected the secrets to be protected")
+	}
+	if counter, ok, err := LastCounter(e); err != nil || !ok || counter != (Counter{Usage: 1, Session: 1}) {
+		t.Fatalf("Unexpected counter %+v (%v, %v)", counter, ok, err)
+	}
+	if _, err := Verify(e, generate(t, c, 1, 1)); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("Expected ErrReplayed after reopening, got %v", err)
+	}
+	if _, err := Verify(e, generate(t, c, 2, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	Store(e, c)
+	if _, ok, _ := LastCounter(e); !ok {
+		t.Fatal("Expected the counter to be kept for the same public ID")
+	}
+	c.PublicID = []byte{6, 5, 4, 3, 2, 1}
+	Store(e, c)
+	if _, ok, _ := LastCounter(e); ok {
+		t.Fatal("Expected the counter to be reset for a new public ID")
+	}
+	if _, err := Verify(&kdbx.Entry{}, first); !errors.Is(err, ErrNoCredential) {
+		t.Fatalf("Expected ErrNoCredential, got %v", err)
+	}
+}
+
+func TestVerify_Merge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.kdbx")
+	v, err := vault.Create(path, kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := v.AddEntry("otp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCredential([]byte{1, 2, 3, 4, 5, 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	Store(e, c)
+	if err := v.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// two processes verify OTPs of the same token, the one with the lower counter saves last
+	var copies [2]*vault.Vault
+	for i := range copies {
+		if copies[i], err = vault.Open(path, kdbx.NewPasswordCredentials("secret")); err != nil {
+			t.Fatal(err)
+		}
+		copies[i].MergeRules = []kdbx.MergeRule{MergeCounter}
+	}
+	for i, session := range []uint8{5, 2} {
+		if _, err := Verify(Find(copies[i].Root(), c.PublicID), generate(t, c, 1, session)); err != nil {
+			t.Fatal(err)
+		}
+		if err := copies[i].Save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	v, err = vault.Open(path, kdbx.NewPasswordCredentials("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e = Find(v.Root(), c.PublicID)
+	if counter, ok, err := LastCounter(e); err != nil || !ok || counter != (Counter{Usage: 1, Session: 5}) {
+		t.Fatalf("Expected the higher counter to be kept, got %+v (%v, %v)", counter, ok, err)
+	}
+	if _, err := Verify(e, generate(t, c, 1, 3)); !errors.Is(err, ErrReplayed) {
+		t.Fatalf("Expected ErrReplayed, got %v", err)
+	}
+}